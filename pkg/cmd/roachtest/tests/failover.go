@@ -11,23 +11,74 @@
 package tests
 
 import (
+	"bytes"
 	"context"
 	gosql "database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/cockroachdb/cockroach-go/v2/crdb"
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/cluster"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/option"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/registry"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil/clusterupgrade"
+	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/roachtestutil/mixedversion"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/spec"
 	"github.com/cockroachdb/cockroach/pkg/cmd/roachtest/test"
 	"github.com/cockroachdb/cockroach/pkg/roachprod/install"
+	"github.com/cockroachdb/cockroach/pkg/roachprod/logger"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/randutil"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/workload/histogram"
+	"github.com/cockroachdb/cockroach/pkg/workload/tpcc"
+	"github.com/cockroachdb/errors"
+	"github.com/codahale/hdrhistogram"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/require"
 )
 
+// envFailoverReuseCluster, if set, makes the failover tests that support it
+// run against a pre-existing cluster instead of setting one up. The cluster
+// must already be running, with ranges placed as the test expects.
+const envFailoverReuseCluster = "ROACHTEST_FAILOVER_REUSE_CLUSTER"
+
+// envFailoverFailureOffset, if set to a duration, makes the failover tests that
+// support it fail nodes at this fixed offset into each cycle rather than at a
+// random offset. See failoverConfig.fixedFailureOffset.
+const envFailoverFailureOffset = "ROACHTEST_FAILOVER_FAILURE_OFFSET"
+
+// failoverReuseCluster returns true if envFailoverReuseCluster is set.
+func failoverReuseCluster() bool {
+	return os.Getenv(envFailoverReuseCluster) != ""
+}
+
 func registerFailover(r registry.Registry) {
+	scenarios, err := loadFailoverScenarios()
+	if err != nil {
+		panic(err)
+	}
+
 	for _, expirationLeases := range []bool{false, true} {
 		expirationLeases := expirationLeases // pin loop variable
 		var suffix string
@@ -41,7 +92,7 @@ func registerFailover(r registry.Registry) {
 			Timeout: 30 * time.Minute,
 			Cluster: r.MakeClusterSpec(8, spec.CPU(4)),
 			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
-				runFailoverPartialLeaseGateway(ctx, t, c, expirationLeases)
+				runFailoverPartialLeaseGateway(ctx, t, c, expirationLeases, failoverConfig{})
 			},
 		})
 
@@ -51,7 +102,7 @@ func registerFailover(r registry.Registry) {
 			Timeout: 30 * time.Minute,
 			Cluster: r.MakeClusterSpec(7, spec.CPU(4)),
 			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
-				runFailoverPartialLeaseLeader(ctx, t, c, expirationLeases)
+				runFailoverPartialLeaseLeader(ctx, t, c, expirationLeases, failoverConfig{})
 			},
 		})
 
@@ -61,17 +112,258 @@ func registerFailover(r registry.Registry) {
 			Timeout: 30 * time.Minute,
 			Cluster: r.MakeClusterSpec(8, spec.CPU(4)),
 			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
-				runFailoverPartialLeaseLiveness(ctx, t, c, expirationLeases)
+				runFailoverPartialLeaseLiveness(ctx, t, c, expirationLeases, failoverConfig{})
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/partial/sql-gateway" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 30 * time.Minute,
+			Cluster: r.MakeClusterSpec(7, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverPartialSQLGateway(ctx, t, c, expirationLeases, failoverConfig{})
+			},
+		})
+
+		// Secure variants of the partial partition tests. Production clusters are
+		// always secure, and TLS affects the connection setup and teardown timings.
+		r.Add(registry.TestSpec{
+			Name:    "failover/partial/lease-gateway/secure" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 30 * time.Minute,
+			Cluster: r.MakeClusterSpec(8, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverPartialLeaseGateway(ctx, t, c, expirationLeases, failoverConfig{secure: true})
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/partial/lease-leader/secure" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 30 * time.Minute,
+			Cluster: r.MakeClusterSpec(7, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverPartialLeaseLeader(ctx, t, c, expirationLeases, failoverConfig{secure: true})
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/partial/lease-liveness/secure" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 30 * time.Minute,
+			Cluster: r.MakeClusterSpec(8, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverPartialLeaseLiveness(ctx, t, c, expirationLeases, failoverConfig{secure: true})
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/partial/sql-gateway/secure" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 30 * time.Minute,
+			Cluster: r.MakeClusterSpec(7, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverPartialSQLGateway(ctx, t, c, expirationLeases, failoverConfig{secure: true})
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/double/crash" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 30 * time.Minute,
+			Cluster: r.MakeClusterSpec(9, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverDoubleFailure(ctx, t, c, expirationLeases)
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/conn-pool/crash" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 30 * time.Minute,
+			Cluster: r.MakeClusterSpec(4, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverConnPool(ctx, t, c, expirationLeases)
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/gateway-txn/crash" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 30 * time.Minute,
+			Cluster: r.MakeClusterSpec(4, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverGatewayTxn(ctx, t, c, expirationLeases)
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/lagging-follower/blackhole" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 30 * time.Minute,
+			Cluster: r.MakeClusterSpec(6, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverLaggingFollower(ctx, t, c, expirationLeases)
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/quorum-loss/crash" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 45 * time.Minute,
+			// The lost nodes remain dead, and recovery may leave invalid
+			// descriptors behind.
+			SkipPostValidations: registry.PostValidationInvalidDescriptors |
+				registry.PostValidationNoDeadNodes,
+			Cluster: r.MakeClusterSpec(7, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverQuorumLoss(ctx, t, c, expirationLeases, failoverConfig{})
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/quorum-loss/crash/secure" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 45 * time.Minute,
+			SkipPostValidations: registry.PostValidationInvalidDescriptors |
+				registry.PostValidationNoDeadNodes,
+			Cluster: r.MakeClusterSpec(7, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverQuorumLoss(ctx, t, c, expirationLeases, failoverConfig{secure: true})
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/upreplication/crash" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 60 * time.Minute,
+			Cluster: r.MakeClusterSpec(9, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverUpreplication(ctx, t, c, expirationLeases)
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/drain-snapshots/drain-stop" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 60 * time.Minute,
+			Cluster: r.MakeClusterSpec(8, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverDrainSnapshots(ctx, t, c, expirationLeases)
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/snapshot-recv/crash" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 60 * time.Minute,
+			Cluster: r.MakeClusterSpec(8, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverSnapshotRecvCrash(ctx, t, c, expirationLeases)
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/stale-reads/pause" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 30 * time.Minute,
+			Cluster: r.MakeClusterSpec(6, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverPausedStaleReads(ctx, t, c, expirationLeases)
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/lease-preferences/crash" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 60 * time.Minute,
+			Cluster: r.MakeClusterSpec(6, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverLeasePreferences(ctx, t, c, expirationLeases)
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:            "failover/follower-reads/partition" + suffix,
+			Owner:           registry.OwnerKV,
+			Timeout:         30 * time.Minute,
+			Cluster:         r.MakeClusterSpec(6, spec.CPU(4)),
+			RequiresLicense: true,
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverFollowerReadsPartition(ctx, t, c, expirationLeases)
+			},
+		})
+
+		r.Add(registry.TestSpec{
+			Name:    "failover/consistency/crash" + suffix,
+			Owner:   registry.OwnerKV,
+			Timeout: 30 * time.Minute,
+			Cluster: r.MakeClusterSpec(7, spec.CPU(4)),
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverConsistency(ctx, t, c, expirationLeases)
+			},
+		})
+
+		// The disk stall uses PDs in an attempt to work around flakes encountered
+		// when using SSDs. See #97968.
+		compoundSpec := r.MakeClusterSpec(9, spec.CPU(4))
+		compoundSpec.PreferLocalSSD = false
+		r.Add(registry.TestSpec{
+			Name:                "failover/compound/disk-stall-blackhole" + suffix,
+			Owner:               registry.OwnerKV,
+			Timeout:             30 * time.Minute,
+			SkipPostValidations: registry.PostValidationNoDeadNodes,
+			Cluster:             compoundSpec,
+			Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+				runFailoverCompound(ctx, t, c, expirationLeases)
 			},
 		})
 
+		for _, scenario := range scenarios {
+			scenario := scenario // pin loop variable
+			var postValidation registry.PostValidation = 0
+			if scenario.FailureMode == failureModeDiskStall {
+				postValidation = registry.PostValidationNoDeadNodes
+			}
+			r.Add(registry.TestSpec{
+				Name:                "failover/scenario/" + scenario.Name + suffix,
+				Owner:               registry.OwnerKV,
+				Timeout:             30 * time.Minute,
+				SkipPostValidations: postValidation,
+				Cluster:             r.MakeClusterSpec(scenario.Nodes, spec.CPU(4)),
+				Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+					runFailoverScenario(ctx, t, c, scenario, expirationLeases, failoverConfig{})
+				},
+			})
+			r.Add(registry.TestSpec{
+				Name:                "failover/scenario/" + scenario.Name + "/secure" + suffix,
+				Owner:               registry.OwnerKV,
+				Timeout:             30 * time.Minute,
+				SkipPostValidations: postValidation,
+				Cluster:             r.MakeClusterSpec(scenario.Nodes, spec.CPU(4)),
+				Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+					runFailoverScenario(ctx, t, c, scenario, expirationLeases, failoverConfig{
+						secure: true,
+					})
+				},
+			})
+		}
+
 		for _, failureMode := range []failureMode{
 			failureModeBlackhole,
 			failureModeBlackholeRecv,
 			failureModeBlackholeSend,
+			failureModeBandwidth,
+			failureModeLatencyEgress,
+			failureModeLatencyIngress,
 			failureModeCrash,
+			failureModeDrainStop,
 			failureModeDiskStall,
+			failureModeHang,
 			failureModePause,
+			failureModeGCThrash,
+			failureModeRaftDrop,
 		} {
 			failureMode := failureMode // pin loop variable
 			makeSpec := func(nNodes, nCPU int) spec.ClusterSpec {
@@ -94,9 +386,246 @@ func registerFailover(r registry.Registry) {
 				SkipPostValidations: postValidation,
 				Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
 				Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
-					runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases)
+					runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases, failoverConfig{})
 				},
 			})
+			if failureMode == failureModeCrash {
+				// Gate on the worst-case latency being within a few seconds of the
+				// lease interval (9s).
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/non-system/%s/slo%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							latencySLO: failoverLatencySLO{pMax: 15 * time.Second},
+						})
+					},
+				})
+			}
+			if failureMode == failureModeDiskStall {
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/non-system/%s/admission%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							admissionMetrics: true,
+						})
+					},
+				})
+			}
+			if failureMode == failureModePause || failureMode == failureModeDiskStall {
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/non-system/%s/goroutines%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							goroutineDumps: true,
+						})
+					},
+				})
+			}
+			if failureMode == failureModeCrash {
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/non-system/%s/lease-trigger%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							leaseTrigger: true,
+						})
+					},
+				})
+			}
+			if failureMode == failureModeBlackhole || failureMode == failureModeCrash {
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/non-system/%s/raw-errors%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							rawErrorsDuration: 20 * time.Second,
+						})
+					},
+				})
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/non-system/%s/txn%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							txnSize: 4,
+						})
+					},
+				})
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/non-system/%s/read-write%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							splitReadWrite: true,
+						})
+					},
+				})
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/non-system/%s/scan%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							spanPercent: 10,
+						})
+					},
+				})
+				// 5x replication variants. The default tests use 3x replication.
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/non-system/%s/replicas=5%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(11 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							replicas: 5,
+						})
+					},
+				})
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/liveness/%s/replicas=5%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverLiveness(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							replicas: 5,
+						})
+					},
+				})
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/tpcc/%s%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             45 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverTPCC(ctx, t, c, failureMode, expirationLeases)
+					},
+				})
+				// Secure variants. Production clusters are always secure, and TLS
+				// affects the connection setup and teardown timings.
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/non-system/%s/secure%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							secure: true,
+						})
+					},
+				})
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/liveness/%s/secure%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(5 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverLiveness(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							secure: true,
+						})
+					},
+				})
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/system-non-liveness/%s/secure%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverSystemNonLiveness(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							secure: true,
+						})
+					},
+				})
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/changefeed/%s/secure%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverChangefeed(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							secure: true,
+						})
+					},
+				})
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/changefeed/%s%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverChangefeed(ctx, t, c, failureMode, expirationLeases, failoverConfig{})
+					},
+				})
+			}
+			if failureMode == failureModeCrash {
+				// Planned failover, where leases are moved away before the crash.
+				r.Add(registry.TestSpec{
+					Name:                fmt.Sprintf("failover/non-system/%s/planned%s", failureMode, suffix),
+					Owner:               registry.OwnerKV,
+					Timeout:             30 * time.Minute,
+					SkipPostValidations: postValidation,
+					Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+					Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+						runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+							planned: true,
+						})
+					},
+				})
+
+				// Short and long lease variants, to study the latency/availability
+				// tradeoff of the lease duration (default 6s).
+				for _, leaseDuration := range []time.Duration{3 * time.Second, 12 * time.Second} {
+					leaseDuration := leaseDuration // pin loop variable
+					r.Add(registry.TestSpec{
+						Name: fmt.Sprintf("failover/non-system/%s/lease-duration=%s%s",
+							failureMode, leaseDuration, suffix),
+						Owner:               registry.OwnerKV,
+						Timeout:             30 * time.Minute,
+						SkipPostValidations: postValidation,
+						Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
+						Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
+							runFailoverNonSystem(ctx, t, c, failureMode, expirationLeases, failoverConfig{
+								raftCfg: base.RaftConfig{RangeLeaseDuration: leaseDuration},
+							})
+						},
+					})
+				}
+			}
 			r.Add(registry.TestSpec{
 				Name:                fmt.Sprintf("failover/liveness/%s%s", failureMode, suffix),
 				Owner:               registry.OwnerKV,
@@ -104,7 +633,7 @@ func registerFailover(r registry.Registry) {
 				SkipPostValidations: postValidation,
 				Cluster:             makeSpec(5 /* nodes */, 4 /* cpus */),
 				Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
-					runFailoverLiveness(ctx, t, c, failureMode, expirationLeases)
+					runFailoverLiveness(ctx, t, c, failureMode, expirationLeases, failoverConfig{})
 				},
 			})
 			r.Add(registry.TestSpec{
@@ -114,11 +643,243 @@ func registerFailover(r registry.Registry) {
 				SkipPostValidations: postValidation,
 				Cluster:             makeSpec(7 /* nodes */, 4 /* cpus */),
 				Run: func(ctx context.Context, t test.Test, c cluster.Cluster) {
-					runFailoverSystemNonLiveness(ctx, t, c, failureMode, expirationLeases)
+					runFailoverSystemNonLiveness(ctx, t, c, failureMode, expirationLeases, failoverConfig{})
 				},
 			})
 		}
 	}
+
+	r.Add(registry.TestSpec{
+		Name:    "failover/smoke/localities",
+		Owner:   registry.OwnerKV,
+		Timeout: 10 * time.Minute,
+		Cluster: r.MakeClusterSpec(3, spec.CPU(4)),
+		Run:     runFailoverLocalitiesSmoke,
+	})
+
+	r.Add(registry.TestSpec{
+		Name:    "failover/mixed-version/crash",
+		Owner:   registry.OwnerKV,
+		Timeout: 60 * time.Minute,
+		Cluster: r.MakeClusterSpec(5, spec.CPU(4)),
+		Run:     runFailoverMixedVersion,
+	})
+}
+
+// runFailoverLocalitiesSmoke is a smoke test for startWithLocalities. It starts
+// a cluster with per-node localities and node attributes, and verifies that
+// each node reports the assigned values.
+func runFailoverLocalitiesSmoke(ctx context.Context, t test.Test, c cluster.Cluster) {
+	localities := []string{
+		"region=us-east1,zone=us-east1-b;ssd",
+		"region=us-east1,zone=us-east1-c;ssd:fast",
+		"region=us-west1,zone=us-west1-a",
+	}
+
+	c.Put(ctx, t.Cockroach(), "./cockroach")
+	startWithLocalities(ctx, t, c, option.DefaultStartOpts(), install.MakeClusterSettings(),
+		c.Range(1, 3), localities)
+
+	for i, node := range c.Range(1, 3) {
+		expLocality, expAttrs, _ := strings.Cut(localities[i], ";")
+
+		conn := c.Conn(ctx, t.L(), node)
+		var locality, attrs string
+		require.NoError(t, conn.QueryRowContext(ctx, `SHOW LOCALITY`).Scan(&locality))
+		require.NoError(t, conn.QueryRowContext(ctx, `
+SELECT array_to_string(ARRAY(SELECT jsonb_array_elements_text(attrs)), ':')
+FROM crdb_internal.kv_node_status WHERE node_id = crdb_internal.node_id()`).Scan(&attrs))
+		require.NoError(t, conn.Close())
+
+		t.L().Printf("n%d: locality=%q attrs=%q", node, locality, attrs)
+		require.Equal(t, expLocality, locality, "locality of n%d", node)
+		require.Equal(t, expAttrs, attrs, "attrs of n%d", node)
+	}
+}
+
+// mixedVersionFailover is a single leaseholder failure in
+// runFailoverMixedVersion.
+type mixedVersionFailover struct {
+	fromVersion    string
+	toVersion      string
+	nodeVersion    string
+	node           int
+	unavailability time.Duration
+}
+
+const (
+	// mixedVersionProbeTimeout is the timeout of each write probe in
+	// runFailoverMixedVersion.
+	mixedVersionProbeTimeout = 5 * time.Second
+	// mixedVersionUnavailabilityTimeout bounds how long runFailoverMixedVersion
+	// waits for writes to succeed after failing a leaseholder.
+	mixedVersionUnavailabilityTimeout = 2 * time.Minute
+)
+
+// runFailoverMixedVersion tests leaseholder failures in a cluster running
+// mixed binary versions, using the mixed-version framework. It is similar to
+// runFailoverNonSystem, except:
+//
+//   - The nodes are upgraded (and possibly downgraded) by the mixed-version
+//     framework, which also decides in which mixed-version states we fail.
+//
+//   - The workload is a probe writing a single row via a live gateway, rather
+//     than the kv workload.
+//
+//   - The workload table is replicated to all nodes, since any node may be
+//     failed.
+//
+// In each mixed-version state, we pick a node running the old binary and a node
+// running the new binary (when present), move the workload table leases to it,
+// and crash it. The time until a write succeeds is recorded as the
+// unavailability, and the node is restarted with its current binary. The
+// failures are written to mixed-version-failover.txt, along with a summary
+// broken down by the failed leaseholder's binary version.
+func runFailoverMixedVersion(ctx context.Context, t test.Test, c cluster.Cluster) {
+	require.Equal(t, 5, c.Spec().NodeCount)
+
+	mvt := mixedversion.NewTest(ctx, t, t.L(), c, c.All())
+
+	mvt.OnStartup("create workload table", func(
+		ctx context.Context, l *logger.Logger, rng *rand.Rand, h *mixedversion.Helper,
+	) error {
+		for _, stmt := range []string{
+			`CREATE DATABASE kv`,
+			`ALTER DATABASE kv CONFIGURE ZONE USING num_replicas = 5`,
+			`CREATE TABLE kv.mixed_version (k INT PRIMARY KEY, v INT NOT NULL)`,
+		} {
+			if err := h.Exec(rng, stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	var failovers []mixedVersionFailover
+	mvt.InMixedVersion("fail leaseholders", func(
+		ctx context.Context, l *logger.Logger, rng *rand.Rand, h *mixedversion.Helper,
+	) error {
+		tc := h.Context()
+		for _, group := range []struct {
+			version string
+			nodes   option.NodeListOption
+		}{
+			{tc.FromVersion, tc.FromVersionNodes},
+			{tc.ToVersion, tc.ToVersionNodes},
+		} {
+			if len(group.nodes) == 0 {
+				continue
+			}
+			node := h.RandomNode(rng, group.nodes)
+			gateway := node%c.Spec().NodeCount + 1 // any other node will do
+			unavailability, err := failMixedVersionLeaseholder(
+				ctx, t, l, c, h.Connect(gateway), node, group.version)
+			if err != nil {
+				return err
+			}
+			failovers = append(failovers, mixedVersionFailover{
+				fromVersion:    tc.FromVersion,
+				toVersion:      tc.ToVersion,
+				nodeVersion:    group.version,
+				node:           node,
+				unavailability: unavailability,
+			})
+		}
+		return nil
+	})
+
+	mvt.Run()
+
+	// Write out the individual failures, and summarize them by whether the
+	// failed leaseholder ran the old or new binary.
+	var report strings.Builder
+	report.WriteString("from_version,to_version,node_version,node,unavailability\n")
+	type summary struct {
+		count      int
+		total, max time.Duration
+	}
+	summaries := map[string]*summary{"old": {}, "new": {}}
+	for _, f := range failovers {
+		fmt.Fprintf(&report, "%s,%s,%s,%d,%s\n", clusterupgrade.VersionMsg(f.fromVersion),
+			clusterupgrade.VersionMsg(f.toVersion), clusterupgrade.VersionMsg(f.nodeVersion),
+			f.node, f.unavailability)
+		s := summaries["old"]
+		if f.nodeVersion == clusterupgrade.MainVersion {
+			s = summaries["new"]
+		}
+		s.count++
+		s.total += f.unavailability
+		if f.unavailability > s.max {
+			s.max = f.unavailability
+		}
+	}
+	report.WriteString("\nleaseholder_version,failures,mean_unavailability,max_unavailability\n")
+	for _, version := range []string{"old", "new"} {
+		s := summaries[version]
+		var mean time.Duration
+		if s.count > 0 {
+			mean = s.total / time.Duration(s.count)
+		}
+		fmt.Fprintf(&report, "%s,%d,%s,%s\n", version, s.count, mean, s.max)
+	}
+	t.L().Printf("mixed-version failover:\n%s", report.String())
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "mixed-version-failover.txt"),
+		[]byte(report.String()), 0644))
+}
+
+// failMixedVersionLeaseholder moves the leases of the workload table in
+// runFailoverMixedVersion to the given node, crashes it, and returns the time
+// until a write via conn succeeds. The node is then restarted with the binary
+// for the given version, and we wait for it to rejoin the cluster.
+func failMixedVersionLeaseholder(
+	ctx context.Context,
+	t test.Test,
+	l *logger.Logger,
+	c cluster.Cluster,
+	conn *gosql.DB,
+	node int,
+	version string,
+) (time.Duration, error) {
+	// There is no monitor in mixed-version tests, so the failer is never
+	// Ready()'d.
+	failer := &crashFailer{
+		t:         t,
+		c:         c,
+		startOpts: option.DefaultStartOptsNoBackups(),
+		startSettings: install.MakeClusterSettings(
+			install.BinaryOption(clusterupgrade.BinaryPathFromVersion(version))),
+	}
+
+	if err := relocateLeases(t, ctx, conn, `database_name = 'kv'`, node); err != nil {
+		return 0, err
+	}
+
+	l.Printf("failing leaseholder n%d (%s)", node, clusterupgrade.VersionMsg(version))
+	failer.Fail(ctx, node)
+	start := timeutil.Now()
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, mixedVersionProbeTimeout)
+		_, err := conn.ExecContext(probeCtx, `UPSERT INTO kv.mixed_version VALUES (1, 1)`)
+		cancel()
+		if err == nil {
+			break
+		}
+		if timeutil.Since(start) > mixedVersionUnavailabilityTimeout {
+			return 0, errors.Wrapf(err, "writes unavailable for %s after failing n%d",
+				mixedVersionUnavailabilityTimeout, node)
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+	}
+	unavailability := timeutil.Since(start)
+	l.Printf("writes recovered %s after failing n%d", unavailability, node)
+
+	failer.Recover(ctx, node)
+	if err := waitForNodeRejoin(ctx, t, conn, node, nodeRejoinTimeout); err != nil {
+		return 0, err
+	}
+	return unavailability, nil
 }
 
 // runFailoverPartialLeaseGateway tests a partial network partition between a
@@ -152,7 +913,7 @@ func registerFailover(r registry.Registry) {
 //
 // We run a kv50 workload on SQL gateways and collect pMax latency for graphing.
 func runFailoverPartialLeaseGateway(
-	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool,
+	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool, cfg failoverConfig,
 ) {
 	require.Equal(t, 8, c.Spec().NodeCount)
 
@@ -160,31 +921,20 @@ func runFailoverPartialLeaseGateway(
 
 	// Create cluster.
 	opts := option.DefaultStartOpts()
-	settings := install.MakeClusterSettings()
+	settings := cfg.clusterSettings()
 
 	failer := makeFailer(t, c, failureModeBlackhole, opts, settings).(partialFailer)
 	failer.Setup(ctx)
 	defer failer.Cleanup(ctx)
 
-	c.Put(ctx, t.Cockroach(), "./cockroach")
-	c.Start(ctx, t.L(), opts, settings, c.Range(1, 7))
-
-	conn := c.Conn(ctx, t.L(), 1)
-	defer conn.Close()
-
-	_, err := conn.ExecContext(ctx, `SET CLUSTER SETTING kv.expiration_leases_only.enabled = $1`,
-		expLeases)
-	require.NoError(t, err)
-
 	// Place all ranges on n1-n3 to start with.
-	configureAllZones(t, ctx, conn, zoneConfig{replicas: 3, onlyNodes: []int{1, 2, 3}})
-
-	// Wait for upreplication.
-	require.NoError(t, WaitFor3XReplication(ctx, t, conn))
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 7), systemNodes: []int{1, 2, 3}})
+	defer conn.Close()
 
 	// Create the kv database with 5 replicas on n2-n6, and leases on n4.
 	t.Status("creating workload database")
-	_, err = conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
 	require.NoError(t, err)
 	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{
 		replicas: 5, onlyNodes: []int{2, 3, 4, 5, 6}, leaseNode: 4})
@@ -200,23 +950,30 @@ func runFailoverPartialLeaseGateway(
 	// all nodes regardless.
 	relocateRanges(t, ctx, conn, `database_name = 'kv'`, []int{1, 7}, []int{2, 3, 4, 5, 6})
 	relocateRanges(t, ctx, conn, `database_name != 'kv'`, []int{4, 5, 6, 7}, []int{1, 2, 3})
-	relocateLeases(t, ctx, conn, `database_name = 'kv'`, 4)
+	require.NoError(t, relocateLeases(t, ctx, conn, `database_name = 'kv'`, 4))
+
+	const cycles = 3
+	workloadCmd := `./cockroach workload run kv --read-percent 50 ` +
+		`--duration 20m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors ` +
+		`--histograms=` + t.PerfArtifactsDir() + `/stats.json`
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeBlackhole,
+		ExpirationLeases: expLeases,
+		Cycles:           cycles,
+		Workload:         workloadCmd,
+		Config:           cfg.manifest(),
+	})
 
 	// Start workload on n8 using n6-n7 as gateways.
-	t.Status("running workload")
-	m := c.NewMonitor(ctx, c.Range(1, 7))
-	m.Go(func(ctx context.Context) error {
-		c.Run(ctx, c.Node(8), `./cockroach workload run kv --read-percent 50 `+
-			`--duration 20m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors `+
-			`--histograms=`+t.PerfArtifactsDir()+`/stats.json `+
-			`{pgurl:6-7}`)
-		return nil
-	})
+	m, _ := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 7), failoverWorkload{
+		name: "kv", node: 8, gateways: []int{6, 7}, cmds: []string{workloadCmd}})
 
 	// Start a worker to fail and recover partial partitions between n4,n5
 	// (leases) and n6,n7 (gateways), both fully and individually, for 3 cycles.
 	// Leases are only placed on n4.
 	failer.Ready(ctx, m)
+	thrash := newLeaseThrashTracker(t, conn, `database_name = 'kv'`)
+	var thrashRows []string
 	m.Go(func(ctx context.Context) error {
 		var raftCfg base.RaftConfig
 		raftCfg.SetDefaults()
@@ -224,7 +981,8 @@ func runFailoverPartialLeaseGateway(
 		ticker := time.NewTicker(time.Minute)
 		defer ticker.Stop()
 
-		for i := 0; i < 3; i++ {
+		var cycle int
+		for i := 0; i < cycles; i++ {
 			testcases := []struct {
 				nodes []int
 				peers []int
@@ -252,7 +1010,7 @@ func runFailoverPartialLeaseGateway(
 				// them to where they should be.
 				relocateRanges(t, ctx, conn, `database_name = 'kv'`, []int{1, 7}, []int{2, 3, 4, 5, 6})
 				relocateRanges(t, ctx, conn, `database_name != 'kv'`, []int{4, 5, 6, 7}, []int{1, 2, 3})
-				relocateLeases(t, ctx, conn, `database_name = 'kv'`, 4)
+				require.NoError(t, relocateLeases(t, ctx, conn, `database_name = 'kv'`, 4))
 
 				// Randomly sleep up to the lease renewal interval, to vary the time
 				// between the last lease renewal and the failure. We start the timer
@@ -262,6 +1020,7 @@ func runFailoverPartialLeaseGateway(
 				case <-ctx.Done():
 				}
 
+				thrash.start(ctx)
 				for _, node := range tc.nodes {
 					t.Status(fmt.Sprintf("failing n%d (blackhole lease/gateway)", node))
 					failer.FailPartial(ctx, node, tc.peers)
@@ -273,6 +1032,13 @@ func runFailoverPartialLeaseGateway(
 					return ctx.Err()
 				}
 
+				// Count the leaseholder changes during the partition.
+				report := thrash.stop()
+				partition := fmt.Sprintf("%s/%s", leaseThrashNodes(tc.nodes), leaseThrashNodes(tc.peers))
+				t.L().Printf("partition %s: %s", partition, report)
+				thrashRows = append(thrashRows, leaseThrashRow(cycle, partition, report))
+				cycle++
+
 				for _, node := range tc.nodes {
 					t.Status(fmt.Sprintf("recovering n%d (blackhole lease/gateway)", node))
 					failer.Recover(ctx, node)
@@ -282,6 +1048,8 @@ func runFailoverPartialLeaseGateway(
 		return nil
 	})
 	m.Wait()
+	assertWorkloadHistograms(ctx, t, c, 8, t.PerfArtifactsDir()+"/stats.json")
+	writeLeaseThrash(t, thrashRows)
 }
 
 // runFailoverLeaseLeader tests a partial network partition between leaseholders
@@ -301,7 +1069,7 @@ func runFailoverPartialLeaseGateway(
 //
 // We run a kv50 workload on SQL gateways and collect pMax latency for graphing.
 func runFailoverPartialLeaseLeader(
-	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool,
+	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool, cfg failoverConfig,
 ) {
 	require.Equal(t, 7, c.Spec().NodeCount)
 
@@ -311,31 +1079,22 @@ func runFailoverPartialLeaseLeader(
 	// n1-n3, to precisely place system ranges, since we'll have to disable the
 	// replicate queue shortly.
 	opts := option.DefaultStartOpts()
-	settings := install.MakeClusterSettings()
+	settings := cfg.clusterSettings()
 	settings.Env = append(settings.Env, "COCKROACH_DISABLE_LEADER_FOLLOWS_LEASEHOLDER=true")
 
 	failer := makeFailer(t, c, failureModeBlackhole, opts, settings).(partialFailer)
 	failer.Setup(ctx)
 	defer failer.Cleanup(ctx)
 
-	c.Put(ctx, t.Cockroach(), "./cockroach")
-	c.Start(ctx, t.L(), opts, settings, c.Range(1, 3))
-
-	conn := c.Conn(ctx, t.L(), 1)
+	// Place all ranges on n1-n3 to start with.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 3), systemNodes: []int{1, 2, 3}})
 	defer conn.Close()
 
-	_, err := conn.ExecContext(ctx, `SET CLUSTER SETTING kv.expiration_leases_only.enabled = $1`,
-		expLeases)
-	require.NoError(t, err)
-
-	// Place all ranges on n1-n3 to start with, and wait for upreplication.
-	configureAllZones(t, ctx, conn, zoneConfig{replicas: 3, onlyNodes: []int{1, 2, 3}})
-	require.NoError(t, WaitFor3XReplication(ctx, t, conn))
-
 	// Disable the replicate queue. It can otherwise end up with stuck
 	// overreplicated ranges during rebalancing, because downreplication requires
 	// the Raft leader to be colocated with the leaseholder.
-	_, err = conn.ExecContext(ctx, `SET CLUSTER SETTING kv.replicate_queue.enabled = false`)
+	_, err := conn.ExecContext(ctx, `SET CLUSTER SETTING kv.replicate_queue.enabled = false`)
 	require.NoError(t, err)
 
 	// Now that system ranges are properly placed on n1-n3, start n4-n6.
@@ -371,20 +1130,27 @@ func runFailoverPartialLeaseLeader(
 		time.Sleep(time.Second)
 	}
 
-	// Start workload on n7 using n1-n3 as gateways.
-	t.Status("running workload")
-	m := c.NewMonitor(ctx, c.Range(1, 6))
-	m.Go(func(ctx context.Context) error {
-		c.Run(ctx, c.Node(7), `./cockroach workload run kv --read-percent 50 `+
-			`--duration 20m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors `+
-			`--histograms=`+t.PerfArtifactsDir()+`/stats.json `+
-			`{pgurl:1-3}`)
-		return nil
+	const cycles = 3
+	workloadCmd := `./cockroach workload run kv --read-percent 50 ` +
+		`--duration 20m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors ` +
+		`--histograms=` + t.PerfArtifactsDir() + `/stats.json`
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeBlackhole,
+		ExpirationLeases: expLeases,
+		Cycles:           cycles,
+		Workload:         workloadCmd,
+		Config:           cfg.manifest(),
 	})
 
+	// Start workload on n7 using n1-n3 as gateways.
+	m, _ := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 6), failoverWorkload{
+		name: "kv", node: 7, gateways: []int{1, 2, 3}, cmds: []string{workloadCmd}})
+
 	// Start a worker to fail and recover partial partitions between each pair of
 	// n4-n6 for 3 cycles (9 failures total).
 	failer.Ready(ctx, m)
+	thrash := newLeaseThrashTracker(t, conn, `database_name = 'kv'`)
+	var thrashRows []string
 	m.Go(func(ctx context.Context) error {
 		var raftCfg base.RaftConfig
 		raftCfg.SetDefaults()
@@ -392,7 +1158,8 @@ func runFailoverPartialLeaseLeader(
 		ticker := time.NewTicker(time.Minute)
 		defer ticker.Stop()
 
-		for i := 0; i < 3; i++ {
+		var cycle int
+		for i := 0; i < cycles; i++ {
 			for _, node := range []int{4, 5, 6} {
 				select {
 				case <-ticker.C:
@@ -420,6 +1187,7 @@ func runFailoverPartialLeaseLeader(
 				if nextNode > 6 {
 					nextNode = 4
 				}
+				thrash.start(ctx)
 				failer.FailPartial(ctx, node, []int{nextNode})
 
 				select {
@@ -428,6 +1196,13 @@ func runFailoverPartialLeaseLeader(
 					return ctx.Err()
 				}
 
+				// Count the leaseholder changes during the partition.
+				report := thrash.stop()
+				partition := fmt.Sprintf("n%d/n%d", node, nextNode)
+				t.L().Printf("partition %s: %s", partition, report)
+				thrashRows = append(thrashRows, leaseThrashRow(cycle, partition, report))
+				cycle++
+
 				t.Status(fmt.Sprintf("recovering n%d (blackhole lease/leader)", node))
 				failer.Recover(ctx, node)
 			}
@@ -435,6 +1210,8 @@ func runFailoverPartialLeaseLeader(
 		return nil
 	})
 	m.Wait()
+	assertWorkloadHistograms(ctx, t, c, 7, t.PerfArtifactsDir()+"/stats.json")
+	writeLeaseThrash(t, thrashRows)
 }
 
 // runFailoverPartialLeaseLiveness tests a partial network partition between a
@@ -445,6 +1222,13 @@ func runFailoverPartialLeaseLeader(
 // from moving unless we explicitly handle this. See also:
 // https://github.com/cockroachdb/cockroach/pull/87244.
 //
+// The liveness range always uses expiration leases, so the liveness lease is
+// unaffected by the partition. With epoch leases, we assert that the
+// partitioned user leaseholder loses its leases within
+// partialLivenessLeaseHandoffTimeout, and record the lease handoff times in
+// lease-handoff.txt. With expiration leases, the user leaseholder can keep
+// extending its leases, so we only record them.
+//
 // Cluster topology:
 //
 // n1-n3: system ranges and SQL gateways
@@ -456,7 +1240,7 @@ func runFailoverPartialLeaseLeader(
 // is running against SQL gateways on n1-n3, and we collect the pMax latency for
 // graphing.
 func runFailoverPartialLeaseLiveness(
-	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool,
+	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool, cfg failoverConfig,
 ) {
 	require.Equal(t, 8, c.Spec().NodeCount)
 
@@ -464,33 +1248,23 @@ func runFailoverPartialLeaseLiveness(
 
 	// Create cluster.
 	opts := option.DefaultStartOpts()
-	settings := install.MakeClusterSettings()
+	settings := cfg.clusterSettings()
 
 	failer := makeFailer(t, c, failureModeBlackhole, opts, settings).(partialFailer)
 	failer.Setup(ctx)
 	defer failer.Cleanup(ctx)
 
-	c.Put(ctx, t.Cockroach(), "./cockroach")
-	c.Start(ctx, t.L(), opts, settings, c.Range(1, 7))
-
-	conn := c.Conn(ctx, t.L(), 1)
-	defer conn.Close()
-
-	_, err := conn.ExecContext(ctx, `SET CLUSTER SETTING kv.expiration_leases_only.enabled = $1`,
-		expLeases)
-	require.NoError(t, err)
-
 	// Place all ranges on n1-n3, and an extra liveness leaseholder replica on n4.
-	configureAllZones(t, ctx, conn, zoneConfig{replicas: 3, onlyNodes: []int{1, 2, 3}})
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 7), systemNodes: []int{1, 2, 3}})
+	defer conn.Close()
 	configureZone(t, ctx, conn, `RANGE liveness`, zoneConfig{
 		replicas: 4, onlyNodes: []int{1, 2, 3, 4}, leaseNode: 4})
-
-	// Wait for upreplication.
-	require.NoError(t, WaitFor3XReplication(ctx, t, conn))
+	requireFullyReplicated(ctx, t, conn)
 
 	// Create the kv database on n5-n7.
 	t.Status("creating workload database")
-	_, err = conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
 	require.NoError(t, err)
 	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: []int{5, 6, 7}})
 
@@ -504,21 +1278,29 @@ func runFailoverPartialLeaseLiveness(
 	relocateRanges(t, ctx, conn, `database_name != 'kv'`, []int{5, 6, 7}, []int{1, 2, 3, 4})
 	relocateRanges(t, ctx, conn, `range_id != 2`, []int{4}, []int{1, 2, 3})
 
-	// Start workload on n8 using n1-n3 as gateways (not partitioned).
-	t.Status("running workload")
-	m := c.NewMonitor(ctx, c.Range(1, 7))
-	m.Go(func(ctx context.Context) error {
-		c.Run(ctx, c.Node(8), `./cockroach workload run kv --read-percent 50 `+
-			`--duration 20m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors `+
-			`--histograms=`+t.PerfArtifactsDir()+`/stats.json `+
-			`{pgurl:1-3}`)
-		return nil
+	const cycles = 3
+	workloadCmd := `./cockroach workload run kv --read-percent 50 ` +
+		`--duration 20m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors ` +
+		`--histograms=` + t.PerfArtifactsDir() + `/stats.json`
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeBlackhole,
+		ExpirationLeases: expLeases,
+		Cycles:           cycles,
+		Workload:         workloadCmd,
+		Config:           cfg.manifest(),
 	})
 
+	// Start workload on n8 using n1-n3 as gateways (not partitioned).
+	m, _ := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 7), failoverWorkload{
+		name: "kv", node: 8, gateways: []int{1, 2, 3}, cmds: []string{workloadCmd}})
+
 	// Start a worker to fail and recover partial partitions between n4 (liveness)
 	// and workload leaseholders n5-n7 for 1 minute each, 3 times per node for 9
 	// times total.
 	failer.Ready(ctx, m)
+	var handoffs []string
+	thrash := newLeaseThrashTracker(t, conn, `database_name = 'kv'`)
+	var thrashRows []string
 	m.Go(func(ctx context.Context) error {
 		var raftCfg base.RaftConfig
 		raftCfg.SetDefaults()
@@ -526,7 +1308,8 @@ func runFailoverPartialLeaseLiveness(
 		ticker := time.NewTicker(time.Minute)
 		defer ticker.Stop()
 
-		for i := 0; i < 3; i++ {
+		var cycle int
+		for i := 0; i < cycles; i++ {
 			for _, node := range []int{5, 6, 7} {
 				select {
 				case <-ticker.C:
@@ -541,7 +1324,7 @@ func runFailoverPartialLeaseLiveness(
 				relocateRanges(t, ctx, conn, `database_name = 'kv'`, []int{1, 2, 3, 4}, []int{5, 6, 7})
 				relocateRanges(t, ctx, conn, `database_name != 'kv'`, []int{node}, []int{1, 2, 3})
 				relocateRanges(t, ctx, conn, `range_id = 2`, []int{5, 6, 7}, []int{1, 2, 3, 4})
-				relocateLeases(t, ctx, conn, `range_id = 2`, 4)
+				require.NoError(t, relocateLeases(t, ctx, conn, `range_id = 2`, 4))
 
 				// Randomly sleep up to the lease renewal interval, to vary the time
 				// between the last lease renewal and the failure. We start the timer
@@ -552,14 +1335,40 @@ func runFailoverPartialLeaseLiveness(
 				}
 
 				t.Status(fmt.Sprintf("failing n%d (blackhole lease/liveness)", node))
+				thrash.start(ctx)
 				failer.FailPartial(ctx, node, []int{4})
 
+				// Measure the time until the partitioned node loses its leases.
+				// With expiration leases, the node can still extend its leases
+				// without liveness, so we don't expect it to lose them.
+				handoff, err := waitForLeaseHandoff(ctx, t, conn, `database_name = 'kv'`, node,
+					partialLivenessLeaseHandoffTimeout)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				} else if err != nil {
+					t.L().Printf("n%d: %s", node, err)
+					handoffs = append(handoffs, fmt.Sprintf("n%d: no handoff", node))
+				} else {
+					t.L().Printf("n%d: leases handed off after %s", node, handoff)
+					handoffs = append(handoffs, fmt.Sprintf("n%d: %s", node, handoff))
+				}
+				if !expLeases {
+					require.NoError(t, err)
+				}
+
 				select {
 				case <-ticker.C:
 				case <-ctx.Done():
 					return ctx.Err()
 				}
 
+				// Count the leaseholder changes during the partition.
+				report := thrash.stop()
+				partition := fmt.Sprintf("n%d/n4", node)
+				t.L().Printf("partition %s: %s", partition, report)
+				thrashRows = append(thrashRows, leaseThrashRow(cycle, partition, report))
+				cycle++
+
 				t.Status(fmt.Sprintf("recovering n%d (blackhole lease/liveness)", node))
 				failer.Recover(ctx, node)
 			}
@@ -567,6 +1376,198 @@ func runFailoverPartialLeaseLiveness(
 		return nil
 	})
 	m.Wait()
+	assertWorkloadHistograms(ctx, t, c, 8, t.PerfArtifactsDir()+"/stats.json")
+
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "lease-handoff.txt"),
+		[]byte(strings.Join(handoffs, "\n")+"\n"), 0644))
+	writeLeaseThrash(t, thrashRows)
+}
+
+// partialLivenessLeaseHandoffTimeout is the maximum time a leaseholder that is
+// partitioned from the liveness leaseholder may retain its epoch leases. This
+// is bounded by the liveness expiration interval, plus the time for another
+// replica to acquire Raft leadership and the lease. See also:
+// https://github.com/cockroachdb/cockroach/pull/87244.
+const partialLivenessLeaseHandoffTimeout = 30 * time.Second
+
+// waitForLeaseHandoff waits until the given node holds no leases for ranges
+// matching the given predicate, and returns the elapsed time. It returns an
+// error if the node still holds leases after the timeout.
+func waitForLeaseHandoff(
+	ctx context.Context,
+	t test.Test,
+	conn *gosql.DB,
+	predicate string,
+	nodeID int,
+	timeout time.Duration,
+) (time.Duration, error) {
+	query := fmt.Sprintf(`SELECT count(distinct range_id) FROM [SHOW CLUSTER RANGES WITH DETAILS] `+
+		`WHERE (%s) AND lease_holder = %d`, predicate, nodeID)
+	start := timeutil.Now()
+	var count int
+	for {
+		queryCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := conn.QueryRowContext(queryCtx, query).Scan(&count)
+		cancel()
+		if err != nil && ctx.Err() != nil {
+			return 0, ctx.Err()
+		} else if err == nil && count == 0 {
+			return timeutil.Since(start), nil
+		} else if err != nil {
+			t.L().Printf("failed to fetch leases on n%d: %s", nodeID, err)
+		}
+		if timeutil.Since(start) >= timeout {
+			return 0, errors.Errorf("n%d still holds %d leases after %s", nodeID, count, timeout)
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// leaseThrashInterval is the interval at which leaseThrashTracker samples
+// leaseholders.
+const leaseThrashInterval = time.Second
+
+// leaseThrashTracker samples the leaseholders of the ranges matching a
+// predicate during a failure window, and counts the number of leaseholder
+// changes. A lease that moves once in response to a failure is expected, but
+// leases that bounce back and forth between nodes (thrash) indicate that the
+// lease can't find a stable home, e.g. because the leaseholder keeps losing
+// its lease to a replica that can't actually serve it. See also:
+// https://github.com/cockroachdb/cockroach/pull/87244.
+type leaseThrashTracker struct {
+	t         test.Test
+	conn      *gosql.DB
+	predicate string
+
+	cancel context.CancelFunc
+	doneC  chan struct{}
+
+	// changes contains the number of leaseholder changes per range ID. It is
+	// written by the sampling goroutine, and can be read once doneC is closed.
+	changes map[int]int
+}
+
+// leaseThrashReport summarizes the leaseholder changes in a failure window.
+type leaseThrashReport struct {
+	changes   int // total leaseholder changes
+	ranges    int // ranges with at least one leaseholder change
+	maxChange int // maximum leaseholder changes for a single range
+}
+
+func (r leaseThrashReport) String() string {
+	return fmt.Sprintf("%d lease changes across %d ranges (max %d per range)",
+		r.changes, r.ranges, r.maxChange)
+}
+
+// newLeaseThrashTracker creates a leaseThrashTracker for the ranges matching
+// the given predicate.
+func newLeaseThrashTracker(t test.Test, conn *gosql.DB, predicate string) *leaseThrashTracker {
+	return &leaseThrashTracker{t: t, conn: conn, predicate: predicate}
+}
+
+// start starts sampling leaseholders in the background, until stop is called.
+func (lt *leaseThrashTracker) start(ctx context.Context) {
+	lt.changes = map[int]int{}
+	lt.doneC = make(chan struct{})
+	ctx, lt.cancel = context.WithCancel(ctx)
+	go func() {
+		defer close(lt.doneC)
+		lt.run(ctx)
+	}()
+}
+
+// stop stops sampling, and returns a report of the leaseholder changes.
+func (lt *leaseThrashTracker) stop() leaseThrashReport {
+	lt.cancel()
+	<-lt.doneC
+	var report leaseThrashReport
+	for _, changes := range lt.changes {
+		report.changes += changes
+		report.ranges++
+		if changes > report.maxChange {
+			report.maxChange = changes
+		}
+	}
+	return report
+}
+
+// leaseholders returns the current leaseholder of each range, omitting ranges
+// with an unknown leaseholder.
+func (lt *leaseThrashTracker) leaseholders(ctx context.Context) (map[int]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	rows, err := lt.conn.QueryContext(ctx, `SELECT DISTINCT range_id, lease_holder `+
+		`FROM [SHOW CLUSTER RANGES WITH TABLES, DETAILS] WHERE `+lt.predicate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	leaseholders := map[int]int{}
+	for rows.Next() {
+		var rangeID int
+		var leaseholder gosql.NullInt64
+		if err := rows.Scan(&rangeID, &leaseholder); err != nil {
+			return nil, err
+		}
+		if leaseholder.Valid && leaseholder.Int64 > 0 {
+			leaseholders[rangeID] = int(leaseholder.Int64)
+		}
+	}
+	return leaseholders, rows.Err()
+}
+
+func (lt *leaseThrashTracker) run(ctx context.Context) {
+	ticker := time.NewTicker(leaseThrashInterval)
+	defer ticker.Stop()
+
+	prev := map[int]int{}
+	for {
+		leaseholders, err := lt.leaseholders(ctx)
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
+			lt.t.L().Printf("failed to sample leaseholders: %s", err)
+		}
+		for rangeID, leaseholder := range leaseholders {
+			if p, ok := prev[rangeID]; ok && p != leaseholder {
+				lt.changes[rangeID]++
+			}
+			prev[rangeID] = leaseholder
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// writeLeaseThrash writes the given lease thrash CSV rows, with columns
+// cycle,partition,changes,ranges,max_range_changes, to lease-thrash.txt in the
+// artifacts directory.
+func writeLeaseThrash(t test.Test, rows []string) {
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "lease-thrash.txt"),
+		[]byte("cycle,partition,changes,ranges,max_range_changes\n"+strings.Join(rows, "")), 0644))
+}
+
+// leaseThrashNodes formats a node list for a lease-thrash.txt partition, e.g.
+// n6+n7.
+func leaseThrashNodes(nodes []int) string {
+	parts := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		parts = append(parts, fmt.Sprintf("n%d", node))
+	}
+	return strings.Join(parts, "+")
+}
+
+// leaseThrashRow formats a lease-thrash.txt row for writeLeaseThrash.
+func leaseThrashRow(cycle int, partition string, report leaseThrashReport) string {
+	return fmt.Sprintf("%d,%s,%d,%d,%d\n",
+		cycle+1, partition, report.changes, report.ranges, report.maxChange)
 }
 
 // runFailoverNonSystem benchmarks the maximum duration of range unavailability
@@ -582,264 +1583,930 @@ func runFailoverPartialLeaseLiveness(
 // since the last heartbeat and other variables, we run 9 failures and record
 // the pMax latency to find the upper bound on unavailability. We expect this
 // worst-case latency to be slightly larger than the lease interval (9s), to
-// account for lease acquisition and retry latencies. We do not assert this, but
-// instead export latency histograms for graphing.
+// account for lease acquisition and retry latencies. By default we do not
+// assert this, but instead export latency histograms for graphing. Variants
+// with failoverConfig.latencySLO assert it.
 //
-// The cluster layout is as follows:
+// The cluster layout is as follows, with the default replication factor of 3:
 //
 // n1-n3: System ranges and SQL gateways.
 // n4-n6: Workload ranges.
 // n7:    Workload runner.
 //
+// With a replication factor of R (see failoverConfig.replicas), n1-nR contain
+// the system ranges, nR+1-n2R the workload ranges, and n2R+1 runs the
+// workload.
+//
 // The test runs a kv50 workload with batch size 1, using 256 concurrent workers
 // directed at n1-n3 with a rate of 2048 reqs/s. n4-n6 fail and recover in
-// order, with 1 minute between each operation, for 3 cycles totaling 9
-// failures.
+// order, with 1 minute between each operation, for a total of 9 failures. The
+// failure detection and lease reacquisition latencies of each
+// failure are written to recovery.txt (see recoveryTracker). The test fails if
+// the workload completes too few operations (see assertWorkloadOpCount).
 func runFailoverNonSystem(
-	ctx context.Context, t test.Test, c cluster.Cluster, failureMode failureMode, expLeases bool,
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	failureMode failureMode,
+	expLeases bool,
+	cfg failoverConfig,
 ) {
-	require.Equal(t, 7, c.Spec().NodeCount)
+	// With a replication factor of R, n1-nR contain system ranges, nR+1-n2R
+	// contain the workload ranges, and n2R+1 runs the workload.
+	replicas := cfg.replicationFactor()
+	systemNodes := failoverNodeRange(1, replicas)
+	kvNodes := failoverNodeRange(replicas+1, replicas)
+	workloadNode := 2*replicas + 1
+	gateways := fmt.Sprintf(`{pgurl:1-%d}`, replicas)
+	require.Equal(t, workloadNode, c.Spec().NodeCount)
 
 	rng, _ := randutil.NewTestRand()
 
 	// Create cluster.
 	opts := option.DefaultStartOpts()
-	settings := install.MakeClusterSettings()
+	settings := cfg.clusterSettings()
+
+	// When reusing a pre-existing cluster, it must already be running with the
+	// expected placement, and be prepared for the failure mode.
+	reuse := failoverReuseCluster()
 
 	failer := makeFailer(t, c, failureMode, opts, settings)
-	failer.Setup(ctx)
+	if !reuse {
+		failer.Setup(ctx)
+	}
 	defer failer.Cleanup(ctx)
 
-	c.Put(ctx, t.Cockroach(), "./cockroach")
-	c.Start(ctx, t.L(), opts, settings, c.Range(1, 6))
+	// Collect the logs around the failures if the test fails.
+	failureLogs := newFailureLogCollector(t, c, c.Range(1, 2*replicas))
+	defer failureLogs.collectOnFailure()
 
-	conn := c.Conn(ctx, t.L(), 1)
+	// This test controls the ranges manually.
+	spec := failoverClusterSpec{manualSplits: true}
+	if !reuse {
+		spec.nodes = c.Range(1, 2*replicas)
+	}
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases, spec)
 	defer conn.Close()
 
-	// Configure cluster. This test controls the ranges manually.
-	t.Status("configuring cluster")
-	_, err := conn.ExecContext(ctx, `SET CLUSTER SETTING kv.range_split.by_load_enabled = 'false'`)
-	require.NoError(t, err)
-	_, err = conn.ExecContext(ctx, `SET CLUSTER SETTING kv.expiration_leases_only.enabled = $1`,
-		expLeases)
-	require.NoError(t, err)
+	if reuse {
+		// Skip range placement, but make sure the existing cluster has the
+		// topology the test expects.
+		t.Status("reusing existing cluster")
+		assertPlacement(t, ctx, conn, `database_name = 'kv'`, kvNodes)
+		assertPlacement(t, ctx, conn, `database_name IS DISTINCT FROM 'kv'`, systemNodes)
+	} else {
+		placeFailoverNonSystemRanges(ctx, t, c, conn, replicas, systemNodes, kvNodes, workloadNode)
+	}
 
-	// Constrain all existing zone configs to n1-n3.
-	configureAllZones(t, ctx, conn, zoneConfig{replicas: 3, onlyNodes: []int{1, 2, 3}})
+	// Start workload on the workload node, using the system nodes as gateways.
+	// Run it for 20 minutes, since we take ~2 minutes to fail and recover each
+	// node, and we do 9 failures.
+	const workloadDuration, workloadMaxRate = 20 * time.Minute, 2048
+	const cycles = 9
+	var histogramPaths, workloadCmds []string
+	if cfg.splitReadWrite {
+		// Run concurrent read-only and write-only workloads, splitting the
+		// concurrency and rate between them, and write their histograms to
+		// separate read/ and write/ directories.
+		for _, w := range []struct {
+			name        string
+			readPercent int
+		}{{"read", 100}, {"write", 0}} {
+			histogramsPath := fmt.Sprintf("%s/%s/stats.json", t.PerfArtifactsDir(), w.name)
+			histogramPaths = append(histogramPaths, histogramsPath)
+			workloadCmds = append(workloadCmds, fmt.Sprintf(`./cockroach workload run kv `+
+				`--read-percent %d --duration %s --concurrency 128 --max-rate %d --timeout 1m `+
+				`--tolerate-errors --histograms=%s%s`,
+				w.readPercent, workloadDuration, workloadMaxRate/2, histogramsPath,
+				cfg.workloadFlags()))
+		}
+	} else {
+		histogramsPath := t.PerfArtifactsDir() + "/stats.json"
+		histogramPaths = append(histogramPaths, histogramsPath)
+		workloadCmds = append(workloadCmds, fmt.Sprintf(`./cockroach workload run kv `+
+			`--read-percent %d --duration %s --concurrency 256 --max-rate %d --timeout 1m `+
+			`--tolerate-errors --histograms=%s%s%s`, cfg.readPercent(50), workloadDuration,
+			workloadMaxRate, histogramsPath, cfg.workloadFlags(), cfg.spanFlags()))
+	}
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureMode,
+		ExpirationLeases: expLeases,
+		Cycles:           cycles,
+		Workload:         strings.Join(workloadCmds, "; "),
+		Config:           cfg.manifest(),
+	})
+	recordRangeDistribution(ctx, t, c, conn, "pre-workload")
+	rangesBefore := recordRangeCount(ctx, t, conn)
 
-	// Wait for upreplication.
-	require.NoError(t, WaitFor3XReplication(ctx, t, conn))
+	m, _ := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 2*replicas), failoverWorkload{
+		name: "kv", node: workloadNode, gateways: systemNodes, cmds: workloadCmds})
 
-	// Create the kv database, constrained to n4-n6. Despite the zone config, the
-	// ranges will initially be distributed across all cluster nodes.
-	t.Status("creating workload database")
-	_, err = conn.ExecContext(ctx, `CREATE DATABASE kv`)
-	require.NoError(t, err)
-	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: []int{4, 5, 6}})
-	c.Run(ctx, c.Node(7), `./cockroach workload init kv --splits 1000 {pgurl:1}`)
+	// Start a worker to fail and recover the kv nodes in order. Latencies are
+	// measured from here, once the workload has warmed up.
+	measureStart := timeutil.Now()
+	rawErrors := &workloadErrorTaxonomy{}
+	failer.Ready(ctx, m)
 
-	// The replicate queue takes forever to move the kv ranges from n1-n3 to
-	// n4-n6, so we do it ourselves. Precreating the database/range and moving it
-	// to the correct nodes first is not sufficient, since workload will spread
-	// the ranges across all nodes regardless.
-	relocateRanges(t, ctx, conn, `database_name = 'kv'`, []int{1, 2, 3}, []int{4, 5, 6})
+	// Fail the test if a node dies outside of the intended failures.
+	deaths := newUnexpectedDeathChecker(t, conn, failureMode)
+	deaths.start(ctx, m)
+	defer deaths.stop()
+
+	// Track the failure detection and lease reacquisition latencies.
+	recovery := newRecoveryTracker(ctx, t, c, 1, kvNodes)
+	defer recovery.close()
+	var recoveryReports []string
+	var triggers []string
+
+	// Record the kv nodes' admission control metrics, if enabled. The stalled
+	// node would otherwise be killed by the disk stall detector before
+	// admission control has had much time to react.
+	var admission *admissionMetricsRecorder
+	admissionCtx, stopAdmission := context.WithCancel(ctx)
+	defer stopAdmission()
+	if cfg.admissionMetrics {
+		_, err := conn.ExecContext(ctx,
+			`SET CLUSTER SETTING storage.max_sync_duration.fatal.enabled = false`)
+		require.NoError(t, err)
+		admission = newAdmissionMetricsRecorder(t, conn, kvNodes)
+		m.Go(func(ctx context.Context) error {
+			admission.run(admissionCtx)
+			return nil
+		})
+	}
 
-	// Start workload on n7, using n1-n3 as gateways. Run it for 20
-	// minutes, since we take ~2 minutes to fail and recover each node, and
-	// we do 3 cycles of each of the 3 nodes in order.
-	t.Status("running workload")
-	m := c.NewMonitor(ctx, c.Range(1, 6))
 	m.Go(func(ctx context.Context) error {
-		c.Run(ctx, c.Node(7), `./cockroach workload run kv --read-percent 50 `+
-			`--duration 20m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors `+
-			`--histograms=`+t.PerfArtifactsDir()+`/stats.json `+
-			`{pgurl:1-3}`)
-		return nil
-	})
+		defer deaths.stop()
+		defer stopAdmission()
 
-	// Start a worker to fail and recover n4-n6 in order.
-	failer.Ready(ctx, m)
-	m.Go(func(ctx context.Context) error {
-		var raftCfg base.RaftConfig
-		raftCfg.SetDefaults()
+		raftCfg := cfg.raftConfig()
 
 		ticker := time.NewTicker(time.Minute)
 		defer ticker.Stop()
 
-		for i := 0; i < 3; i++ {
-			for _, node := range []int{4, 5, 6} {
-				select {
-				case <-ticker.C:
-				case <-ctx.Done():
-					return ctx.Err()
-				}
+		for cycle, node := range roundRobinFailures(kvNodes, cycles) {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 
-				randTimer := time.After(randutil.RandDuration(rng, raftCfg.RangeLeaseRenewalDuration()))
+			var randTimer <-chan time.Time
+			if !cfg.leaseTrigger {
+				randTimer = time.After(cfg.preFailureDelay(t, rng, raftCfg, cycle))
+			}
+			recovery.prepare(ctx, node)
 
-				// Ranges may occasionally escape their constraints. Move them
-				// to where they should be.
-				relocateRanges(t, ctx, conn, `database_name = 'kv'`, []int{1, 2, 3}, []int{4, 5, 6})
-				relocateRanges(t, ctx, conn, `database_name != 'kv'`, []int{node}, []int{1, 2, 3})
+			// Ranges may occasionally escape their constraints. Move them
+			// to where they should be.
+			relocateRanges(t, ctx, conn, `database_name = 'kv'`, systemNodes, kvNodes)
+			relocateRanges(t, ctx, conn, `database_name != 'kv'`, []int{node}, systemNodes)
+
+			// If enabled, wait for the node to acquire the hottest lease, falling
+			// back to the pre-failure delay if it doesn't.
+			if cfg.leaseTrigger {
+				trigger, ok := waitForLeaseTrigger(ctx, t, conn, node, leaseTriggerTimeout)
+				t.L().Printf("cycle %d: n%d trigger: %s", cycle+1, node, trigger)
+				triggers = append(triggers, fmt.Sprintf("cycle %d: n%d: %s", cycle+1, node, trigger))
+				if !ok {
+					randTimer = time.After(cfg.preFailureDelay(t, rng, raftCfg, cycle))
+				}
+			}
 
-				// Randomly sleep up to the lease renewal interval, to vary the time
-				// between the last lease renewal and the failure. We start the timer
-				// before the range relocation above to run them concurrently.
+			// Sleep before the failure, by default for a random duration up to the
+			// lease renewal interval (see preFailureDelay). We start the timer before
+			// the range relocation above to run them concurrently.
+			if randTimer != nil {
 				select {
 				case <-randTimer:
 				case <-ctx.Done():
 				}
+			}
 
-				t.Status(fmt.Sprintf("failing n%d (%s)", node, failureMode))
-				failer.Fail(ctx, node)
-
-				select {
-				case <-ticker.C:
-				case <-ctx.Done():
-					return ctx.Err()
+			// For planned failures, move the leases off of the node first.
+			if cfg.planned {
+				t.Status(fmt.Sprintf("moving leases off of n%d", node))
+				others := make([]int, 0, len(kvNodes)-1)
+				for _, n := range kvNodes {
+					if n != node {
+						others = append(others, n)
+					}
 				}
+				require.NoError(t, transferLeasesAway(t, ctx, conn, `database_name = 'kv'`, node, others))
+			}
 
-				t.Status(fmt.Sprintf("recovering n%d (%s)", node, failureMode))
-				failer.Recover(ctx, node)
+			t.Status(fmt.Sprintf("failing n%d (%s)", node, failureMode))
+			deaths.failing(node)
+			recovery.failed(ctx)
+			restartsBefore := cfg.txnRestarts(ctx, t, c, systemNodes)
+			failStart := timeutil.Now()
+			failureLogs.failing(cycle, node, failureMode)
+			failer.Fail(ctx, node)
+			if admission != nil {
+				admission.setFailed(node)
+			}
+			cycleDir := failoverCycleArtifactsDir(t, cycle)
+			cfg.captureRawErrors(ctx, t, c, workloadNode, gateways, cycleDir, rawErrors)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			cfg.reportTxnRestarts(t, cycleDir, node, restartsBefore,
+				cfg.txnRestarts(ctx, t, c, systemNodes), timeutil.Since(failStart))
+
+			report := recovery.stop()
+			t.L().Printf("recovery latencies: %s", report)
+			recoveryReports = append(recoveryReports, report)
+			require.NoError(t, os.WriteFile(
+				filepath.Join(cycleDir, "recovery.txt"), []byte(report+"\n"), 0644))
+
+			cfg.captureGoroutineDump(ctx, t, c, failureMode, node, cycleDir)
+			t.Status(fmt.Sprintf("recovering n%d (%s)", node, failureMode))
+			failer.Recover(ctx, node)
+			if admission != nil {
+				admission.setFailed(0)
+			}
+			failureLogs.recovered(node)
+			deaths.recovered(node)
+			if failureMode == failureModeCrash {
+				require.NoError(t, waitForNodeRejoin(ctx, t, conn, node, nodeRejoinTimeout))
 			}
+			cfg.waitAfterRecovery(ctx, t, conn)
+			captureCycleArtifacts(ctx, t, conn, cycleDir, zoneConfigs)
 		}
 		return nil
 	})
 	m.Wait()
+	cfg.reportRawErrors(t, rawErrors)
+	if admission != nil {
+		require.NoError(t, admission.writeCSV(filepath.Join(t.ArtifactsDir(), "admission.csv")))
+		admissionSummary := admission.summary()
+		t.L().Printf("admission control:\n%s", admissionSummary)
+		require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "admission.txt"),
+			[]byte(admissionSummary), 0644))
+	}
+	workloadHistograms := fetchWorkloadHistograms(ctx, t, c, workloadNode, histogramPaths)
+	cfg.assertWorkloadOpCount(t, workloadHistograms, workloadMaxRate, workloadDuration)
+	cfg.assertLatencySLO(t, workloadHistograms, measureStart)
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "recovery.txt"),
+		[]byte(strings.Join(recoveryReports, "\n")+"\n"), 0644))
+	if cfg.leaseTrigger {
+		require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "failure-triggers.txt"),
+			[]byte(strings.Join(triggers, "\n")+"\n"), 0644))
+	}
+	recordRangeDistribution(ctx, t, c, conn, "post-test")
+	assertRangeCountStable(t, rangesBefore, recordRangeCount(ctx, t, conn), rangeCountDriftTolerance)
+
+	// Repeated failovers shouldn't leave leases piled up on a subset of nodes.
+	assertLeaseBalance(ctx, t, conn, kvNodes, 0.5)
 }
 
-// runFailoverLiveness benchmarks the maximum duration of *user* range
-// unavailability following a liveness-only leaseholder failure. When the
-// liveness range becomes unavailable, other nodes are unable to heartbeat and
-// extend their leases, and their leases may thus expire as well making them
-// unavailable.
-//
-//   - Only liveness range located on the failed node, as leaseholder.
-//
-//   - SQL clients do not connect to the failed node.
-//
-//   - The workload consists of individual point reads and writes.
-//
-// Since the range unavailability is probabilistic, depending e.g. on the time
-// since the last heartbeat and other variables, we run 9 failures and record
-// the number of expired leases on n1-n3 as well as the pMax latency to find the
-// upper bound on unavailability. We do not assert anything, but instead export
-// metrics for graphing.
-//
-// The cluster layout is as follows:
-//
-// n1-n3: All ranges, including liveness.
-// n4:    Liveness range leaseholder.
-// n5:    Workload runner.
-//
-// The test runs a kv50 workload with batch size 1, using 256 concurrent workers
-// directed at n1-n3 with a rate of 2048 reqs/s. n4 fails and recovers, with 1
-// minute between each operation, for 9 cycles.
-//
-// TODO(erikgrinaker): The metrics resolution of 10 seconds isn't really good
-// enough to accurately measure the number of invalid leases, but it's what we
-// have currently. Prometheus scraping more often isn't enough, because CRDB
-// itself only samples every 10 seconds.
-func runFailoverLiveness(
-	ctx context.Context, t test.Test, c cluster.Cluster, failureMode failureMode, expLeases bool,
+// placeFailoverNonSystemRanges sets up the ranges for runFailoverNonSystem:
+// system ranges are constrained to the system nodes, and the kv workload
+// database is created and moved to the kv nodes.
+func placeFailoverNonSystemRanges(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	conn *gosql.DB,
+	replicas int,
+	systemNodes, kvNodes []int,
+	workloadNode int,
 ) {
-	require.Equal(t, 5, c.Spec().NodeCount)
-
-	rng, _ := randutil.NewTestRand()
+	// Constrain all existing zone configs to the system nodes.
+	configureAllZones(t, ctx, conn, zoneConfig{replicas: replicas, onlyNodes: systemNodes})
 
-	// Create cluster. Don't schedule a backup as this roachtest reports to roachperf.
-	opts := option.DefaultStartOptsNoBackups()
-	settings := install.MakeClusterSettings()
-
-	failer := makeFailer(t, c, failureMode, opts, settings)
-	failer.Setup(ctx)
-	defer failer.Cleanup(ctx)
-
-	c.Put(ctx, t.Cockroach(), "./cockroach")
-	c.Start(ctx, t.L(), opts, settings, c.Range(1, 4))
-
-	conn := c.Conn(ctx, t.L(), 1)
-	defer conn.Close()
+	// Wait for upreplication.
+	require.NoError(t, WaitForReplication(ctx, t, conn, replicas))
+	requireFullyReplicated(ctx, t, conn)
 
-	// Configure cluster. This test controls the ranges manually.
-	t.Status("configuring cluster")
-	_, err := conn.ExecContext(ctx, `SET CLUSTER SETTING kv.range_split.by_load_enabled = 'false'`)
-	require.NoError(t, err)
-	_, err = conn.ExecContext(ctx, `SET CLUSTER SETTING kv.expiration_leases_only.enabled = $1`,
-		expLeases)
+	// Create the kv database, constrained to the kv nodes. Despite the zone
+	// config, the ranges will initially be distributed across all cluster nodes.
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
 	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: replicas, onlyNodes: kvNodes})
+	c.Run(ctx, c.Node(workloadNode), `./cockroach workload init kv --splits 1000 {pgurl:1}`)
+
+	// The replicate queue takes forever to move the kv ranges from the system
+	// nodes to the kv nodes, so we do it ourselves. Precreating the
+	// database/range and moving it to the correct nodes first is not
+	// sufficient, since workload will spread the ranges across all nodes
+	// regardless.
+	relocateRanges(t, ctx, conn, `database_name = 'kv'`, systemNodes, kvNodes)
+
+	// Wait for any ongoing rebalancing to settle before starting the workload.
+	require.NoError(t, waitForReplicateQueueIdle(ctx, t, conn, replicateQueueIdleTimeout))
+}
 
-	// Constrain all existing zone configs to n1-n3.
-	configureAllZones(t, ctx, conn, zoneConfig{replicas: 3, onlyNodes: []int{1, 2, 3}})
+// envFailoverScenarioDir, if set to a directory, registers the failover
+// scenarios in its *.json files in addition to the built-in failoverScenarios.
+// See failoverScenario for the format.
+const envFailoverScenarioDir = "ROACHTEST_FAILOVER_SCENARIO_DIR"
+
+// failoverScenarios are the built-in declarative failover scenarios, registered
+// as failover/scenario/<name>. Simple scenarios should be added here rather than
+// as new run functions.
+var failoverScenarios = []string{
+	// non-system-crash is equivalent to failover/non-system/crash.
+	`{
+		"name": "non-system-crash",
+		"nodes": 7,
+		"failure_mode": "crash",
+		"system_zone": {"replicas": 3, "only_nodes": [1, 2, 3]},
+		"workload_zone": {"replicas": 3, "only_nodes": [4, 5, 6]},
+		"workload": {
+			"node": 7,
+			"gateways": [1, 2, 3],
+			"splits": 1000,
+			"read_percent": 50,
+			"concurrency": 256,
+			"max_rate": 2048,
+			"duration": "20m"
+		},
+		"schedule": {"nodes": [4, 5, 6], "cycles": 9, "interval": "1m"}
+	}`,
+	// non-system-blackhole-lease fails the workload leaseholder, with all
+	// workload leases pinned to n4.
+	`{
+		"name": "non-system-blackhole-lease",
+		"nodes": 7,
+		"failure_mode": "blackhole",
+		"system_zone": {"replicas": 3, "only_nodes": [1, 2, 3]},
+		"workload_zone": {"replicas": 3, "only_nodes": [4, 5, 6], "lease_node": 4},
+		"workload": {
+			"node": 7,
+			"gateways": [1, 2, 3],
+			"splits": 1000,
+			"read_percent": 50,
+			"concurrency": 256,
+			"max_rate": 2048,
+			"duration": "10m"
+		},
+		"schedule": {"nodes": [4], "cycles": 4, "interval": "1m"}
+	}`,
+}
 
-	// Constrain the liveness range to n1-n4, with leaseholder preference on n4.
-	configureZone(t, ctx, conn, `RANGE liveness`, zoneConfig{replicas: 4, leaseNode: 4})
-	require.NoError(t, err)
+// failoverScenario is a declarative failover scenario, parsed from JSON by
+// parseFailoverScenario and run by runFailoverScenario. It places the system
+// ranges and the kv workload database on the given nodes, runs a kv workload,
+// and fails and recovers the scheduled nodes in order.
+type failoverScenario struct {
+	// Name is the test name, as failover/scenario/<name>.
+	Name string `json:"name"`
+	// Nodes is the number of nodes in the cluster, including the workload node.
+	Nodes int `json:"nodes"`
+	// FailureMode is the failure mode to inject.
+	FailureMode failureMode `json:"failure_mode"`
+	// SystemZone is applied to all existing zone configs.
+	SystemZone failoverScenarioZone `json:"system_zone"`
+	// WorkloadZone is applied to the kv database.
+	WorkloadZone failoverScenarioZone     `json:"workload_zone"`
+	Workload     failoverScenarioWorkload `json:"workload"`
+	Schedule     failoverScenarioSchedule `json:"schedule"`
+}
 
-	// Wait for upreplication.
-	require.NoError(t, WaitFor3XReplication(ctx, t, conn))
+// failoverScenarioZone is a zoneConfig in a failoverScenario.
+type failoverScenarioZone struct {
+	Replicas  int   `json:"replicas"`
+	OnlyNodes []int `json:"only_nodes"`
+	LeaseNode int   `json:"lease_node,omitempty"`
+}
 
-	// Create the kv database, constrained to n1-n3. Despite the zone config, the
-	// ranges will initially be distributed across all cluster nodes.
-	t.Status("creating workload database")
-	_, err = conn.ExecContext(ctx, `CREATE DATABASE kv`)
-	require.NoError(t, err)
-	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: []int{1, 2, 3}})
-	c.Run(ctx, c.Node(5), `./cockroach workload init kv --splits 1000 {pgurl:1}`)
+// failoverScenarioWorkload is the kv workload in a failoverScenario.
+type failoverScenarioWorkload struct {
+	// Node runs the workload, and does not run CockroachDB.
+	Node        int                      `json:"node"`
+	Gateways    []int                    `json:"gateways"`
+	Splits      int                      `json:"splits"`
+	ReadPercent int                      `json:"read_percent"`
+	Concurrency int                      `json:"concurrency"`
+	MaxRate     int                      `json:"max_rate"`
+	Duration    failoverScenarioDuration `json:"duration"`
+}
 
-	// The replicate queue takes forever to move the other ranges off of n4 so we
-	// do it ourselves. Precreating the database/range and moving it to the
-	// correct nodes first is not sufficient, since workload will spread the
-	// ranges across all nodes regardless.
-	relocateRanges(t, ctx, conn, `range_id != 2`, []int{4}, []int{1, 2, 3})
+// failoverScenarioSchedule is the failure schedule in a failoverScenario. The
+// nodes are failed in order, round-robin, for the given number of cycles.
+type failoverScenarioSchedule struct {
+	Nodes    []int                    `json:"nodes"`
+	Cycles   int                      `json:"cycles"`
+	Interval failoverScenarioDuration `json:"interval"`
+}
 
-	// We also make sure the lease is located on n4.
-	relocateLeases(t, ctx, conn, `range_id = 2`, 4)
+// failoverScenarioDuration is a time.Duration encoded as a JSON string, e.g.
+// "1m30s".
+type failoverScenarioDuration time.Duration
 
-	// Start workload on n7, using n1-n3 as gateways. Run it for 20 minutes, since
-	// we take ~2 minutes to fail and recover the node, and we do 9 cycles.
-	t.Status("running workload")
-	m := c.NewMonitor(ctx, c.Range(1, 4))
-	m.Go(func(ctx context.Context) error {
-		c.Run(ctx, c.Node(5), `./cockroach workload run kv --read-percent 50 `+
-			`--duration 20m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors `+
-			`--histograms=`+t.PerfArtifactsDir()+`/stats.json `+
-			`{pgurl:1-3}`)
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *failoverScenarioDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = failoverScenarioDuration(duration)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d failoverScenarioDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// parseFailoverScenario parses and validates a JSON failover scenario.
+func parseFailoverScenario(data []byte) (failoverScenario, error) {
+	var s failoverScenario
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return failoverScenario{}, errors.Wrap(err, "parsing failover scenario")
+	}
+	if err := s.validate(); err != nil {
+		return failoverScenario{}, errors.Wrapf(err, "invalid failover scenario %q", s.Name)
+	}
+	return s, nil
+}
+
+// validate checks that the scenario is well-formed.
+func (s failoverScenario) validate() error {
+	if s.Name == "" {
+		return errors.New("missing name")
+	}
+	if s.Nodes < 2 {
+		return errors.Newf("need at least 2 nodes, got %d", s.Nodes)
+	}
+	var knownMode bool
+	for _, mode := range failureModes {
+		knownMode = knownMode || s.FailureMode == mode
+	}
+	if !knownMode {
+		return errors.Newf("unknown failure mode %q", s.FailureMode)
+	}
+	w := s.Workload
+	if w.Node < 1 || w.Node > s.Nodes {
+		return errors.Newf("workload node n%d not in cluster", w.Node)
+	}
+	checkNodes := func(field string, nodes []int) error {
+		if len(nodes) == 0 {
+			return errors.Newf("%s: no nodes", field)
+		}
+		for _, n := range nodes {
+			if n < 1 || n > s.Nodes || n == w.Node {
+				return errors.Newf("%s: n%d is not a CockroachDB node", field, n)
+			}
+		}
 		return nil
+	}
+	for _, z := range []struct {
+		field string
+		zone  failoverScenarioZone
+	}{{"system_zone", s.SystemZone}, {"workload_zone", s.WorkloadZone}} {
+		field, zone := z.field, z.zone
+		if zone.Replicas < 1 {
+			return errors.Newf("%s: need at least 1 replica, got %d", field, zone.Replicas)
+		}
+		if err := checkNodes(field, zone.OnlyNodes); err != nil {
+			return err
+		}
+		if zone.LeaseNode != 0 && !failoverNodesContain(zone.OnlyNodes, zone.LeaseNode) {
+			return errors.Newf("%s: lease node n%d not in only_nodes", field, zone.LeaseNode)
+		}
+	}
+	if err := checkNodes("workload.gateways", w.Gateways); err != nil {
+		return err
+	}
+	if w.Concurrency < 1 || w.MaxRate < 1 || w.Duration <= 0 {
+		return errors.New("workload: concurrency, max_rate and duration must be positive")
+	}
+	if w.ReadPercent < 0 || w.ReadPercent > 100 {
+		return errors.Newf("workload: read_percent %d not in [0,100]", w.ReadPercent)
+	}
+	if err := checkNodes("schedule", s.Schedule.Nodes); err != nil {
+		return err
+	}
+	for _, n := range s.Schedule.Nodes {
+		if failoverNodesContain(w.Gateways, n) {
+			return errors.Newf("schedule: n%d is a workload gateway", n)
+		}
+	}
+	if s.Schedule.Cycles < 1 || s.Schedule.Interval <= 0 {
+		return errors.New("schedule: cycles and interval must be positive")
+	}
+	return nil
+}
+
+// failoverNodesContain returns true if nodes contains node.
+func failoverNodesContain(nodes []int, node int) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// cockroachNodes returns the nodes running CockroachDB, i.e. all but the
+// workload node.
+func (s failoverScenario) cockroachNodes() []int {
+	nodes := make([]int, 0, s.Nodes-1)
+	for n := 1; n <= s.Nodes; n++ {
+		if n != s.Workload.Node {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// zoneConfigs returns the zone configs for all existing zones and for the kv
+// database.
+func (s failoverScenario) zoneConfigs() (system, workload zoneConfig) {
+	toZoneConfig := func(z failoverScenarioZone) zoneConfig {
+		return zoneConfig{replicas: z.Replicas, onlyNodes: z.OnlyNodes, leaseNode: z.LeaseNode}
+	}
+	return toZoneConfig(s.SystemZone), toZoneConfig(s.WorkloadZone)
+}
+
+// schedule returns the failure schedule.
+func (s failoverScenario) schedule() failoverSchedule {
+	return failoverSchedule{
+		nodes:    roundRobinFailures(s.Schedule.Nodes, s.Schedule.Cycles),
+		interval: time.Duration(s.Schedule.Interval),
+	}
+}
+
+// workloadCmd returns the kv workload command, writing histograms to the given
+// path. It excludes the connection URLs.
+func (s failoverScenario) workloadCmd(histogramsPath string) string {
+	w := s.Workload
+	return fmt.Sprintf(`./cockroach workload run kv --read-percent %d --duration %s `+
+		`--concurrency %d --max-rate %d --timeout 1m --tolerate-errors --histograms=%s`,
+		w.ReadPercent, time.Duration(w.Duration), w.Concurrency, w.MaxRate, histogramsPath)
+}
+
+// loadFailoverScenarios parses the built-in failoverScenarios, and any in the
+// directory given by envFailoverScenarioDir.
+func loadFailoverScenarios() ([]failoverScenario, error) {
+	var scenarios []failoverScenario
+	for _, data := range failoverScenarios {
+		s, err := parseFailoverScenario([]byte(data))
+		if err != nil {
+			return nil, err
+		}
+		scenarios = append(scenarios, s)
+	}
+	if dir := os.Getenv(envFailoverScenarioDir); dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			s, err := parseFailoverScenario(data)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s", path)
+			}
+			scenarios = append(scenarios, s)
+		}
+	}
+	return scenarios, nil
+}
+
+// runFailoverScenario runs a declarative failover scenario. See
+// failoverScenario.
+func runFailoverScenario(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	scenario failoverScenario,
+	expLeases bool,
+	cfg failoverConfig,
+) {
+	require.Equal(t, scenario.Nodes, c.Spec().NodeCount)
+
+	rng, _ := randutil.NewTestRand()
+	cockroachNodes := c.Nodes(scenario.cockroachNodes()...)
+	systemZone, workloadZone := scenario.zoneConfigs()
+	workloadNode := scenario.Workload.Node
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := cfg.clusterSettings()
+
+	failer := makeFailer(t, c, scenario.FailureMode, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// This test controls the ranges manually.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{
+			nodes: cockroachNodes, gateway: scenario.Workload.Gateways[0], manualSplits: true})
+	defer conn.Close()
+
+	// Place the system ranges and the kv database, as in
+	// placeFailoverNonSystemRanges.
+	configureAllZones(t, ctx, conn, systemZone)
+	require.NoError(t, WaitForReplication(ctx, t, conn, systemZone.replicas))
+	requireFullyReplicated(ctx, t, conn)
+
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, workloadZone)
+	c.Run(ctx, c.Node(workloadNode), fmt.Sprintf(`./cockroach workload init kv --splits %d {pgurl:%d}`,
+		scenario.Workload.Splits, scenario.Workload.Gateways[0]))
+
+	// relocate moves ranges and leases that have escaped their constraints back
+	// to where they should be.
+	relocate := func() {
+		relocateRanges(t, ctx, conn, `database_name = 'kv'`, systemZone.onlyNodes, workloadZone.onlyNodes)
+		relocateRanges(t, ctx, conn, `database_name IS DISTINCT FROM 'kv'`,
+			workloadZone.onlyNodes, systemZone.onlyNodes)
+		if workloadZone.leaseNode > 0 {
+			require.NoError(t, relocateLeases(t, ctx, conn, `database_name = 'kv'`, workloadZone.leaseNode))
+		}
+		if systemZone.leaseNode > 0 {
+			require.NoError(t, relocateLeases(t, ctx, conn, `database_name IS DISTINCT FROM 'kv'`,
+				systemZone.leaseNode))
+		}
+	}
+	relocate()
+
+	schedule := scenario.schedule()
+	histogramsPath := t.PerfArtifactsDir() + "/stats.json"
+	workloadCmd := scenario.workloadCmd(histogramsPath)
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      scenario.FailureMode,
+		ExpirationLeases: expLeases,
+		Cycles:           len(schedule.nodes),
+		Workload:         workloadCmd,
+		Scenario:         &scenario,
+		Config:           cfg.manifest(),
 	})
 
-	// Start a worker to fail and recover n4.
+	m, _ := startFailoverWorkload(ctx, t, c, conn, cockroachNodes, failoverWorkload{
+		name: "kv", node: workloadNode, gateways: scenario.Workload.Gateways,
+		cmds: []string{workloadCmd}})
+
 	failer.Ready(ctx, m)
+
+	// Fail the test if a node dies outside of the intended failures.
+	deaths := newUnexpectedDeathChecker(t, conn, scenario.FailureMode)
+	deaths.start(ctx, m)
+	defer deaths.stop()
+
+	raftCfg := failoverConfig{}.raftConfig()
 	m.Go(func(ctx context.Context) error {
-		var raftCfg base.RaftConfig
-		raftCfg.SetDefaults()
+		defer deaths.stop()
+		return runFailoverSchedule(ctx, t, conn, failer, scenario.FailureMode, schedule, deaths,
+			func(cycle, node int) {
+				// Sleep for a random duration up to the lease renewal interval, as
+				// the hand-written tests do, while relocating ranges.
+				delay := time.After(failoverConfig{}.preFailureDelay(t, rng, raftCfg, cycle))
+				relocate()
+				select {
+				case <-delay:
+				case <-ctx.Done():
+				}
+			})
+	})
+	m.Wait()
+	assertWorkloadHistograms(ctx, t, c, workloadNode, histogramsPath)
+}
+
+// failoverSchedule is a failure schedule, run by runFailoverSchedule.
+type failoverSchedule struct {
+	// nodes are the nodes to fail and recover, one per cycle.
+	nodes []int
+	// interval is the time to wait before each failure and each recovery.
+	interval time.Duration
+}
+
+// roundRobinFailures returns the node to fail in each of the given number of
+// cycles, cycling through the given nodes in order.
+func roundRobinFailures(nodes []int, cycles int) []int {
+	schedule := make([]int, 0, cycles)
+	for cycle := 0; cycle < cycles; cycle++ {
+		schedule = append(schedule, nodes[cycle%len(nodes)])
+	}
+	return schedule
+}
+
+// runFailoverSchedule fails and recovers the nodes in the schedule in order,
+// waiting for the schedule interval before each failure and each recovery.
+// beforeFailure, if given, is called right before each failure. Crashed nodes
+// must rejoin the cluster before the next cycle.
+func runFailoverSchedule(
+	ctx context.Context,
+	t test.Test,
+	conn *gosql.DB,
+	failer failer,
+	failureMode failureMode,
+	schedule failoverSchedule,
+	deaths *unexpectedDeathChecker,
+	beforeFailure func(cycle, node int),
+) error {
+	ticker := time.NewTicker(schedule.interval)
+	defer ticker.Stop()
+
+	for cycle, node := range schedule.nodes {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if beforeFailure != nil {
+			beforeFailure(cycle, node)
+		}
+
+		t.Status(fmt.Sprintf("failing n%d (%s)", node, failureMode))
+		deaths.failing(node)
+		failer.Fail(ctx, node)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		t.Status(fmt.Sprintf("recovering n%d (%s)", node, failureMode))
+		failer.Recover(ctx, node)
+		deaths.recovered(node)
+		if failureMode == failureModeCrash {
+			if err := waitForNodeRejoin(ctx, t, conn, node, nodeRejoinTimeout); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runFailoverLiveness benchmarks the maximum duration of *user* range
+// unavailability following a liveness-only leaseholder failure. When the
+// liveness range becomes unavailable, other nodes are unable to heartbeat and
+// extend their leases, and their leases may thus expire as well making them
+// unavailable.
+//
+//   - Only liveness range located on the failed node, as leaseholder.
+//
+//   - SQL clients do not connect to the failed node.
+//
+//   - The workload consists of individual point reads and writes.
+//
+// Since the range unavailability is probabilistic, depending e.g. on the time
+// since the last heartbeat and other variables, we run 9 failures and record
+// the number of expired leases on n1-n3 as well as the pMax latency to find the
+// upper bound on unavailability. We do not assert on these, but instead export
+// metrics for graphing. We do however assert that all nodes resume
+// heartbeating their liveness records after each recovery, and that the
+// expired leases on n1-n3 clear.
+//
+// The cluster layout is as follows, with the default replication factor of 3:
+//
+// n1-n3: All ranges, including liveness.
+// n4:    Liveness range leaseholder.
+// n5:    Workload runner.
+//
+// With a replication factor of R (see failoverConfig.replicas), n1-nR contain
+// all ranges, nR+1 is the liveness range leaseholder, and nR+2 runs the
+// workload.
+//
+// The test runs a kv50 workload with batch size 1, using 256 concurrent workers
+// directed at n1-n3 with a rate of 2048 reqs/s. n4 fails and recovers, with 1
+// minute between each operation, for 9 cycles.
+//
+// TODO(erikgrinaker): The metrics resolution of 10 seconds isn't really good
+// enough to accurately measure the number of invalid leases, but it's what we
+// have currently. Prometheus scraping more often isn't enough, because CRDB
+// itself only samples every 10 seconds.
+func runFailoverLiveness(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	failureMode failureMode,
+	expLeases bool,
+	cfg failoverConfig,
+) {
+	// With a replication factor of R, n1-nR contain all ranges, nR+1 is the
+	// liveness leaseholder, and nR+2 runs the workload.
+	replicas := cfg.replicationFactor()
+	nodes := failoverNodeRange(1, replicas)
+	livenessNode := replicas + 1
+	workloadNode := replicas + 2
+	gateways := fmt.Sprintf(`{pgurl:1-%d}`, replicas)
+	require.Equal(t, workloadNode, c.Spec().NodeCount)
+
+	rng, _ := randutil.NewTestRand()
+
+	// Create cluster. Don't schedule a backup as this roachtest reports to roachperf.
+	opts := option.DefaultStartOptsNoBackups()
+	settings := cfg.clusterSettings()
+
+	failer := makeFailer(t, c, failureMode, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// Collect the logs around the failures if the test fails.
+	failureLogs := newFailureLogCollector(t, c, c.Range(1, livenessNode))
+	defer failureLogs.collectOnFailure()
+
+	// Place all ranges on n1-nR. This test controls the ranges manually.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{
+			nodes:        c.Range(1, livenessNode),
+			manualSplits: true,
+			systemNodes:  nodes,
+			replicas:     replicas,
+		})
+	defer conn.Close()
+
+	// Constrain the liveness range to n1-nR+1, with leaseholder preference on
+	// nR+1.
+	configureZone(t, ctx, conn, `RANGE liveness`, zoneConfig{
+		replicas: replicas + 1, leaseNode: livenessNode})
+	requireFullyReplicated(ctx, t, conn)
+
+	// Create the kv database, constrained to n1-nR. Despite the zone config, the
+	// ranges will initially be distributed across all cluster nodes.
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: replicas, onlyNodes: nodes})
+	c.Run(ctx, c.Node(workloadNode), `./cockroach workload init kv --splits 1000 {pgurl:1}`)
+
+	// The replicate queue takes forever to move the other ranges off of the
+	// liveness node so we do it ourselves. Precreating the database/range and
+	// moving it to the correct nodes first is not sufficient, since workload
+	// will spread the ranges across all nodes regardless.
+	relocateRanges(t, ctx, conn, `range_id != 2`, []int{livenessNode}, nodes)
+
+	// We also make sure the lease is located on the liveness node.
+	require.NoError(t, relocateLeases(t, ctx, conn, `range_id = 2`, livenessNode))
+
+	const cycles = 9
+	workloadCmd := `./cockroach workload run kv --read-percent 50 ` +
+		`--duration 20m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors ` +
+		`--histograms=` + t.PerfArtifactsDir() + `/stats.json`
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureMode,
+		ExpirationLeases: expLeases,
+		Cycles:           cycles,
+		Workload:         workloadCmd,
+		Config:           cfg.manifest(),
+	})
+	rangesBefore := recordRangeCount(ctx, t, conn)
+
+	// Start workload on the workload node, using n1-nR as gateways. Run it for
+	// 20 minutes, since we take ~2 minutes to fail and recover the node, and we
+	// do 9 cycles.
+	m, _ := startFailoverWorkload(ctx, t, c, conn, c.Range(1, livenessNode), failoverWorkload{
+		name: "kv", node: workloadNode, gateways: nodes, cmds: []string{workloadCmd}})
+
+	// Start a worker to fail and recover the liveness node.
+	rawErrors := &workloadErrorTaxonomy{}
+	failer.Ready(ctx, m)
+	m.Go(func(ctx context.Context) error {
+		raftCfg := cfg.raftConfig()
 
 		ticker := time.NewTicker(time.Minute)
 		defer ticker.Stop()
 
-		for i := 0; i < 9; i++ {
+		for i := 0; i < cycles; i++ {
 			select {
 			case <-ticker.C:
 			case <-ctx.Done():
 				return ctx.Err()
 			}
 
-			randTimer := time.After(randutil.RandDuration(rng, raftCfg.RangeLeaseRenewalDuration()))
+			randTimer := time.After(cfg.preFailureDelay(t, rng, raftCfg, i))
 
 			// Ranges and leases may occasionally escape their constraints. Move them
 			// to where they should be.
-			relocateRanges(t, ctx, conn, `range_id != 2`, []int{4}, []int{1, 2, 3})
-			relocateLeases(t, ctx, conn, `range_id = 2`, 4)
+			relocateRanges(t, ctx, conn, `range_id != 2`, []int{livenessNode}, nodes)
+			require.NoError(t, relocateLeases(t, ctx, conn, `range_id = 2`, livenessNode))
 
-			// Randomly sleep up to the lease renewal interval, to vary the time
-			// between the last lease renewal and the failure. We start the timer
-			// before the range relocation above to run them concurrently.
+			// Sleep before the failure, by default for a random duration up to the
+			// lease renewal interval (see preFailureDelay). We start the timer before
+			// the range relocation above to run them concurrently.
 			select {
 			case <-randTimer:
 			case <-ctx.Done():
 			}
 
-			t.Status(fmt.Sprintf("failing n%d (%s)", 4, failureMode))
-			failer.Fail(ctx, 4)
+			t.Status(fmt.Sprintf("failing n%d (%s)", livenessNode, failureMode))
+			failureLogs.failing(i, livenessNode, failureMode)
+			failer.Fail(ctx, livenessNode)
+			cycleDir := failoverCycleArtifactsDir(t, i)
+			cfg.captureRawErrors(ctx, t, c, workloadNode, gateways, cycleDir, rawErrors)
 
 			select {
 			case <-ticker.C:
@@ -847,119 +2514,167 @@ func runFailoverLiveness(
 				return ctx.Err()
 			}
 
-			t.Status(fmt.Sprintf("recovering n%d (%s)", 4, failureMode))
-			failer.Recover(ctx, 4)
-			relocateLeases(t, ctx, conn, `range_id = 2`, 4)
+			t.Status(fmt.Sprintf("recovering n%d (%s)", livenessNode, failureMode))
+			failer.Recover(ctx, livenessNode)
+			failureLogs.recovered(livenessNode)
+			if failureMode == failureModeCrash {
+				require.NoError(t, waitForNodeRejoin(ctx, t, conn, livenessNode, nodeRejoinTimeout))
+			}
+			require.NoError(t, waitForAllNodesLive(ctx, t, conn, allNodesLiveTimeout))
+			require.NoError(t, waitForZeroExpiredLeases(ctx, t, c, nodes, expiredLeasesTimeout))
+			cfg.waitAfterRecovery(ctx, t, conn)
+			captureCycleArtifacts(ctx, t, conn, cycleDir, zoneConfigs)
+			require.NoError(t, relocateLeases(t, ctx, conn, `range_id = 2`, livenessNode))
 		}
 		return nil
 	})
 	m.Wait()
+	assertWorkloadHistograms(ctx, t, c, workloadNode, t.PerfArtifactsDir()+"/stats.json")
+	assertRangeCountStable(t, rangesBefore, recordRangeCount(ctx, t, conn), rangeCountDriftTolerance)
 }
 
-// runFailoverSystemNonLiveness benchmarks the maximum duration of range
-// unavailability following a leaseholder failure with only system ranges,
-// excluding the liveness range which is tested separately in
-// runFailoverLiveness.
+const (
+	// changefeedLagInterval is the interval at which runFailoverChangefeed
+	// samples the changefeed lag.
+	changefeedLagInterval = time.Second
+	// changefeedCaughtUpLag is the lag below which the changefeed is considered
+	// caught up after a failure. The steady-state lag is a few seconds, given
+	// the closed timestamp target duration and the checkpoint frequency.
+	changefeedCaughtUpLag = 10 * time.Second
+	// changefeedCatchUpTimeout is the maximum time to wait for the changefeed to
+	// catch up after a failure.
+	changefeedCatchUpTimeout = 5 * time.Minute
+)
+
+// runFailoverChangefeed measures the changefeed lag accrued during a
+// leaseholder failure, and how quickly the changefeed catches up afterwards.
+// Changefeeds consume rangefeeds from the leaseholders (or followers), so a
+// failover stalls the resolved timestamp of the affected ranges until the
+// rangefeeds are restarted elsewhere and the leases have moved.
 //
-//   - No user or liveness ranges located on the failed node.
+//   - No system ranges located on the failed node.
 //
 //   - SQL clients do not connect to the failed node.
 //
-//   - The workload consists of individual point reads and writes.
+//   - The workload consists of individual point reads and writes, with a
+//     changefeed on the kv table emitting to a null sink.
 //
-// Since the lease unavailability is probabilistic, depending e.g. on the time
-// since the last heartbeat and other variables, we run 9 failures and record
-// the pMax latency to find the upper bound on unavailability. Ideally, losing
-// the lease on these ranges should have no impact on the user traffic.
+// The changefeed lag is the difference between the current time and the
+// changefeed's high-water mark, sampled every second. For each cycle, we record
+// the peak lag from the failure until the changefeed has caught up (lag below
+// changefeedCaughtUpLag) after the recovery, and the catch-up time after the
+// recovery, in changefeed-lag.txt. We do not assert anything.
 //
 // The cluster layout is as follows:
 //
-// n1-n3: Workload ranges, liveness range, and SQL gateways.
-// n4-n6: System ranges excluding liveness.
+// n1-n3: System ranges and SQL gateways.
+// n4-n6: Workload ranges.
 // n7:    Workload runner.
 //
 // The test runs a kv50 workload with batch size 1, using 256 concurrent workers
-// directed at n1-n3 with a rate of 2048 reqs/s. n4-n6 fail and recover in
-// order, with 1 minute between each operation, for 3 cycles totaling 9
-// failures.
-func runFailoverSystemNonLiveness(
-	ctx context.Context, t test.Test, c cluster.Cluster, failureMode failureMode, expLeases bool,
+// directed at n1-n3 with a rate of 2048 reqs/s. n4-n6 fail and recover in order,
+// with 1 minute between each operation, for 3 cycles totaling 9 failures.
+func runFailoverChangefeed(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	failureMode failureMode,
+	expLeases bool,
+	cfg failoverConfig,
 ) {
 	require.Equal(t, 7, c.Spec().NodeCount)
+	require.Equal(t, 3, cfg.replicationFactor(), "only 3 replicas are supported")
 
 	rng, _ := randutil.NewTestRand()
 
 	// Create cluster.
 	opts := option.DefaultStartOpts()
-	settings := install.MakeClusterSettings()
+	settings := cfg.clusterSettings()
 
 	failer := makeFailer(t, c, failureMode, opts, settings)
 	failer.Setup(ctx)
 	defer failer.Cleanup(ctx)
 
-	c.Put(ctx, t.Cockroach(), "./cockroach")
-	c.Start(ctx, t.L(), opts, settings, c.Range(1, 6))
-
-	conn := c.Conn(ctx, t.L(), 1)
+	// Place all ranges on n1-n3. This test controls the ranges manually.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 6), manualSplits: true, systemNodes: []int{1, 2, 3}})
 	defer conn.Close()
 
-	// Configure cluster. This test controls the ranges manually.
-	t.Status("configuring cluster")
-	_, err := conn.ExecContext(ctx, `SET CLUSTER SETTING kv.range_split.by_load_enabled = 'false'`)
-	require.NoError(t, err)
-	_, err = conn.ExecContext(ctx, `SET CLUSTER SETTING kv.expiration_leases_only.enabled = $1`,
-		expLeases)
-	require.NoError(t, err)
-
-	// Constrain all existing zone configs to n4-n6, except liveness which is
-	// constrained to n1-n3.
-	configureAllZones(t, ctx, conn, zoneConfig{replicas: 3, onlyNodes: []int{4, 5, 6}})
-	configureZone(t, ctx, conn, `RANGE liveness`, zoneConfig{replicas: 3, onlyNodes: []int{1, 2, 3}})
+	_, err := conn.ExecContext(ctx, `SET CLUSTER SETTING kv.rangefeed.enabled = true`)
 	require.NoError(t, err)
 
-	// Wait for upreplication.
-	require.NoError(t, WaitFor3XReplication(ctx, t, conn))
-
-	// Create the kv database, constrained to n1-n3. Despite the zone config, the
+	// Create the kv database, constrained to n4-n6. Despite the zone config, the
 	// ranges will initially be distributed across all cluster nodes.
 	t.Status("creating workload database")
 	_, err = conn.ExecContext(ctx, `CREATE DATABASE kv`)
 	require.NoError(t, err)
-	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: []int{1, 2, 3}})
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: []int{4, 5, 6}})
 	c.Run(ctx, c.Node(7), `./cockroach workload init kv --splits 1000 {pgurl:1}`)
 
-	// The replicate queue takes forever to move the kv ranges from n4-n6 to
-	// n1-n3, so we do it ourselves. Precreating the database/range and moving it
-	// to the correct nodes first is not sufficient, since workload will spread
-	// the ranges across all nodes regardless.
-	relocateRanges(t, ctx, conn, `database_name = 'kv' OR range_id = 2`,
-		[]int{4, 5, 6}, []int{1, 2, 3})
-	relocateRanges(t, ctx, conn, `database_name != 'kv' AND range_id != 2`,
-		[]int{1, 2, 3}, []int{4, 5, 6})
+	// The replicate queue takes forever to move the kv ranges from n1-n3 to
+	// n4-n6, so we do it ourselves.
+	relocateRanges(t, ctx, conn, `database_name = 'kv'`, []int{1, 2, 3}, []int{4, 5, 6})
+
+	const cycles = 3
+	workloadCmd := `./cockroach workload run kv --read-percent 50 ` +
+		`--duration 20m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors ` +
+		`--histograms=` + t.PerfArtifactsDir() + `/stats.json`
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureMode,
+		ExpirationLeases: expLeases,
+		Cycles:           cycles,
+		Workload:         workloadCmd,
+		Config:           cfg.manifest(),
+	})
+
+	// Create the changefeed. Checkpoint frequently, such that the high-water
+	// mark closely tracks the resolved timestamp.
+	t.Status("creating changefeed")
+	var jobID int64
+	require.NoError(t, conn.QueryRowContext(ctx, `CREATE CHANGEFEED FOR TABLE kv.kv `+
+		`INTO 'null://' WITH resolved = '1s', min_checkpoint_frequency = '1s'`).Scan(&jobID))
+
+	// changefeedLag returns the current changefeed lag, or 0 if the changefeed
+	// doesn't have a high-water mark yet.
+	changefeedLag := func(ctx context.Context) (time.Duration, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		var lag gosql.NullFloat64
+		err := conn.QueryRowContext(ctx, `SELECT `+
+			`((cluster_logical_timestamp() - high_water_timestamp) / 1e9)::FLOAT `+
+			`FROM crdb_internal.jobs WHERE job_id = $1`, jobID).Scan(&lag)
+		if err != nil || !lag.Valid {
+			return 0, err
+		}
+		return time.Duration(lag.Float64 * float64(time.Second)), nil
+	}
 
 	// Start workload on n7, using n1-n3 as gateways. Run it for 20 minutes, since
 	// we take ~2 minutes to fail and recover each node, and we do 3 cycles of each
 	// of the 3 nodes in order.
-	t.Status("running workload")
-	m := c.NewMonitor(ctx, c.Range(1, 6))
-	m.Go(func(ctx context.Context) error {
-		c.Run(ctx, c.Node(7), `./cockroach workload run kv --read-percent 50 `+
-			`--duration 20m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors `+
-			`--histograms=`+t.PerfArtifactsDir()+`/stats.json `+
-			`{pgurl:1-3}`)
-		return nil
-	})
+	m, _ := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 6), failoverWorkload{
+		name: "kv", node: 7, gateways: []int{1, 2, 3}, cmds: []string{workloadCmd}})
 
 	// Start a worker to fail and recover n4-n6 in order.
 	failer.Ready(ctx, m)
+
+	// Fail the test if a node dies outside of the intended failures.
+	deaths := newUnexpectedDeathChecker(t, conn, failureMode)
+	deaths.start(ctx, m)
+	defer deaths.stop()
+
+	var report strings.Builder
+	report.WriteString("cycle,node,peak_lag_seconds,catchup_seconds\n")
 	m.Go(func(ctx context.Context) error {
-		var raftCfg base.RaftConfig
-		raftCfg.SetDefaults()
+		defer deaths.stop()
+
+		raftCfg := cfg.raftConfig()
 
 		ticker := time.NewTicker(time.Minute)
 		defer ticker.Stop()
 
-		for i := 0; i < 3; i++ {
+		var cycle int
+		for i := 0; i < cycles; i++ {
 			for _, node := range []int{4, 5, 6} {
 				select {
 				case <-ticker.C:
@@ -967,16 +2682,364 @@ func runFailoverSystemNonLiveness(
 					return ctx.Err()
 				}
 
-				randTimer := time.After(randutil.RandDuration(rng, raftCfg.RangeLeaseRenewalDuration()))
+				randTimer := time.After(cfg.preFailureDelay(t, rng, raftCfg, cycle))
 
 				// Ranges may occasionally escape their constraints. Move them
 				// to where they should be.
-				relocateRanges(t, ctx, conn, `database_name != 'kv' AND range_id != 2`,
-					[]int{1, 2, 3}, []int{4, 5, 6})
-				relocateRanges(t, ctx, conn, `database_name = 'kv' OR range_id = 2`,
-					[]int{4, 5, 6}, []int{1, 2, 3})
+				relocateRanges(t, ctx, conn, `database_name = 'kv'`, []int{1, 2, 3}, []int{4, 5, 6})
+				relocateRanges(t, ctx, conn, `database_name != 'kv'`, []int{node}, []int{1, 2, 3})
 
-				// Randomly sleep up to the lease renewal interval, to vary the time
+				// Sleep before the failure, by default for a random duration up to the
+				// lease renewal interval (see preFailureDelay). We start the timer before
+				// the range relocation above to run them concurrently.
+				select {
+				case <-randTimer:
+				case <-ctx.Done():
+				}
+
+				t.Status(fmt.Sprintf("failing n%d (%s)", node, failureMode))
+				deaths.failing(node)
+				failer.Fail(ctx, node)
+
+				// Sample the lag until the ticker fires.
+				var peakLag time.Duration
+				sampleLag := func() time.Duration {
+					lag, err := changefeedLag(ctx)
+					if err != nil {
+						t.L().Printf("failed to fetch changefeed lag: %s", err)
+					}
+					if lag > peakLag {
+						peakLag = lag
+					}
+					return lag
+				}
+			sampling:
+				for {
+					sampleLag()
+					select {
+					case <-time.After(changefeedLagInterval):
+					case <-ticker.C:
+						break sampling
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+
+				t.Status(fmt.Sprintf("recovering n%d (%s)", node, failureMode))
+				failer.Recover(ctx, node)
+				deaths.recovered(node)
+				if failureMode == failureModeCrash {
+					require.NoError(t, waitForNodeRejoin(ctx, t, conn, node, nodeRejoinTimeout))
+				}
+
+				// Wait for the changefeed to catch up.
+				recovered := timeutil.Now()
+				catchUp := time.Duration(-1)
+				for timeutil.Since(recovered) < changefeedCatchUpTimeout {
+					if lag := sampleLag(); lag > 0 && lag < changefeedCaughtUpLag {
+						catchUp = timeutil.Since(recovered)
+						break
+					}
+					select {
+					case <-time.After(changefeedLagInterval):
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				// A catch-up time of -1 means the changefeed didn't catch up.
+				catchUpSeconds := -1.0
+				if catchUp < 0 {
+					t.L().Printf("changefeed did not catch up within %s after recovering n%d",
+						changefeedCatchUpTimeout, node)
+				} else {
+					catchUpSeconds = catchUp.Seconds()
+					t.L().Printf("changefeed peak lag %s, caught up after %s", peakLag, catchUp)
+				}
+				fmt.Fprintf(&report, "%d,%d,%.1f,%.1f\n", cycle, node, peakLag.Seconds(), catchUpSeconds)
+
+				cfg.waitAfterRecovery(ctx, t, conn)
+				cycle++
+			}
+		}
+		return nil
+	})
+	m.Wait()
+	assertWorkloadHistograms(ctx, t, c, 7, t.PerfArtifactsDir()+"/stats.json")
+
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "changefeed-lag.txt"),
+		[]byte(report.String()), 0644))
+}
+
+// runFailoverSystemNonLiveness benchmarks the maximum duration of range
+// unavailability following a leaseholder failure with only system ranges,
+// excluding the liveness range which is tested separately in
+// runFailoverLiveness.
+//
+//   - No user or liveness ranges located on the failed node.
+//
+//   - SQL clients do not connect to the failed node.
+//
+//   - The workload consists of individual point reads and writes.
+//
+// Since the lease unavailability is probabilistic, depending e.g. on the time
+// since the last heartbeat and other variables, we run 9 failures and record
+// the pMax latency to find the upper bound on unavailability. Ideally, losing
+// the lease on these ranges should have no impact on the user traffic.
+//
+// The cluster layout is as follows:
+//
+// n1-n3: Workload ranges, liveness range, and SQL gateways.
+// n4-n6: System ranges excluding liveness.
+// n7:    Workload runner.
+//
+// The test runs a kv50 workload with batch size 1, using 256 concurrent workers
+// directed at n1-n3 with a rate of 2048 reqs/s. n4-n6 fail and recover in
+// order, with 1 minute between each operation, for 3 cycles totaling 9
+// failures.
+func runFailoverSystemNonLiveness(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	failureMode failureMode,
+	expLeases bool,
+	cfg failoverConfig,
+) {
+	require.Equal(t, 7, c.Spec().NodeCount)
+	require.Equal(t, 3, cfg.replicationFactor(), "only 3 replicas are supported")
+
+	rng, _ := randutil.NewTestRand()
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := cfg.clusterSettings()
+
+	failer := makeFailer(t, c, failureMode, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// Collect the logs around the failures if the test fails.
+	failureLogs := newFailureLogCollector(t, c, c.Range(1, 6))
+	defer failureLogs.collectOnFailure()
+
+	// Constrain all existing zone configs to n4-n6, except liveness which is
+	// constrained to n1-n3. This test controls the ranges manually.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 6), manualSplits: true, systemNodes: []int{4, 5, 6}})
+	defer conn.Close()
+	configureZone(t, ctx, conn, `RANGE liveness`, zoneConfig{replicas: 3, onlyNodes: []int{1, 2, 3}})
+	requireFullyReplicated(ctx, t, conn)
+
+	// Create the kv database, constrained to n1-n3. Despite the zone config, the
+	// ranges will initially be distributed across all cluster nodes.
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: []int{1, 2, 3}})
+	c.Run(ctx, c.Node(7), `./cockroach workload init kv --splits 1000 {pgurl:1}`)
+
+	// The replicate queue takes forever to move the kv ranges from n4-n6 to
+	// n1-n3, so we do it ourselves. Precreating the database/range and moving it
+	// to the correct nodes first is not sufficient, since workload will spread
+	// the ranges across all nodes regardless.
+	relocateRanges(t, ctx, conn, `database_name = 'kv' OR range_id = 2`,
+		[]int{4, 5, 6}, []int{1, 2, 3})
+	relocateRanges(t, ctx, conn, `database_name != 'kv' AND range_id != 2`,
+		[]int{1, 2, 3}, []int{4, 5, 6})
+
+	const cycles = 3
+	workloadCmd := `./cockroach workload run kv --read-percent 50 ` +
+		`--duration 20m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors ` +
+		`--histograms=` + t.PerfArtifactsDir() + `/stats.json`
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureMode,
+		ExpirationLeases: expLeases,
+		Cycles:           cycles,
+		Workload:         workloadCmd,
+		Config:           cfg.manifest(),
+	})
+	rangesBefore := recordRangeCount(ctx, t, conn)
+
+	// Start workload on n7, using n1-n3 as gateways. Run it for 20 minutes, since
+	// we take ~2 minutes to fail and recover each node, and we do 3 cycles of each
+	// of the 3 nodes in order.
+	m, _ := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 6), failoverWorkload{
+		name: "kv", node: 7, gateways: []int{1, 2, 3}, cmds: []string{workloadCmd}})
+
+	// Start a worker to fail and recover n4-n6 in order.
+	rawErrors := &workloadErrorTaxonomy{}
+	failer.Ready(ctx, m)
+
+	// Fail the test if a node dies outside of the intended failures.
+	deaths := newUnexpectedDeathChecker(t, conn, failureMode)
+	deaths.start(ctx, m)
+	defer deaths.stop()
+
+	m.Go(func(ctx context.Context) error {
+		defer deaths.stop()
+
+		raftCfg := cfg.raftConfig()
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		var cycle int
+		for i := 0; i < cycles; i++ {
+			for _, node := range []int{4, 5, 6} {
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+
+				randTimer := time.After(cfg.preFailureDelay(t, rng, raftCfg, cycle))
+
+				// Ranges may occasionally escape their constraints. Move them
+				// to where they should be.
+				relocateRanges(t, ctx, conn, `database_name != 'kv' AND range_id != 2`,
+					[]int{1, 2, 3}, []int{4, 5, 6})
+				relocateRanges(t, ctx, conn, `database_name = 'kv' OR range_id = 2`,
+					[]int{4, 5, 6}, []int{1, 2, 3})
+
+				// Sleep before the failure, by default for a random duration up to the
+				// lease renewal interval (see preFailureDelay). We start the timer before
+				// the range relocation above to run them concurrently.
+				select {
+				case <-randTimer:
+				case <-ctx.Done():
+				}
+
+				t.Status(fmt.Sprintf("failing n%d (%s)", node, failureMode))
+				deaths.failing(node)
+				failureLogs.failing(cycle, node, failureMode)
+				failer.Fail(ctx, node)
+				cycleDir := failoverCycleArtifactsDir(t, cycle)
+				cfg.captureRawErrors(ctx, t, c, 7, `{pgurl:1-3}`, cycleDir, rawErrors)
+
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+
+				t.Status(fmt.Sprintf("recovering n%d (%s)", node, failureMode))
+				failer.Recover(ctx, node)
+				failureLogs.recovered(node)
+				deaths.recovered(node)
+				if failureMode == failureModeCrash {
+					require.NoError(t, waitForNodeRejoin(ctx, t, conn, node, nodeRejoinTimeout))
+				}
+				cfg.waitAfterRecovery(ctx, t, conn)
+				captureCycleArtifacts(ctx, t, conn, cycleDir, zoneConfigs)
+				cycle++
+			}
+		}
+		return nil
+	})
+	m.Wait()
+	assertWorkloadHistograms(ctx, t, c, 7, t.PerfArtifactsDir()+"/stats.json")
+	assertRangeCountStable(t, rangesBefore, recordRangeCount(ctx, t, conn), rangeCountDriftTolerance)
+	cfg.reportRawErrors(t, rawErrors)
+
+	// Repeated failovers shouldn't leave leases piled up on a subset of nodes.
+	assertLeaseBalance(ctx, t, conn, []int{4, 5, 6}, 0.5)
+}
+
+// runFailoverConsistency verifies that reads observe all writes that were
+// acknowledged before a leaseholder failover, i.e. that the lease handoff
+// doesn't lose or hide acknowledged writes. It uses crash failures, such that
+// the old leaseholder is definitively gone.
+//
+//   - No system ranges located on the failed node.
+//
+//   - SQL clients do not connect to the failed node.
+//
+//   - The workload writes monotonically increasing values to a set of keys and
+//     reads them back via a different gateway.
+//
+// Each key's value must never be lower than the last acknowledged write, nor
+// lower than the last observed read. Any violation fails the test, and is
+// reported with the key, values, and MVCC timestamps involved.
+//
+// The cluster layout is as follows:
+//
+// n1-n3: System ranges and SQL gateways.
+// n4-n6: Workload ranges.
+// n7:    Unused (kept for parity with the non-system topology).
+//
+// n4-n6 fail and recover in order, with 1 minute between each operation, for 3
+// cycles totaling 9 failures.
+func runFailoverConsistency(ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool) {
+	require.Equal(t, 7, c.Spec().NodeCount)
+
+	rng, _ := randutil.NewTestRand()
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := install.MakeClusterSettings()
+
+	failer := makeFailer(t, c, failureModeCrash, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// Place all ranges on n1-n3.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 6), manualSplits: true, systemNodes: []int{1, 2, 3}})
+	defer conn.Close()
+
+	// Create the kv database and checker table, constrained to n4-n6.
+	t.Status("creating workload database")
+	createConsistencyCheckerTable(t, ctx, conn, []int{4, 5, 6})
+
+	relocateRanges(t, ctx, conn, `database_name = 'kv'`, []int{1, 2, 3}, []int{4, 5, 6})
+
+	const cycles = 3
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeCrash,
+		ExpirationLeases: expLeases,
+		Cycles:           cycles,
+		Workload:         "consistency checker",
+	})
+
+	// Start the consistency checker, using n1-n3 as gateways. It runs until the
+	// failure worker below completes.
+	t.Status("running consistency checker")
+	m := c.NewMonitor(ctx, c.Range(1, 6))
+	checkerCtx, cancelChecker := context.WithCancel(ctx)
+	defer cancelChecker()
+	checker := newConsistencyChecker(t, c, []int{1, 2, 3})
+	defer checker.close()
+	m.Go(func(context.Context) error {
+		checker.run(checkerCtx)
+		return nil
+	})
+
+	// Start a worker to fail and recover n4-n6 in order.
+	failer.Ready(ctx, m)
+	m.Go(func(ctx context.Context) error {
+		defer cancelChecker()
+
+		var raftCfg base.RaftConfig
+		raftCfg.SetDefaults()
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for i := 0; i < cycles; i++ {
+			for _, node := range []int{4, 5, 6} {
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+
+				randTimer := time.After(randutil.RandDuration(rng, raftCfg.RangeLeaseRenewalDuration()))
+
+				// Ranges may occasionally escape their constraints. Move them
+				// to where they should be.
+				relocateRanges(t, ctx, conn, `database_name = 'kv'`, []int{1, 2, 3}, []int{4, 5, 6})
+				relocateRanges(t, ctx, conn, `database_name != 'kv'`, []int{node}, []int{1, 2, 3})
+
+				// Randomly sleep up to the lease renewal interval, to vary the time
 				// between the last lease renewal and the failure. We start the timer
 				// before the range relocation above to run them concurrently.
 				select {
@@ -984,397 +3047,6454 @@ func runFailoverSystemNonLiveness(
 				case <-ctx.Done():
 				}
 
-				t.Status(fmt.Sprintf("failing n%d (%s)", node, failureMode))
-				failer.Fail(ctx, node)
+				t.Status(fmt.Sprintf("failing n%d (%s)", node, failureModeCrash))
+				failer.Fail(ctx, node)
+
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+
+				t.Status(fmt.Sprintf("recovering n%d (%s)", node, failureModeCrash))
+				failer.Recover(ctx, node)
+				require.NoError(t, waitForNodeRejoin(ctx, t, conn, node, nodeRejoinTimeout))
+			}
+		}
+		return nil
+	})
+	m.Wait()
+
+	violations := checker.violations()
+	t.Status(fmt.Sprintf("consistency checker performed %d writes and %d reads, %d violations",
+		checker.writes.Load(), checker.reads.Load(), len(violations)))
+	if len(violations) > 0 {
+		for _, v := range violations {
+			t.L().Printf("%s", v)
+		}
+		t.Fatalf("observed %d consistency violations, first: %s", len(violations), violations[0])
+	}
+}
+
+// laggingFollowerPartition is the duration for which runFailoverLaggingFollower
+// partitions the follower, such that its Raft log falls behind.
+const laggingFollowerPartition = 20 * time.Second
+
+// runFailoverLaggingFollower tests the safety of lease transfers to a lagging
+// follower. The follower is briefly partitioned while writes continue, such
+// that its Raft log falls behind, and a lease transfer to it is then attempted
+// immediately after the partition heals. The transfer must be delayed until
+// the follower has caught up, and must never result in stale reads.
+//
+// The cluster layout is as follows:
+//
+// n1-n3: System ranges and SQL gateways.
+// n4-n6: Consistency checker ranges.
+//
+// The consistency checker (see consistencyChecker) writes and reads keys in
+// 100 separate ranges via n1-n3. In each of 6 cycles, the leases are moved to
+// one of n4-n6 and the next node is blackholed for laggingFollowerPartition,
+// after which all leases are transferred to it using relocateLeases. The time
+// taken by each transfer is written to lagging-follower.txt. The test fails if
+// the checker observes any stale reads.
+func runFailoverLaggingFollower(
+	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool,
+) {
+	require.Equal(t, 6, c.Spec().NodeCount)
+
+	systemNodes := []int{1, 2, 3}
+	kvNodes := []int{4, 5, 6}
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := install.MakeClusterSettings()
+
+	failer := makeFailer(t, c, failureModeBlackhole, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// Place all ranges on n1-n3.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 6), manualSplits: true, systemNodes: systemNodes})
+	defer conn.Close()
+
+	// Create the checker table, constrained to n4-n6.
+	t.Status("creating workload database")
+	createConsistencyCheckerTable(t, ctx, conn, kvNodes)
+	relocateRanges(t, ctx, conn, `database_name = 'kv'`, systemNodes, kvNodes)
+
+	const cycles = 6
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeBlackhole,
+		ExpirationLeases: expLeases,
+		Cycles:           cycles,
+		Workload:         "consistency checker",
+	})
+
+	// Start the consistency checker, using n1-n3 as gateways. It runs until the
+	// failure worker below completes.
+	t.Status("running consistency checker")
+	m := c.NewMonitor(ctx, c.Range(1, 6))
+	checkerCtx, cancelChecker := context.WithCancel(ctx)
+	defer cancelChecker()
+	checker := newConsistencyChecker(t, c, systemNodes)
+	defer checker.close()
+	m.Go(func(context.Context) error {
+		checker.run(checkerCtx)
+		return nil
+	})
+
+	// Start a worker to partition each follower in turn, and transfer the
+	// leases to it once it recovers.
+	failer.Ready(ctx, m)
+	rows := []string{"cycle,leaseholder,follower,transfer_duration"}
+	m.Go(func(ctx context.Context) error {
+		defer cancelChecker()
+
+		for cycle := 0; cycle < cycles; cycle++ {
+			leaseholder := kvNodes[cycle%len(kvNodes)]
+			follower := kvNodes[(cycle+1)%len(kvNodes)]
+
+			// Ranges may occasionally escape their constraints. Move them
+			// to where they should be, and move the leases to the leaseholder.
+			relocateRanges(t, ctx, conn, `database_name = 'kv'`, systemNodes, kvNodes)
+			relocateRanges(t, ctx, conn, `database_name != 'kv'`, kvNodes, systemNodes)
+			require.NoError(t, relocateLeases(t, ctx, conn, `database_name = 'kv'`, leaseholder))
+
+			// Partition the follower while the checker keeps writing, such that
+			// its log falls behind.
+			t.Status(fmt.Sprintf("partitioning n%d for %s", follower, laggingFollowerPartition))
+			failer.Fail(ctx, follower)
+			select {
+			case <-time.After(laggingFollowerPartition):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			failer.Recover(ctx, follower)
+
+			// Immediately transfer the leases to the lagging follower. The
+			// transfers should be delayed until it has caught up.
+			t.Status(fmt.Sprintf("transferring leases from n%d to lagging n%d", leaseholder, follower))
+			start := timeutil.Now()
+			require.NoError(t, relocateLeases(t, ctx, conn, `database_name = 'kv'`, follower))
+			duration := timeutil.Since(start)
+			t.L().Printf("lease transfer to lagging n%d took %s", follower, duration)
+			rows = append(rows, fmt.Sprintf("%d,%d,%d,%s", cycle+1, leaseholder, follower, duration))
+
+			require.NoError(t, waitForNodeRejoin(ctx, t, conn, follower, nodeRejoinTimeout))
+		}
+		return nil
+	})
+	m.Wait()
+
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "lagging-follower.txt"),
+		[]byte(strings.Join(rows, "\n")+"\n"), 0644))
+
+	violations := checker.violations()
+	t.Status(fmt.Sprintf("consistency checker performed %d writes and %d reads, %d violations",
+		checker.writes.Load(), checker.reads.Load(), len(violations)))
+	if len(violations) > 0 {
+		for _, v := range violations {
+			t.L().Printf("%s", v)
+		}
+		t.Fatalf("observed %d consistency violations, first: %s", len(violations), violations[0])
+	}
+}
+
+const (
+	// quorumLossProbeTimeout is the statement timeout used when probing the
+	// availability of the workload ranges in runFailoverQuorumLoss.
+	quorumLossProbeTimeout = 10 * time.Second
+	// quorumLossRecoveryTimeout is the maximum time to wait for the loss of
+	// quorum recovery to be applied, and for the ranges to become available.
+	quorumLossRecoveryTimeout = 5 * time.Minute
+)
+
+// runFailoverQuorumLoss tests the disaster recovery path following a permanent
+// loss of quorum, rather than a transient failover. A majority of the replicas
+// of the workload ranges are killed, and availability is restored using
+// half-online loss of quorum recovery (cockroach debug recover make-plan and
+// apply-plan), after which the workload must resume.
+//
+//   - No system ranges located on the failed nodes.
+//
+//   - SQL clients do not connect to the failed nodes.
+//
+//   - The workload consists of individual point reads and writes.
+//
+// The downtime, from the failure until the workload ranges are available again,
+// and whether the recovery succeeded, are written to quorum-loss.txt. Data
+// written to the lost replicas may be lost, so we do not assert anything about
+// the data, only availability.
+//
+// The cluster layout is as follows:
+//
+// n1-n3: System ranges and SQL gateways.
+// n4-n6: Workload ranges.
+// n7:    Workload runner, and recovery controller.
+//
+// The test runs a kv50 workload with batch size 1, using 256 concurrent workers
+// directed at n1-n3 with a rate of 2048 reqs/s. Once it reaches a steady state,
+// n5 and n6 are killed permanently, along with the leases, leaving only the
+// replicas on n4. The plan is staged on n4 via n1, and n4 is restarted to apply
+// it.
+func runFailoverQuorumLoss(
+	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool, cfg failoverConfig,
+) {
+	require.Equal(t, 7, c.Spec().NodeCount)
+
+	systemNodes := []int{1, 2, 3}
+	kvNodes := []int{4, 5, 6}
+	survivorNode, lostNodes := 4, []int{5, 6}
+	workloadNode := 7
+	const planName = "recover-plan.json"
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := cfg.clusterSettings()
+
+	// Place all ranges on n1-n3. This test controls the ranges manually.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 6), manualSplits: true, systemNodes: systemNodes})
+	defer conn.Close()
+	c.Run(ctx, c.Node(workloadNode), "rm", "-f", planName)
+
+	// Create the kv database, constrained to n4-n6.
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: kvNodes})
+	c.Run(ctx, c.Node(workloadNode), `./cockroach workload init kv --splits 100 {pgurl:1}`)
+
+	// The replicate queue takes forever to move the ranges, so we do it
+	// ourselves. The ranges must be placed exactly, such that killing n5 and n6
+	// loses quorum for all of the workload ranges and none of the system ranges.
+	relocateRanges(t, ctx, conn, `database_name = 'kv'`, systemNodes, kvNodes)
+	relocateRanges(t, ctx, conn, `database_name != 'kv'`, kvNodes, systemNodes)
+
+	workloadCmd := `./cockroach workload run kv ` +
+		`--read-percent 50 --duration 20m --concurrency 256 --max-rate 2048 --timeout 1m ` +
+		`--tolerate-errors`
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeCrash,
+		ExpirationLeases: expLeases,
+		Workload:         workloadCmd,
+		Config:           cfg.manifest(),
+	})
+
+	// Start workload on n7, using n1-n3 as gateways. It tolerates errors while
+	// the ranges are unavailable.
+	m, cancelWorkload := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 6), failoverWorkload{
+		name: "kv", node: workloadNode, gateways: systemNodes, cmds: []string{workloadCmd}})
+	defer cancelWorkload()
+
+	// probe returns nil if all workload ranges are available.
+	probe := func(ctx context.Context) error {
+		probeConn := c.Conn(ctx, t.L(), 1)
+		defer probeConn.Close()
+		_, err := probeConn.ExecContext(ctx, fmt.Sprintf(`SET statement_timeout = '%s'`,
+			quorumLossProbeTimeout))
+		if err != nil {
+			return err
+		}
+		_, err = probeConn.ExecContext(ctx, `SELECT count(*) FROM kv.kv`)
+		return err
+	}
+
+	var recovered bool
+	var downtime time.Duration
+	m.Go(func(ctx context.Context) error {
+		defer cancelWorkload()
+
+		// Verify the placement, since killing the nodes must only affect the
+		// workload ranges.
+		relocateRanges(t, ctx, conn, `database_name = 'kv'`, systemNodes, kvNodes)
+		relocateRanges(t, ctx, conn, `database_name != 'kv'`, kvNodes, systemNodes)
+		assertPlacement(t, ctx, conn, `database_name = 'kv'`, kvNodes)
+		assertPlacement(t, ctx, conn, `database_name != 'kv'`, systemNodes)
+
+		// Move the leases to n5. Otherwise, n4 could continue to serve reads
+		// under its existing leases, since it remains live.
+		require.NoError(t, relocateLeases(t, ctx, conn, `database_name = 'kv'`, lostNodes[0]))
+		var lostRanges int
+		require.NoError(t, conn.QueryRowContext(ctx, `SELECT count(DISTINCT range_id) `+
+			`FROM [SHOW CLUSTER RANGES WITH TABLES] WHERE database_name = 'kv'`).Scan(&lostRanges))
+
+		// Kill n5 and n6 permanently.
+		t.Status(fmt.Sprintf("killing n%v, losing quorum for %d ranges", lostNodes, lostRanges))
+		m.ExpectDeaths(int32(len(lostNodes)))
+		failedAt := timeutil.Now()
+		c.Stop(ctx, t.L(), option.DefaultStopOpts(), c.Nodes(lostNodes...))
+
+		// Make sure the ranges are unavailable, even after a lease has had time
+		// to expire.
+		if err := probe(ctx); err == nil {
+			t.Fatalf("workload ranges available after losing quorum")
+		} else {
+			t.L().Printf("workload ranges unavailable as expected: %s", err)
+		}
+
+		// Create the recovery plan using n1, and stage it on n4.
+		t.Status("recovering loss of quorum")
+		addrs, err := c.ExternalAddr(ctx, t.L(), c.Node(1))
+		require.NoError(t, err)
+		require.NotEmpty(t, addrs)
+		hostFlags := cfg.cliHostFlags(addrs[0])
+		if err := c.RunE(ctx, c.Node(workloadNode), fmt.Sprintf(
+			"./cockroach debug recover make-plan --confirm y %s -o %s", hostFlags, planName)); err != nil {
+			t.L().Printf("failed to create recovery plan: %s", err)
+			return nil
+		}
+		if err := c.Get(ctx, t.L(), planName, filepath.Join(t.ArtifactsDir(), planName),
+			c.Node(workloadNode)); err != nil {
+			t.L().Printf("failed to collect recovery plan: %s", err)
+		}
+		if err := c.RunE(ctx, c.Node(workloadNode), fmt.Sprintf(
+			"./cockroach debug recover apply-plan --confirm y %s %s", hostFlags, planName)); err != nil {
+			t.L().Printf("failed to apply recovery plan: %s", err)
+			return nil
+		}
+
+		// Restart n4 to apply the staged plan.
+		t.Status(fmt.Sprintf("restarting n%d to apply recovery plan", survivorNode))
+		m.ExpectDeath()
+		c.Stop(ctx, t.L(), option.DefaultStopOpts(), c.Node(survivorNode))
+		c.Start(ctx, t.L(), opts, settings, c.Node(survivorNode))
+
+		// Wait for the plan to be applied, and the ranges to become available.
+		deadline := timeutil.Now().Add(quorumLossRecoveryTimeout)
+		for {
+			err := c.RunE(ctx, c.Node(workloadNode), fmt.Sprintf(
+				"./cockroach debug recover verify %s %s", hostFlags, planName))
+			if err == nil {
+				err = probe(ctx)
+			}
+			if err == nil {
+				recovered, downtime = true, timeutil.Since(failedAt)
+				break
+			}
+			if timeutil.Now().After(deadline) {
+				t.L().Printf("workload ranges not recovered within %s: %s",
+					quorumLossRecoveryTimeout, err)
+				return nil
+			}
+			t.L().Printf("recovery not yet complete: %s", err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		t.L().Printf("workload ranges recovered after %s", downtime)
+
+		// The workload must resume across all gateways.
+		require.NoError(t, waitForWorkloadSteadyState(ctx, t, c, systemNodes,
+			workloadSteadyStateTimeout))
+		return nil
+	})
+	m.Wait()
+
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "quorum-loss.txt"),
+		[]byte(fmt.Sprintf("recovered,downtime\n%t,%s\n", recovered, downtime)), 0644))
+	if !recovered {
+		t.Fatalf("failed to recover from loss of quorum")
+	}
+}
+
+const (
+	// upreplicationFailureInterval is the duration of each failure, and of the
+	// recovery period after it, in runFailoverUpreplication.
+	upreplicationFailureInterval = 30 * time.Second
+	// upreplicationTimeout is the maximum time to wait for full replication
+	// after the last failure in runFailoverUpreplication.
+	upreplicationTimeout = 15 * time.Minute
+)
+
+// runFailoverUpreplication tests that the cluster reaches full replication when
+// nodes fail while it is upreplicating, stressing the allocator and snapshot
+// machinery under concurrent failures and rebalancing. The other failover tests
+// wait for full replication before failing anything.
+//
+//   - No system ranges located on the failed nodes.
+//
+//   - SQL clients do not connect to the failed nodes.
+//
+//   - The workload consists of individual point reads and writes.
+//
+// The workload ranges are first placed with 3 replicas across 5 nodes. Once the
+// workload reaches a steady state, their replication factor is raised to 5,
+// and each of the 5 nodes is then crashed and restarted in turn without waiting
+// for the upreplication to converge. The number of under-replicated ranges at
+// each failure is logged, to show whether the failures in fact overlapped with
+// the upreplication. After the last recovery, the ranges must reach full
+// replication within upreplicationTimeout. The time to full replication, both
+// from the replication factor change and from the last recovery, is written to
+// upreplication.txt.
+//
+// The cluster layout is as follows:
+//
+// n1-n3: System ranges and SQL gateways.
+// n4-n8: Workload ranges.
+// n9:    Workload runner.
+func runFailoverUpreplication(ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool) {
+	require.Equal(t, 9, c.Spec().NodeCount)
+
+	systemNodes := []int{1, 2, 3}
+	kvNodes := []int{4, 5, 6, 7, 8}
+	workloadNode := 9
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := install.MakeClusterSettings()
+
+	failer := makeFailer(t, c, failureModeCrash, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// Place all ranges on n1-n3.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 8), manualSplits: true, systemNodes: systemNodes})
+	defer conn.Close()
+
+	// Create the kv database with 3 replicas, constrained to n4-n8.
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: kvNodes})
+	c.Run(ctx, c.Node(workloadNode), `./cockroach workload init kv --splits 1000 {pgurl:1}`)
+
+	// The replicate queue takes forever to move the ranges, so we do it
+	// ourselves.
+	relocateRanges(t, ctx, conn, `database_name = 'kv'`, systemNodes, kvNodes)
+	relocateRanges(t, ctx, conn, `database_name != 'kv'`, kvNodes, systemNodes)
+
+	workloadCmd := `./cockroach workload run kv ` +
+		`--read-percent 50 --duration 40m --concurrency 256 --max-rate 2048 --timeout 1m ` +
+		`--tolerate-errors`
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeCrash,
+		ExpirationLeases: expLeases,
+		Cycles:           len(kvNodes),
+		Workload:         workloadCmd,
+	})
+
+	// Start workload on n9, using n1-n3 as gateways.
+	m, cancelWorkload := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 8), failoverWorkload{
+		name: "kv", node: workloadNode, gateways: systemNodes, cmds: []string{workloadCmd}})
+	defer cancelWorkload()
+	failer.Ready(ctx, m)
+
+	// underReplicated returns the number of workload ranges with fewer than 5
+	// voting replicas.
+	underReplicated := func(ctx context.Context) int {
+		var count int
+		require.NoError(t, conn.QueryRowContext(ctx, `SELECT count(DISTINCT range_id) `+
+			`FROM [SHOW CLUSTER RANGES WITH TABLES, DETAILS] `+
+			`WHERE database_name = 'kv' AND array_length(voting_replicas, 1) < 5`).Scan(&count))
+		return count
+	}
+
+	var sinceUpreplication, sinceRecovery time.Duration
+	m.Go(func(ctx context.Context) error {
+		defer cancelWorkload()
+
+		// Raise the replication factor, and fail the nodes in turn while the
+		// ranges are upreplicating.
+		t.Status("upreplicating workload ranges")
+		configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 5, onlyNodes: kvNodes})
+		upreplicationStart := timeutil.Now()
+
+		var recoveredAt time.Time
+		for _, node := range kvNodes {
+			t.Status(fmt.Sprintf("failing n%d with %d under-replicated ranges",
+				node, underReplicated(ctx)))
+			failer.Fail(ctx, node)
+			select {
+			case <-time.After(upreplicationFailureInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			t.Status(fmt.Sprintf("recovering n%d", node))
+			failer.Recover(ctx, node)
+			recoveredAt = timeutil.Now()
+			select {
+			case <-time.After(upreplicationFailureInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		// The ranges must reach full replication.
+		t.Status("waiting for full replication")
+		if laggards, err := waitForFullReplication(ctx, t, conn, upreplicationTimeout); err != nil {
+			t.Fatalf("ranges did not reach full replication after failures: %s (ranges %v)",
+				err, laggards)
+		}
+		sinceUpreplication = timeutil.Since(upreplicationStart)
+		sinceRecovery = timeutil.Since(recoveredAt)
+		t.L().Printf("full replication reached %s after upreplication started, %s after last recovery",
+			sinceUpreplication, sinceRecovery)
+		return nil
+	})
+	m.Wait()
+
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "upreplication.txt"),
+		[]byte(fmt.Sprintf("since_upreplication_s,since_recovery_s\n%.1f,%.1f\n",
+			sinceUpreplication.Seconds(), sinceRecovery.Seconds())), 0644))
+}
+
+const (
+	// drainSnapshotRate is the snapshot rate limit in runFailoverDrainSnapshots,
+	// low enough that each snapshot takes several seconds to send.
+	drainSnapshotRate = "8MiB"
+	// drainSnapshotTimeout is the maximum time to wait for snapshots to be in
+	// flight before draining.
+	drainSnapshotTimeout = 5 * time.Minute
+	// drainRebalanceTimeout is the maximum time to wait for the rebalance to
+	// complete after the drained node returns.
+	drainRebalanceTimeout = 15 * time.Minute
+)
+
+// runFailoverDrainSnapshots tests that gracefully draining a node while it is
+// sending Raft snapshots doesn't leave any snapshot half-applied: the recipient
+// must either have applied a complete snapshot or none at all, and all ranges
+// must remain consistent.
+//
+//   - No system ranges located on the drained node.
+//
+//   - SQL clients do not connect to the drained node.
+//
+//   - The workload consists of individual point reads and writes.
+//
+// The cluster layout is as follows:
+//
+// n1-n3: System ranges and SQL gateways.
+// n4-n6: Workload ranges, with leases on n4.
+// n7:    Rebalance target.
+// n8:    Workload runner.
+//
+// The workload ranges are first filled with data, and the snapshot rate is
+// limited such that each snapshot takes several seconds. The workload ranges
+// are then rebalanced from n4 to n7, which causes n4 to send snapshots to n7.
+// Once snapshots are in flight, n4 is gracefully drained and stopped, and
+// restarted a minute later. The rebalance must then complete, and a full
+// consistency check must pass. The number of snapshots in flight at drain
+// time, failed snapshot receipts on n7, and the rebalance duration are written
+// to drain-snapshots.txt.
+func runFailoverDrainSnapshots(
+	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool,
+) {
+	require.Equal(t, 8, c.Spec().NodeCount)
+
+	systemNodes := []int{1, 2, 3}
+	kvNodes := []int{4, 5, 6}
+	drainNode, targetNode, workloadNode := 4, 7, 8
+	rebalancedNodes := []int{5, 6, 7}
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := install.MakeClusterSettings()
+
+	// Place all ranges on n1-n3. This test controls the ranges manually.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 7), manualSplits: true, systemNodes: systemNodes})
+	defer conn.Close()
+
+	// Disable snapshot delegation, such that the leaseholder on n4 sends the
+	// snapshots.
+	_, err := conn.ExecContext(ctx,
+		`SET CLUSTER SETTING kv.snapshot_delegation.max_delegation_attempts = 0`)
+	require.NoError(t, err)
+
+	// Create the kv database, constrained to n4-n6, and fill it with data.
+	t.Status("creating workload database")
+	_, err = conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: kvNodes})
+	c.Run(ctx, c.Node(workloadNode), `./cockroach workload init kv --splits 20 {pgurl:1}`)
+	relocateRanges(t, ctx, conn, `database_name = 'kv'`, append(systemNodes, targetNode), kvNodes)
+	relocateRanges(t, ctx, conn, `database_name != 'kv'`, append(kvNodes, targetNode), systemNodes)
+
+	t.Status("writing workload data")
+	c.Run(ctx, c.Node(workloadNode), `./cockroach workload run kv --read-percent 0 `+
+		`--min-block-bytes 16384 --max-block-bytes 16384 --duration 3m --concurrency 64 `+
+		`--max-rate 500 {pgurl:1-3}`)
+
+	workloadCmd := `./cockroach workload run kv ` +
+		`--read-percent 50 --duration 30m --concurrency 256 --max-rate 2048 --timeout 1m ` +
+		`--tolerate-errors`
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeDrainStop,
+		ExpirationLeases: expLeases,
+		Workload:         workloadCmd,
+	})
+
+	// Start workload on n8, using n1-n3 as gateways.
+	m, cancelWorkload := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 7), failoverWorkload{
+		name: "kv", node: workloadNode, gateways: systemNodes, cmds: []string{workloadCmd}})
+	defer cancelWorkload()
+
+	var inFlight, recvFailed int
+	var rebalanceDuration time.Duration
+	m.Go(func(ctx context.Context) error {
+		defer cancelWorkload()
+
+		drainConn := c.Conn(ctx, t.L(), drainNode)
+		defer drainConn.Close()
+		targetConn := c.Conn(ctx, t.L(), targetNode)
+		defer targetConn.Close()
+		storeMetric := func(conn *gosql.DB, name string) (int, error) {
+			var value int
+			err := conn.QueryRowContext(ctx, `SELECT coalesce(sum(value), 0)::INT `+
+				`FROM crdb_internal.node_metrics WHERE name = $1`, name).Scan(&value)
+			return value, err
+		}
+
+		// Move the leases to n4, limit the snapshot rate, and start the
+		// rebalance from n4 to n7.
+		require.NoError(t, relocateLeases(t, ctx, conn, `database_name = 'kv'`, drainNode))
+		for _, setting := range []string{"kv.snapshot_rebalance.max_rate", "kv.snapshot_recovery.max_rate"} {
+			_, err := conn.ExecContext(ctx, fmt.Sprintf(`SET CLUSTER SETTING %s = '%s'`,
+				setting, drainSnapshotRate))
+			require.NoError(t, err)
+		}
+		t.Status(fmt.Sprintf("rebalancing workload ranges from n%d to n%d", drainNode, targetNode))
+		rebalanceStart := timeutil.Now()
+		configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: rebalancedNodes})
+
+		// Wait for n4 to send snapshots.
+		deadline := timeutil.Now().Add(drainSnapshotTimeout)
+		for inFlight == 0 {
+			if timeutil.Now().After(deadline) {
+				t.Fatalf("no snapshots in flight from n%d within %s", drainNode, drainSnapshotTimeout)
+			}
+			select {
+			case <-time.After(500 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			inFlight, err = storeMetric(drainConn, "range.snapshots.send-in-progress")
+			require.NoError(t, err)
+		}
+
+		// Gracefully drain and stop n4 while the snapshots are in flight.
+		t.Status(fmt.Sprintf("draining n%d with %d snapshots in flight", drainNode, inFlight))
+		m.ExpectDeath()
+		require.NoError(t, c.StopCockroachGracefullyOnNode(ctx, t.L(), drainNode))
+
+		select {
+		case <-time.After(time.Minute):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		t.Status(fmt.Sprintf("restarting n%d", drainNode))
+		c.Start(ctx, t.L(), opts, settings, c.Node(drainNode))
+		require.NoError(t, waitForNodeRejoin(ctx, t, conn, drainNode, nodeRejoinTimeout))
+
+		// The rebalance must complete, and n7 must not be left with any
+		// snapshots in progress.
+		t.Status("waiting for rebalance to complete")
+		deadline = timeutil.Now().Add(drainRebalanceTimeout)
+		for {
+			var remaining, receiving int
+			require.NoError(t, conn.QueryRowContext(ctx, `SELECT count(DISTINCT range_id) `+
+				`FROM [SHOW CLUSTER RANGES WITH TABLES] WHERE database_name = 'kv' `+
+				`AND NOT replicas <@ $1::INT[]`, pq.Array(rebalancedNodes)).Scan(&remaining))
+			receiving, err = storeMetric(targetConn, "range.snapshots.recv-in-progress")
+			require.NoError(t, err)
+			if remaining == 0 && receiving == 0 {
+				break
+			}
+			if timeutil.Now().After(deadline) {
+				t.Fatalf("rebalance incomplete after %s: %d ranges not rebalanced, "+
+					"%d snapshots in progress on n%d", drainRebalanceTimeout, remaining, receiving, targetNode)
+			}
+			t.Status(fmt.Sprintf("waiting for %d ranges to rebalance, %d snapshots in progress",
+				remaining, receiving))
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		rebalanceDuration = timeutil.Since(rebalanceStart)
+		recvFailed, err = storeMetric(targetConn, "range.snapshots.recv-failed")
+		require.NoError(t, err)
+		return nil
+	})
+	m.Wait()
+
+	report := fmt.Sprintf("in_flight_at_drain,recv_failed,rebalance_duration\n%d,%d,%s\n",
+		inFlight, recvFailed, rebalanceDuration)
+	t.L().Printf("drain snapshots:\n%s", report)
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "drain-snapshots.txt"),
+		[]byte(report), 0644))
+
+	assertRangesConsistent(ctx, t, conn)
+}
+
+const (
+	// snapshotRecvCrashRate is the snapshot rate limit in
+	// runFailoverSnapshotRecvCrash, low enough that the snapshot takes about a
+	// minute to send.
+	snapshotRecvCrashRate = "4MiB"
+	// snapshotRecvCrashTimeout is the maximum time to wait for the snapshot to
+	// be received, both before the crash and after the restart.
+	snapshotRecvCrashTimeout = 10 * time.Minute
+)
+
+// runFailoverSnapshotRecvCrash tests that crashing a node while it is receiving
+// a Raft snapshot doesn't leave a partially applied snapshot behind: after the
+// restart, the node must either have discarded the partial snapshot and
+// received a new one, or have completed the snapshot before the crash, and the
+// range must be consistent.
+//
+//   - No system ranges located on the crashed node.
+//
+//   - SQL clients do not connect to the crashed node.
+//
+//   - The workload consists of individual point reads and writes.
+//
+// The cluster layout is as follows:
+//
+// n1-n3: System ranges and SQL gateways.
+// n4-n6: Workload range, with the lease on n4.
+// n7:    Snapshot recipient, crashed.
+// n8:    Workload runner.
+//
+// The workload table is a single range, which is first filled with data, and
+// the snapshot rate is limited such that a snapshot takes about a minute. A
+// replica is then added on n7, which causes n4 to send it a snapshot. Once n7
+// is receiving the snapshot, it is crashed, and restarted a minute later. The
+// range must then have a replica on n7, and a full consistency check must
+// pass. Whether a new snapshot was needed after the restart, along with the
+// recovery duration, is written to snapshot-recv-crash.txt.
+func runFailoverSnapshotRecvCrash(
+	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool,
+) {
+	require.Equal(t, 8, c.Spec().NodeCount)
+
+	systemNodes := []int{1, 2, 3}
+	kvNodes := []int{4, 5, 6}
+	leaseNode, crashNode, workloadNode := 4, 7, 8
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := install.MakeClusterSettings()
+
+	failer := makeFailer(t, c, failureModeCrash, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// Place all ranges on n1-n3. This test controls the ranges manually.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 7), manualSplits: true, systemNodes: systemNodes})
+	defer conn.Close()
+
+	// Disable snapshot delegation, such that the leaseholder on n4 sends the
+	// snapshot.
+	_, err := conn.ExecContext(ctx,
+		`SET CLUSTER SETTING kv.snapshot_delegation.max_delegation_attempts = 0`)
+	require.NoError(t, err)
+
+	// Create the kv database, constrained to n4-n6, and fill its single range
+	// with about 250 MB of data.
+	t.Status("creating workload database")
+	_, err = conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: kvNodes})
+	c.Run(ctx, c.Node(workloadNode), `./cockroach workload init kv {pgurl:1}`)
+	relocateRanges(t, ctx, conn, `database_name = 'kv'`, append(systemNodes, crashNode), kvNodes)
+	relocateRanges(t, ctx, conn, `database_name != 'kv'`, append(kvNodes, crashNode), systemNodes)
+
+	t.Status("writing workload data")
+	c.Run(ctx, c.Node(workloadNode), `./cockroach workload run kv --read-percent 0 `+
+		`--min-block-bytes 16384 --max-block-bytes 16384 --duration 1m --concurrency 64 `+
+		`--max-rate 250 {pgurl:1-3}`)
+
+	var rangeIDs []int
+	rows, err := conn.QueryContext(ctx,
+		`SELECT DISTINCT range_id FROM [SHOW CLUSTER RANGES WITH TABLES] WHERE database_name = 'kv'`)
+	require.NoError(t, err)
+	for rows.Next() {
+		var rangeID int
+		require.NoError(t, rows.Scan(&rangeID))
+		rangeIDs = append(rangeIDs, rangeID)
+	}
+	require.NoError(t, rows.Err())
+	require.Len(t, rangeIDs, 1, "expected a single workload range")
+	rangeID := rangeIDs[0]
+
+	workloadCmd := `./cockroach workload run kv ` +
+		`--read-percent 50 --duration 30m --concurrency 256 --max-rate 2048 --timeout 1m ` +
+		`--tolerate-errors`
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeCrash,
+		ExpirationLeases: expLeases,
+		Workload:         workloadCmd,
+	})
+
+	// Start workload on n8, using n1-n3 as gateways.
+	m, cancelWorkload := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 7), failoverWorkload{
+		name: "kv", node: workloadNode, gateways: systemNodes, cmds: []string{workloadCmd}})
+	defer cancelWorkload()
+	failer.Ready(ctx, m)
+
+	var recvBytesAtCrash, resnapshots, recvFailed int
+	var recoveryDuration time.Duration
+	m.Go(func(ctx context.Context) error {
+		defer cancelWorkload()
+
+		storeMetric := func(conn *gosql.DB, name string) (int, error) {
+			var value int
+			err := conn.QueryRowContext(ctx, `SELECT coalesce(sum(value), 0)::INT `+
+				`FROM crdb_internal.node_metrics WHERE name = $1`, name).Scan(&value)
+			return value, err
+		}
+		// hasReplica returns true if n7 is a voter for the workload range.
+		hasReplica := func() (bool, error) {
+			var ok bool
+			err := conn.QueryRowContext(ctx, `SELECT $1::INT = ANY(voting_replicas) `+
+				`FROM [SHOW CLUSTER RANGES] WHERE range_id = $2`,
+				crashNode, rangeID).Scan(&ok)
+			return ok, err
+		}
+
+		// Move the lease to n4, limit the snapshot rate, and add a replica on n7.
+		require.NoError(t, relocateLeases(t, ctx, conn, `database_name = 'kv'`, leaseNode))
+		for _, setting := range []string{"kv.snapshot_rebalance.max_rate", "kv.snapshot_recovery.max_rate"} {
+			_, err := conn.ExecContext(ctx, fmt.Sprintf(`SET CLUSTER SETTING %s = '%s'`,
+				setting, snapshotRecvCrashRate))
+			require.NoError(t, err)
+		}
+		t.Status(fmt.Sprintf("adding replica of r%d on n%d", rangeID, crashNode))
+		configureZone(t, ctx, conn, `DATABASE kv`,
+			zoneConfig{replicas: 4, onlyNodes: append(kvNodes, crashNode)})
+
+		// Wait for n7 to be receiving the snapshot, as seen by its received
+		// bytes increasing, to make sure the crash happens during ingest rather
+		// than while the snapshot is queued.
+		crashConn := c.Conn(ctx, t.L(), crashNode)
+		defer crashConn.Close()
+		deadline := timeutil.Now().Add(snapshotRecvCrashTimeout)
+		for {
+			if timeutil.Now().After(deadline) {
+				t.Fatalf("n%d not receiving a snapshot within %s", crashNode, snapshotRecvCrashTimeout)
+			}
+			select {
+			case <-time.After(500 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			receiving, err := storeMetric(crashConn, "range.snapshots.recv-in-progress")
+			require.NoError(t, err)
+			recvBytesAtCrash, err = storeMetric(crashConn, "range.snapshots.rcvd-bytes")
+			require.NoError(t, err)
+			if receiving > 0 && recvBytesAtCrash > 0 {
+				break
+			}
+		}
+
+		t.Status(fmt.Sprintf("crashing n%d after receiving %s of the snapshot", crashNode,
+			humanizeutil.IBytes(int64(recvBytesAtCrash))))
+		failer.Fail(ctx, crashNode)
+
+		select {
+		case <-time.After(time.Minute):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		t.Status(fmt.Sprintf("recovering n%d", crashNode))
+		recoveryStart := timeutil.Now()
+		failer.Recover(ctx, crashNode)
+		require.NoError(t, waitForNodeRejoin(ctx, t, conn, crashNode, nodeRejoinTimeout))
+
+		// The range must get a replica on n7, and n7 must not be left with any
+		// snapshots in progress.
+		t.Status(fmt.Sprintf("waiting for replica of r%d on n%d", rangeID, crashNode))
+		crashConn = c.Conn(ctx, t.L(), crashNode)
+		defer crashConn.Close()
+		deadline = timeutil.Now().Add(snapshotRecvCrashTimeout)
+		for {
+			ok, err := hasReplica()
+			require.NoError(t, err)
+			receiving, err := storeMetric(crashConn, "range.snapshots.recv-in-progress")
+			require.NoError(t, err)
+			if ok && receiving == 0 {
+				break
+			}
+			if timeutil.Now().After(deadline) {
+				t.Fatalf("no replica of r%d on n%d after %s (%d snapshots in progress)",
+					rangeID, crashNode, snapshotRecvCrashTimeout, receiving)
+			}
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		recoveryDuration = timeutil.Since(recoveryStart)
+
+		// n7's metrics were reset by the restart, so any applied snapshots were
+		// received after it.
+		for _, name := range []string{
+			"range.snapshots.applied-initial",
+			"range.snapshots.applied-voter",
+			"range.snapshots.applied-non-voter",
+		} {
+			applied, err := storeMetric(crashConn, name)
+			require.NoError(t, err)
+			resnapshots += applied
+		}
+		recvFailed, err = storeMetric(crashConn, "range.snapshots.recv-failed")
+		require.NoError(t, err)
+		return nil
+	})
+	m.Wait()
+
+	report := fmt.Sprintf("recv_bytes_at_crash,resnapshot_needed,resnapshots,recv_failed,"+
+		"recovery_duration\n%d,%t,%d,%d,%s\n",
+		recvBytesAtCrash, resnapshots > 0, resnapshots, recvFailed, recoveryDuration)
+	t.L().Printf("snapshot reception crash:\n%s", report)
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "snapshot-recv-crash.txt"),
+		[]byte(report), 0644))
+
+	assertRangesConsistent(ctx, t, conn)
+}
+
+// assertRangesConsistent runs a full consistency check, which compares the
+// replicas' data, and fails the test if any range is inconsistent.
+func assertRangesConsistent(ctx context.Context, t test.Test, conn *gosql.DB) {
+	t.Status("checking consistency")
+	rows, err := conn.QueryContext(ctx, `
+SET statement_timeout = '10m';
+SELECT range_id, status, detail FROM crdb_internal.check_consistency(false, '', '')
+WHERE status NOT IN ('RANGE_CONSISTENT', 'RANGE_CONSISTENT_STATS_ESTIMATED', 'RANGE_INDETERMINATE')`)
+	require.NoError(t, err)
+	defer rows.Close()
+	var inconsistent int
+	for rows.Next() {
+		var rangeID int
+		var status, detail string
+		require.NoError(t, rows.Scan(&rangeID, &status, &detail))
+		t.L().Printf("r%d is inconsistent: %s %s", rangeID, status, detail)
+		inconsistent++
+	}
+	require.NoError(t, rows.Err())
+	require.Zero(t, inconsistent, "found inconsistent ranges")
+}
+
+const (
+	// staleReadCycles is the number of pauses in runFailoverPausedStaleReads.
+	staleReadCycles = 5
+	// staleReadKeys is the number of keys written and read in
+	// runFailoverPausedStaleReads.
+	staleReadKeys = 10
+	// staleReadPauseDuration is how long the leaseholder is paused, long enough
+	// for its lease to expire and be acquired elsewhere with both epoch and
+	// expiration leases.
+	staleReadPauseDuration = 30 * time.Second
+	// staleReadWindow is how long reads are issued via the paused node after
+	// it is resumed.
+	staleReadWindow = 10 * time.Second
+)
+
+// staleRead is a stale read observed by runFailoverPausedStaleReads.
+type staleRead struct {
+	cycle    int
+	key      int
+	expected int
+	observed int
+	// writeTS is the commit timestamp of the expected value, and readTS is the
+	// timestamp of the stale read.
+	writeTS string
+	readTS  string
+}
+
+// runFailoverPausedStaleReads tests that a leaseholder which is paused for
+// longer than its lease doesn't serve stale reads when it is resumed, based on
+// its old lease. While it is paused, the lease is acquired by another replica,
+// which serves new writes. Reads issued via the paused node right as it resumes
+// must see these writes, i.e. the node must detect that its lease is gone and
+// redirect the reads to the new leaseholder.
+//
+//   - No system ranges located on the paused node.
+//
+//   - SQL clients connect to the paused node for the reads, and to n1 for the
+//     writes.
+//
+//   - The workload consists of explicit writes and reads of a few keys, rather
+//     than the kv workload.
+//
+// The cluster layout is as follows:
+//
+// n1-n3: System ranges and SQL gateway for writes.
+// n4-n6: Workload range, with the lease on n4.
+//
+// n4 is paused and resumed for 5 cycles. Any stale reads are written to
+// stale-reads.txt, and fail the test.
+func runFailoverPausedStaleReads(
+	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool,
+) {
+	require.Equal(t, 6, c.Spec().NodeCount)
+
+	const pausedNode = 4
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := install.MakeClusterSettings()
+
+	failer := makeFailer(t, c, failureModePause, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// Place all ranges on n1-n3.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 6), manualSplits: true, systemNodes: []int{1, 2, 3}})
+	defer conn.Close()
+
+	// Create the kv database, constrained to n4-n6.
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: []int{4, 5, 6}})
+	_, err = conn.ExecContext(ctx, `CREATE TABLE kv.stale_reads (k INT PRIMARY KEY, v INT NOT NULL)`)
+	require.NoError(t, err)
+	_, err = conn.ExecContext(ctx,
+		`INSERT INTO kv.stale_reads SELECT k, 0 FROM generate_series(1, $1) AS g(k)`, staleReadKeys)
+	require.NoError(t, err)
+
+	relocateRanges(t, ctx, conn, `database_name = 'kv'`, []int{1, 2, 3}, []int{4, 5, 6})
+	relocateRanges(t, ctx, conn, `database_name != 'kv'`, []int{4, 5, 6}, []int{1, 2, 3})
+
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModePause,
+		ExpirationLeases: expLeases,
+		Cycles:           staleReadCycles,
+		Workload:         "explicit writes on n1 and reads on n4",
+	})
+
+	// Open a connection to n4 before pausing it, such that reads can be sent
+	// while it is paused, and are processed as soon as it resumes.
+	readConn := c.Conn(ctx, t.L(), pausedNode)
+	defer readConn.Close()
+	readConn.SetMaxOpenConns(1)
+	require.NoError(t, readConn.PingContext(ctx))
+
+	m := c.NewMonitor(ctx, c.Range(1, 6))
+	failer.Ready(ctx, m)
+
+	var staleReads []staleRead
+	m.Go(func(ctx context.Context) error {
+		for cycle := 1; cycle <= staleReadCycles; cycle++ {
+			// Move the lease to n4, and make sure it is serving reads.
+			require.NoError(t, relocateLeases(t, ctx, conn, `database_name = 'kv'`, pausedNode))
+			_, err := readConn.ExecContext(ctx, `SELECT * FROM kv.stale_reads`)
+			require.NoError(t, err)
+
+			t.Status(fmt.Sprintf("pausing n%d (cycle %d)", pausedNode, cycle))
+			failer.Fail(ctx, pausedNode)
+
+			select {
+			case <-time.After(staleReadPauseDuration):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			// Write a new value to all keys via n1. This can only commit once the
+			// lease has been acquired by another replica.
+			t.Status(fmt.Sprintf("writing via n1 while n%d is paused (cycle %d)", pausedNode, cycle))
+			var writeTS string
+			require.NoError(t, crdb.ExecuteTx(ctx, conn, nil, func(tx *gosql.Tx) error {
+				if _, err := tx.ExecContext(ctx, `UPDATE kv.stale_reads SET v = $1`, cycle); err != nil {
+					return err
+				}
+				return tx.QueryRowContext(ctx, `SELECT cluster_logical_timestamp()::STRING`).Scan(&writeTS)
+			}))
+
+			// Start reading via n4 before resuming it, such that the first read is
+			// processed immediately on resumption.
+			var reads int
+			readsDone := make(chan struct{})
+			go func() {
+				defer close(readsDone)
+				var deadline time.Time
+				for deadline.IsZero() || timeutil.Now().Before(deadline) {
+					readCtx, cancel := context.WithTimeout(ctx, staleReadPauseDuration)
+					rows, err := readConn.QueryContext(readCtx,
+						`SELECT k, v, cluster_logical_timestamp()::STRING FROM kv.stale_reads`)
+					if err == nil {
+						for rows.Next() {
+							var key, value int
+							var readTS string
+							if err = rows.Scan(&key, &value, &readTS); err != nil {
+								break
+							}
+							if value < cycle {
+								staleReads = append(staleReads, staleRead{
+									cycle: cycle, key: key, expected: cycle, observed: value,
+									writeTS: writeTS, readTS: readTS,
+								})
+							}
+						}
+						if err == nil {
+							err = rows.Err()
+						}
+						rows.Close()
+					}
+					cancel()
+					if err != nil {
+						t.L().Printf("read via n%d failed: %s", pausedNode, err)
+					} else {
+						reads++
+					}
+					if deadline.IsZero() {
+						// The first read returns once the node has resumed.
+						deadline = timeutil.Now().Add(staleReadWindow)
+					}
+					if ctx.Err() != nil {
+						return
+					}
+				}
+			}()
+
+			t.Status(fmt.Sprintf("resuming n%d (cycle %d)", pausedNode, cycle))
+			failer.Recover(ctx, pausedNode)
+			<-readsDone
+			require.NotZero(t, reads, "no successful reads via n%d after resuming it", pausedNode)
+			t.L().Printf("cycle %d: %d reads via n%d after resuming it", cycle, reads, pausedNode)
+		}
+		return nil
+	})
+	m.Wait()
+
+	var report strings.Builder
+	report.WriteString("cycle,key,expected,observed,write_ts,read_ts\n")
+	for _, r := range staleReads {
+		fmt.Fprintf(&report, "%d,%d,%d,%d,%s,%s\n",
+			r.cycle, r.key, r.expected, r.observed, r.writeTS, r.readTS)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "stale-reads.txt"),
+		[]byte(report.String()), 0644))
+	if len(staleReads) > 0 {
+		r := staleReads[0]
+		t.Fatalf("observed %d stale reads via paused leaseholder n%d, first: key %d = %d "+
+			"at %s, expected %d written at %s", len(staleReads), pausedNode, r.key, r.observed,
+			r.readTS, r.expected, r.writeTS)
+	}
+}
+
+// gatewayTxnCycles is the number of gateway crashes in runFailoverGatewayTxn.
+const gatewayTxnCycles = 5
+
+const (
+	// leasePreferenceFailoverTimeout bounds how long runFailoverLeasePreferences
+	// waits for the leases to move to the secondary region once the primary
+	// region fails.
+	leasePreferenceFailoverTimeout = 5 * time.Minute
+	// leasePreferenceFailbackTimeout bounds how long runFailoverLeasePreferences
+	// waits for the leases to move back to the primary region once it recovers.
+	// This is enforced by the lease and replicate queues, which may take a while
+	// to get around to all of the ranges.
+	leasePreferenceFailbackTimeout = 15 * time.Minute
+)
+
+// runFailoverLeasePreferences tests that ordered lease preferences are honored
+// when the preferred region fails: the leases should move to the second
+// preferred region rather than any surviving region, and move back once the
+// preferred region recovers. It is similar to runFailoverNonSystem, except:
+//
+//   - The nodes are started with region localities, and the workload table has
+//     the lease preferences [+region=us-east1], [+region=us-west1].
+//
+//   - All ranges are replicated to all nodes, such that losing us-east1 (2/5
+//     replicas) does not lose quorum for any range.
+//
+//   - SQL clients connect to the nodes outside of us-east1.
+//
+// The cluster layout is as follows:
+//
+// n1-n2: us-east1, preferred leaseholders.
+// n3-n4: us-west1, secondary leaseholders.
+// n5:    europe-west1.
+// n6:    Workload runner.
+//
+// Both us-east1 nodes are crashed at once, and we measure how long it takes for
+// all workload leases to move to us-west1. The nodes are then restarted, and we
+// measure how long it takes for the leases to move back to us-east1. The
+// durations are written to lease-preferences.txt. The test fails if the leases
+// don't move to us-west1, but only reports if they don't move back.
+func runFailoverLeasePreferences(
+	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool,
+) {
+	require.Equal(t, 6, c.Spec().NodeCount)
+
+	primaryNodes := []int{1, 2}
+	secondaryNodes := []int{3, 4}
+	gateways := []int{3, 4, 5}
+	workloadNode := 6
+	localities := []string{
+		"region=us-east1,zone=us-east1-b",
+		"region=us-east1,zone=us-east1-c",
+		"region=us-west1,zone=us-west1-a",
+		"region=us-west1,zone=us-west1-b",
+		"region=europe-west1,zone=europe-west1-b",
+	}
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := install.MakeClusterSettings()
+
+	failer := makeFailer(t, c, failureModeCrash, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 5), localities: localities, gateway: gateways[0]})
+	defer conn.Close()
+
+	// Replicate all ranges to all nodes.
+	configureAllZones(t, ctx, conn, zoneConfig{replicas: 5})
+	require.NoError(t, WaitForReplication(ctx, t, conn, 5 /* replicationFactor */))
+	requireFullyReplicated(ctx, t, conn)
+
+	// Create the kv database with ordered lease preferences, and start out with
+	// all leases on n1.
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{
+		replicas:         5,
+		leasePreferences: []string{"+region=us-east1", "+region=us-west1"},
+	})
+	c.Run(ctx, c.Node(workloadNode), fmt.Sprintf(
+		`./cockroach workload init kv --splits 100 {pgurl:%d}`, gateways[0]))
+
+	require.NoError(t, relocateLeases(t, ctx, conn, `database_name = 'kv'`, primaryNodes[0]))
+
+	workloadCmd := `./cockroach workload run kv ` +
+		`--read-percent 50 --duration 60m --concurrency 256 --max-rate 2048 --timeout 1m ` +
+		`--tolerate-errors --histograms=` + t.PerfArtifactsDir() + `/stats.json`
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeCrash,
+		ExpirationLeases: expLeases,
+		Cycles:           1,
+		Workload:         workloadCmd,
+		Localities:       localities,
+	})
+
+	// Start workload on n6, using n3-n5 as gateways. It is canceled once the
+	// leases have moved back, or failed to.
+	m, cancelWorkload := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 5), failoverWorkload{
+		name: "kv", node: workloadNode, gateways: gateways, cmds: []string{workloadCmd}})
+	defer cancelWorkload()
+
+	failer.Ready(ctx, m)
+
+	var failover, failback time.Duration
+	var failedBack bool
+	m.Go(func(ctx context.Context) error {
+		defer cancelWorkload()
+
+		t.Status(fmt.Sprintf("failing us-east1 (n%v)", primaryNodes))
+		for _, node := range primaryNodes {
+			failer.Fail(ctx, node)
+		}
+		var err error
+		failover, err = waitForLeasesOn(ctx, t, conn, `database_name = 'kv'`, secondaryNodes,
+			leasePreferenceFailoverTimeout)
+		require.NoError(t, err)
+		t.L().Printf("leases moved to us-west1 after %s", failover)
+
+		// Failers restart nodes without their localities, so we restart them
+		// ourselves.
+		t.Status(fmt.Sprintf("recovering us-east1 (n%v)", primaryNodes))
+		startWithLocalities(ctx, t, c, opts, settings, c.Nodes(primaryNodes...),
+			localities[:len(primaryNodes)])
+		for _, node := range primaryNodes {
+			require.NoError(t, waitForNodeRejoin(ctx, t, conn, node, nodeRejoinTimeout))
+		}
+		failback, err = waitForLeasesOn(ctx, t, conn, `database_name = 'kv'`, primaryNodes,
+			leasePreferenceFailbackTimeout)
+		if err != nil {
+			t.L().Printf("leases did not move back to us-east1: %s", err)
+		} else {
+			failedBack = true
+			t.L().Printf("leases moved back to us-east1 after %s", failback)
+		}
+		return nil
+	})
+	m.Wait()
+	assertWorkloadHistograms(ctx, t, c, workloadNode, t.PerfArtifactsDir()+"/stats.json")
+
+	report := fmt.Sprintf("failover_duration,failed_back,failback_duration\n%s,%t,%s\n",
+		failover, failedBack, failback)
+	t.L().Printf("lease preference failover:\n%s", report)
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "lease-preferences.txt"),
+		[]byte(report), 0644))
+}
+
+const (
+	// followerReadCycles is the number of times runFailoverFollowerReadsPartition
+	// partitions the follower from the leaseholder.
+	followerReadCycles = 3
+	// followerReadPartitionDuration is the duration of each partition in
+	// runFailoverFollowerReadsPartition, and also the time between partitions.
+	followerReadPartitionDuration = time.Minute
+	// followerReadTimeout is the timeout of each follower read in
+	// runFailoverFollowerReadsPartition. Reads may not be served while
+	// partitioned, which is tolerated.
+	followerReadTimeout = 10 * time.Second
+)
+
+// followerReadWrite is a write observed by runFailoverFollowerReadsPartition.
+type followerReadWrite struct {
+	value int
+	ts    hlc.Timestamp
+}
+
+// followerRead is a follower read observed by
+// runFailoverFollowerReadsPartition. The read was done at readTS, and returned
+// the given value written at valueTS, if found.
+type followerRead struct {
+	partitioned bool
+	readTS      hlc.Timestamp
+	found       bool
+	value       int
+	valueTS     hlc.Timestamp
+	// staleness is the age of readTS when the read returned.
+	staleness time.Duration
+}
+
+// runFailoverFollowerReadsPartition tests that follower reads remain consistent
+// while the follower is partitioned from the leaseholder. The follower's closed
+// timestamp can't advance while partitioned, so reads at a follower read
+// timestamp must either be served at that timestamp or fail, but never return
+// data that is newer or older than the state of the range at that timestamp.
+// It is similar to runFailoverPausedStaleReads, except:
+//
+//   - The failure is a partial blackhole between the leaseholder and the
+//     follower, rather than a pause.
+//
+//   - SQL clients read from the follower with AS OF SYSTEM TIME
+//     follower_read_timestamp(), and write to n1.
+//
+// The cluster layout is as follows:
+//
+// n1-n3: System ranges and SQL gateway for writes.
+// n4-n6: Workload range, with the lease on n4.
+//
+// A single row is continually incremented via n1, recording the commit
+// timestamp of each write, and continually read via n5 at the follower read
+// timestamp. n5 is partitioned from n4 for 3 cycles. Once done, each read is
+// checked against the writes: it must not return a value written after the read
+// timestamp, and must return the latest value written at or before it. The
+// maximum staleness and number of failed reads, with and without the
+// partition, is written to follower-reads.txt along with any inconsistent
+// reads, which fail the test.
+func runFailoverFollowerReadsPartition(
+	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool,
+) {
+	require.Equal(t, 6, c.Spec().NodeCount)
+
+	const leaseNode, followerNode = 4, 5
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := install.MakeClusterSettings()
+
+	failer := makeFailer(t, c, failureModeBlackhole, opts, settings).(partialFailer)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// Place all ranges on n1-n3.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 6), manualSplits: true, systemNodes: []int{1, 2, 3}})
+	defer conn.Close()
+
+	// Create the kv database, constrained to n4-n6 with the lease on n4.
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{
+		replicas: 3, onlyNodes: []int{4, 5, 6}, leaseNode: leaseNode})
+	_, err = conn.ExecContext(ctx,
+		`CREATE TABLE kv.follower_reads (k INT PRIMARY KEY, v INT NOT NULL)`)
+	require.NoError(t, err)
+
+	relocateRanges(t, ctx, conn, `database_name = 'kv'`, []int{1, 2, 3}, []int{4, 5, 6})
+	relocateRanges(t, ctx, conn, `database_name != 'kv'`, []int{4, 5, 6}, []int{1, 2, 3})
+	require.NoError(t, relocateLeases(t, ctx, conn, `database_name = 'kv'`, leaseNode))
+
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeBlackhole,
+		ExpirationLeases: expLeases,
+		Cycles:           followerReadCycles,
+		Workload:         "explicit writes on n1 and follower reads on n5",
+	})
+
+	// write increments the row, and records the write with its commit
+	// timestamp. The first write inserts the row.
+	var writes []followerReadWrite
+	write := func(ctx context.Context, value int) error {
+		var ts string
+		if err := crdb.ExecuteTx(ctx, conn, nil, func(tx *gosql.Tx) error {
+			if _, err := tx.ExecContext(ctx,
+				`UPSERT INTO kv.follower_reads VALUES (1, $1)`, value); err != nil {
+				return err
+			}
+			return tx.QueryRowContext(ctx, `SELECT cluster_logical_timestamp()::STRING`).Scan(&ts)
+		}); err != nil {
+			return err
+		}
+		commitTS, err := hlc.ParseHLC(ts)
+		if err != nil {
+			return err
+		}
+		writes = append(writes, followerReadWrite{value: value, ts: commitTS})
+		return nil
+	}
+	require.NoError(t, write(ctx, 0))
+
+	readConn := c.Conn(ctx, t.L(), followerNode)
+	defer readConn.Close()
+
+	// read reads the row via n5 at the follower read timestamp.
+	read := func(ctx context.Context) (followerRead, error) {
+		ctx, cancel := context.WithTimeout(ctx, followerReadTimeout)
+		defer cancel()
+		start := timeutil.Now()
+		var now, followerTS time.Time
+		if err := readConn.QueryRowContext(ctx,
+			`SELECT now(), follower_read_timestamp()`).Scan(&now, &followerTS); err != nil {
+			return followerRead{}, err
+		}
+		r := followerRead{readTS: hlc.Timestamp{WallTime: followerTS.UnixNano()}}
+		var valueTS string
+		err := readConn.QueryRowContext(ctx, fmt.Sprintf(
+			`SELECT v, crdb_internal_mvcc_timestamp::STRING FROM kv.follower_reads `+
+				`AS OF SYSTEM TIME '%s' WHERE k = 1`, r.readTS.AsOfSystemTime())).
+			Scan(&r.value, &valueTS)
+		if err == nil {
+			r.found = true
+			r.valueTS, err = hlc.ParseHLC(valueTS)
+		} else if errors.Is(err, gosql.ErrNoRows) {
+			err = nil
+		}
+		r.staleness = now.Sub(followerTS) + timeutil.Since(start)
+		return r, err
+	}
+
+	// Wait for follower reads to observe the initial write.
+	t.Status("waiting for follower reads")
+	require.NoError(t, retry.ForDuration(time.Minute, func() error {
+		r, err := read(ctx)
+		if err == nil && !r.found {
+			err = errors.New("row not found")
+		}
+		return err
+	}))
+	followerReadsBefore := nodeMetric(ctx, t, c, followerNode, "follower_reads.success_count")
+
+	m := c.NewMonitor(ctx, c.Range(1, 6))
+	failer.Ready(ctx, m)
+
+	// Start workers to write and read the row until the partitions are done.
+	var partitioned atomic.Bool
+	var reads []followerRead
+	var readErrors [2]int // by partitioned
+	workloadCtx, cancelWorkload := context.WithCancel(ctx)
+	defer cancelWorkload()
+	m.Go(func(context.Context) error {
+		for value := 1; workloadCtx.Err() == nil; value++ {
+			if err := write(workloadCtx, value); err != nil {
+				if workloadCtx.Err() != nil {
+					return nil // canceled below
+				}
+				// The partition doesn't affect writes, and an ambiguous write would
+				// invalidate the consistency checks below.
+				return errors.Wrapf(err, "write %d failed", value)
+			}
+		}
+		return nil
+	})
+	m.Go(func(context.Context) error {
+		for workloadCtx.Err() == nil {
+			// Reads that overlap a partition at all are considered partitioned.
+			wasPartitioned := partitioned.Load()
+			r, err := read(workloadCtx)
+			if workloadCtx.Err() != nil {
+				return nil // canceled below
+			}
+			r.partitioned = wasPartitioned || partitioned.Load()
+			if err != nil {
+				if r.partitioned {
+					readErrors[1]++
+				} else {
+					readErrors[0]++
+				}
+				t.L().Printf("follower read failed (partitioned=%t): %s", r.partitioned, err)
+				select {
+				case <-time.After(100 * time.Millisecond):
+				case <-workloadCtx.Done():
+				}
+				continue
+			}
+			reads = append(reads, r)
+		}
+		return nil
+	})
+
+	// Start a worker to partition n5 from n4.
+	m.Go(func(ctx context.Context) error {
+		defer cancelWorkload()
+
+		for cycle := 1; cycle <= followerReadCycles; cycle++ {
+			select {
+			case <-time.After(followerReadPartitionDuration):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			t.Status(fmt.Sprintf("partitioning n%d from n%d (cycle %d)",
+				followerNode, leaseNode, cycle))
+			failer.FailPartial(ctx, followerNode, []int{leaseNode})
+			partitioned.Store(true)
+
+			select {
+			case <-time.After(followerReadPartitionDuration):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			t.Status(fmt.Sprintf("recovering n%d (cycle %d)", followerNode, cycle))
+			partitioned.Store(false)
+			failer.Recover(ctx, followerNode)
+			require.NoError(t, waitForNodeRejoin(ctx, t, conn, followerNode, nodeRejoinTimeout))
+		}
+		return nil
+	})
+	m.Wait()
+
+	followerReads := nodeMetric(ctx, t, c, followerNode, "follower_reads.success_count") -
+		followerReadsBefore
+
+	// Check each read against the writes, which are ordered by commit timestamp.
+	var maxStaleness [2]time.Duration // by partitioned
+	var readCounts [2]int             // by partitioned
+	var inconsistent []string
+	for _, r := range reads {
+		var idx int
+		if r.partitioned {
+			idx = 1
+		}
+		readCounts[idx]++
+		if r.staleness > maxStaleness[idx] {
+			maxStaleness[idx] = r.staleness
+		}
+
+		i := sort.Search(len(writes), func(i int) bool { return r.readTS.Less(writes[i].ts) })
+		switch {
+		case r.found && r.readTS.Less(r.valueTS):
+			inconsistent = append(inconsistent, fmt.Sprintf(
+				"read at %s (partitioned=%t) returned v=%d written at %s, after the read timestamp",
+				r.readTS, r.partitioned, r.value, r.valueTS))
+		case i == 0 && r.found:
+			inconsistent = append(inconsistent, fmt.Sprintf(
+				"read at %s (partitioned=%t) returned v=%d, before the row was written at %s",
+				r.readTS, r.partitioned, r.value, writes[0].ts))
+		case i > 0 && (!r.found || r.value != writes[i-1].value):
+			inconsistent = append(inconsistent, fmt.Sprintf(
+				"read at %s (partitioned=%t) returned v=%d (found=%t), expected v=%d written at %s",
+				r.readTS, r.partitioned, r.value, r.found, writes[i-1].value, writes[i-1].ts))
+		}
+	}
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "writes=%d follower_reads_served=%.0f inconsistent_reads=%d\n",
+		len(writes), followerReads, len(inconsistent))
+	report.WriteString("partitioned,reads,read_errors,max_staleness\n")
+	for idx, name := range []string{"false", "true"} {
+		fmt.Fprintf(&report, "%s,%d,%d,%s\n",
+			name, readCounts[idx], readErrors[idx], maxStaleness[idx])
+	}
+	for _, s := range inconsistent {
+		fmt.Fprintf(&report, "%s\n", s)
+	}
+	t.L().Printf("follower reads:\n%s", report.String())
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "follower-reads.txt"),
+		[]byte(report.String()), 0644))
+
+	if len(inconsistent) > 0 {
+		t.Fatalf("%d inconsistent follower reads, first: %s", len(inconsistent), inconsistent[0])
+	}
+}
+
+// runFailoverGatewayTxn tests client-side transaction handling when the SQL
+// gateway of an open transaction crashes. The client opens a transaction on the
+// gateway and writes to it, the gateway is crashed, and the client then
+// attempts to commit. The commit must fail with an ambiguous result or
+// connection error rather than succeed, and a retry of the transaction via a
+// different gateway must succeed.
+//
+//   - No ranges located on the failed node.
+//
+//   - The client holds an explicit transaction open across the failure,
+//     rather than running the kv workload.
+//
+// The error class returned by each commit is logged and written to
+// gateway-txn-errors.txt, but any class is accepted as long as the commit does
+// not succeed.
+//
+// The cluster layout is as follows:
+//
+// n1-n3: All ranges, and SQL gateway for retries.
+// n4:    SQL gateway for the open transactions.
+//
+// n4 crashes and recovers for 5 cycles.
+func runFailoverGatewayTxn(ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool) {
+	require.Equal(t, 4, c.Spec().NodeCount)
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := install.MakeClusterSettings()
+
+	failer := makeFailer(t, c, failureModeCrash, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// Place all ranges on n1-n3, such that n4 is only a SQL gateway.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 4), systemNodes: []int{1, 2, 3}})
+	defer conn.Close()
+
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: []int{1, 2, 3}})
+	_, err = conn.ExecContext(ctx, `CREATE TABLE kv.gateway_txn (k INT PRIMARY KEY, v INT NOT NULL)`)
+	require.NoError(t, err)
+
+	relocateRanges(t, ctx, conn, `true`, []int{4}, []int{1, 2, 3})
+
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeCrash,
+		ExpirationLeases: expLeases,
+		Cycles:           gatewayTxnCycles,
+		Workload:         "explicit transactions on n4",
+	})
+
+	m := c.NewMonitor(ctx, c.Range(1, 4))
+	failer.Ready(ctx, m)
+
+	var report strings.Builder
+	report.WriteString("cycle,class,error\n")
+	m.Go(func(ctx context.Context) error {
+		for cycle := 0; cycle < gatewayTxnCycles; cycle++ {
+			// Open a transaction on n4 and write to it, such that it has intents
+			// outstanding when the gateway crashes.
+			t.Status(fmt.Sprintf("opening transaction on n4 (cycle %d)", cycle))
+			gatewayConn := c.Conn(ctx, t.L(), 4)
+			tx, err := gatewayConn.BeginTx(ctx, nil)
+			require.NoError(t, err)
+			_, err = tx.ExecContext(ctx, `INSERT INTO kv.gateway_txn VALUES ($1, 1)`, cycle)
+			require.NoError(t, err)
+			_, err = tx.ExecContext(ctx, `UPDATE kv.gateway_txn SET v = v + 1 WHERE k = $1`, cycle)
+			require.NoError(t, err)
+
+			t.Status(fmt.Sprintf("failing n4 (%s)", failureModeCrash))
+			failer.Fail(ctx, 4)
+
+			commitErr := tx.Commit()
+			_ = gatewayConn.Close()
+			if commitErr == nil {
+				t.Fatalf("commit succeeded on crashed gateway n4 (cycle %d)", cycle)
+			}
+			class := classifyGatewayTxnError(commitErr)
+			t.L().Printf("commit on crashed gateway failed with %s error: %s", class, commitErr)
+			fmt.Fprintf(&report, "%d,%s,%q\n", cycle, class, commitErr.Error())
+
+			// Retry the transaction via n1. This may have to wait for the
+			// abandoned transaction to expire before its intents can be removed.
+			t.Status(fmt.Sprintf("retrying transaction on n1 (cycle %d)", cycle))
+			require.NoError(t, crdb.ExecuteTx(ctx, conn, nil, func(tx *gosql.Tx) error {
+				if _, err := tx.ExecContext(ctx, `UPSERT INTO kv.gateway_txn VALUES ($1, 1)`, cycle); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, `UPDATE kv.gateway_txn SET v = v + 1 WHERE k = $1`, cycle)
+				return err
+			}))
+			var v int
+			require.NoError(t, conn.QueryRowContext(ctx,
+				`SELECT v FROM kv.gateway_txn WHERE k = $1`, cycle).Scan(&v))
+			require.Equal(t, 2, v, "unexpected value for key %d after retry", cycle)
+
+			t.Status(fmt.Sprintf("recovering n4 (%s)", failureModeCrash))
+			failer.Recover(ctx, 4)
+			require.NoError(t, waitForNodeRejoin(ctx, t, conn, 4, nodeRejoinTimeout))
+		}
+		return nil
+	})
+	m.Wait()
+
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "gateway-txn-errors.txt"),
+		[]byte(report.String()), 0644))
+}
+
+// classifyGatewayTxnError classifies the error returned when committing a
+// transaction whose gateway crashed.
+func classifyGatewayTxnError(err error) string {
+	if pqErr := (*pq.Error)(nil); errors.As(err, &pqErr) {
+		if pgcode.MakeCode(string(pqErr.Code)) == pgcode.StatementCompletionUnknown {
+			return "ambiguous"
+		}
+		return "pgcode-" + string(pqErr.Code)
+	}
+	switch {
+	case errors.Is(err, driver.ErrBadConn):
+		return "bad-conn"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "connection-reset"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection-refused"
+	default:
+		return "other"
+	}
+}
+
+const (
+	// connPoolCycles is the number of gateway crashes in runFailoverConnPool.
+	connPoolCycles = 5
+	// connPoolSize is the number of connections held by the client pool in
+	// runFailoverConnPool.
+	connPoolSize = 32
+	// connPoolQueryInterval is the interval between queries on each pooled
+	// connection.
+	connPoolQueryInterval = 100 * time.Millisecond
+	// connPoolRecoveryTimeout is the maximum time for the pool to recover all
+	// of its connections after the gateway crashes.
+	connPoolRecoveryTimeout = time.Minute
+)
+
+// runFailoverConnPool measures how quickly a client connection pool recovers
+// when the gateway it is connected to crashes. Unlike the workload, which
+// reports the server-side latency of individual operations, this measures
+// the client's experience: how long until every pooled connection is healthy
+// again on another gateway.
+//
+//   - No ranges located on the failed node, such that there is no KV failover,
+//     only a SQL gateway failover.
+//
+//   - The client is a small pool in the test runner, holding connPoolSize
+//     connections that each run a point write every connPoolQueryInterval.
+//
+// All pooled connections are initially established to n4. When a query fails,
+// the connection is discarded, and the client reconnects to the next gateway
+// in turn (n1-n3), as a client with a multi-host connection string would. For
+// each connection, we record:
+//
+//   - detect: the time from the crash until the connection's query failed.
+//   - reconnect: the time for the driver to establish a new connection.
+//   - recover: the time from reconnecting until the first successful query,
+//     i.e. until the cluster served the connection again.
+//
+// The individual timings are written to conn-pool.txt, and their distributions,
+// along with the time until the whole pool recovered, to
+// conn-pool-summary.txt.
+//
+// The cluster layout is as follows:
+//
+// n1-n3: All ranges, and fallback gateways.
+// n4:    Primary SQL gateway.
+//
+// n4 crashes and recovers for connPoolCycles cycles, with a fresh pool each
+// cycle.
+func runFailoverConnPool(ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool) {
+	require.Equal(t, 4, c.Spec().NodeCount)
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := install.MakeClusterSettings()
+
+	failer := makeFailer(t, c, failureModeCrash, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// Place all ranges on n1-n3, such that n4 is only a SQL gateway.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 4), systemNodes: []int{1, 2, 3}})
+	defer conn.Close()
+
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: []int{1, 2, 3}})
+	_, err = conn.ExecContext(ctx, `CREATE TABLE kv.conn_pool (k INT PRIMARY KEY, ts TIMESTAMP)`)
+	require.NoError(t, err)
+
+	relocateRanges(t, ctx, conn, `true`, []int{4}, []int{1, 2, 3})
+
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeCrash,
+		ExpirationLeases: expLeases,
+		Cycles:           connPoolCycles,
+		Workload:         fmt.Sprintf("pool of %d connections to n4", connPoolSize),
+	})
+
+	// The client connects to n4 first, then falls back to n1-n3 in order.
+	gateways := []int{4, 1, 2, 3}
+	urls := make([]string, 0, len(gateways))
+	for _, node := range gateways {
+		nodeURLs, err := c.ExternalPGUrl(ctx, t.L(), c.Node(node), "" /* tenant */)
+		require.NoError(t, err)
+		urls = append(urls, nodeURLs[0])
+	}
+
+	m := c.NewMonitor(ctx, c.Range(1, 4))
+	failer.Ready(ctx, m)
+
+	var reconnects []connPoolReconnect
+	var poolRecoveries []time.Duration
+	m.Go(func(ctx context.Context) error {
+		for cycle := 0; cycle < connPoolCycles; cycle++ {
+			t.Status(fmt.Sprintf("connecting pool to n4 (cycle %d)", cycle))
+			pool := newFailoverConnPool(t, gateways, urls)
+			poolCtx, stopPool := context.WithCancel(ctx)
+			var wg sync.WaitGroup
+			for slot := 0; slot < connPoolSize; slot++ {
+				slot := slot
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					pool.runSlot(poolCtx, slot)
+				}()
+			}
+			stop := func() {
+				stopPool()
+				wg.Wait()
+				pool.close()
+			}
+			if err := pool.waitHealthy(ctx, connPoolRecoveryTimeout); err != nil {
+				stop()
+				return errors.Wrap(err, "pool did not connect")
+			}
+
+			t.Status(fmt.Sprintf("failing n4 (%s)", failureModeCrash))
+			crashedAt := timeutil.Now()
+			pool.markFailure(crashedAt)
+			failer.Fail(ctx, 4)
+
+			err := pool.waitHealthy(ctx, connPoolRecoveryTimeout)
+			recoveredAt := timeutil.Now()
+			stop()
+			if err != nil {
+				return errors.Wrapf(err, "pool did not recover (cycle %d)", cycle)
+			}
+			poolRecovery := recoveredAt.Sub(crashedAt)
+			t.L().Printf("pool recovered in %s (cycle %d)", poolRecovery, cycle)
+			poolRecoveries = append(poolRecoveries, poolRecovery)
+			for _, r := range pool.reconnects() {
+				r.cycle = cycle
+				reconnects = append(reconnects, r)
+			}
+
+			t.Status(fmt.Sprintf("recovering n4 (%s)", failureModeCrash))
+			failer.Recover(ctx, 4)
+			require.NoError(t, waitForNodeRejoin(ctx, t, conn, 4, nodeRejoinTimeout))
+		}
+		return nil
+	})
+	m.Wait()
+
+	var b strings.Builder
+	b.WriteString("cycle,slot,gateway,detect_ms,reconnect_ms,recover_ms\n")
+	var detectTimes, reconnectTimes, recoverTimes []time.Duration
+	for _, r := range reconnects {
+		fmt.Fprintf(&b, "%d,%d,%d,%d,%d,%d\n", r.cycle, r.slot, r.gateway,
+			r.detect().Milliseconds(), r.reconnect().Milliseconds(), r.recovery().Milliseconds())
+		detectTimes = append(detectTimes, r.detect())
+		reconnectTimes = append(reconnectTimes, r.reconnect())
+		recoverTimes = append(recoverTimes, r.recovery())
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "conn-pool.txt"),
+		[]byte(b.String()), 0644))
+
+	var summary strings.Builder
+	summary.WriteString("phase,p50_ms,p90_ms,p99_ms,max_ms\n")
+	for _, phase := range []struct {
+		name      string
+		durations []time.Duration
+	}{
+		{"detect", detectTimes},
+		{"reconnect", reconnectTimes},
+		{"recover", recoverTimes},
+		{"pool", poolRecoveries},
+	} {
+		fmt.Fprintf(&summary, "%s,%s\n", phase.name, durationPercentiles(phase.durations))
+	}
+	t.L().Printf("connection pool recovery:\n%s", summary.String())
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "conn-pool-summary.txt"),
+		[]byte(summary.String()), 0644))
+}
+
+// durationPercentiles formats the p50, p90, p99, and max of the given
+// durations in milliseconds, as CSV fields.
+func durationPercentiles(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "0,0,0,0"
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(q float64) int64 {
+		return sorted[int(q*float64(len(sorted)-1))].Milliseconds()
+	}
+	return fmt.Sprintf("%d,%d,%d,%d", at(0.5), at(0.9), at(0.99), sorted[len(sorted)-1].Milliseconds())
+}
+
+// connPoolReconnect records the recovery of a single pooled connection after a
+// gateway failure.
+type connPoolReconnect struct {
+	cycle       int
+	slot        int
+	gateway     int       // the gateway reconnected to
+	crashedAt   time.Time // the gateway was crashed
+	failedAt    time.Time // the connection's first query failed
+	connectedAt time.Time // a new connection was established
+	recoveredAt time.Time // the first query on the new connection succeeded
+}
+
+func (r connPoolReconnect) detect() time.Duration    { return r.failedAt.Sub(r.crashedAt) }
+func (r connPoolReconnect) reconnect() time.Duration { return r.connectedAt.Sub(r.failedAt) }
+func (r connPoolReconnect) recovery() time.Duration  { return r.recoveredAt.Sub(r.connectedAt) }
+
+// failoverConnPool is a minimal client connection pool, holding a fixed number
+// of connections (slots) which each run point writes until the pool is closed.
+// When a query fails, the slot discards its connection and reconnects to the
+// next gateway in turn.
+type failoverConnPool struct {
+	t        test.Test
+	gateways []int
+	dbs      []*gosql.DB // per gateway
+
+	mu struct {
+		syncutil.Mutex
+		crashedAt  time.Time
+		healthy    map[int]bool // by slot
+		pending    map[int]*connPoolReconnect
+		reconnects []connPoolReconnect
+	}
+}
+
+func newFailoverConnPool(t test.Test, gateways []int, urls []string) *failoverConnPool {
+	p := &failoverConnPool{t: t, gateways: gateways}
+	for _, u := range urls {
+		db, err := gosql.Open("postgres", u)
+		require.NoError(t, err)
+		p.dbs = append(p.dbs, db)
+	}
+	p.mu.healthy = map[int]bool{}
+	p.mu.pending = map[int]*connPoolReconnect{}
+	return p
+}
+
+func (p *failoverConnPool) close() {
+	for _, db := range p.dbs {
+		_ = db.Close()
+	}
+}
+
+// markFailure records the time of a gateway failure, and marks all slots as
+// unhealthy until they have run a successful query after it.
+func (p *failoverConnPool) markFailure(ts time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.crashedAt = ts
+	p.mu.healthy = map[int]bool{}
+}
+
+// reconnects returns the recorded connection recoveries.
+func (p *failoverConnPool) reconnects() []connPoolReconnect {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]connPoolReconnect(nil), p.mu.reconnects...)
+}
+
+// waitHealthy waits until all slots have run a successful query since the last
+// failure (or since starting), and have no pending reconnects.
+func (p *failoverConnPool) waitHealthy(ctx context.Context, timeout time.Duration) error {
+	deadline := timeutil.Now().Add(timeout)
+	for {
+		p.mu.Lock()
+		healthy := len(p.mu.healthy) == connPoolSize && len(p.mu.pending) == 0
+		unhealthy := connPoolSize - len(p.mu.healthy)
+		p.mu.Unlock()
+		if healthy {
+			return nil
+		}
+		if timeutil.Now().After(deadline) {
+			return errors.Errorf("%d of %d connections not healthy after %s",
+				unhealthy, connPoolSize, timeout)
+		}
+		select {
+		case <-time.After(connPoolQueryInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// runSlot runs the given slot's connection until the context is cancelled.
+func (p *failoverConnPool) runSlot(ctx context.Context, slot int) {
+	var gateway int // index into p.gateways
+	var conn *gosql.Conn
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	for ctx.Err() == nil {
+		if conn == nil {
+			conn = p.connect(ctx, slot, &gateway)
+			continue
+		}
+
+		queryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		_, err := conn.ExecContext(queryCtx,
+			`UPSERT INTO kv.conn_pool VALUES ($1, now())`, slot)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		p.mu.Lock()
+		if err == nil {
+			p.mu.healthy[slot] = true
+			if r := p.mu.pending[slot]; r != nil && !r.connectedAt.IsZero() {
+				r.recoveredAt = timeutil.Now()
+				p.mu.reconnects = append(p.mu.reconnects, *r)
+				delete(p.mu.pending, slot)
+			}
+		} else if p.mu.pending[slot] == nil {
+			p.mu.pending[slot] = &connPoolReconnect{
+				slot:      slot,
+				crashedAt: p.mu.crashedAt,
+				failedAt:  timeutil.Now(),
+			}
+		}
+		p.mu.Unlock()
+
+		if err != nil {
+			// Discard the connection, and move on to the next gateway.
+			_ = conn.Close()
+			conn = nil
+			gateway = (gateway + 1) % len(p.gateways)
+			continue
+		}
+
+		select {
+		case <-time.After(connPoolQueryInterval):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// connect establishes a new connection for the given slot, trying the
+// gateways in turn starting at the given one, which is updated to the
+// connected gateway. It returns nil if the context is cancelled.
+func (p *failoverConnPool) connect(ctx context.Context, slot int, gateway *int) *gosql.Conn {
+	for ctx.Err() == nil {
+		connectCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		conn, err := p.dbs[*gateway].Conn(connectCtx)
+		if err == nil {
+			err = conn.PingContext(connectCtx)
+			if err != nil {
+				_ = conn.Close()
+			}
+		}
+		cancel()
+		if err == nil {
+			p.mu.Lock()
+			if r := p.mu.pending[slot]; r != nil {
+				r.connectedAt = timeutil.Now()
+				r.gateway = p.gateways[*gateway]
+			}
+			p.mu.Unlock()
+			return conn
+		}
+		*gateway = (*gateway + 1) % len(p.gateways)
+	}
+	return nil
+}
+
+// failoverSQLGatewayPort is the separate SQL port used by SQL gateways in
+// runFailoverPartialSQLGateway. It must not collide with the RPC port (26257)
+// or the Admin UI port (26258).
+const failoverSQLGatewayPort = 26300
+
+// runFailoverPartialSQLGateway tests a partition between SQL clients and a SQL
+// gateway, where inter-node RPC traffic to and from the gateway is unaffected.
+// This tests SQL client failover separately from KV failover: the partitioned
+// gateway remains a healthy member of the cluster, but clients must route
+// around it via the remaining gateways.
+//
+// Cluster topology:
+//
+// n1-n3: system and user ranges
+// n4-n6: SQL gateways, listening for SQL on a separate port
+// n7:    workload runner and SQL client probes
+//
+// Each of n4-n6 is partitioned in turn by blackholing its SQL port, 3 times.
+// While the partition is in place, we assert that the gateway remains live
+// in the cluster (i.e. RPC traffic is unaffected) and that clients can still
+// connect to the remaining gateways.
+//
+// We run a kv50 workload on SQL gateways and collect pMax latency for graphing.
+// Additionally, client-observed connection errors and latencies for each
+// gateway are written to gateway-probes.csv in the artifacts directory, and the
+// throughput and latency served by each gateway (see
+// gatewayThroughputRecorder) to gateway-throughput.csv, summarized in
+// gateway-throughput.txt.
+func runFailoverPartialSQLGateway(
+	ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool, cfg failoverConfig,
+) {
+	require.Equal(t, 7, c.Spec().NodeCount)
+
+	if c.IsLocal() {
+		// Local cluster nodes share a host, so they can't listen on the same SQL
+		// port, and blackhole failures are skipped anyway.
+		t.Skip("SQL gateway partitions are not supported on local clusters")
+	}
+
+	rng, _ := randutil.NewTestRand()
+
+	// Create cluster. The gateways listen for SQL on a separate port, such that
+	// we can blackhole SQL traffic without affecting RPC traffic.
+	opts := option.DefaultStartOpts()
+	settings := cfg.clusterSettings()
+
+	failer := &blackholeFailer{
+		t:      t,
+		c:      c,
+		input:  true,
+		output: true,
+		ports:  []int{failoverSQLGatewayPort},
+	}
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	gatewayOpts := option.DefaultStartOpts()
+	gatewayOpts.RoachprodOpts.ExtraArgs = append(gatewayOpts.RoachprodOpts.ExtraArgs,
+		fmt.Sprintf("--sql-addr=:%d", failoverSQLGatewayPort))
+
+	// Place all ranges on n1-n3 before starting the gateways, such that they
+	// don't hold any replicas. This test controls the ranges manually.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 3), manualSplits: true, systemNodes: []int{1, 2, 3}})
+	defer conn.Close()
+	c.Start(ctx, t.L(), gatewayOpts, settings, c.Range(4, 6))
+
+	// Create the kv database on n1-n3.
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: []int{1, 2, 3}})
+	c.Run(ctx, c.Node(7), `./cockroach workload init kv --splits 1000 {pgurl:1}`)
+
+	// Wait for the KV table to upreplicate, and move any stray ranges off of
+	// the gateways.
+	waitForUpreplication(t, ctx, conn, `database_name = 'kv'`, 3)
+	relocateRanges(t, ctx, conn, `true`, []int{4, 5, 6}, []int{1, 2, 3})
+
+	const cycles = 3
+	workloadCmd := `./cockroach workload run kv --read-percent 50 ` +
+		`--duration 20m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors ` +
+		`--histograms=` + t.PerfArtifactsDir() + `/stats.json `
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeBlackhole,
+		ExpirationLeases: expLeases,
+		Cycles:           cycles,
+		Workload:         workloadCmd,
+		Config:           cfg.manifest(),
+	})
+
+	// Construct SQL URLs for the gateways' SQL port, both for the workload on
+	// n7 (internal) and the client probes in the test runner (external).
+	gateways := c.Range(4, 6)
+	workloadURLs := failoverSQLGatewayURLs(ctx, t, c, gateways, false /* external */)
+	probeURLs := failoverSQLGatewayURLs(ctx, t, c, gateways, true /* external */)
+
+	// Start workload on n7 using n4-n6 as gateways.
+	t.Status("running workload")
+	m := c.NewMonitor(ctx, c.Range(1, 6))
+	m.Go(func(ctx context.Context) error {
+		c.Run(ctx, c.Node(7), workloadCmd+`'`+strings.Join(workloadURLs, `' '`)+`'`)
+		return nil
+	})
+
+	// Start a client prober, which connects to each gateway once per second
+	// using a fresh connection and records the outcome.
+	prober := newSQLGatewayProber(t, gateways, probeURLs)
+	defer prober.close()
+	probeCtx, stopProbes := context.WithCancel(ctx)
+	defer stopProbes()
+	m.Go(func(ctx context.Context) error {
+		prober.run(probeCtx)
+		return nil
+	})
+
+	// Record the throughput of each gateway, to localize throughput drops.
+	throughput := newGatewayThroughputRecorder(t, conn, gateways)
+	m.Go(func(ctx context.Context) error {
+		throughput.run(probeCtx)
+		return nil
+	})
+
+	// Start a worker to fail and recover the SQL gateways in turn.
+	failer.Ready(ctx, m)
+	m.Go(func(ctx context.Context) error {
+		defer stopProbes()
+
+		var raftCfg base.RaftConfig
+		raftCfg.SetDefaults()
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for i := 0; i < cycles; i++ {
+			for _, node := range gateways {
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+
+				randTimer := time.After(randutil.RandDuration(rng, raftCfg.RangeLeaseRenewalDuration()))
+
+				// Ranges may occasionally escape their constraints. Move them to
+				// where they should be.
+				relocateRanges(t, ctx, conn, `true`, []int{4, 5, 6}, []int{1, 2, 3})
+
+				// Randomly sleep up to the lease renewal interval, to vary the time
+				// between the last lease renewal and the failure. We start the timer
+				// before the range relocation above to run them concurrently.
+				select {
+				case <-randTimer:
+				case <-ctx.Done():
+				}
+
+				t.Status(fmt.Sprintf("failing n%d (blackhole SQL gateway)", node))
+				prober.setPartitioned(node)
+				throughput.setFailed(node)
+				failer.Fail(ctx, node)
+
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+
+				// The partitioned gateway should still be live, since only its SQL
+				// traffic is blackholed.
+				var isLive bool
+				require.NoError(t, conn.QueryRowContext(ctx,
+					`SELECT is_live FROM crdb_internal.gossip_nodes WHERE node_id = $1`, node,
+				).Scan(&isLive))
+				require.True(t, isLive, "n%d not live during SQL partition", node)
+
+				t.Status(fmt.Sprintf("recovering n%d (blackhole SQL gateway)", node))
+				failer.Recover(ctx, node)
+				prober.setPartitioned(0)
+				throughput.setFailed(0)
+			}
+		}
+		return nil
+	})
+	m.Wait()
+	assertWorkloadHistograms(ctx, t, c, 7, t.PerfArtifactsDir()+"/stats.json")
+
+	// Write the probe results, and check that clients could connect to healthy
+	// gateways during the partitions, but not to partitioned ones.
+	require.NoError(t, prober.writeCSV(filepath.Join(t.ArtifactsDir(), "gateway-probes.csv")))
+	require.NoError(t, throughput.writeCSV(
+		filepath.Join(t.ArtifactsDir(), "gateway-throughput.csv")))
+	throughputSummary := throughput.summary()
+	t.L().Printf("gateway throughput:\n%s", throughputSummary)
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "gateway-throughput.txt"),
+		[]byte(throughputSummary), 0644))
+	healthyFailed, partitionedOK := prober.summary()
+	require.Zero(t, healthyFailed, "probes to healthy gateways failed during SQL partition")
+	require.Zero(t, partitionedOK, "probes to partitioned gateways succeeded during SQL partition")
+}
+
+// failoverSQLGatewayURLs returns SQL URLs for the given nodes, using the
+// separate failoverSQLGatewayPort.
+func failoverSQLGatewayURLs(
+	ctx context.Context, t test.Test, c cluster.Cluster, nodes option.NodeListOption, external bool,
+) []string {
+	var urls []string
+	var err error
+	if external {
+		urls, err = c.ExternalPGUrl(ctx, t.L(), nodes, "" /* tenant */)
+	} else {
+		urls, err = c.InternalPGUrl(ctx, t.L(), nodes, "" /* tenant */)
+	}
+	require.NoError(t, err)
+	for i := range urls {
+		u, err := url.Parse(urls[i])
+		require.NoError(t, err)
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(failoverSQLGatewayPort))
+		urls[i] = u.String()
+	}
+	return urls
+}
+
+// sqlGatewayProbe is the outcome of a single SQL gateway client probe.
+type sqlGatewayProbe struct {
+	ts          time.Time
+	node        int
+	partitioned bool // a gateway was partitioned at the time of the probe
+	isTarget    bool // the probed gateway was the partitioned one
+	latency     time.Duration
+	err         error
+}
+
+// sqlGatewayProber probes SQL gateways from the client's perspective, by
+// establishing a new connection to each gateway and running a trivial query.
+type sqlGatewayProber struct {
+	t     test.Test
+	nodes option.NodeListOption
+	dbs   []*gosql.DB
+
+	// partitioned is the currently partitioned node, or 0 if none.
+	partitioned atomic.Int32
+
+	mu struct {
+		syncutil.Mutex
+		probes []sqlGatewayProbe
+	}
+}
+
+func newSQLGatewayProber(
+	t test.Test, nodes option.NodeListOption, urls []string,
+) *sqlGatewayProber {
+	p := &sqlGatewayProber{t: t, nodes: nodes}
+	for _, u := range urls {
+		db, err := gosql.Open("postgres", u)
+		require.NoError(t, err)
+		// Don't keep idle connections around, such that each probe has to
+		// establish a new connection like a reconnecting client would.
+		db.SetMaxIdleConns(0)
+		p.dbs = append(p.dbs, db)
+	}
+	return p
+}
+
+func (p *sqlGatewayProber) close() {
+	for _, db := range p.dbs {
+		_ = db.Close()
+	}
+}
+
+// setPartitioned marks the given node as partitioned, or none if 0.
+func (p *sqlGatewayProber) setPartitioned(nodeID int) {
+	p.partitioned.Store(int32(nodeID))
+}
+
+// run probes all gateways once per second until the context is cancelled.
+func (p *sqlGatewayProber) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		var wg sync.WaitGroup
+		for i := range p.nodes {
+			i := i
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.probe(ctx, p.nodes[i], p.dbs[i])
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func (p *sqlGatewayProber) probe(ctx context.Context, nodeID int, db *gosql.DB) {
+	partitioned := int(p.partitioned.Load())
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	start := timeutil.Now()
+	_, err := db.ExecContext(ctx, `SELECT 1`)
+	latency := timeutil.Since(start)
+
+	// If the partition changed while we were probing, we can't attribute the
+	// outcome to either state, so discard it. Also discard probes that were
+	// cancelled because the prober was stopped.
+	if int(p.partitioned.Load()) != partitioned || errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.probes = append(p.mu.probes, sqlGatewayProbe{
+		ts:          start,
+		node:        nodeID,
+		partitioned: partitioned != 0,
+		isTarget:    partitioned == nodeID,
+		latency:     latency,
+		err:         err,
+	})
+}
+
+// summary returns the number of failed probes to healthy gateways during a
+// partition, and the number of successful probes to partitioned gateways.
+func (p *sqlGatewayProber) summary() (healthyFailed, partitionedOK int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, probe := range p.mu.probes {
+		if !probe.partitioned {
+			continue
+		}
+		if probe.isTarget && probe.err == nil {
+			partitionedOK++
+		} else if !probe.isTarget && probe.err != nil {
+			p.t.L().Printf("probe to healthy gateway n%d failed at %s: %v",
+				probe.node, probe.ts, probe.err)
+			healthyFailed++
+		}
+	}
+	return healthyFailed, partitionedOK
+}
+
+// writeCSV writes the probe results to the given file.
+func (p *sqlGatewayProber) writeCSV(path string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("timestamp,node,partitioned,target,latency_ms,error\n")
+	for _, probe := range p.mu.probes {
+		var errStr string
+		if probe.err != nil {
+			errStr = strconv.Quote(probe.err.Error())
+		}
+		fmt.Fprintf(&b, "%s,%d,%t,%t,%.1f,%s\n", probe.ts.Format(time.RFC3339Nano), probe.node,
+			probe.partitioned, probe.isTarget, float64(probe.latency)/float64(time.Millisecond), errStr)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// gatewayThroughputInterval is the interval at which gatewayThroughputRecorder
+// samples node metrics. Node statuses are only updated every 10 seconds.
+const gatewayThroughputInterval = 10 * time.Second
+
+// gatewayThroughputRecorder attributes SQL throughput and latency to individual
+// gateways, by sampling their node status metrics. This localizes a throughput
+// drop to a failed gateway, and shows whether clients shed it and move the
+// load to the remaining gateways. The metrics are read via the status server of
+// the connection's node, so they're available even when a gateway's SQL port
+// is unreachable.
+type gatewayThroughputRecorder struct {
+	t        test.Test
+	conn     *gosql.DB
+	gateways []int
+
+	// failed is the currently failed gateway, or 0 if none.
+	failed atomic.Int32
+
+	mu struct {
+		syncutil.Mutex
+		samples []gatewayThroughputSample
+	}
+}
+
+// gatewayThroughputSample is a gateway's throughput and latency over a
+// sampling interval.
+type gatewayThroughputSample struct {
+	ts         time.Time
+	node       int
+	failed     bool // the node was failed
+	anyFailed  bool // any gateway was failed
+	qps        float64
+	latencyP99 time.Duration
+}
+
+func newGatewayThroughputRecorder(
+	t test.Test, conn *gosql.DB, gateways []int,
+) *gatewayThroughputRecorder {
+	return &gatewayThroughputRecorder{t: t, conn: conn, gateways: gateways}
+}
+
+// setFailed marks the given gateway as failed, or none if 0.
+func (r *gatewayThroughputRecorder) setFailed(nodeID int) {
+	r.failed.Store(int32(nodeID))
+}
+
+// run samples the gateways' metrics until the context is cancelled.
+func (r *gatewayThroughputRecorder) run(ctx context.Context) {
+	type prevSample struct {
+		updatedAt time.Time
+		count     float64
+	}
+	prev := map[int]prevSample{}
+
+	ticker := time.NewTicker(gatewayThroughputInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		failed := int(r.failed.Load())
+		rows, err := r.conn.QueryContext(ctx, `
+SELECT
+	node_id,
+	updated_at,
+	coalesce((metrics->>'sql.query.count')::FLOAT8, 0),
+	coalesce((metrics->>'sql.service.latency-p99')::FLOAT8, 0)
+FROM crdb_internal.kv_node_status
+WHERE node_id = ANY($1)`, pq.Array(r.gateways))
+		if err != nil {
+			if ctx.Err() == nil {
+				r.t.L().Printf("failed to sample gateway metrics: %s", err)
+			}
+			continue
+		}
+		var samples []gatewayThroughputSample
+		for rows.Next() {
+			var nodeID int
+			var updatedAt time.Time
+			var count, latencyP99 float64
+			if err := rows.Scan(&nodeID, &updatedAt, &count, &latencyP99); err != nil {
+				r.t.L().Printf("failed to sample gateway metrics: %s", err)
+				break
+			}
+			// Only record a sample once the node status has been updated.
+			p, ok := prev[nodeID]
+			if ok && !updatedAt.After(p.updatedAt) {
+				continue
+			}
+			prev[nodeID] = prevSample{updatedAt: updatedAt, count: count}
+			if !ok {
+				continue
+			}
+			samples = append(samples, gatewayThroughputSample{
+				ts:         updatedAt,
+				node:       nodeID,
+				failed:     nodeID == failed,
+				anyFailed:  failed != 0,
+				qps:        (count - p.count) / updatedAt.Sub(p.updatedAt).Seconds(),
+				latencyP99: time.Duration(latencyP99),
+			})
+		}
+		_ = rows.Close()
+
+		r.mu.Lock()
+		r.mu.samples = append(r.mu.samples, samples...)
+		r.mu.Unlock()
+	}
+}
+
+// summary returns each gateway's mean throughput while no gateway was failed,
+// while another gateway was failed, and while it was itself failed.
+func (r *gatewayThroughputRecorder) summary() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type mean struct {
+		sum float64
+		n   int
+	}
+	format := func(m mean) string {
+		if m.n == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f", m.sum/float64(m.n))
+	}
+	var b strings.Builder
+	b.WriteString("node,healthy_qps,other_failed_qps,failed_qps\n")
+	for _, node := range r.gateways {
+		var healthy, otherFailed, failed mean
+		for _, s := range r.mu.samples {
+			if s.node != node {
+				continue
+			}
+			m := &healthy
+			if s.failed {
+				m = &failed
+			} else if s.anyFailed {
+				m = &otherFailed
+			}
+			m.sum += s.qps
+			m.n++
+		}
+		fmt.Fprintf(&b, "%d,%s,%s,%s\n", node, format(healthy), format(otherFailed), format(failed))
+	}
+	return b.String()
+}
+
+// writeCSV writes the samples to the given file.
+func (r *gatewayThroughputRecorder) writeCSV(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("timestamp,node,failed,any_failed,qps,latency_p99_ms\n")
+	for _, s := range r.mu.samples {
+		fmt.Fprintf(&b, "%s,%d,%t,%t,%.1f,%.1f\n", s.ts.Format(time.RFC3339Nano), s.node,
+			s.failed, s.anyFailed, s.qps, float64(s.latencyP99)/float64(time.Millisecond))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// admissionMetricNames are the admission control metrics recorded by
+// admissionMetricsRecorder, along with whether they're counters, which are
+// recorded as per-second rates.
+var admissionMetricNames = []struct {
+	name    string
+	counter bool
+}{
+	{"admission.requested.kv-stores", true},
+	{"admission.admitted.kv-stores", true},
+	{"admission.errored.kv-stores", true},
+	{"admission.wait_queue_length.kv-stores", false},
+	{"admission.wait_queue_length.kv", false},
+	{"admission.granter.used_slots.kv", false},
+	{"admission.granter.io_tokens_exhausted_duration.kv", true},
+}
+
+// admissionMetricsRecorder records the admission control metrics of a set of
+// nodes, by sampling their node status metrics. Like gatewayThroughputRecorder,
+// it reads them via the status server of the connection's node, so they're
+// available even when the sampled node's SQL is unresponsive.
+type admissionMetricsRecorder struct {
+	t     test.Test
+	conn  *gosql.DB
+	nodes []int
+
+	// failed is the currently failed node, or 0 if none.
+	failed atomic.Int32
+
+	mu struct {
+		syncutil.Mutex
+		samples []admissionMetricsSample
+	}
+}
+
+// admissionMetricsSample is a node's admission control metrics over a
+// sampling interval, in the order of admissionMetricNames.
+type admissionMetricsSample struct {
+	ts     time.Time
+	node   int
+	failed bool
+	values []float64
+}
+
+func newAdmissionMetricsRecorder(
+	t test.Test, conn *gosql.DB, nodes []int,
+) *admissionMetricsRecorder {
+	return &admissionMetricsRecorder{t: t, conn: conn, nodes: nodes}
+}
+
+// setFailed marks the given node as failed, or none if 0.
+func (r *admissionMetricsRecorder) setFailed(nodeID int) {
+	r.failed.Store(int32(nodeID))
+}
+
+// run samples the nodes' metrics until the context is cancelled.
+func (r *admissionMetricsRecorder) run(ctx context.Context) {
+	type prevSample struct {
+		updatedAt time.Time
+		metrics   map[string]float64
+	}
+	prev := map[int]prevSample{}
+
+	// Node statuses are updated at the same interval as for
+	// gatewayThroughputRecorder.
+	ticker := time.NewTicker(gatewayThroughputInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		failed := int(r.failed.Load())
+		rows, err := r.conn.QueryContext(ctx,
+			`SELECT node_id, updated_at, metrics FROM crdb_internal.kv_node_status WHERE node_id = ANY($1)`,
+			pq.Array(r.nodes))
+		if err != nil {
+			if ctx.Err() == nil {
+				r.t.L().Printf("failed to sample admission metrics: %s", err)
+			}
+			continue
+		}
+		var samples []admissionMetricsSample
+		for rows.Next() {
+			var nodeID int
+			var updatedAt time.Time
+			var rawMetrics []byte
+			var metrics map[string]float64
+			if err := rows.Scan(&nodeID, &updatedAt, &rawMetrics); err != nil {
+				r.t.L().Printf("failed to sample admission metrics: %s", err)
+				break
+			}
+			if err := json.Unmarshal(rawMetrics, &metrics); err != nil {
+				r.t.L().Printf("failed to decode admission metrics: %s", err)
+				break
+			}
+			// Only record a sample once the node status has been updated.
+			p, ok := prev[nodeID]
+			if ok && !updatedAt.After(p.updatedAt) {
+				continue
+			}
+			prev[nodeID] = prevSample{updatedAt: updatedAt, metrics: metrics}
+			if !ok {
+				continue
+			}
+			sample := admissionMetricsSample{ts: updatedAt, node: nodeID, failed: nodeID == failed}
+			for _, m := range admissionMetricNames {
+				value := metrics[m.name]
+				if m.counter {
+					value = (value - p.metrics[m.name]) / updatedAt.Sub(p.updatedAt).Seconds()
+				}
+				sample.values = append(sample.values, value)
+			}
+			samples = append(samples, sample)
+		}
+		_ = rows.Close()
+
+		r.mu.Lock()
+		r.mu.samples = append(r.mu.samples, samples...)
+		r.mu.Unlock()
+	}
+}
+
+// summary returns the maximum of each metric across all nodes, separately for
+// nodes that were healthy and failed at the time.
+func (r *admissionMetricsRecorder) summary() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("metric,healthy_max,failed_max\n")
+	for i, m := range admissionMetricNames {
+		var healthy, failed float64
+		for _, s := range r.mu.samples {
+			if s.failed {
+				failed = math.Max(failed, s.values[i])
+			} else {
+				healthy = math.Max(healthy, s.values[i])
+			}
+		}
+		fmt.Fprintf(&b, "%s,%.1f,%.1f\n", m.name, healthy, failed)
+	}
+	return b.String()
+}
+
+// writeCSV writes the samples to the given file.
+func (r *admissionMetricsRecorder) writeCSV(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("timestamp,node,failed")
+	for _, m := range admissionMetricNames {
+		b.WriteString("," + m.name)
+	}
+	b.WriteString("\n")
+	for _, s := range r.mu.samples {
+		fmt.Fprintf(&b, "%s,%d,%t", s.ts.Format(time.RFC3339Nano), s.node, s.failed)
+		for _, value := range s.values {
+			fmt.Fprintf(&b, ",%.1f", value)
+		}
+		b.WriteString("\n")
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// runFailoverDoubleFailure tests quorum behavior with 5x replication under
+// multiple simultaneous node failures. A range with 5 replicas tolerates two
+// failures, so crashing two nodes at once should only cause a latency blip,
+// while crashing three nodes at once loses quorum and must make the ranges
+// unavailable.
+//
+// Cluster topology:
+//
+// n1,n7,n8: system ranges and SQL gateways
+// n2-n6:    user ranges (5/5 replicas)
+// n9:       workload runner
+//
+// The test runs in two phases, each with its own kv50 workload and histogram
+// file, such that they can be graphed separately:
+//
+//   - double: pairs of n2-n6 are crashed simultaneously, 3 times. We assert
+//     that all ranges remain available for writes while the pair is down.
+//
+//   - triple: three of n2-n6 are crashed simultaneously, once. We assert that
+//     the user ranges become unavailable.
+func runFailoverDoubleFailure(ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool) {
+	require.Equal(t, 9, c.Spec().NodeCount)
+
+	rng, _ := randutil.NewTestRand()
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := install.MakeClusterSettings()
+
+	failer := makeFailer(t, c, failureModeCrash, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// Place all ranges on n1,n7,n8.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 8), manualSplits: true, systemNodes: []int{1, 7, 8}})
+	defer conn.Close()
+
+	// Create the kv database with 5 replicas on n2-n6.
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{
+		replicas: 5, onlyNodes: []int{2, 3, 4, 5, 6}})
+	c.Run(ctx, c.Node(9), `./cockroach workload init kv --splits 1000 {pgurl:1}`)
+
+	// Wait for the KV table to upreplicate, and move the ranges into place.
+	waitForUpreplication(t, ctx, conn, `database_name = 'kv'`, 5)
+	relocateRanges(t, ctx, conn, `database_name = 'kv'`, []int{1, 7, 8}, []int{2, 3, 4, 5, 6})
+	relocateRanges(t, ctx, conn, `database_name != 'kv'`, []int{2, 3, 4, 5, 6}, []int{1, 7, 8})
+
+	workloadCmd := `./cockroach workload run kv --read-percent 50 ` +
+		`--concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors `
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeCrash,
+		ExpirationLeases: expLeases,
+		Workload:         workloadCmd,
+	})
+
+	// runPhase runs a workload for the given duration, writing histograms to
+	// a phase-specific directory, while failing and recovering the given node
+	// sets in order. During each failure, checkAvailability is called with the
+	// number of ranges that failed a write probe.
+	runPhase := func(
+		name string, duration time.Duration, nodeSets [][]int, checkAvailability func(failed int),
+	) {
+		t.Status(fmt.Sprintf("running %s failure phase", name))
+		m, _ := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 8), failoverWorkload{
+			name: "kv", node: 9, gateways: []int{1, 7, 8},
+			cmds: []string{fmt.Sprintf(`%s--duration %s --histograms=%s/%s/stats.json`,
+				workloadCmd, duration, t.PerfArtifactsDir(), name)},
+		})
+
+		failer.Ready(ctx, m)
+		m.Go(func(ctx context.Context) error {
+			var raftCfg base.RaftConfig
+			raftCfg.SetDefaults()
+
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+
+			for _, nodes := range nodeSets {
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+
+				randTimer := time.After(randutil.RandDuration(rng, raftCfg.RangeLeaseRenewalDuration()))
+
+				// Ranges may occasionally escape their constraints. Move them
+				// to where they should be.
+				relocateRanges(t, ctx, conn, `database_name = 'kv'`,
+					[]int{1, 7, 8}, []int{2, 3, 4, 5, 6})
+				relocateRanges(t, ctx, conn, `database_name != 'kv'`,
+					[]int{2, 3, 4, 5, 6}, []int{1, 7, 8})
+
+				// Randomly sleep up to the lease renewal interval, to vary the time
+				// between the last lease renewal and the failure. We start the timer
+				// before the range relocation above to run them concurrently.
+				select {
+				case <-randTimer:
+				case <-ctx.Done():
+				}
+
+				t.Status(fmt.Sprintf("failing %v (%s, %s)", nodes, failureModeCrash, name))
+				for _, node := range nodes {
+					failer.Fail(ctx, node)
+				}
+
+				// Give the leases time to move, then probe all ranges for writes.
+				select {
+				case <-time.After(2 * raftCfg.RangeLeaseDuration):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				var failed int
+				require.NoError(t, conn.QueryRowContext(ctx,
+					`SELECT count(*) FROM crdb_internal.probe_ranges(INTERVAL '10s', 'write') `+
+						`WHERE error != ''`).Scan(&failed))
+				t.Status(fmt.Sprintf("%d ranges failed write probe with %v down", failed, nodes))
+				checkAvailability(failed)
+
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+
+				t.Status(fmt.Sprintf("recovering %v (%s, %s)", nodes, failureModeCrash, name))
+				for _, node := range nodes {
+					failer.Recover(ctx, node)
+				}
+				// Only check for rejoins once all nodes are back, since followers on
+				// nodes that are still down can't catch up.
+				for _, node := range nodes {
+					require.NoError(t, waitForNodeRejoin(ctx, t, conn, node, nodeRejoinTimeout))
+				}
+			}
+			return nil
+		})
+		m.Wait()
+		assertWorkloadHistograms(ctx, t, c, 9,
+			fmt.Sprintf("%s/%s/stats.json", t.PerfArtifactsDir(), name))
+	}
+
+	// Quorum is preserved with two of five replicas down.
+	runPhase("double", 10*time.Minute, [][]int{{2, 3}, {4, 5}, {6, 2}}, func(failed int) {
+		require.Zero(t, failed, "ranges unavailable with only two replicas down")
+	})
+
+	// Quorum is lost with three of five replicas down.
+	runPhase("triple", 4*time.Minute, [][]int{{2, 3, 4}}, func(failed int) {
+		require.NotZero(t, failed, "ranges available with three replicas down")
+	})
+}
+
+// runFailoverCompound tests a compound failure, where one node has a disk stall
+// while another node is simultaneously partitioned from the rest of the
+// cluster. Real incidents often involve several concurrent failures, which
+// may interact in unexpected ways.
+//
+// Cluster topology:
+//
+// n1-n3: system ranges and SQL gateways
+// n4-n8: user ranges (5/5 replicas)
+// n9:    workload runner
+//
+// The test runs a kv50 workload with batch size 1, using 256 concurrent workers
+// directed at n1-n3 with a rate of 2048 reqs/s. n4 is disk stalled and n5 is
+// blackholed at the same time, with 1 minute between each operation, for 5
+// cycles. Every user range has replicas on both failed nodes, but retains
+// quorum via the remaining three replicas. We assert that all ranges remain
+// available for writes while the nodes are down, and that the nodes rejoin the
+// cluster once recovered.
+//
+// The workload histograms are written to a compound/ directory, and the
+// failure windows are written to compound-windows.txt, such that the
+// histograms can be sliced by window.
+func runFailoverCompound(ctx context.Context, t test.Test, c cluster.Cluster, expLeases bool) {
+	require.Equal(t, 9, c.Spec().NodeCount)
+
+	rng, _ := randutil.NewTestRand()
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := install.MakeClusterSettings()
+
+	failer := makeCompoundFailer(t, c, map[int]failureMode{
+		4: failureModeDiskStall,
+		5: failureModeBlackhole,
+	}, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// Place all ranges on n1-n3.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 8), manualSplits: true, systemNodes: []int{1, 2, 3}})
+	defer conn.Close()
+
+	// Create the kv database with 5 replicas on n4-n8.
+	t.Status("creating workload database")
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{
+		replicas: 5, onlyNodes: []int{4, 5, 6, 7, 8}})
+	c.Run(ctx, c.Node(9), `./cockroach workload init kv --splits 1000 {pgurl:1}`)
+
+	// Wait for the KV table to upreplicate, and move the ranges into place.
+	waitForUpreplication(t, ctx, conn, `database_name = 'kv'`, 5)
+	relocateRanges(t, ctx, conn, `database_name = 'kv'`, []int{1, 2, 3}, []int{4, 5, 6, 7, 8})
+	relocateRanges(t, ctx, conn, `database_name != 'kv'`, []int{4, 5, 6, 7, 8}, []int{1, 2, 3})
+
+	const cycles = 5
+	workloadCmd := `./cockroach workload run kv --read-percent 50 ` +
+		`--duration 15m --concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors ` +
+		`--histograms=` + t.PerfArtifactsDir() + `/compound/stats.json`
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureModeDiskStall + "+" + failureModeBlackhole,
+		ExpirationLeases: expLeases,
+		Cycles:           cycles,
+		Workload:         workloadCmd,
+	})
+
+	// Start workload on n9, using n1-n3 as gateways. Run it for 15 minutes,
+	// since we take ~2-3 minutes to fail and recover the nodes, and we do 5
+	// compound failures.
+	m, _ := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 8), failoverWorkload{
+		name: "kv", node: 9, gateways: []int{1, 2, 3}, cmds: []string{workloadCmd}})
+
+	// Start a worker to fail and recover the nodes together.
+	failer.Ready(ctx, m)
+	var windows []string
+	m.Go(func(ctx context.Context) error {
+		var raftCfg base.RaftConfig
+		raftCfg.SetDefaults()
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for i := 0; i < cycles; i++ {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			randTimer := time.After(randutil.RandDuration(rng, raftCfg.RangeLeaseRenewalDuration()))
+
+			// Ranges may occasionally escape their constraints. Move them
+			// to where they should be.
+			relocateRanges(t, ctx, conn, `database_name = 'kv'`,
+				[]int{1, 2, 3}, []int{4, 5, 6, 7, 8})
+			relocateRanges(t, ctx, conn, `database_name != 'kv'`,
+				[]int{4, 5, 6, 7, 8}, []int{1, 2, 3})
+
+			// Randomly sleep up to the lease renewal interval, to vary the time
+			// between the last lease renewal and the failure. We start the timer
+			// before the range relocation above to run them concurrently.
+			select {
+			case <-randTimer:
+			case <-ctx.Done():
+			}
+
+			t.Status(fmt.Sprintf("failing %s", failer))
+			failStart := timeutil.Now()
+			failer.FailAll(ctx)
+
+			// Give the leases time to move, then probe all ranges for writes.
+			select {
+			case <-time.After(2 * raftCfg.RangeLeaseDuration):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			var failed int
+			require.NoError(t, conn.QueryRowContext(ctx,
+				`SELECT count(*) FROM crdb_internal.probe_ranges(INTERVAL '10s', 'write') `+
+					`WHERE error != ''`).Scan(&failed))
+			t.Status(fmt.Sprintf("%d ranges failed write probe with %s", failed, failer))
+			require.Zero(t, failed, "ranges unavailable with only two replicas down")
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			t.Status(fmt.Sprintf("recovering %s", failer))
+			failer.RecoverAll(ctx)
+			windows = append(windows, fmt.Sprintf("%d,%s,%s", i+1,
+				failStart.Format(time.RFC3339Nano), timeutil.Now().Format(time.RFC3339Nano)))
+			// Only check for rejoins once both nodes are back, since followers on a
+			// node that is still down or partitioned can't catch up.
+			for _, node := range failer.nodes {
+				require.NoError(t, waitForNodeRejoin(ctx, t, conn, node, nodeRejoinTimeout))
+			}
+		}
+		return nil
+	})
+	m.Wait()
+	assertWorkloadHistograms(ctx, t, c, 9, t.PerfArtifactsDir()+"/compound/stats.json")
+
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "compound-windows.txt"),
+		[]byte("cycle,fail_start,recover_end\n"+strings.Join(windows, "\n")+"\n"), 0644))
+}
+
+// tpccFailoverWarehouses is the number of TPCC warehouses used by
+// runFailoverTPCC.
+const tpccFailoverWarehouses = 100
+
+// runFailoverTPCC benchmarks the impact of a leaseholder failure on a TPCC
+// workload. Unlike the kv workload used by the other failover tests, TPCC
+// consists of multi-statement transactions across tables with foreign keys,
+// which is more representative of real applications.
+//
+//   - No system ranges located on the failed node.
+//
+//   - SQL clients do not connect to the failed node.
+//
+// The cluster layout is as follows:
+//
+// n1-n3: System ranges and SQL gateways.
+// n4-n6: TPCC ranges.
+// n7:    Workload runner.
+//
+// The test imports a TPCC dataset with tpccFailoverWarehouses warehouses, and
+// runs the TPCC workload directed at n1-n3 for 20 minutes. n4-n6 fail and
+// recover in order, with 1 minute between each operation, for a total of 6
+// failures. The standard TPCC histograms are exported, and the tpmC,
+// efficiency and per-transaction latencies of the overall run and of each
+// failure window are written to tpcc-failover.txt (see tpccFailoverSummary).
+func runFailoverTPCC(
+	ctx context.Context, t test.Test, c cluster.Cluster, failureMode failureMode, expLeases bool,
+) {
+	require.Equal(t, 7, c.Spec().NodeCount)
+
+	systemNodes := []int{1, 2, 3}
+	tpccNodes := []int{4, 5, 6}
+	workloadNode := 7
+
+	rng, _ := randutil.NewTestRand()
+
+	// Create cluster.
+	opts := option.DefaultStartOpts()
+	settings := install.MakeClusterSettings()
+
+	failer := makeFailer(t, c, failureMode, opts, settings)
+	failer.Setup(ctx)
+	defer failer.Cleanup(ctx)
+
+	// Place all ranges on n1-n3.
+	conn, zoneConfigs := setupFailoverCluster(ctx, t, c, opts, settings, expLeases,
+		failoverClusterSpec{nodes: c.Range(1, 6), manualSplits: true, systemNodes: systemNodes})
+	defer conn.Close()
+
+	// Import the TPCC dataset, and move the warehouses to n4-n6.
+	t.Status("importing tpcc dataset")
+	c.Run(ctx, c.Node(1), tpccImportCmd(tpccFailoverWarehouses))
+	configureZone(t, ctx, conn, `DATABASE tpcc`, zoneConfig{replicas: 3, onlyNodes: tpccNodes})
+	relocateRanges(t, ctx, conn, `database_name = 'tpcc'`, systemNodes, tpccNodes)
+
+	const cycles = 6
+	histogramsPath := t.PerfArtifactsDir() + "/stats.json"
+	workloadCmd := fmt.Sprintf(`./cockroach workload run tpcc `+
+		`--warehouses=%d --duration 20m --tolerate-errors --histograms=%s`,
+		tpccFailoverWarehouses, histogramsPath)
+	finishFailoverPlacement(ctx, t, c, conn, zoneConfigs, failoverManifest{
+		FailureMode:      failureMode,
+		ExpirationLeases: expLeases,
+		Cycles:           cycles,
+		Workload:         workloadCmd,
+	})
+
+	// Start workload on n7, using n1-n3 as gateways. Run it for 20 minutes,
+	// since we take ~2 minutes to fail and recover each node, and we do 6
+	// failures.
+	m, _ := startFailoverWorkload(ctx, t, c, conn, c.Range(1, 6), failoverWorkload{
+		name: "tpcc", node: workloadNode, gateways: systemNodes, cmds: []string{workloadCmd}})
+
+	// Start a worker to fail and recover the TPCC nodes in order.
+	failer.Ready(ctx, m)
+
+	// Fail the test if a node dies outside of the intended failures.
+	deaths := newUnexpectedDeathChecker(t, conn, failureMode)
+	deaths.start(ctx, m)
+	defer deaths.stop()
+
+	var windows []tpccFailoverWindow
+	m.Go(func(ctx context.Context) error {
+		defer deaths.stop()
+
+		var raftCfg base.RaftConfig
+		raftCfg.SetDefaults()
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for cycle, node := range roundRobinFailures(tpccNodes, cycles) {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			randTimer := time.After(randutil.RandDuration(rng, raftCfg.RangeLeaseRenewalDuration()))
+
+			// Ranges may occasionally escape their constraints. Move them
+			// to where they should be.
+			relocateRanges(t, ctx, conn, `database_name = 'tpcc'`, systemNodes, tpccNodes)
+			relocateRanges(t, ctx, conn, `database_name != 'tpcc'`, []int{node}, systemNodes)
+
+			// Randomly sleep up to the lease renewal interval, to vary the time
+			// between the last lease renewal and the failure. We start the timer
+			// before the range relocation above to run them concurrently.
+			select {
+			case <-randTimer:
+			case <-ctx.Done():
+			}
+
+			t.Status(fmt.Sprintf("failing n%d (%s)", node, failureMode))
+			deaths.failing(node)
+			failStart := timeutil.Now()
+			failer.Fail(ctx, node)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			t.Status(fmt.Sprintf("recovering n%d (%s)", node, failureMode))
+			failer.Recover(ctx, node)
+			windows = append(windows, tpccFailoverWindow{
+				cycle: cycle + 1, node: node, start: failStart, end: timeutil.Now()})
+			deaths.recovered(node)
+			if failureMode == failureModeCrash {
+				require.NoError(t, waitForNodeRejoin(ctx, t, conn, node, nodeRejoinTimeout))
+			}
+		}
+		return nil
+	})
+	m.Wait()
+
+	// Fetch the workload histograms and summarize the failover impact.
+	localHistogramsPath := filepath.Join(t.ArtifactsDir(), "tpcc-stats.json")
+	snapshots := fetchWorkloadHistogram(ctx, t, c, workloadNode, histogramsPath,
+		localHistogramsPath)
+	summary := tpccFailoverSummary(snapshots, windows)
+	t.L().Printf("tpcc failover impact:\n%s", summary)
+	require.NoError(t, os.WriteFile(
+		filepath.Join(t.ArtifactsDir(), "tpcc-failover.txt"), []byte(summary), 0644))
+}
+
+// tpccFailoverWindow is the time between a failure and its recovery in
+// runFailoverTPCC.
+type tpccFailoverWindow struct {
+	cycle, node int
+	start, end  time.Time
+}
+
+// tpccFailoverSummary returns the tpmC, efficiency, and per-transaction p99 and
+// max latencies of the overall TPCC run, followed by the same for the
+// histogram ticks within each failure window, one per line.
+func tpccFailoverSummary(
+	snapshots map[string][]histogram.SnapshotTick, windows []tpccFailoverWindow,
+) string {
+	var b strings.Builder
+	writeResult := func(label string, res *tpcc.Result) {
+		fmt.Fprintf(&b, "%s: tpmC=%.1f efc=%.1f%%", label, res.TpmC(), res.Efficiency())
+		names := make([]string, 0, len(res.Cumulative))
+		for name := range res.Cumulative {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			h := res.Cumulative[name]
+			fmt.Fprintf(&b, " %s(p99=%s max=%s)", name,
+				time.Duration(h.ValueAtQuantile(99)), time.Duration(h.Max()))
+		}
+		b.WriteString("\n")
+	}
+
+	writeResult("overall", tpcc.NewResultWithSnapshots(tpccFailoverWarehouses, 0, snapshots))
+	for _, w := range windows {
+		inWindow := map[string][]histogram.SnapshotTick{}
+		for name, ticks := range snapshots {
+			for _, tick := range ticks {
+				if !tick.Now.Before(w.start) && !tick.Now.After(w.end) {
+					inWindow[name] = append(inWindow[name], tick)
+				}
+			}
+		}
+		writeResult(fmt.Sprintf("cycle %d (n%d)", w.cycle, w.node),
+			tpcc.NewResultWithSnapshots(tpccFailoverWarehouses, 0, inWindow))
+	}
+	return b.String()
+}
+
+// failoverConfig contains optional configuration for the failover tests. The
+// zero value retains the default behavior.
+type failoverConfig struct {
+	// fullReplicationTimeout, if non-zero, waits for all ranges to be fully
+	// replicated after each recovery, up to the given timeout, such that the
+	// next failure doesn't start while still recovering from the previous one.
+	fullReplicationTimeout time.Duration
+
+	// settle, if non-zero, waits for the given duration after each recovery
+	// (after waiting for full replication, if enabled) before starting the next
+	// failure cycle, allowing the cluster to rebalance ranges and leases such
+	// that measurements don't bleed across cycles. The regular cycle interval
+	// still applies, so this only has an effect if it's longer than the
+	// remainder of the interval. By default, the next failure happens after one
+	// cycle interval.
+	settle time.Duration
+
+	// rawErrorsDuration, if non-zero, runs a secondary workload that does not
+	// tolerate errors for the given duration immediately after each failure,
+	// and reports the classes of errors returned to clients. This shows what
+	// clients see during the unavailability window, which is otherwise masked
+	// by --tolerate-errors.
+	rawErrorsDuration time.Duration
+
+	// raftCfg overrides the Raft configuration used by the cluster. Unset fields
+	// use the defaults. Only the Raft tick interval, election timeout, heartbeat
+	// interval, and range lease duration can be overridden, since these are
+	// applied to the nodes via environment variables. The lease renewal
+	// duration is also used to randomize the time before each failure.
+	raftCfg base.RaftConfig
+
+	// txnSize, if non-zero, runs the workload with explicit multi-statement
+	// transactions (a SELECT FOR UPDATE followed by an UPSERT of txnSize keys)
+	// instead of individual point writes, and records the rate of transaction
+	// restarts (txn.restarts) during each failure. Transactions experience
+	// failover differently than single-statement writes, e.g. via restarts and
+	// ambiguous commits.
+	txnSize int
+
+	// planned, if true, transfers all leases away from the node before failing
+	// it, emulating a planned maintenance flow (e.g. a rolling restart) rather
+	// than an abrupt failure. This should result in near-zero unavailability.
+	// Only supported by runFailoverNonSystem.
+	planned bool
+
+	// replicas is the replication factor of the ranges, defaulting to 3. A
+	// higher replication factor changes the quorum tolerance and failover
+	// dynamics. The cluster topology is scaled accordingly, see the individual
+	// run functions. Only supported by runFailoverNonSystem and
+	// runFailoverLiveness, since the other tests rely on specific topologies and
+	// reject other replication factors.
+	replicas int
+
+	// splitReadWrite, if true, runs concurrent read-only and write-only
+	// workloads instead of a single mixed workload, with separate histograms.
+	// This shows how a failover affects reads and writes at the same time.
+	splitReadWrite bool
+
+	// fixedFailureOffset, if true, fails the node exactly failureOffset into
+	// each cycle (zero to not sleep at all), instead of after a random duration
+	// up to the lease renewal interval. This removes timing noise, e.g. for A/B
+	// comparisons of a code change, at the expense of coverage. It can also be
+	// enabled via envFailoverFailureOffset.
+	fixedFailureOffset bool
+	failureOffset      time.Duration
+
+	// spanPercent, if non-zero, replaces this percentage of the workload's point
+	// reads with spanning scans of up to failoverSpanLimit rows. Scans can touch
+	// several ranges, including the failed leaseholder's, so they fail over
+	// differently than point reads. They are recorded in a separate span
+	// histogram. Only supported by runFailoverNonSystem, and not in combination
+	// with splitReadWrite.
+	spanPercent int
+
+	// minOpFraction is the minimum fraction of the workload's maximum number of
+	// operations (--max-rate × --duration) that must succeed, defaulting to
+	// failoverMinOpFraction. See assertWorkloadOpCount.
+	minOpFraction float64
+
+	// latencySLO, if set, fails the test if the workload's p99 or pMax latency
+	// exceeds it over the measurement window, for regression gating. By
+	// default, latencies are only reported for graphing. See assertLatencySLO.
+	latencySLO failoverLatencySLO
+
+	// leaseTrigger, if true, fails each node as soon as it holds the lease of
+	// the hottest kv range, rather than after the pre-failure delay, to
+	// maximize the disruption. The kv workload writes uniformly across the
+	// keyspace, so the largest range is taken to be the hottest. If the node
+	// doesn't acquire the lease within leaseTriggerTimeout, the pre-failure
+	// delay is used instead. The condition that triggered each failure is
+	// written to failure-triggers.txt. Only supported by runFailoverNonSystem.
+	leaseTrigger bool
+
+	// admissionMetrics, if true, records the kv nodes' admission control metrics
+	// throughout the test to admission.csv, summarized in admission.txt, to
+	// show whether admission control throttles work on a failed node rather
+	// than letting it pile up. The disk stall detector's fatal timeout is
+	// disabled, such that a disk-stalled node stays up for the duration of the
+	// failure. Only supported by runFailoverNonSystem.
+	admissionMetrics bool
+
+	// secure, if true, runs the test against a secure cluster, as production
+	// clusters always are. TLS changes the connection setup and teardown
+	// timings, and thus the recovery behavior after e.g. a blackhole or crash.
+	// Clients connect using the cluster's certs.
+	secure bool
+
+	// goroutineDumps, if true, fetches a goroutine dump from the failed node
+	// immediately before each recovery, and writes it to goroutines.txt in the
+	// cycle's artifacts directory. This helps diagnose nodes that misbehave on
+	// recovery, e.g. after a pause or disk stall. See captureGoroutineDump.
+	// Only supported by runFailoverNonSystem, and not in combination with
+	// secure.
+	goroutineDumps bool
+}
+
+// goroutineDumpTimeout is the timeout for fetching a goroutine dump, see
+// failoverConfig.goroutineDumps.
+const goroutineDumpTimeout = 10 * time.Second
+
+// captureGoroutineDump fetches a goroutine dump from the given failed node and
+// writes it to goroutines.txt in the cycle directory, if enabled. It is
+// best-effort, and only logs failures, since the failed node may well be
+// unable to respond. A paused process can't serve the request at all, so with
+// failureModePause the process is briefly continued while fetching the dump,
+// and then stopped again until it is recovered.
+func (cfg failoverConfig) captureGoroutineDump(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	failureMode failureMode,
+	node int,
+	cycleDir string,
+) {
+	if !cfg.goroutineDumps {
+		return
+	}
+	if cfg.secure {
+		t.L().Printf("skipping goroutine dump of n%d: not supported on secure clusters", node)
+		return
+	}
+	if failureMode == failureModePause && c.IsLocal() {
+		// There's no reliable way to find the node's process on a local cluster.
+		t.L().Printf("skipping goroutine dump of paused n%d on local cluster", node)
+		return
+	}
+	addrs, err := c.InternalAdminUIAddr(ctx, t.L(), c.Node(node))
+	if err != nil {
+		t.L().Printf("failed to get admin address of n%d for goroutine dump: %s", node, err)
+		return
+	}
+	cmd := fmt.Sprintf(`curl -sf --max-time %d 'http://%s/debug/pprof/goroutine?debug=2'`,
+		int(goroutineDumpTimeout.Seconds()), addrs[0])
+	if failureMode == failureModePause {
+		cmd = fmt.Sprintf(`pid=$(pgrep -o -f 'cockroach start') && kill -CONT $pid && %s; `+
+			`status=$?; kill -STOP $pid; exit $status`, cmd)
+	}
+	details, err := c.RunWithDetailsSingleNode(ctx, t.L(), c.Node(node), cmd)
+	if err != nil {
+		t.L().Printf("failed to fetch goroutine dump of n%d: %s %s", node, err, details.Stderr)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(cycleDir, "goroutines.txt"),
+		[]byte(details.Stdout), 0644); err != nil {
+		t.L().Printf("failed to write goroutine dump of n%d: %s", node, err)
+	}
+}
+
+// failoverMinOpFraction is the default failoverConfig.minOpFraction. It is far
+// below what the workload achieves even with repeated failovers, and only
+// trips if the cluster is unavailable for much of the test.
+const failoverMinOpFraction = 0.25
+
+// failoverSpanLimit is the maximum number of rows read by each spanning scan,
+// see failoverConfig.spanPercent. It is large enough for scans to typically
+// span several ranges.
+const failoverSpanLimit = 10000
+
+// workloadFlags returns additional flags for the kv workload.
+func (cfg failoverConfig) workloadFlags() string {
+	if cfg.txnSize > 0 {
+		return fmt.Sprintf(" --sfu-writes --batch %d", cfg.txnSize)
+	}
+	return ""
+}
+
+// readPercent returns the workload's point read percentage, given the default
+// percentage, adjusted for spanPercent.
+func (cfg failoverConfig) readPercent(readPercent int) int {
+	if cfg.spanPercent > readPercent {
+		return 0
+	}
+	return readPercent - cfg.spanPercent
+}
+
+// spanFlags returns additional kv workload flags for spanPercent.
+func (cfg failoverConfig) spanFlags() string {
+	if cfg.spanPercent > 0 {
+		return fmt.Sprintf(" --span-percent %d --span-limit %d", cfg.spanPercent, failoverSpanLimit)
+	}
+	return ""
+}
+
+// fetchWorkloadHistograms fetches the given histogram files from the workload
+// node and decodes them. Ticks from all files are combined by operation name.
+func fetchWorkloadHistograms(
+	ctx context.Context, t test.Test, c cluster.Cluster, workloadNode int, histogramPaths []string,
+) map[string][]histogram.SnapshotTick {
+	combined := map[string][]histogram.SnapshotTick{}
+	for i, path := range histogramPaths {
+		localPath := filepath.Join(t.ArtifactsDir(), fmt.Sprintf("workload-ops-%d.json", i))
+		snapshots := fetchWorkloadHistogram(ctx, t, c, workloadNode, path, localPath)
+		for name, ticks := range snapshots {
+			combined[name] = append(combined[name], ticks...)
+		}
+	}
+	return combined
+}
+
+// fetchWorkloadHistogram fetches the given histogram file from the workload
+// node to localPath and decodes it. It fails the test if the file is missing,
+// empty, or can't be decoded, e.g. because the workload exited early or was
+// given the wrong path, since the test would otherwise silently produce no
+// perf data.
+func fetchWorkloadHistogram(
+	ctx context.Context, t test.Test, c cluster.Cluster, workloadNode int, path, localPath string,
+) map[string][]histogram.SnapshotTick {
+	if err := c.Get(ctx, t.L(), path, localPath, c.Node(workloadNode)); err != nil {
+		t.Fatalf("failed to fetch workload histograms %s from n%d: %s", path, workloadNode, err)
+	}
+	info, err := os.Stat(localPath)
+	require.NoError(t, err)
+	size := humanizeutil.IBytes(info.Size())
+	t.L().Printf("found workload histograms %s on n%d (%s)", path, workloadNode, size)
+	if info.Size() == 0 {
+		t.Fatalf("workload histograms %s on n%d are empty", path, workloadNode)
+	}
+	snapshots, err := histogram.DecodeSnapshots(localPath)
+	if err != nil {
+		t.Fatalf("failed to decode workload histograms %s on n%d (%s): %s",
+			path, workloadNode, size, err)
+	}
+	if len(snapshots) == 0 {
+		t.Fatalf("workload histograms %s on n%d (%s) contain no operations",
+			path, workloadNode, size)
+	}
+	return snapshots
+}
+
+// assertWorkloadHistograms checks that the given histogram files on the
+// workload node exist and can be decoded, see fetchWorkloadHistogram. It is
+// used by tests that don't otherwise inspect the histograms.
+func assertWorkloadHistograms(
+	ctx context.Context, t test.Test, c cluster.Cluster, workloadNode int, paths ...string,
+) {
+	dir, err := os.MkdirTemp("", "workload-histograms")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	for i, path := range paths {
+		fetchWorkloadHistogram(ctx, t, c, workloadNode, path,
+			filepath.Join(dir, fmt.Sprintf("stats-%d.json", i)))
+	}
+}
+
+// assertWorkloadOpCount fails the test if the workload recorded fewer than
+// minOpFraction of maxRate × duration successful operations in the given
+// histograms (see fetchWorkloadHistograms). The failover tests only export
+// latency graphs, so a catastrophic failover would otherwise pass silently.
+func (cfg failoverConfig) assertWorkloadOpCount(
+	t test.Test,
+	snapshots map[string][]histogram.SnapshotTick,
+	maxRate int,
+	duration time.Duration,
+) {
+	fraction := cfg.minOpFraction
+	if fraction == 0 {
+		fraction = failoverMinOpFraction
+	}
+	expected := int64(fraction * float64(maxRate) * duration.Seconds())
+
+	var ops int64
+	for _, ticks := range snapshots {
+		for _, tick := range ticks {
+			for _, count := range tick.Hist.Counts {
+				ops += count
+			}
+		}
+	}
+
+	msg := fmt.Sprintf("workload completed %d operations, expected at least %d "+
+		"(%.0f%% of %d ops/s for %s)", ops, expected, 100*fraction, maxRate, duration)
+	t.L().Printf("%s", msg)
+	if ops < expected {
+		t.Fatal(msg)
+	}
+}
+
+// failoverLatencySLO is an envelope for the workload's operation latencies over
+// a test's measurement window. Zero fields are not asserted.
+type failoverLatencySLO struct {
+	p99  time.Duration
+	pMax time.Duration
+}
+
+// assertLatencySLO computes the p99 and pMax latencies of each operation type
+// in the given histograms (see fetchWorkloadHistograms), from the given start
+// of the measurement window, and writes them to latency-slo.txt. It fails the
+// test if they exceed latencySLO. Without a latencySLO, nothing is asserted.
+func (cfg failoverConfig) assertLatencySLO(
+	t test.Test, snapshots map[string][]histogram.SnapshotTick, since time.Time,
+) {
+	names := make([]string, 0, len(snapshots))
+	for name := range snapshots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	var violations []string
+	for _, name := range names {
+		var h *hdrhistogram.Histogram
+		for _, tick := range snapshots[name] {
+			if tick.Now.Before(since) {
+				continue
+			}
+			if h == nil {
+				h = hdrhistogram.Import(tick.Hist)
+			} else {
+				h.Merge(hdrhistogram.Import(tick.Hist))
+			}
+		}
+		if h == nil {
+			continue
+		}
+		p99, pMax := time.Duration(h.ValueAtQuantile(99)), time.Duration(h.Max())
+		fmt.Fprintf(&b, "%s: p99=%s pMax=%s\n", name, p99, pMax)
+		if slo := cfg.latencySLO.p99; slo > 0 && p99 > slo {
+			violations = append(violations, fmt.Sprintf("%s p99 %s exceeds SLO %s", name, p99, slo))
+		}
+		if slo := cfg.latencySLO.pMax; slo > 0 && pMax > slo {
+			violations = append(violations, fmt.Sprintf("%s pMax %s exceeds SLO %s", name, pMax, slo))
+		}
+	}
+	t.L().Printf("workload latencies:\n%s", b.String())
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "latency-slo.txt"),
+		[]byte(b.String()), 0644))
+	if len(violations) > 0 {
+		t.Fatalf("latency SLO violated: %s", strings.Join(violations, "; "))
+	}
+}
+
+// txnRestarts returns the total number of transaction restarts across the
+// given gateways, or 0 if txnSize is not set.
+func (cfg failoverConfig) txnRestarts(
+	ctx context.Context, t test.Test, c cluster.Cluster, gateways []int,
+) float64 {
+	if cfg.txnSize == 0 {
+		return 0
+	}
+	var restarts float64
+	for _, node := range gateways {
+		restarts += nodeMetric(ctx, t, c, node, "txn.restarts")
+	}
+	return restarts
+}
+
+// reportTxnRestarts logs the number and rate of transaction restarts during a
+// failure, given the restart counts before and after it, and writes it to
+// txn-restarts.txt in the cycle directory. It does nothing if txnSize is not
+// set.
+func (cfg failoverConfig) reportTxnRestarts(
+	t test.Test, cycleDir string, node int, before, after float64, duration time.Duration,
+) {
+	if cfg.txnSize == 0 {
+		return
+	}
+	restarts := after - before
+	report := fmt.Sprintf("n%d: %.0f txn restarts in %s (%.1f/s)",
+		node, restarts, duration.Round(time.Second), restarts/duration.Seconds())
+	t.L().Printf("%s", report)
+	require.NoError(t, os.WriteFile(
+		filepath.Join(cycleDir, "txn-restarts.txt"), []byte(report+"\n"), 0644))
+}
+
+// replicationFactor returns the replication factor, defaulting to 3.
+func (cfg failoverConfig) replicationFactor() int {
+	if cfg.replicas == 0 {
+		return 3
+	}
+	return cfg.replicas
+}
+
+// failoverNodeRange returns the given number of consecutive node IDs, starting
+// at first.
+func failoverNodeRange(first, count int) []int {
+	nodes := make([]int, 0, count)
+	for i := 0; i < count; i++ {
+		nodes = append(nodes, first+i)
+	}
+	return nodes
+}
+
+// preFailureDelay returns the time to wait before failing a node in the given
+// cycle, and logs it. By default, this is a random duration up to the lease
+// renewal interval, to vary the time between the last lease renewal and the
+// failure.
+func (cfg failoverConfig) preFailureDelay(
+	t test.Test, rng *rand.Rand, raftCfg base.RaftConfig, cycle int,
+) time.Duration {
+	if !cfg.fixedFailureOffset {
+		if env := os.Getenv(envFailoverFailureOffset); env != "" {
+			offset, err := time.ParseDuration(env)
+			require.NoError(t, err, "invalid %s", envFailoverFailureOffset)
+			cfg.fixedFailureOffset, cfg.failureOffset = true, offset
+		}
+	}
+	if cfg.fixedFailureOffset {
+		t.L().Printf("cycle %d: failing after fixed offset %s", cycle+1, cfg.failureOffset)
+		return cfg.failureOffset
+	}
+	offset := randutil.RandDuration(rng, raftCfg.RangeLeaseRenewalDuration())
+	t.L().Printf("cycle %d: failing after random offset %s", cycle+1, offset)
+	return offset
+}
+
+// leaseTriggerTimeout is the maximum duration to wait for a node to acquire the
+// hottest lease before falling back to the pre-failure delay, see
+// failoverConfig.leaseTrigger. It leaves most of the cycle for the failure.
+const leaseTriggerTimeout = 20 * time.Second
+
+// waitForLeaseTrigger waits for the given node to hold the lease of the hottest
+// kv range, polling SHOW CLUSTER RANGES, see failoverConfig.leaseTrigger. It
+// returns a description of the condition that was met, or of the timeout, and
+// whether the condition was met.
+func waitForLeaseTrigger(
+	ctx context.Context, t test.Test, conn *gosql.DB, node int, timeout time.Duration,
+) (string, bool) {
+	var rangeID, leaseholder int
+	if err := conn.QueryRowContext(ctx, `SELECT range_id, lease_holder `+
+		`FROM [SHOW CLUSTER RANGES WITH TABLES, DETAILS] WHERE database_name = 'kv' `+
+		`ORDER BY range_size DESC LIMIT 1`).Scan(&rangeID, &leaseholder); err != nil {
+		return fmt.Sprintf("failed to find hottest range: %s", err), false
+	}
+	if leaseholder == node {
+		return fmt.Sprintf("already held lease for hottest range r%d", rangeID), true
+	}
+
+	t.Status(fmt.Sprintf("waiting for n%d to acquire lease for r%d (held by n%d)",
+		node, rangeID, leaseholder))
+	start := timeutil.Now()
+	for timeutil.Since(start) < timeout {
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err().Error(), false
+		}
+		// Errors are transient, e.g. when the range is being moved, so we just
+		// keep polling.
+		if err := conn.QueryRowContext(ctx, `SELECT lease_holder `+
+			`FROM [SHOW CLUSTER RANGES WITH DETAILS] WHERE range_id = $1`, rangeID).
+			Scan(&leaseholder); err == nil && leaseholder == node {
+			return fmt.Sprintf("acquired lease for hottest range r%d after %s",
+				rangeID, timeutil.Since(start).Truncate(time.Millisecond)), true
+		}
+	}
+	return fmt.Sprintf("timed out after %s waiting for lease for hottest range r%d "+
+		"(held by n%d), using pre-failure delay", timeout, rangeID, leaseholder), false
+}
+
+// raftConfig returns the Raft configuration, with unset fields populated
+// with defaults.
+func (cfg failoverConfig) raftConfig() base.RaftConfig {
+	raftCfg := cfg.raftCfg
+	raftCfg.SetDefaults()
+	return raftCfg
+}
+
+// clusterSettings returns the settings used to start the cluster's nodes.
+func (cfg failoverConfig) clusterSettings() install.ClusterSettings {
+	settings := install.MakeClusterSettings(install.SecureOption(cfg.secure))
+	settings.Env = append(settings.Env, cfg.raftEnv()...)
+	return settings
+}
+
+// cliHostFlags returns the flags which connect a cockroach CLI command to the
+// node at the given address, using the cluster's certs if secure.
+func (cfg failoverConfig) cliHostFlags(addr string) string {
+	if cfg.secure {
+		return "--certs-dir=certs --host " + addr
+	}
+	return "--insecure --host " + addr
+}
+
+// raftEnv returns environment variables that apply the overridden Raft
+// configuration fields to the nodes.
+func (cfg failoverConfig) raftEnv() []string {
+	var env []string
+	if d := cfg.raftCfg.RaftTickInterval; d != 0 {
+		env = append(env, fmt.Sprintf("COCKROACH_RAFT_TICK_INTERVAL=%s", d))
+	}
+	if n := cfg.raftCfg.RaftElectionTimeoutTicks; n != 0 {
+		env = append(env, fmt.Sprintf("COCKROACH_RAFT_ELECTION_TIMEOUT_TICKS=%d", n))
+	}
+	if n := cfg.raftCfg.RaftHeartbeatIntervalTicks; n != 0 {
+		env = append(env, fmt.Sprintf("COCKROACH_RAFT_HEARTBEAT_INTERVAL_TICKS=%d", n))
+	}
+	if d := cfg.raftCfg.RangeLeaseDuration; d != 0 {
+		env = append(env, fmt.Sprintf("COCKROACH_RANGE_LEASE_DURATION=%s", d))
+	}
+	return env
+}
+
+// waitAfterRecovery is called after a node is recovered, before starting the
+// next failure cycle.
+func (cfg failoverConfig) waitAfterRecovery(ctx context.Context, t test.Test, conn *gosql.DB) {
+	if cfg.fullReplicationTimeout > 0 {
+		_, err := waitForFullReplication(ctx, t, conn, cfg.fullReplicationTimeout)
+		require.NoError(t, err)
+	}
+	if cfg.settle > 0 {
+		t.Status(fmt.Sprintf("waiting %s for cluster to settle", cfg.settle))
+		select {
+		case <-time.After(cfg.settle):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// captureRawErrors is called immediately after a node is failed. If
+// rawErrorsDuration is set, it runs a secondary kv workload from the given
+// workload node against the given gateways (a {pgurl} expansion) with errors
+// not tolerated, and records the errors returned during the unavailability
+// window in the given taxonomy. Since the workload exits on the first error,
+// it is restarted until rawErrorsDuration has elapsed. The errors for this
+// failure alone are written to raw-errors.txt in the given cycle directory.
+func (cfg failoverConfig) captureRawErrors(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	workloadNode int,
+	gateways string,
+	cycleDir string,
+	taxonomy *workloadErrorTaxonomy,
+) {
+	if cfg.rawErrorsDuration == 0 {
+		return
+	}
+	cycleTaxonomy := &workloadErrorTaxonomy{}
+	defer func() {
+		taxonomy.merge(cycleTaxonomy)
+		require.NoError(t, os.WriteFile(filepath.Join(cycleDir, "raw-errors.txt"),
+			[]byte(cycleTaxonomy.report()), 0644))
+	}()
+	deadline := timeutil.Now().Add(cfg.rawErrorsDuration)
+	for ctx.Err() == nil {
+		remaining := deadline.Sub(timeutil.Now()).Round(time.Second)
+		if remaining < time.Second {
+			break
+		}
+		details, err := c.RunWithDetailsSingleNode(ctx, t.L(), c.Node(workloadNode), fmt.Sprintf(
+			`./cockroach workload run kv --read-percent 50 --duration %s --concurrency 8 `+
+				`--timeout 10s --tolerate-errors=false %s`, remaining, gateways))
+		if err == nil {
+			cycleTaxonomy.recordSuccess()
+			continue
+		}
+		cycleTaxonomy.record(details.Stdout + details.Stderr)
+	}
+	t.Status(fmt.Sprintf("raw workload errors: %s", cycleTaxonomy))
+}
+
+// reportRawErrors writes the raw error taxonomy to raw-errors.txt in the
+// artifacts directory, if rawErrorsDuration is set.
+func (cfg failoverConfig) reportRawErrors(t test.Test, taxonomy *workloadErrorTaxonomy) {
+	if cfg.rawErrorsDuration == 0 {
+		return
+	}
+	t.Status(fmt.Sprintf("raw workload errors: %s", taxonomy))
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "raw-errors.txt"),
+		[]byte(taxonomy.report()), 0644))
+}
+
+// workloadErrorClass is a class of error returned to a workload client.
+type workloadErrorClass string
+
+const (
+	workloadErrorAmbiguous   workloadErrorClass = "ambiguous result"
+	workloadErrorDeadline    workloadErrorClass = "context deadline"
+	workloadErrorUnavailable workloadErrorClass = "unavailable"
+	workloadErrorConnection  workloadErrorClass = "connection"
+	workloadErrorRetry       workloadErrorClass = "retry"
+	workloadErrorOther       workloadErrorClass = "other"
+)
+
+// classifyWorkloadError classifies the error in the given workload output.
+func classifyWorkloadError(output string) workloadErrorClass {
+	output = strings.ToLower(output)
+	switch {
+	case strings.Contains(output, "result is ambiguous"):
+		return workloadErrorAmbiguous
+	case strings.Contains(output, "context deadline exceeded"),
+		strings.Contains(output, "query execution canceled due to statement timeout"):
+		return workloadErrorDeadline
+	case strings.Contains(output, "unavailable"):
+		return workloadErrorUnavailable
+	case strings.Contains(output, "connection refused"),
+		strings.Contains(output, "connection reset"),
+		strings.Contains(output, "broken pipe"),
+		strings.Contains(output, "bad connection"):
+		return workloadErrorConnection
+	case strings.Contains(output, "restart transaction"):
+		return workloadErrorRetry
+	default:
+		return workloadErrorOther
+	}
+}
+
+// workloadErrorTaxonomy aggregates workload errors by class, keeping the
+// first output seen for each class as a sample.
+type workloadErrorTaxonomy struct {
+	successes int
+	counts    map[workloadErrorClass]int
+	samples   map[workloadErrorClass]string
+}
+
+// recordSuccess records a workload run that completed without errors.
+func (e *workloadErrorTaxonomy) recordSuccess() {
+	e.successes++
+}
+
+// record classifies and records the error in the given workload output.
+func (e *workloadErrorTaxonomy) record(output string) {
+	if e.counts == nil {
+		e.counts = map[workloadErrorClass]int{}
+		e.samples = map[workloadErrorClass]string{}
+	}
+	class := classifyWorkloadError(output)
+	e.counts[class]++
+	if _, ok := e.samples[class]; !ok {
+		e.samples[class] = strings.TrimSpace(output)
+	}
+}
+
+// merge adds the errors recorded in other to this taxonomy.
+func (e *workloadErrorTaxonomy) merge(other *workloadErrorTaxonomy) {
+	e.successes += other.successes
+	for class, count := range other.counts {
+		if e.counts == nil {
+			e.counts = map[workloadErrorClass]int{}
+			e.samples = map[workloadErrorClass]string{}
+		}
+		e.counts[class] += count
+		if _, ok := e.samples[class]; !ok {
+			e.samples[class] = other.samples[class]
+		}
+	}
+}
+
+// classes returns the recorded error classes, sorted by name.
+func (e *workloadErrorTaxonomy) classes() []workloadErrorClass {
+	classes := make([]workloadErrorClass, 0, len(e.counts))
+	for class := range e.counts {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i] < classes[j] })
+	return classes
+}
+
+// String returns a one-line summary of the error counts.
+func (e *workloadErrorTaxonomy) String() string {
+	if len(e.counts) == 0 {
+		return fmt.Sprintf("none (%d clean runs)", e.successes)
+	}
+	var parts []string
+	for _, class := range e.classes() {
+		parts = append(parts, fmt.Sprintf("%s=%d", class, e.counts[class]))
+	}
+	return fmt.Sprintf("%s (%d clean runs)", strings.Join(parts, " "), e.successes)
+}
+
+// report returns a detailed report of the error counts and samples.
+func (e *workloadErrorTaxonomy) report() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", e)
+	for _, class := range e.classes() {
+		fmt.Fprintf(&b, "\n%s (%d):\n%s\n", class, e.counts[class], e.samples[class])
+	}
+	return b.String()
+}
+
+// failureMode specifies a failure mode.
+type failureMode string
+
+const (
+	failureModeBlackhole      failureMode = "blackhole"
+	failureModeBlackholeRecv  failureMode = "blackhole-recv"
+	failureModeBlackholeSend  failureMode = "blackhole-send"
+	failureModeBandwidth      failureMode = "bandwidth"
+	failureModeLatencyEgress  failureMode = "latency-egress"
+	failureModeLatencyIngress failureMode = "latency-ingress"
+	failureModeCrash          failureMode = "crash"
+	failureModeDrainStop      failureMode = "drain-stop"
+	failureModeDiskStall      failureMode = "disk-stall"
+	failureModeHang           failureMode = "hang"
+	failureModePause          failureMode = "pause"
+	failureModeGCThrash       failureMode = "gc-thrash"
+	failureModeRaftDrop       failureMode = "raft-drop"
+)
+
+// failureModes lists all failure modes.
+var failureModes = []failureMode{
+	failureModeBlackhole,
+	failureModeBlackholeRecv,
+	failureModeBlackholeSend,
+	failureModeBandwidth,
+	failureModeLatencyEgress,
+	failureModeLatencyIngress,
+	failureModeCrash,
+	failureModeDrainStop,
+	failureModeDiskStall,
+	failureModeHang,
+	failureModePause,
+	failureModeGCThrash,
+	failureModeRaftDrop,
+}
+
+// makeFailer creates a new failer for the given failureMode.
+func makeFailer(
+	t test.Test,
+	c cluster.Cluster,
+	failureMode failureMode,
+	opts option.StartOpts,
+	settings install.ClusterSettings,
+) failer {
+	switch failureMode {
+	case failureModeBlackhole:
+		return &blackholeFailer{
+			t:      t,
+			c:      c,
+			input:  true,
+			output: true,
+		}
+	case failureModeBlackholeRecv:
+		return &blackholeFailer{
+			t:     t,
+			c:     c,
+			input: true,
+		}
+	case failureModeBlackholeSend:
+		return &blackholeFailer{
+			t:      t,
+			c:      c,
+			output: true,
+		}
+	case failureModeBandwidth:
+		return &bandwidthFailer{
+			t:    t,
+			c:    c,
+			rate: "1mbit",
+		}
+	case failureModeLatencyEgress:
+		return &netemFailer{
+			t:         t,
+			c:         c,
+			delay:     "500ms",
+			direction: netemEgress,
+		}
+	case failureModeLatencyIngress:
+		return &netemFailer{
+			t:         t,
+			c:         c,
+			delay:     "500ms",
+			direction: netemIngress,
+		}
+	case failureModeCrash:
+		return &crashFailer{
+			t:             t,
+			c:             c,
+			startOpts:     opts,
+			startSettings: settings,
+		}
+	case failureModeDrainStop:
+		return &gracefulStopFailer{
+			t:             t,
+			c:             c,
+			startOpts:     opts,
+			startSettings: settings,
+		}
+	case failureModeDiskStall:
+		// TODO(baptist): This mode doesn't work on local clusters since
+		// dmsetupDiskStaller does not support local clusters. Either support could
+		// be added for it or there could be a flag to not fatal when run in local
+		// mode. The net impact is that this failure can't be simulated on local
+		// clusters today.
+		return &diskStallFailer{
+			t:             t,
+			c:             c,
+			startOpts:     opts,
+			startSettings: settings,
+			staller:       &dmsetupDiskStaller{t: t, c: c},
+		}
+	case failureModeHang:
+		return &hangFailer{
+			t: t,
+			c: c,
+		}
+	case failureModePause:
+		return &pauseFailer{
+			t: t,
+			c: c,
+		}
+	case failureModeGCThrash:
+		return &pauseFailer{
+			t:            t,
+			c:            c,
+			stopDuration: time.Second,
+			contDuration: 2 * time.Second,
+		}
+	case failureModeRaftDrop:
+		return &raftDropFailer{
+			t: t,
+			c: c,
+		}
+	default:
+		t.Fatalf("unknown failure mode %s", failureMode)
+		return nil
+	}
+}
+
+// failer fails and recovers a given node in some particular way.
+type failer interface {
+	// Setup prepares the failer. It is called before the cluster is started.
+	Setup(ctx context.Context)
+
+	// Ready is called when the cluster is ready, with a running workload.
+	Ready(ctx context.Context, m cluster.Monitor)
+
+	// Cleanup cleans up when the test exits. This is needed e.g. when the cluster
+	// is reused by a different test.
+	Cleanup(ctx context.Context)
+
+	// Fail fails the given node.
+	Fail(ctx context.Context, nodeID int)
+
+	// Recover recovers the given node.
+	Recover(ctx context.Context, nodeID int)
+}
+
+// partialFailer supports partial failures between specific node pairs.
+type partialFailer interface {
+	failer
+
+	// FailPartial fails the node for the given peers.
+	FailPartial(ctx context.Context, nodeID int, peerIDs []int)
+}
+
+// compoundFailer combines several failers, each of which fails a specific node
+// in its own way, e.g. a disk stall on one node and a network partition on
+// another. It implements failer, dispatching Fail and Recover to the failer
+// for the given node, and can also fail and recover all of its nodes together.
+type compoundFailer struct {
+	t       test.Test
+	nodes   []int // sorted
+	modes   map[int]failureMode
+	failers map[int]failer
+}
+
+// makeCompoundFailer creates a compoundFailer that fails each given node using
+// the given failure mode.
+func makeCompoundFailer(
+	t test.Test,
+	c cluster.Cluster,
+	modes map[int]failureMode,
+	opts option.StartOpts,
+	settings install.ClusterSettings,
+) *compoundFailer {
+	f := &compoundFailer{t: t, modes: modes, failers: map[int]failer{}}
+	for node, failureMode := range modes {
+		f.nodes = append(f.nodes, node)
+		f.failers[node] = makeFailer(t, c, failureMode, opts, settings)
+	}
+	sort.Ints(f.nodes)
+	return f
+}
+
+// String implements fmt.Stringer.
+func (f *compoundFailer) String() string {
+	parts := make([]string, 0, len(f.nodes))
+	for _, node := range f.nodes {
+		parts = append(parts, fmt.Sprintf("n%d (%s)", node, f.modes[node]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (f *compoundFailer) Setup(ctx context.Context) {
+	for _, node := range f.nodes {
+		f.failers[node].Setup(ctx)
+	}
+}
+
+func (f *compoundFailer) Ready(ctx context.Context, m cluster.Monitor) {
+	for _, node := range f.nodes {
+		f.failers[node].Ready(ctx, m)
+	}
+}
+
+// Cleanup cleans up the failers in reverse order, since some failers (e.g.
+// diskStallFailer) stop the cluster during cleanup.
+func (f *compoundFailer) Cleanup(ctx context.Context) {
+	for i := len(f.nodes) - 1; i >= 0; i-- {
+		f.failers[f.nodes[i]].Cleanup(ctx)
+	}
+}
+
+func (f *compoundFailer) Fail(ctx context.Context, nodeID int) {
+	f.failer(nodeID).Fail(ctx, nodeID)
+}
+
+func (f *compoundFailer) Recover(ctx context.Context, nodeID int) {
+	f.failer(nodeID).Recover(ctx, nodeID)
+}
+
+// FailAll fails all of the failer's nodes, in quick succession.
+func (f *compoundFailer) FailAll(ctx context.Context) {
+	for _, node := range f.nodes {
+		f.Fail(ctx, node)
+	}
+}
+
+// RecoverAll recovers all of the failer's nodes.
+func (f *compoundFailer) RecoverAll(ctx context.Context) {
+	for _, node := range f.nodes {
+		f.Recover(ctx, node)
+	}
+}
+
+func (f *compoundFailer) failer(nodeID int) failer {
+	failer, ok := f.failers[nodeID]
+	if !ok {
+		f.t.Fatalf("no failer for n%d", nodeID)
+	}
+	return failer
+}
+
+// blackholeFailer causes a network failure where TCP/IP packets to/from port
+// 26257 are dropped, causing network hangs and timeouts.
+//
+// If only one if input or output are enabled, connections in that direction
+// will fail (even already established connections), but connections in the
+// other direction are still functional (including responses).
+//
+// If ports is set, only packets to/from the given ports are dropped instead.
+// This can e.g. be used to partition SQL clients from a node that listens for
+// SQL on a separate port, while leaving inter-node RPC traffic intact.
+//
+// If protocols is set, packets of the given IP protocols are dropped instead of
+// only TCP packets. UDP packets are dropped for the same ports as TCP, while
+// ICMP packets are dropped regardless of port, which e.g. breaks path MTU
+// discovery. This models firewall misconfigurations that aren't limited to
+// TCP.
+//
+// The rules are kept in dedicated iptables chains (see blackholeInputChain and
+// blackholeOutputChain), such that they can be inspected while a test is
+// running, and recovery only removes the rules added by the failer.
+type blackholeFailer struct {
+	t      test.Test
+	c      cluster.Cluster
+	input  bool
+	output bool
+	ports  []int
+	// protocols are the IP protocols to drop: tcp, udp, or icmp. Defaults to
+	// tcp.
+	protocols []string
+}
+
+// blackholePorts returns the ports to drop packets for.
+func (f *blackholeFailer) blackholePorts() []int {
+	if len(f.ports) == 0 {
+		return []int{26257}
+	}
+	return f.ports
+}
+
+// blackholeProtocols returns the IP protocols to drop packets for.
+func (f *blackholeFailer) blackholeProtocols() []string {
+	if len(f.protocols) == 0 {
+		return []string{"tcp"}
+	}
+	return f.protocols
+}
+
+func (f *blackholeFailer) Setup(_ context.Context)                    {}
+func (f *blackholeFailer) Ready(_ context.Context, _ cluster.Monitor) {}
+
+func (f *blackholeFailer) Cleanup(ctx context.Context) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping blackhole cleanup on local cluster")
+		return
+	}
+	f.c.Run(ctx, f.c.All(), blackholeTeardownCmd)
+	assertNetworkClean(ctx, f.t, f.c, "iptables rules",
+		fmt.Sprintf(`sudo iptables -S | grep -e %s -e %s || true`,
+			blackholeInputChain, blackholeOutputChain))
+}
+
+const (
+	// blackholeInputChain and blackholeOutputChain are the iptables chains
+	// containing the blackholeFailer's rules, jumped to from INPUT and OUTPUT
+	// respectively. They can be inspected with e.g. iptables -L
+	// CRDB_FAILOVER_INPUT to see exactly what the test applied.
+	blackholeInputChain  = "CRDB_FAILOVER_INPUT"
+	blackholeOutputChain = "CRDB_FAILOVER_OUTPUT"
+)
+
+// blackholeSetupCmd creates the blackhole chains and jumps to them, unless they
+// already exist.
+var blackholeSetupCmd = fmt.Sprintf(
+	`sudo iptables -N %[1]s 2>/dev/null; sudo iptables -N %[2]s 2>/dev/null; `+
+		`{ sudo iptables -C INPUT -j %[1]s 2>/dev/null || sudo iptables -I INPUT -j %[1]s; } && `+
+		`{ sudo iptables -C OUTPUT -j %[2]s 2>/dev/null || sudo iptables -I OUTPUT -j %[2]s; }`,
+	blackholeInputChain, blackholeOutputChain)
+
+// blackholeTeardownCmd removes the jumps to the blackhole chains, and flushes
+// and removes the chains, leaving any other rules intact. It tolerates the
+// chains not existing.
+var blackholeTeardownCmd = fmt.Sprintf(
+	`sudo iptables -D INPUT -j %[1]s 2>/dev/null; sudo iptables -D OUTPUT -j %[2]s 2>/dev/null; `+
+		`sudo iptables -F %[1]s 2>/dev/null; sudo iptables -F %[2]s 2>/dev/null; `+
+		`sudo iptables -X %[1]s 2>/dev/null; sudo iptables -X %[2]s 2>/dev/null; true`,
+	blackholeInputChain, blackholeOutputChain)
+
+// blackholeRules returns the iptables rules to append for a blackhole, as
+// arguments to iptables -A. If peerIP is non-empty, only packets to/from that
+// peer are dropped. All rules are placed in the blackhole chains.
+func (f *blackholeFailer) blackholeRules(peerIP string) []string {
+	var src, dst string
+	if peerIP != "" {
+		src, dst = fmt.Sprintf("-s %s ", peerIP), fmt.Sprintf("-d %s ", peerIP)
+	}
+	in, out := blackholeInputChain, blackholeOutputChain
+
+	var rules []string
+	for _, proto := range f.blackholeProtocols() {
+		if proto == "icmp" {
+			// ICMP has no ports, so drop all ICMP packets in the blackholed
+			// directions.
+			if f.input {
+				rules = append(rules, fmt.Sprintf(`%s -p icmp %s-j DROP`, in, src))
+			}
+			if f.output {
+				rules = append(rules, fmt.Sprintf(`%s -p icmp %s-j DROP`, out, dst))
+			}
+			continue
+		}
+		rules = append(rules, f.blackholePortRules(proto, src, dst)...)
+	}
+	return rules
+}
+
+// blackholePortRules returns the rules to drop packets of the given port-based
+// protocol (tcp or udp) to/from the blackholed ports. src and dst are the
+// peer matches, if any.
+func (f *blackholeFailer) blackholePortRules(proto, src, dst string) []string {
+	in, out := blackholeInputChain, blackholeOutputChain
+
+	var rules []string
+	for _, port := range f.blackholePorts() {
+		// When dropping both input and output, make sure we drop packets in both
+		// directions for both the inbound and outbound TCP connections, such that
+		// we get a proper black hole. Only dropping one direction for both of
+		// INPUT and OUTPUT will still let e.g. TCP retransmits through, which may
+		// affect the TCP stack behavior and is not representative of real network
+		// outages.
+		//
+		// For the asymmetric partitions, only drop packets in one direction since
+		// this is representative of accidental firewall rules we've seen cause
+		// such outages in the wild.
+		if f.input && f.output {
+			rules = append(rules,
+				// Inbound connections, both received and sent packets.
+				fmt.Sprintf(`%s -p %s %s--dport %d -j DROP`, in, proto, src, port),
+				fmt.Sprintf(`%s -p %s %s--sport %d -j DROP`, out, proto, dst, port),
+				// Outbound connections, both sent and received packets.
+				fmt.Sprintf(`%s -p %s %s--dport %d -j DROP`, out, proto, dst, port),
+				fmt.Sprintf(`%s -p %s %s--sport %d -j DROP`, in, proto, src, port))
+		} else if f.input {
+			rules = append(rules, fmt.Sprintf(`%s -p %s %s--dport %d -j DROP`, in, proto, src, port))
+		} else if f.output {
+			rules = append(rules, fmt.Sprintf(`%s -p %s %s--dport %d -j DROP`, out, proto, dst, port))
+		}
+	}
+	return rules
+}
+
+// applyRules sets up the blackhole chains on the given node and appends the
+// given rules to them.
+func (f *blackholeFailer) applyRules(ctx context.Context, nodeID int, rules []string) {
+	f.c.Run(ctx, f.c.Node(nodeID), blackholeSetupCmd)
+	for _, rule := range rules {
+		f.c.Run(ctx, f.c.Node(nodeID), `sudo iptables -A `+rule)
+	}
+}
+
+func (f *blackholeFailer) Fail(ctx context.Context, nodeID int) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping blackhole failure on local cluster")
+		return
+	}
+	f.applyRules(ctx, nodeID, f.blackholeRules("" /* peerIP */))
+
+	peerID := 1
+	if nodeID == 1 {
+		peerID = 2
+	}
+	f.verifyPartition(ctx, nodeID, peerID)
+}
+
+// FailPartial creates a partial blackhole failure between the given node and
+// peers.
+func (f *blackholeFailer) FailPartial(ctx context.Context, nodeID int, peerIDs []int) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping blackhole failure on local cluster")
+		return
+	}
+	peerIPs, err := f.c.InternalIP(ctx, f.t.L(), peerIDs)
+	require.NoError(f.t, err)
+
+	var rules []string
+	for _, peerIP := range peerIPs {
+		rules = append(rules, f.blackholeRules(peerIP)...)
+	}
+	f.applyRules(ctx, nodeID, rules)
+
+	if len(peerIDs) > 0 {
+		f.verifyPartition(ctx, nodeID, peerIDs[0])
+	}
+}
+
+// verifyPartition checks that the blackhole is in effect between the given
+// node and peer, by attempting TCP connections to the blackholed ports in the
+// blackholed directions and expecting them to time out, rather than succeed or
+// be refused. This guards against silently measuring a non-failure, e.g. if
+// the iptables rules failed to apply.
+func (f *blackholeFailer) verifyPartition(ctx context.Context, nodeID, peerID int) {
+	nodeIPs, err := f.c.InternalIP(ctx, f.t.L(), f.c.Node(nodeID))
+	require.NoError(f.t, err)
+	peerIPs, err := f.c.InternalIP(ctx, f.t.L(), f.c.Node(peerID))
+	require.NoError(f.t, err)
+	nodeIP, peerIP := nodeIPs[0], peerIPs[0]
+
+	// Only TCP connections can be verified this way.
+	var dropsTCP bool
+	for _, proto := range f.blackholeProtocols() {
+		dropsTCP = dropsTCP || proto == "tcp"
+	}
+	if !dropsTCP {
+		return
+	}
+
+	verify := func(fromID int, toIP string, port int) {
+		// timeout exits with 124 if the connection attempt times out.
+		err := f.c.RunE(ctx, f.c.Node(fromID), fmt.Sprintf(
+			`timeout 2 bash -c '</dev/tcp/%s/%d' 2>/dev/null; [ $? -eq 124 ]`, toIP, port))
+		if err != nil {
+			f.t.Fatalf("blackhole not in effect: n%d can connect to %s:%d", fromID, toIP, port)
+		}
+	}
+	for _, port := range f.blackholePorts() {
+		if f.input {
+			verify(peerID, nodeIP, port)
+		}
+		if f.output {
+			verify(nodeID, peerIP, port)
+		}
+	}
+}
+
+func (f *blackholeFailer) Recover(ctx context.Context, nodeID int) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping blackhole recovery on local cluster")
+		return
+	}
+	f.c.Run(ctx, f.c.Node(nodeID), blackholeTeardownCmd)
+}
+
+// assertNetworkClean runs the given command on all nodes, and fails the test if
+// it prints anything on any of them. Network failers use it after Cleanup to
+// verify that none of the iptables rules or tc qdiscs they may have created
+// are left behind, such that a subsequent test on a reused cluster starts from
+// a clean network state. The command should print the residual state, if any,
+// and succeed regardless.
+func assertNetworkClean(ctx context.Context, t test.Test, c cluster.Cluster, what, cmd string) {
+	var residue []string
+	for _, node := range c.All() {
+		details, err := c.RunWithDetailsSingleNode(ctx, t.L(), c.Node(node), cmd)
+		require.NoError(t, err)
+		if out := strings.TrimSpace(details.Stdout); out != "" {
+			residue = append(residue, fmt.Sprintf("n%d:\n%s", node, out))
+		}
+	}
+	if len(residue) > 0 {
+		t.Fatalf("%s left behind after cleanup:\n%s", what, strings.Join(residue, "\n"))
+	}
+}
+
+// bandwidthFailer caps the egress bandwidth of TCP/IP packets to/from port
+// 26257, simulating a saturated uplink rather than added latency. Unlike a
+// blackhole, the node remains reachable, but throughput collapses, which
+// notably affects snapshot transfers and Raft catchup.
+//
+// The cap is applied with an HTB qdisc on the node's default network
+// interface, with port 26257 traffic classified into a rate-limited class and
+// all other traffic into an unlimited default class. Recovery removes the root
+// qdisc, which restores the interface's default qdisc.
+type bandwidthFailer struct {
+	t    test.Test
+	c    cluster.Cluster
+	rate string // tc rate, e.g. 1mbit
+}
+
+// bandwidthFailerIface expands to the node's default network interface.
+const bandwidthFailerIface = `$(ip route show default | awk '{print $5; exit}')`
+
+func (f *bandwidthFailer) Setup(_ context.Context)                    {}
+func (f *bandwidthFailer) Ready(_ context.Context, _ cluster.Monitor) {}
+
+func (f *bandwidthFailer) Cleanup(ctx context.Context) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping bandwidth cleanup on local cluster")
+		return
+	}
+	f.c.Run(ctx, f.c.All(), `sudo tc qdisc del dev `+bandwidthFailerIface+` root || true`)
+	assertNetworkClean(ctx, f.t, f.c, "tc qdiscs",
+		`sudo tc qdisc show dev `+bandwidthFailerIface+` | grep 'qdisc htb 1: root' || true`)
+}
+
+func (f *bandwidthFailer) Fail(ctx context.Context, nodeID int) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping bandwidth failure on local cluster")
+		return
+	}
+	iface := bandwidthFailerIface
+	for _, cmd := range []string{
+		`sudo tc qdisc add dev ` + iface + ` root handle 1: htb default 10`,
+		`sudo tc class add dev ` + iface + ` parent 1: classid 1:1 htb rate 100gbit`,
+		`sudo tc class add dev ` + iface + ` parent 1:1 classid 1:10 htb rate 100gbit`,
+		fmt.Sprintf(`sudo tc class add dev %s parent 1:1 classid 1:20 htb rate %s ceil %s`,
+			iface, f.rate, f.rate),
+		// Both outbound connections (dport) and responses on inbound connections
+		// (sport) are capped.
+		`sudo tc filter add dev ` + iface + ` protocol ip parent 1: prio 1 ` +
+			`u32 match ip dport 26257 0xffff flowid 1:20`,
+		`sudo tc filter add dev ` + iface + ` protocol ip parent 1: prio 1 ` +
+			`u32 match ip sport 26257 0xffff flowid 1:20`,
+	} {
+		f.c.Run(ctx, f.c.Node(nodeID), cmd)
+	}
+}
+
+func (f *bandwidthFailer) Recover(ctx context.Context, nodeID int) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping bandwidth recovery on local cluster")
+		return
+	}
+	f.c.Run(ctx, f.c.Node(nodeID), `sudo tc qdisc del dev `+bandwidthFailerIface+` root`)
+}
+
+// netemDirection specifies the direction of traffic delayed by a netemFailer.
+type netemDirection int
+
+const (
+	// netemEgress delays traffic sent by the failed node.
+	netemEgress netemDirection = iota
+	// netemIngress delays traffic received by the failed node.
+	netemIngress
+)
+
+// netemFailer adds latency to TCP/IP packets to/from port 26257 in only one
+// direction, modeling asymmetric routing where e.g. requests are slow but
+// responses are fast. This stresses RTT-based timeouts differently than
+// symmetric latency.
+//
+// netem can only delay egress traffic, so ingress latency for the failed node
+// is applied as egress latency on its peers, for packets destined to the
+// failed node. Both outbound connections (dport) and responses on inbound
+// connections (sport) are delayed.
+//
+// The delay is applied with a prio qdisc with a dedicated handle, where
+// filtered packets are classified into an extra band with a netem child
+// qdisc and all other traffic uses the default bands. Filters use the failed
+// node ID as their priority, such that recovery removes only the filters for
+// that node, and the qdisc is removed once it has no filters left.
+type netemFailer struct {
+	t         test.Test
+	c         cluster.Cluster
+	delay     string // netem delay, e.g. 500ms
+	direction netemDirection
+
+	// installed tracks the nodes that have filters for each failed node.
+	installed map[int][]int
+}
+
+const (
+	// netemFailerHandle is the handle of the netemFailer's root qdisc.
+	netemFailerHandle = "7357:"
+	// netemFailerBand is the class of the delayed band.
+	netemFailerBand = "7357:4"
+)
+
+func (f *netemFailer) Setup(_ context.Context)                    {}
+func (f *netemFailer) Ready(_ context.Context, _ cluster.Monitor) {}
+
+func (f *netemFailer) Cleanup(ctx context.Context) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping netem cleanup on local cluster")
+		return
+	}
+	f.c.Run(ctx, f.c.All(), fmt.Sprintf(`sudo tc qdisc del dev %s root handle %s || true`,
+		bandwidthFailerIface, netemFailerHandle))
+	assertNetworkClean(ctx, f.t, f.c, "tc qdiscs", fmt.Sprintf(
+		`sudo tc qdisc show dev %s | grep -e 'qdisc prio %s' -e 'qdisc netem' || true`,
+		bandwidthFailerIface, netemFailerHandle))
+}
+
+func (f *netemFailer) Fail(ctx context.Context, nodeID int) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping netem failure on local cluster")
+		return
+	}
+	switch f.direction {
+	case netemEgress:
+		f.addFilter(ctx, nodeID, nodeID, "" /* dstIP */)
+	case netemIngress:
+		var peerIDs []int
+		for _, peerID := range f.c.All() {
+			if peerID != nodeID {
+				peerIDs = append(peerIDs, peerID)
+			}
+		}
+		f.failIngress(ctx, nodeID, peerIDs)
+	}
+}
+
+// FailPartial applies the delay only to traffic between the given node and
+// peers.
+func (f *netemFailer) FailPartial(ctx context.Context, nodeID int, peerIDs []int) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping netem failure on local cluster")
+		return
+	}
+	switch f.direction {
+	case netemEgress:
+		peerIPs, err := f.c.InternalIP(ctx, f.t.L(), peerIDs)
+		require.NoError(f.t, err)
+		for _, peerIP := range peerIPs {
+			f.addFilter(ctx, nodeID, nodeID, peerIP)
+		}
+	case netemIngress:
+		f.failIngress(ctx, nodeID, peerIDs)
+	}
+}
+
+// failIngress delays traffic from the given peers to the failed node.
+func (f *netemFailer) failIngress(ctx context.Context, nodeID int, peerIDs []int) {
+	nodeIPs, err := f.c.InternalIP(ctx, f.t.L(), f.c.Node(nodeID))
+	require.NoError(f.t, err)
+	for _, peerID := range peerIDs {
+		f.addFilter(ctx, peerID, nodeID, nodeIPs[0])
+	}
+}
+
+// addFilter delays packets to/from port 26257 sent by the given node, on
+// behalf of the given failed node. If dstIP is non-empty, only packets to that
+// IP are delayed. The qdisc is installed if it doesn't already exist.
+func (f *netemFailer) addFilter(ctx context.Context, onID, failedID int, dstIP string) {
+	iface := bandwidthFailerIface
+	f.c.Run(ctx, f.c.Node(onID), fmt.Sprintf(
+		`sudo tc qdisc show dev %[1]s | grep -q 'qdisc prio %[2]s' || { `+
+			`sudo tc qdisc add dev %[1]s root handle %[2]s prio bands 4 `+
+			`priomap 1 2 2 2 1 2 0 0 1 1 1 1 1 1 1 1 && `+
+			`sudo tc qdisc add dev %[1]s parent %[3]s netem delay %[4]s; }`,
+		iface, netemFailerHandle, netemFailerBand, f.delay))
+
+	var match string
+	if dstIP != "" {
+		match = fmt.Sprintf(`match ip dst %s/32 `, dstIP)
+	}
+	for _, port := range []string{"dport", "sport"} {
+		f.c.Run(ctx, f.c.Node(onID), fmt.Sprintf(
+			`sudo tc filter add dev %s protocol ip parent %s prio %d u32 %smatch ip %s 26257 0xffff flowid %s`,
+			iface, netemFailerHandle, failedID, match, port, netemFailerBand))
+	}
+
+	if f.installed == nil {
+		f.installed = map[int][]int{}
+	}
+	for _, id := range f.installed[failedID] {
+		if id == onID {
+			return
+		}
+	}
+	f.installed[failedID] = append(f.installed[failedID], onID)
+}
+
+func (f *netemFailer) Recover(ctx context.Context, nodeID int) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping netem recovery on local cluster")
+		return
+	}
+	iface := bandwidthFailerIface
+	for _, onID := range f.installed[nodeID] {
+		f.c.Run(ctx, f.c.Node(onID), fmt.Sprintf(
+			`sudo tc filter del dev %[1]s parent %[2]s prio %[3]d && `+
+				`{ sudo tc filter show dev %[1]s parent %[2]s | grep -q . || `+
+				`sudo tc qdisc del dev %[1]s root handle %[2]s; }`,
+			iface, netemFailerHandle, nodeID))
+	}
+	delete(f.installed, nodeID)
+}
+
+// crashFailer is a process crash where the TCP/IP stack remains responsive
+// and sends immediate RST packets to peers.
+type crashFailer struct {
+	t             test.Test
+	c             cluster.Cluster
+	m             cluster.Monitor
+	startOpts     option.StartOpts
+	startSettings install.ClusterSettings
+}
+
+func (f *crashFailer) Setup(_ context.Context)                    {}
+func (f *crashFailer) Ready(_ context.Context, m cluster.Monitor) { f.m = m }
+func (f *crashFailer) Cleanup(_ context.Context)                  {}
+
+func (f *crashFailer) Fail(ctx context.Context, nodeID int) {
+	if f.m != nil { // nil in tests without a monitor, e.g. mixed-version tests
+		f.m.ExpectDeath()
+	}
+	f.c.Stop(ctx, f.t.L(), option.DefaultStopOpts(), f.c.Node(nodeID)) // uses SIGKILL
+}
+
+func (f *crashFailer) Recover(ctx context.Context, nodeID int) {
+	f.c.Start(ctx, f.t.L(), f.startOpts, f.startSettings, f.c.Node(nodeID))
+}
+
+// gracefulStopFailer gracefully stops the node with SIGTERM, which drains it
+// (transferring away leases) before shutting down. This is representative of
+// a routine node restart, as opposed to crashFailer's abrupt SIGKILL, and we
+// expect it to cause much less unavailability.
+type gracefulStopFailer struct {
+	t             test.Test
+	c             cluster.Cluster
+	m             cluster.Monitor
+	startOpts     option.StartOpts
+	startSettings install.ClusterSettings
+}
+
+func (f *gracefulStopFailer) Setup(_ context.Context)                    {}
+func (f *gracefulStopFailer) Ready(_ context.Context, m cluster.Monitor) { f.m = m }
+func (f *gracefulStopFailer) Cleanup(_ context.Context)                  {}
+
+func (f *gracefulStopFailer) Fail(ctx context.Context, nodeID int) {
+	f.m.ExpectDeath()
+	// Sends SIGTERM and waits for the node to drain and exit, falling back to
+	// SIGKILL if it takes too long.
+	require.NoError(f.t, f.c.StopCockroachGracefullyOnNode(ctx, f.t.L(), nodeID))
+}
+
+func (f *gracefulStopFailer) Recover(ctx context.Context, nodeID int) {
+	f.c.Start(ctx, f.t.L(), f.startOpts, f.startSettings, f.c.Node(nodeID))
+}
+
+// diskStallFailer stalls the disk indefinitely. This should cause the node to
+// eventually self-terminate, but we'd want leases to move off before then.
+type diskStallFailer struct {
+	t             test.Test
+	c             cluster.Cluster
+	m             cluster.Monitor
+	startOpts     option.StartOpts
+	startSettings install.ClusterSettings
+	staller       diskStaller
+}
+
+func (f *diskStallFailer) Setup(ctx context.Context) {
+	f.staller.Setup(ctx)
+}
+
+func (f *diskStallFailer) Ready(_ context.Context, m cluster.Monitor) {
+	f.m = m
+}
+
+func (f *diskStallFailer) Cleanup(ctx context.Context) {
+	f.staller.Unstall(ctx, f.c.All())
+	// We have to stop the cluster before cleaning up the staller.
+	f.m.ExpectDeaths(int32(f.c.Spec().NodeCount))
+	f.c.Stop(ctx, f.t.L(), option.DefaultStopOpts(), f.c.All())
+	f.staller.Cleanup(ctx)
+}
+
+func (f *diskStallFailer) Fail(ctx context.Context, nodeID int) {
+	// Pebble's disk stall detector should crash the node.
+	f.m.ExpectDeath()
+	f.staller.Stall(ctx, f.c.Node(nodeID))
+}
+
+func (f *diskStallFailer) Recover(ctx context.Context, nodeID int) {
+	f.staller.Unstall(ctx, f.c.Node(nodeID))
+	// Pebble's disk stall detector should have terminated the node, but in case
+	// it didn't, we explicitly stop it first.
+	f.c.Stop(ctx, f.t.L(), option.DefaultStopOpts(), f.c.Node(nodeID))
+	f.c.Start(ctx, f.t.L(), f.startOpts, f.startSettings, f.c.Node(nodeID))
+}
+
+// pauseFailer pauses the process, but keeps the OS (and thus network
+// connections) alive.
+//
+// If stopDuration and contDuration are set, the process is instead paused
+// intermittently: it is stopped for stopDuration, continued for contDuration,
+// and so on until recovered. This models a process that periodically freezes,
+// e.g. due to long GC pauses. The pattern is driven by a background loop on the
+// node, which runs for as long as a marker file exists.
+type pauseFailer struct {
+	t            test.Test
+	c            cluster.Cluster
+	stopDuration time.Duration
+	contDuration time.Duration
+}
+
+// pauseFailerMarker is a marker file on the node that keeps pauseFailer's
+// intermittent pause loop running for as long as it exists.
+const pauseFailerMarker = "/tmp/pause-failer"
+
+// intermittent returns true if the failer pauses the process intermittently.
+func (f *pauseFailer) intermittent() bool {
+	return f.stopDuration > 0 && f.contDuration > 0
+}
+
+func (f *pauseFailer) Setup(ctx context.Context) {}
+
+func (f *pauseFailer) Cleanup(ctx context.Context) {
+	if f.intermittent() && !f.c.IsLocal() {
+		f.c.Run(ctx, f.c.All(), `rm -f `+pauseFailerMarker)
+	}
+}
+
+func (f *pauseFailer) Ready(ctx context.Context, m cluster.Monitor) {
+	// The process pause can trip the disk stall detector, so we disable it.
+	conn := f.c.Conn(ctx, f.t.L(), 1)
+	_, err := conn.ExecContext(ctx, `SET CLUSTER SETTING storage.max_sync_duration.fatal.enabled = false`)
+	require.NoError(f.t, err)
+}
+
+func (f *pauseFailer) Fail(ctx context.Context, nodeID int) {
+	if !f.intermittent() {
+		f.c.Signal(ctx, f.t.L(), 19, f.c.Node(nodeID)) // SIGSTOP
+		return
+	}
+	if f.c.IsLocal() {
+		f.t.Status("skipping intermittent pause failure on local cluster")
+		return
+	}
+	// Start a background loop that stops and continues the cockroach process
+	// until the marker file is removed. The loop's name is passed as $0, such
+	// that Recover can wait for it to exit.
+	f.c.Run(ctx, f.c.Node(nodeID), fmt.Sprintf(`touch %[1]s && `+
+		`pid=$(pgrep -o -f 'cockroach start') && `+
+		`(nohup bash -c 'while [ -e %[1]s ]; do `+
+		`kill -STOP '$pid'; sleep %.3[2]f; kill -CONT '$pid'; sleep %.3[3]f; done' `+
+		`pause-failer-loop > /dev/null 2>&1 &)`,
+		pauseFailerMarker, f.stopDuration.Seconds(), f.contDuration.Seconds()))
+}
+
+func (f *pauseFailer) Recover(ctx context.Context, nodeID int) {
+	if f.intermittent() && !f.c.IsLocal() {
+		// Remove the marker file, and wait for the loop to exit. The [p] avoids
+		// matching this command itself.
+		f.c.Run(ctx, f.c.Node(nodeID), fmt.Sprintf(
+			`rm -f %s && while pgrep -f '[p]ause-failer-loop' > /dev/null; do sleep 0.1; done`,
+			pauseFailerMarker))
+	}
+	// Always continue the process, regardless of where in the pause cycle it
+	// was.
+	f.c.Signal(ctx, f.t.L(), 18, f.c.Node(nodeID)) // SIGCONT
+}
+
+// hangFailerMarker is a marker file on the node that keeps hangFailer's
+// debugger attached for as long as it exists.
+const hangFailerMarker = "/tmp/hang-failer"
+
+// hangFailer hangs the process by attaching to it with a debugger (via
+// ptrace), which suspends all threads until the debugger detaches. Unlike
+// pauseFailer, the process is not stopped by a signal, so it is not reported
+// as stopped to the rest of the system. This models a process that is alive
+// but stuck, e.g. in a blocked syscall.
+//
+// The debugger is kept attached in the background until a marker file is
+// removed. The process never dies, so the monitor doesn't need to expect any
+// deaths.
+type hangFailer struct {
+	t test.Test
+	c cluster.Cluster
+}
+
+func (f *hangFailer) Setup(ctx context.Context) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping hang setup on local cluster")
+		return
+	}
+	f.c.Run(ctx, f.c.All(), `sudo apt-get -qq update && sudo apt-get -qq install -y gdb`)
+}
+
+func (f *hangFailer) Ready(ctx context.Context, _ cluster.Monitor) {
+	// The process hang can trip the disk stall detector, so we disable it.
+	conn := f.c.Conn(ctx, f.t.L(), 1)
+	_, err := conn.ExecContext(ctx, `SET CLUSTER SETTING storage.max_sync_duration.fatal.enabled = false`)
+	require.NoError(f.t, err)
+}
+
+func (f *hangFailer) Cleanup(ctx context.Context) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping hang cleanup on local cluster")
+		return
+	}
+	f.c.Run(ctx, f.c.All(), `rm -f `+hangFailerMarker)
+}
+
+func (f *hangFailer) Fail(ctx context.Context, nodeID int) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping hang failure on local cluster")
+		return
+	}
+	// Attach to the cockroach process, and block in a shell command while the
+	// process is suspended until the marker file is removed, then detach.
+	f.c.Run(ctx, f.c.Node(nodeID), fmt.Sprintf(`touch %[1]s && `+
+		`(sudo nohup gdb -batch -p $(pgrep -o -f 'cockroach start') `+
+		`-ex 'shell while [ -e %[1]s ]; do sleep 0.1; done' -ex detach `+
+		`> %[1]s.log 2>&1 &) && `+
+		// Wait for the debugger to attach.
+		`until grep -q 'Attaching' %[1]s.log; do sleep 0.1; done`, hangFailerMarker))
+}
+
+func (f *hangFailer) Recover(ctx context.Context, nodeID int) {
+	if f.c.IsLocal() {
+		f.t.Status("skipping hang recovery on local cluster")
+		return
+	}
+	// Remove the marker file, and wait for the debugger to detach and exit.
+	f.c.Run(ctx, f.c.Node(nodeID), fmt.Sprintf(
+		`rm -f %s && while pgrep -x gdb > /dev/null; do sleep 0.1; done`, hangFailerMarker))
+}
+
+// raftDropFailer drops all incoming Raft messages on the node, using the
+// kv.raft.transport.testing.drop_inbound_node_ids cluster setting, while SQL,
+// gossip and liveness heartbeats continue to work. This is a Raft-only
+// partition, which can't be achieved with iptables since Raft shares the RPC
+// port with all other inter-node traffic.
+//
+// Only inbound messages are dropped, so the node's outgoing messages (e.g.
+// campaign votes or heartbeats from its leaders) still reach the other nodes,
+// but it never hears their responses. Incoming snapshots are rejected too, so
+// the node can't apply any Raft commands while failed, which is asserted on
+// recovery.
+type raftDropFailer struct {
+	t test.Test
+	c cluster.Cluster
+
+	// applied is the number of Raft commands applied by the failed node once
+	// the failure took effect.
+	applied float64
+}
+
+func (f *raftDropFailer) Setup(ctx context.Context) {}
+
+func (f *raftDropFailer) Ready(ctx context.Context, _ cluster.Monitor) {}
+
+func (f *raftDropFailer) Cleanup(ctx context.Context) {}
+
+func (f *raftDropFailer) Fail(ctx context.Context, nodeID int) {
+	nodeIDs := strconv.Itoa(nodeID)
+	f.setDropNodeIDs(ctx, nodeID, nodeIDs)
+
+	// Wait for the setting to take effect on the failed node, and for it to
+	// finish applying any commands that were committed before then.
+	conn := f.c.Conn(ctx, f.t.L(), nodeID)
+	defer conn.Close()
+	require.NoError(f.t, retry.ForDuration(time.Minute, func() error {
+		var value string
+		if err := conn.QueryRowContext(ctx,
+			`SHOW CLUSTER SETTING kv.raft.transport.testing.drop_inbound_node_ids`,
+		).Scan(&value); err != nil {
+			return err
+		}
+		if value != nodeIDs {
+			return errors.Newf("n%d has drop_inbound_node_ids=%q, expected %q", nodeID, value, nodeIDs)
+		}
+		return nil
+	}))
+	select {
+	case <-time.After(5 * time.Second):
+	case <-ctx.Done():
+		return
+	}
+	f.applied = nodeMetric(ctx, f.t, f.c, nodeID, "raft.commandsapplied")
+}
+
+func (f *raftDropFailer) Recover(ctx context.Context, nodeID int) {
+	// The failed node can't have applied any commands, since it received
+	// neither Raft messages nor snapshots.
+	applied := nodeMetric(ctx, f.t, f.c, nodeID, "raft.commandsapplied")
+	require.Equal(f.t, f.applied, applied, "n%d applied Raft commands while failed", nodeID)
+
+	f.setDropNodeIDs(ctx, nodeID, "")
+}
+
+// setDropNodeIDs sets the node IDs to drop incoming Raft messages for, via a
+// node other than the failed node.
+func (f *raftDropFailer) setDropNodeIDs(ctx context.Context, failedNodeID int, nodeIDs string) {
+	connNodeID := 1
+	if failedNodeID == connNodeID {
+		connNodeID = 2
+	}
+	conn := f.c.Conn(ctx, f.t.L(), connNodeID)
+	defer conn.Close()
+	_, err := conn.ExecContext(ctx,
+		`SET CLUSTER SETTING kv.raft.transport.testing.drop_inbound_node_ids = $1`, nodeIDs)
+	require.NoError(f.t, err)
+}
+
+// startWithLocalities starts the given nodes with per-node localities and node
+// attributes, using the given start options and settings otherwise. Each entry
+// in localities corresponds to the node at the same position in nodes, and has
+// the form <locality>[;<attrs>], e.g. "region=us-east1,zone=us-east1-b;ssd".
+// Empty localities or attributes are omitted.
+//
+// Failers restart nodes with their own start options, so they will not retain
+// these flags.
+func startWithLocalities(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	opts option.StartOpts,
+	settings install.ClusterSettings,
+	nodes option.NodeListOption,
+	localities []string,
+) {
+	require.Len(t, localities, len(nodes), "need one locality per node")
+
+	for i, node := range nodes {
+		locality, attrs, _ := strings.Cut(localities[i], ";")
+		nodeOpts := opts
+		nodeOpts.RoachprodOpts.ExtraArgs = append([]string(nil), opts.RoachprodOpts.ExtraArgs...)
+		if locality != "" {
+			nodeOpts.RoachprodOpts.ExtraArgs = append(nodeOpts.RoachprodOpts.ExtraArgs,
+				"--locality="+locality)
+		}
+		if attrs != "" {
+			nodeOpts.RoachprodOpts.ExtraArgs = append(nodeOpts.RoachprodOpts.ExtraArgs,
+				"--attrs="+attrs)
+		}
+		c.Start(ctx, t.L(), nodeOpts, settings, c.Node(node))
+	}
+}
+
+// waitForUpreplication waits for upreplication of ranges that satisfy the
+// given predicate (using SHOW RANGES).
+//
+// TODO(erikgrinaker): move this into WaitForReplication() when it can use SHOW
+// RANGES, i.e. when it's no longer needed in mixed-version tests with older
+// versions that don't have SHOW RANGES.
+func waitForUpreplication(
+	t test.Test, ctx context.Context, conn *gosql.DB, predicate string, replicationFactor int,
+) {
+	var count int
+	where := fmt.Sprintf("WHERE array_length(replicas, 1) < %d", replicationFactor)
+	if predicate != "" {
+		where += fmt.Sprintf(" AND (%s)", predicate)
+	}
+	for {
+		require.NoError(t, conn.QueryRowContext(ctx,
+			`SELECT count(distinct range_id) FROM [SHOW CLUSTER RANGES WITH TABLES, DETAILS] `+where).
+			Scan(&count))
+		if count == 0 {
+			break
+		}
+		t.Status(fmt.Sprintf("waiting for %d ranges to upreplicate (%s)", count, predicate))
+		time.Sleep(time.Second)
+	}
+}
+
+// fullyReplicatedTimeout is the grace period given by requireFullyReplicated,
+// to allow recent zone config changes to propagate to the span configs.
+const fullyReplicatedTimeout = 30 * time.Second
+
+// requireFullyReplicated asserts that no ranges are under-replicated with
+// respect to their configured replication factor, and fails the test with the
+// under-replicated ranges otherwise. It should be called after the initial
+// upreplication, since WaitForReplication only waits for a fixed replication
+// factor, and any under-replicated ranges would invalidate the measurements.
+func requireFullyReplicated(ctx context.Context, t test.Test, conn *gosql.DB) {
+	_, err := waitForFullReplication(ctx, t, conn, fullyReplicatedTimeout)
+	require.NoError(t, err, "cluster not fully replicated at test start")
+}
+
+// waitForFullReplication waits until no ranges are under-replicated, i.e. until
+// every range has at least as many voting replicas on live nodes as its
+// configured replication factor. If the timeout fires first, it returns the IDs
+// of the ranges that are still under-replicated along with an error.
+//
+// Unlike WaitForReplication, this uses each range's configured replication
+// factor from its span config, and does not count replicas on dead nodes.
+func waitForFullReplication(
+	ctx context.Context, t test.Test, conn *gosql.DB, timeout time.Duration,
+) ([]int, error) {
+	const query = `
+WITH live AS (SELECT node_id FROM crdb_internal.gossip_nodes WHERE is_live),
+ranges AS (
+	SELECT
+		r.range_id,
+		(SELECT count(*) FROM unnest(r.voting_replicas) AS v(id) WHERE id IN (SELECT node_id FROM live))
+			AS live_replicas,
+		(crdb_internal.pb_to_json('cockroach.roachpb.SpanConfig', sc.config)->>'numReplicas')::INT
+			AS num_replicas
+	FROM [SHOW CLUSTER RANGES WITH KEYS] AS r
+	JOIN system.span_configurations AS sc
+		ON r.raw_start_key >= sc.start_key AND r.raw_start_key < sc.end_key
+)
+SELECT DISTINCT range_id FROM ranges WHERE live_replicas < num_replicas ORDER BY range_id`
+
+	var laggards []int
+	deadline := timeutil.Now().Add(timeout)
+	for {
+		laggards = laggards[:0]
+		rows, err := conn.QueryContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var rangeID int
+			if err := rows.Scan(&rangeID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			laggards = append(laggards, rangeID)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		rows.Close()
+		if len(laggards) == 0 {
+			return nil, nil
+		}
+		if timeutil.Now().After(deadline) {
+			return laggards, errors.Errorf("%d ranges still under-replicated after %s: %v",
+				len(laggards), timeout, laggards)
+		}
+		t.Status(fmt.Sprintf("waiting for %d under-replicated ranges", len(laggards)))
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return laggards, ctx.Err()
+		}
+	}
+}
+
+// workloadGatewaysTimeout is the time to wait for the workload to connect to
+// all gateways.
+const workloadGatewaysTimeout = time.Minute
+
+// assertWorkloadGateways asserts that the SQL connections of the workload with
+// the given application name are distributed across all of the given gateway
+// nodes, and logs the per-gateway connection counts. The tests assume that
+// e.g. {pgurl:1-3} spreads connections across the gateways, but if they all
+// land on a single node the scenario is invalidated. It waits up to
+// workloadGatewaysTimeout for the workload to connect.
+func assertWorkloadGateways(
+	ctx context.Context, t test.Test, conn *gosql.DB, appName string, gateways []int,
+) {
+	var counts map[int]int
+	var missing []int
+	deadline := timeutil.Now().Add(workloadGatewaysTimeout)
+	for {
+		counts = map[int]int{}
+		rows, err := conn.QueryContext(ctx, `SELECT node_id, count(*) `+
+			`FROM crdb_internal.cluster_sessions WHERE application_name = $1 GROUP BY node_id`,
+			appName)
+		require.NoError(t, err)
+		for rows.Next() {
+			var nodeID, count int
+			require.NoError(t, rows.Scan(&nodeID, &count))
+			counts[nodeID] = count
+		}
+		require.NoError(t, rows.Err())
+		rows.Close()
+
+		missing = missing[:0]
+		for _, node := range gateways {
+			if counts[node] == 0 {
+				missing = append(missing, node)
+			}
+		}
+		if len(missing) == 0 || timeutil.Now().After(deadline) {
+			break
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		}
+	}
+
+	var nodes []int
+	for node := range counts {
+		nodes = append(nodes, node)
+	}
+	sort.Ints(nodes)
+	var b strings.Builder
+	for _, node := range nodes {
+		fmt.Fprintf(&b, " n%d=%d", node, counts[node])
+	}
+	t.L().Printf("workload %q connections per gateway:%s", appName, b.String())
+
+	if len(missing) > 0 {
+		t.Fatalf("workload %q has no connections to gateways %v (connections:%s)",
+			appName, missing, b.String())
+	}
+	for node, count := range counts {
+		found := false
+		for _, gateway := range gateways {
+			found = found || gateway == node
+		}
+		if !found {
+			t.Fatalf("workload %q has %d connections to non-gateway n%d", appName, count, node)
+		}
+	}
+}
+
+// replicateQueueIdleTimeout is the timeout used when waiting for the replicate
+// queue to become idle during test setup.
+const replicateQueueIdleTimeout = 5 * time.Minute
+
+const (
+	// workloadSteadyStateInterval is the interval at which
+	// waitForWorkloadSteadyState samples the workload throughput.
+	workloadSteadyStateInterval = 5 * time.Second
+	// workloadSteadyStateSamples is the number of consecutive throughput
+	// samples that must be within workloadSteadyStateTolerance of their mean.
+	workloadSteadyStateSamples = 6
+	// workloadSteadyStateTolerance is the maximum relative deviation of a
+	// throughput sample from the mean.
+	workloadSteadyStateTolerance = 0.1
+	// workloadSteadyStateTimeout is the default maximum time to wait for the
+	// workload to reach a steady state.
+	workloadSteadyStateTimeout = 5 * time.Minute
+)
+
+// waitForWorkloadSteadyState waits until the SQL query throughput across the
+// given gateways is stable, i.e. the last workloadSteadyStateSamples rates are
+// nonzero and within workloadSteadyStateTolerance of their mean. This avoids
+// injecting the first failure while the workload is still ramping up. It
+// returns an error if the throughput doesn't stabilize within the timeout.
+func waitForWorkloadSteadyState(
+	ctx context.Context, t test.Test, c cluster.Cluster, gateways []int, timeout time.Duration,
+) error {
+	conns := make([]*gosql.DB, 0, len(gateways))
+	for _, node := range gateways {
+		conn := c.Conn(ctx, t.L(), node)
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	queryCount := func() (float64, error) {
+		var total float64
+		for _, conn := range conns {
+			var count float64
+			if err := conn.QueryRowContext(ctx, `SELECT value FROM crdb_internal.node_metrics `+
+				`WHERE name = 'sql.query.count'`).Scan(&count); err != nil {
+				return 0, err
+			}
+			total += count
+		}
+		return total, nil
+	}
+
+	t.Status(fmt.Sprintf("waiting for workload steady state on n%v", gateways))
+	start := timeutil.Now()
+	prevCount, err := queryCount()
+	if err != nil {
+		return err
+	}
+	prevTime := timeutil.Now()
+	var rates []float64
+	for {
+		select {
+		case <-time.After(workloadSteadyStateInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		count, err := queryCount()
+		if err != nil {
+			return err
+		}
+		now := timeutil.Now()
+		rates = append(rates, (count-prevCount)/now.Sub(prevTime).Seconds())
+		prevCount, prevTime = count, now
+		if len(rates) > workloadSteadyStateSamples {
+			rates = rates[1:]
+		}
+
+		if len(rates) == workloadSteadyStateSamples {
+			var sum float64
+			for _, rate := range rates {
+				sum += rate
+			}
+			mean := sum / float64(len(rates))
+			steady := mean > 0
+			for _, rate := range rates {
+				if math.Abs(rate-mean) > workloadSteadyStateTolerance*mean {
+					steady = false
+				}
+			}
+			if steady {
+				t.L().Printf("workload reached steady state of %.0f queries/s after %s",
+					mean, timeutil.Since(start))
+				return nil
+			}
+		}
+		if timeutil.Since(start) > timeout {
+			return errors.Errorf("workload did not reach steady state within %s, rates: %.0f",
+				timeout, rates)
+		}
+	}
+}
+
+// waitForReplicateQueueIdle waits until the replicate queues on all stores are
+// idle, i.e. they have no pending or purgatory replicas and haven't processed
+// any replicas since the previous poll. This allows tests to start from a
+// quiescent state, rather than racing with ongoing rebalancing. Store metrics
+// are only updated every 10 seconds, so this polls at the same interval.
+func waitForReplicateQueueIdle(
+	ctx context.Context, t test.Test, conn *gosql.DB, timeout time.Duration,
+) error {
+	const query = `
+SELECT
+	coalesce(sum((metrics->>'queue.replicate.pending')::DECIMAL)::INT, 0),
+	coalesce(sum((metrics->>'queue.replicate.purgatory')::DECIMAL)::INT, 0),
+	coalesce(sum(
+		(metrics->>'queue.replicate.process.success')::DECIMAL +
+		(metrics->>'queue.replicate.process.failure')::DECIMAL
+	)::INT, 0)
+FROM crdb_internal.kv_store_status`
+
+	deadline := timeutil.Now().Add(timeout)
+	lastProcessed := -1
+	for {
+		var pending, purgatory, processed int
+		if err := conn.QueryRowContext(ctx, query).Scan(&pending, &purgatory, &processed); err != nil {
+			return err
+		}
+		if pending == 0 && purgatory == 0 && processed == lastProcessed {
+			return nil
+		}
+		if timeutil.Now().After(deadline) {
+			return errors.Errorf("replicate queue not idle after %s: %d pending, %d in purgatory, "+
+				"%d processed since last poll", timeout, pending, purgatory, processed-lastProcessed)
+		}
+		t.Status(fmt.Sprintf("waiting for replicate queue to become idle "+
+			"(%d pending, %d in purgatory)", pending, purgatory))
+		lastProcessed = processed
+		select {
+		case <-time.After(10 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+const (
+	// nodeRejoinTimeout is the timeout used when waiting for a restarted node
+	// to rejoin the cluster.
+	nodeRejoinTimeout = 5 * time.Minute
+	// nodeRejoinMaxRaftLogBehind is the maximum number of Raft log entries that
+	// followers can be behind across the cluster for a restarted node to be
+	// considered caught up. Some lag is expected under load.
+	nodeRejoinMaxRaftLogBehind = 1000
+	// allNodesLiveTimeout is the timeout used when waiting for all nodes to
+	// heartbeat their liveness record after a failure.
+	allNodesLiveTimeout = time.Minute
+	// livenessStaleThreshold is the age beyond which a node's gossiped
+	// liveness record is considered stale. Liveness is heartbeated every 4.5
+	// seconds, so a node heartbeating normally never exceeds it.
+	livenessStaleThreshold = 10 * time.Second
+	// settingPropagationTimeout is the maximum duration to wait for a cluster
+	// setting change to propagate to all nodes.
+	settingPropagationTimeout = 30 * time.Second
+	// expiredLeasesTimeout is the maximum duration to wait for the number of
+	// expired leases to return to zero after a recovery. Store metrics are only
+	// updated every 10 seconds, so this must allow for a few samples.
+	expiredLeasesTimeout = time.Minute
+	// expiredLeasesMetric is the gauge of Raft leaders with an invalid (e.g.
+	// expired) lease.
+	expiredLeasesMetric = "replicas.leaders_invalid_lease"
+)
+
+// waitForAllNodesLive waits until every active node has heartbeated its
+// liveness record within the last livenessStaleThreshold, as seen via gossip
+// by the given connection's gateway. This verifies that the liveness subsystem
+// has fully recovered after a failure, e.g. of the liveness leaseholder. It
+// returns an error listing the stale nodes if they don't recover within the
+// timeout.
+func waitForAllNodesLive(
+	ctx context.Context, t test.Test, conn *gosql.DB, timeout time.Duration,
+) error {
+	const staleQuery = `
+SELECT node_id
+FROM crdb_internal.gossip_liveness
+WHERE membership = 'active' AND (updated_at IS NULL OR updated_at < now() - $1::INTERVAL)
+ORDER BY node_id`
+
+	t.Status("waiting for all nodes to heartbeat liveness")
+	deadline := timeutil.Now().Add(timeout)
+	for {
+		var stale []int
+		rows, err := conn.QueryContext(ctx, staleQuery, livenessStaleThreshold.String())
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var nodeID int
+			if err := rows.Scan(&nodeID); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			stale = append(stale, nodeID)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(stale) == 0 {
+			return nil
+		}
+		if timeutil.Now().After(deadline) {
+			return errors.Errorf("nodes %v did not heartbeat liveness within %s", stale, timeout)
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// waitForZeroExpiredLeases waits until the expired lease count, as reported by
+// expiredLeasesMetric, is zero on all of the given nodes. An expired lease that
+// persists after recovery indicates a stuck range, which would otherwise only
+// show up as elevated latency. It returns an error listing the offending nodes
+// and their counts if they don't clear within the timeout.
+func waitForZeroExpiredLeases(
+	ctx context.Context, t test.Test, c cluster.Cluster, nodes []int, timeout time.Duration,
+) error {
+	t.Status(fmt.Sprintf("waiting for expired leases to clear on nodes %v", nodes))
+	deadline := timeutil.Now().Add(timeout)
+	for {
+		var expired []string
+		for _, node := range nodes {
+			if value := nodeMetric(ctx, t, c, node, expiredLeasesMetric); value > 0 {
+				expired = append(expired, fmt.Sprintf("n%d=%.0f", node, value))
+			}
+		}
+		if len(expired) == 0 {
+			return nil
+		}
+		if timeutil.Now().After(deadline) {
+			return errors.Errorf("%s did not return to zero within %s: %s",
+				expiredLeasesMetric, timeout, strings.Join(expired, " "))
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// waitForSettingPropagation waits until the given cluster setting has the given
+// value on all live nodes, as reported by SHOW CLUSTER SETTING on each of them.
+// Setting changes propagate to other nodes asynchronously, so a change made via
+// one node may not yet be in effect elsewhere.
+func waitForSettingPropagation(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	setting, value string,
+	timeout time.Duration,
+) error {
+	conn := c.Conn(ctx, t.L(), 1)
+	defer conn.Close()
+	rows, err := conn.QueryContext(ctx,
+		`SELECT node_id FROM crdb_internal.gossip_nodes WHERE is_live ORDER BY node_id`)
+	if err != nil {
+		return err
+	}
+	var nodeIDs []int
+	for rows.Next() {
+		var nodeID int
+		if err := rows.Scan(&nodeID); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	t.Status(fmt.Sprintf("waiting for %s = %s to propagate to nodes %v", setting, value, nodeIDs))
+	conns := map[int]*gosql.DB{}
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	deadline := timeutil.Now().Add(timeout)
+	for {
+		var pending []string
+		for _, nodeID := range nodeIDs {
+			if conns[nodeID] == nil {
+				conns[nodeID] = c.Conn(ctx, t.L(), nodeID)
+			}
+			var current string
+			if err := conns[nodeID].QueryRowContext(ctx,
+				fmt.Sprintf(`SHOW CLUSTER SETTING %s`, setting)).Scan(&current); err != nil {
+				return err
+			}
+			if current != value {
+				pending = append(pending, fmt.Sprintf("n%d=%s", nodeID, current))
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if timeutil.Now().After(deadline) {
+			return errors.Errorf("%s = %s did not propagate within %s: %s",
+				setting, value, timeout, strings.Join(pending, " "))
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// waitForNodeRejoin waits until the given node has rejoined the cluster after
+// a restart: it must be live and not draining, and followers must have caught
+// up, i.e. no Raft snapshots are pending and the Raft log lag across the
+// cluster is below nodeRejoinMaxRaftLogBehind. Store metrics are only updated
+// every 10 seconds, so the catch-up condition must hold for two consecutive
+// polls at that interval. It returns an error if the node doesn't rejoin within
+// the timeout.
+func waitForNodeRejoin(
+	ctx context.Context, t test.Test, conn *gosql.DB, nodeID int, timeout time.Duration,
+) error {
+	const livenessQuery = `
+SELECT n.is_live, l.draining
+FROM crdb_internal.gossip_nodes AS n JOIN crdb_internal.gossip_liveness AS l USING (node_id)
+WHERE node_id = $1`
+	const catchUpQuery = `
+SELECT
+	coalesce(sum((metrics->>'raftlog.behind')::DECIMAL)::INT, 0),
+	coalesce(sum((metrics->>'queue.raftsnapshot.pending')::DECIMAL)::INT, 0)
+FROM crdb_internal.kv_store_status`
+
+	t.Status(fmt.Sprintf("waiting for n%d to rejoin", nodeID))
+	deadline := timeutil.Now().Add(timeout)
+	var caughtUp int
+	for {
+		var live, draining bool
+		var behind, snapshots int
+		err := conn.QueryRowContext(ctx, livenessQuery, nodeID).Scan(&live, &draining)
+		if err != nil && !errors.Is(err, gosql.ErrNoRows) {
+			return err
+		}
+		if err := conn.QueryRowContext(ctx, catchUpQuery).Scan(&behind, &snapshots); err != nil {
+			return err
+		}
+		if live && !draining && behind < nodeRejoinMaxRaftLogBehind && snapshots == 0 {
+			caughtUp++
+		} else {
+			caughtUp = 0
+		}
+		if caughtUp >= 2 {
+			return nil
+		}
+		if timeutil.Now().After(deadline) {
+			return errors.Errorf("n%d did not rejoin within %s: live=%t draining=%t, "+
+				"%d raft log entries behind, %d raft snapshots pending",
+				nodeID, timeout, live, draining, behind, snapshots)
+		}
+		select {
+		case <-time.After(10 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// relocateRanges relocates all ranges matching the given predicate from a set
+// of nodes to a different set of nodes. Moves are attempted sequentially from
+// each source onto each target, and errors are retried indefinitely.
+func relocateRanges(
+	t test.Test, ctx context.Context, conn *gosql.DB, predicate string, from, to []int,
+) {
+	require.NotEmpty(t, predicate)
+	var count int
+	for _, source := range from {
+		where := fmt.Sprintf("(%s) AND %d = ANY(replicas)", predicate, source)
+		for {
+			require.NoError(t, conn.QueryRowContext(ctx,
+				`SELECT count(distinct range_id) FROM [SHOW CLUSTER RANGES WITH TABLES] WHERE `+where).
+				Scan(&count))
+			if count == 0 {
+				break
+			}
+			t.Status(fmt.Sprintf("moving %d ranges off of n%d (%s)", count, source, predicate))
+			for _, target := range to {
+				_, err := conn.ExecContext(ctx, `ALTER RANGE RELOCATE FROM $1::int TO $2::int FOR `+
+					`SELECT DISTINCT range_id FROM [SHOW CLUSTER RANGES WITH TABLES] WHERE `+where,
+					source, target)
+				if err != nil {
+					t.Status(fmt.Sprintf("failed to move ranges: %s", err))
+				}
+			}
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// assertPlacement fails the test if any range matching the given predicate has
+// a replica outside of the given nodes.
+func assertPlacement(
+	t test.Test, ctx context.Context, conn *gosql.DB, predicate string, nodes []int,
+) {
+	require.NotEmpty(t, predicate)
+	rows, err := conn.QueryContext(ctx, `SELECT DISTINCT range_id, replicas `+
+		`FROM [SHOW CLUSTER RANGES WITH TABLES] WHERE (`+predicate+`) `+
+		`AND NOT replicas <@ $1::int[] ORDER BY range_id`, pq.Array(nodes))
+	require.NoError(t, err)
+	defer rows.Close()
+	var misplaced []string
+	for rows.Next() {
+		var rangeID int
+		var replicas []int64
+		require.NoError(t, rows.Scan(&rangeID, pq.Array(&replicas)))
+		misplaced = append(misplaced, fmt.Sprintf("r%d %v", rangeID, replicas))
+	}
+	require.NoError(t, rows.Err())
+	if len(misplaced) > 0 {
+		t.Fatalf("%d ranges (%s) have replicas outside of %v: %s",
+			len(misplaced), predicate, nodes, strings.Join(misplaced, ", "))
+	}
+}
 
-				select {
-				case <-ticker.C:
-				case <-ctx.Done():
-					return ctx.Err()
-				}
+// waitForLeasesOn waits for the leases of all ranges matching the given
+// predicate to be on the given nodes, and returns how long it took. It returns
+// an error if this doesn't happen within the timeout.
+func waitForLeasesOn(
+	ctx context.Context,
+	t test.Test,
+	conn *gosql.DB,
+	predicate string,
+	nodes []int,
+	timeout time.Duration,
+) (time.Duration, error) {
+	require.NotEmpty(t, predicate)
+	start := timeutil.Now()
+	var count int
+	for r := retry.StartWithCtx(ctx, retry.Options{
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Second,
+	}); r.Next(); {
+		err := conn.QueryRowContext(ctx, `SELECT count(DISTINCT range_id) `+
+			`FROM [SHOW CLUSTER RANGES WITH TABLES, DETAILS] WHERE (`+predicate+`) `+
+			`AND NOT lease_holder = ANY($1::int[])`, pq.Array(nodes)).Scan(&count)
+		if err == nil && count == 0 {
+			return timeutil.Since(start), nil
+		}
+		if timeutil.Since(start) > timeout {
+			if err == nil {
+				err = errors.Errorf("%d ranges (%s) have leases outside of %v", count, predicate, nodes)
+			}
+			return 0, errors.Wrapf(err, "leases not on n%v after %s", nodes, timeout)
+		}
+		if err != nil {
+			t.L().Printf("failed to count leases: %s", err)
+		} else {
+			t.Status(fmt.Sprintf("waiting for %d leases to move to n%v (%s)", count, nodes, predicate))
+		}
+	}
+	return 0, ctx.Err()
+}
 
-				t.Status(fmt.Sprintf("recovering n%d (%s)", node, failureMode))
-				failer.Recover(ctx, node)
+// relocateLeasesMaxAttempts is the number of lease relocation attempts
+// relocateLeases makes before giving up. Attempts are made roughly once per
+// second.
+const relocateLeasesMaxAttempts = 120
+
+// relocateLeases relocates all leases matching the given predicate to the
+// given node. Errors and failures are retried up to relocateLeasesMaxAttempts
+// times, after which an error is returned listing the ranges whose lease never
+// landed on the target.
+func relocateLeases(
+	t test.Test, ctx context.Context, conn *gosql.DB, predicate string, to int,
+) error {
+	require.NotEmpty(t, predicate)
+	var count, attempts int
+	where := fmt.Sprintf("%s AND lease_holder != %d", predicate, to)
+	for r := retry.StartWithCtx(ctx, retry.Options{
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Second,
+		MaxRetries:     relocateLeasesMaxAttempts,
+	}); r.Next(); {
+		require.NoError(t, conn.QueryRowContext(ctx,
+			`SELECT count(distinct range_id) FROM [SHOW CLUSTER RANGES WITH TABLES, DETAILS] WHERE `+
+				where).
+			Scan(&count))
+		if count == 0 {
+			if attempts > 0 {
+				t.Status(fmt.Sprintf("moved leases to n%d after %d attempts (%s)",
+					to, attempts, predicate))
 			}
+			return nil
+		}
+		attempts++
+		t.Status(fmt.Sprintf("moving %d leases to n%d (%s), attempt %d", count, to, predicate,
+			attempts))
+		_, err := conn.ExecContext(ctx, `ALTER RANGE RELOCATE LEASE TO $1::int FOR `+
+			`SELECT DISTINCT range_id FROM [SHOW CLUSTER RANGES WITH TABLES, DETAILS] WHERE `+where, to)
+		if err != nil {
+			t.Status(fmt.Sprintf("failed to move leases: %s", err))
 		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The last attempt may have moved the remaining leases, so check again.
+	var rangeIDs []int
+	rows, err := conn.QueryContext(ctx,
+		`SELECT DISTINCT range_id FROM [SHOW CLUSTER RANGES WITH TABLES, DETAILS] WHERE `+where)
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var rangeID int
+		require.NoError(t, rows.Scan(&rangeID))
+		rangeIDs = append(rangeIDs, rangeID)
+	}
+	require.NoError(t, rows.Err())
+	if len(rangeIDs) == 0 {
+		t.Status(fmt.Sprintf("moved leases to n%d after %d attempts (%s)", to, attempts, predicate))
 		return nil
-	})
-	m.Wait()
+	}
+	return errors.Errorf("failed to move leases to n%d after %d attempts (%s), ranges: %v",
+		to, attempts, predicate, rangeIDs)
 }
 
-// failureMode specifies a failure mode.
-type failureMode string
+// transferLeasesAway transfers all leases matching the given predicate off of
+// the given node, spreading them across the given target nodes. This is used
+// to emulate a planned failover, where leases are moved away before the node
+// is taken down. It returns an error if the leases couldn't be moved, see
+// relocateLeases.
+func transferLeasesAway(
+	t test.Test, ctx context.Context, conn *gosql.DB, predicate string, from int, to []int,
+) error {
+	require.NotEmpty(t, to)
+	for i, target := range to {
+		where := fmt.Sprintf("(%s) AND lease_holder = %d AND range_id %% %d = %d",
+			predicate, from, len(to), i)
+		if err := relocateLeases(t, ctx, conn, where, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-const (
-	failureModeBlackhole     failureMode = "blackhole"
-	failureModeBlackholeRecv failureMode = "blackhole-recv"
-	failureModeBlackholeSend failureMode = "blackhole-send"
-	failureModeCrash         failureMode = "crash"
-	failureModeDiskStall     failureMode = "disk-stall"
-	failureModePause         failureMode = "pause"
-)
+// leaseBalanceTimeout is the time assertLeaseBalance waits for leases to
+// become balanced before failing the test.
+const leaseBalanceTimeout = 5 * time.Minute
+
+// assertLeaseBalance asserts that the leaseholder counts on the given nodes
+// are balanced, i.e. that no node's count deviates from the mean by more than
+// the given tolerance as a fraction of the mean (e.g. 0.5 allows 50%). Only
+// leases on the given nodes are counted. Since the allocator may still be
+// rebalancing leases following a recent recovery, this retries for up to
+// leaseBalanceTimeout before failing the test with the per-node counts.
+func assertLeaseBalance(
+	ctx context.Context, t test.Test, conn *gosql.DB, nodes []int, tolerance float64,
+) {
+	require.NotEmpty(t, nodes)
+	require.GreaterOrEqual(t, tolerance, 0.0)
 
-// makeFailer creates a new failer for the given failureMode.
-func makeFailer(
-	t test.Test,
-	c cluster.Cluster,
-	failureMode failureMode,
-	opts option.StartOpts,
-	settings install.ClusterSettings,
-) failer {
-	switch failureMode {
-	case failureModeBlackhole:
-		return &blackholeFailer{
-			t:      t,
-			c:      c,
-			input:  true,
-			output: true,
+	nodeList := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		nodeList = append(nodeList, strconv.Itoa(node))
+	}
+	query := fmt.Sprintf(`SELECT lease_holder, count(distinct range_id) `+
+		`FROM [SHOW CLUSTER RANGES WITH DETAILS] WHERE lease_holder IN (%s) GROUP BY lease_holder`,
+		strings.Join(nodeList, ","))
+
+	// checkBalance returns the per-node lease counts, and whether they're
+	// balanced.
+	checkBalance := func() (map[int]int, bool) {
+		counts := make(map[int]int, len(nodes))
+		for _, node := range nodes {
+			counts[node] = 0
 		}
-	case failureModeBlackholeRecv:
-		return &blackholeFailer{
-			t:     t,
-			c:     c,
-			input: true,
+		rows, err := conn.QueryContext(ctx, query)
+		require.NoError(t, err)
+		defer rows.Close()
+		for rows.Next() {
+			var node, count int
+			require.NoError(t, rows.Scan(&node, &count))
+			counts[node] = count
 		}
-	case failureModeBlackholeSend:
-		return &blackholeFailer{
-			t:      t,
-			c:      c,
-			output: true,
+		require.NoError(t, rows.Err())
+
+		var total int
+		for _, count := range counts {
+			total += count
 		}
-	case failureModeCrash:
-		return &crashFailer{
-			t:             t,
-			c:             c,
-			startOpts:     opts,
-			startSettings: settings,
+		mean := float64(total) / float64(len(nodes))
+		for _, count := range counts {
+			if math.Abs(float64(count)-mean) > tolerance*mean {
+				return counts, false
+			}
 		}
-	case failureModeDiskStall:
-		// TODO(baptist): This mode doesn't work on local clusters since
-		// dmsetupDiskStaller does not support local clusters. Either support could
-		// be added for it or there could be a flag to not fatal when run in local
-		// mode. The net impact is that this failure can't be simulated on local
-		// clusters today.
-		return &diskStallFailer{
-			t:             t,
-			c:             c,
-			startOpts:     opts,
-			startSettings: settings,
-			staller:       &dmsetupDiskStaller{t: t, c: c},
+		return counts, true
+	}
+
+	t.Status(fmt.Sprintf("checking lease balance on n%v", nodes))
+	deadline := timeutil.Now().Add(leaseBalanceTimeout)
+	for {
+		counts, ok := checkBalance()
+		if ok {
+			t.L().Printf("leases balanced on n%v: %v", nodes, counts)
+			return
 		}
-	case failureModePause:
-		return &pauseFailer{
-			t: t,
-			c: c,
+		if timeutil.Now().After(deadline) {
+			t.Fatalf("leases not balanced within %.0f%% after %s: %v",
+				tolerance*100, leaseBalanceTimeout, counts)
 		}
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		}
+	}
+}
+
+// unexpectedDeathGracePeriod is the time after a node is recovered during
+// which unexpectedDeathChecker ignores it, to allow it to regain liveness.
+const unexpectedDeathGracePeriod = 30 * time.Second
+
+// unexpectedDeathChecker polls node liveness, and fails the test if a node
+// that is not currently failed loses liveness. This asserts that failure modes
+// which are not expected to kill nodes (e.g. network failures or pauses) don't
+// do so, either for the failed node itself or any other nodes. It is disabled
+// for failure modes that are expected to kill or stop nodes.
+type unexpectedDeathChecker struct {
+	t       test.Test
+	conn    *gosql.DB
+	enabled bool
+	cancel  context.CancelFunc
+
+	mu struct {
+		syncutil.Mutex
+		// failed contains the failed and recently recovered nodes, along with the
+		// time when their grace period ends (zero while failed).
+		failed map[int]time.Time
+	}
+}
+
+func newUnexpectedDeathChecker(
+	t test.Test, conn *gosql.DB, failureMode failureMode,
+) *unexpectedDeathChecker {
+	d := &unexpectedDeathChecker{t: t, conn: conn}
+	switch failureMode {
+	case failureModeCrash, failureModeDiskStall, failureModeDrainStop:
+		// These failure modes are expected to kill or stop the node.
 	default:
-		t.Fatalf("unknown failure mode %s", failureMode)
+		d.enabled = true
+	}
+	d.mu.failed = map[int]time.Time{}
+	return d
+}
+
+// start starts polling node liveness in the given monitor, until stop is
+// called.
+func (d *unexpectedDeathChecker) start(ctx context.Context, m cluster.Monitor) {
+	if !d.enabled {
+		return
+	}
+	ctx, d.cancel = context.WithCancel(ctx)
+	m.Go(func(context.Context) error {
+		d.run(ctx)
 		return nil
+	})
+}
+
+// stop stops polling node liveness.
+func (d *unexpectedDeathChecker) stop() {
+	if d.cancel != nil {
+		d.cancel()
 	}
 }
 
-// failer fails and recovers a given node in some particular way.
-type failer interface {
-	// Setup prepares the failer. It is called before the cluster is started.
-	Setup(ctx context.Context)
+// failing marks the given node as failed, such that it may lose liveness.
+func (d *unexpectedDeathChecker) failing(nodeID int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.failed[nodeID] = time.Time{}
+}
 
-	// Ready is called when the cluster is ready, with a running workload.
-	Ready(ctx context.Context, m cluster.Monitor)
+// recovered marks the given node as recovered. It must regain liveness within
+// unexpectedDeathGracePeriod.
+func (d *unexpectedDeathChecker) recovered(nodeID int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.failed[nodeID] = timeutil.Now().Add(unexpectedDeathGracePeriod)
+}
 
-	// Cleanup cleans up when the test exits. This is needed e.g. when the cluster
-	// is reused by a different test.
-	Cleanup(ctx context.Context)
+// expected returns true if the given node is expected to be non-live.
+func (d *unexpectedDeathChecker) expected(nodeID int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	graceEnd, ok := d.mu.failed[nodeID]
+	if !ok {
+		return false
+	}
+	if graceEnd.IsZero() || timeutil.Now().Before(graceEnd) {
+		return true
+	}
+	delete(d.mu.failed, nodeID)
+	return false
+}
 
-	// Fail fails the given node.
-	Fail(ctx context.Context, nodeID int)
+func (d *unexpectedDeathChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
 
-	// Recover recovers the given node.
-	Recover(ctx context.Context, nodeID int)
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		rows, err := d.conn.QueryContext(ctx,
+			`SELECT node_id FROM crdb_internal.gossip_nodes WHERE NOT is_live ORDER BY node_id`)
+		if ctx.Err() != nil {
+			return
+		}
+		require.NoError(d.t, err)
+		var dead []int
+		for rows.Next() {
+			var nodeID int
+			require.NoError(d.t, rows.Scan(&nodeID))
+			if !d.expected(nodeID) {
+				dead = append(dead, nodeID)
+			}
+		}
+		if ctx.Err() != nil {
+			rows.Close()
+			return
+		}
+		require.NoError(d.t, rows.Err())
+		rows.Close()
+		for _, nodeID := range dead {
+			d.t.Fatalf("n%d died unexpectedly", nodeID)
+		}
+	}
 }
 
-// partialFailer supports partial failures between specific node pairs.
-type partialFailer interface {
-	failer
+const (
+	// failureLogMargin is the amount of log context collected on either side of
+	// a failure window.
+	failureLogMargin = 30 * time.Second
+	// failureLogMaxBytes bounds the size of the log slice collected for each
+	// failure window on each node.
+	failureLogMaxBytes = 1 << 20 // 1 MiB
+	// failureLogTimeout bounds the time spent collecting logs.
+	failureLogTimeout = 5 * time.Minute
+)
 
-	// FailPartial fails the node for the given peers.
-	FailPartial(ctx context.Context, nodeID int, peerIDs []int)
+// failureLogCollector records failure windows during a test, and if the test
+// fails, collects the slice of each node's cockroach logs around each window
+// into the failure-logs artifacts directory, annotated with the failure
+// cycle. This is a best-effort aid for triaging test failures; errors are
+// logged rather than failing the test.
+type failureLogCollector struct {
+	t     test.Test
+	c     cluster.Cluster
+	nodes option.NodeListOption
+
+	mu struct {
+		syncutil.Mutex
+		windows []failureLogWindow
+	}
 }
 
-// blackholeFailer causes a network failure where TCP/IP packets to/from port
-// 26257 are dropped, causing network hangs and timeouts.
-//
-// If only one if input or output are enabled, connections in that direction
-// will fail (even already established connections), but connections in the
-// other direction are still functional (including responses).
-type blackholeFailer struct {
-	t      test.Test
-	c      cluster.Cluster
-	input  bool
-	output bool
+// failureLogWindow is a failure window, where end is zero while the node is
+// still failed.
+type failureLogWindow struct {
+	cycle       int
+	node        int
+	failureMode failureMode
+	start       time.Time
+	end         time.Time
 }
 
-func (f *blackholeFailer) Setup(_ context.Context)                    {}
-func (f *blackholeFailer) Ready(_ context.Context, _ cluster.Monitor) {}
+// newFailureLogCollector creates a failureLogCollector, which collects logs
+// from the given nodes.
+func newFailureLogCollector(
+	t test.Test, c cluster.Cluster, nodes option.NodeListOption,
+) *failureLogCollector {
+	return &failureLogCollector{t: t, c: c, nodes: nodes}
+}
 
-func (f *blackholeFailer) Cleanup(ctx context.Context) {
-	if f.c.IsLocal() {
-		f.t.Status("skipping blackhole cleanup on local cluster")
-		return
-	}
-	f.c.Run(ctx, f.c.All(), `sudo iptables -F`)
+// failing records the start of a failure window for the given node.
+func (lc *failureLogCollector) failing(cycle, nodeID int, failureMode failureMode) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.mu.windows = append(lc.mu.windows, failureLogWindow{
+		cycle:       cycle,
+		node:        nodeID,
+		failureMode: failureMode,
+		start:       timeutil.Now(),
+	})
 }
 
-func (f *blackholeFailer) Fail(ctx context.Context, nodeID int) {
-	if f.c.IsLocal() {
-		f.t.Status("skipping blackhole failure on local cluster")
-		return
-	}
-	// When dropping both input and output, make sure we drop packets in both
-	// directions for both the inbound and outbound TCP connections, such that we
-	// get a proper black hole. Only dropping one direction for both of INPUT and
-	// OUTPUT will still let e.g. TCP retransmits through, which may affect the
-	// TCP stack behavior and is not representative of real network outages.
-	//
-	// For the asymmetric partitions, only drop packets in one direction since
-	// this is representative of accidental firewall rules we've seen cause such
-	// outages in the wild.
-	if f.input && f.output {
-		// Inbound TCP connections, both received and sent packets.
-		f.c.Run(ctx, f.c.Node(nodeID), `sudo iptables -A INPUT -p tcp --dport 26257 -j DROP`)
-		f.c.Run(ctx, f.c.Node(nodeID), `sudo iptables -A OUTPUT -p tcp --sport 26257 -j DROP`)
-		// Outbound TCP connections, both sent and received packets.
-		f.c.Run(ctx, f.c.Node(nodeID), `sudo iptables -A OUTPUT -p tcp --dport 26257 -j DROP`)
-		f.c.Run(ctx, f.c.Node(nodeID), `sudo iptables -A INPUT -p tcp --sport 26257 -j DROP`)
-	} else if f.input {
-		f.c.Run(ctx, f.c.Node(nodeID), `sudo iptables -A INPUT -p tcp --dport 26257 -j DROP`)
-	} else if f.output {
-		f.c.Run(ctx, f.c.Node(nodeID), `sudo iptables -A OUTPUT -p tcp --dport 26257 -j DROP`)
+// recovered records the end of the given node's current failure window.
+func (lc *failureLogCollector) recovered(nodeID int) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	for i := len(lc.mu.windows) - 1; i >= 0; i-- {
+		if w := &lc.mu.windows[i]; w.node == nodeID && w.end.IsZero() {
+			w.end = timeutil.Now()
+			return
+		}
 	}
 }
 
-// FailPartial creates a partial blackhole failure between the given node and
-// peers.
-func (f *blackholeFailer) FailPartial(ctx context.Context, nodeID int, peerIDs []int) {
-	if f.c.IsLocal() {
-		f.t.Status("skipping blackhole failure on local cluster")
+// collectOnFailure collects the logs around the recorded failure windows if
+// the test has failed. It is intended to be deferred.
+func (lc *failureLogCollector) collectOnFailure() {
+	if !lc.t.Failed() {
+		return
+	}
+	lc.mu.Lock()
+	windows := append([]failureLogWindow(nil), lc.mu.windows...)
+	lc.mu.Unlock()
+	if len(windows) == 0 {
 		return
 	}
-	peerIPs, err := f.c.InternalIP(ctx, f.t.L(), peerIDs)
-	require.NoError(f.t, err)
 
-	for _, peerIP := range peerIPs {
-		// When dropping both input and output, make sure we drop packets in both
-		// directions for both the inbound and outbound TCP connections, such that
-		// we get a proper black hole. Only dropping one direction for both of INPUT
-		// and OUTPUT will still let e.g. TCP retransmits through, which may affect
-		// TCP stack behavior and is not representative of real network outages.
-		//
-		// For the asymmetric partitions, only drop packets in one direction since
-		// this is representative of accidental firewall rules we've seen cause such
-		// outages in the wild.
-		if f.input && f.output {
-			// Inbound TCP connections, both received and sent packets.
-			f.c.Run(ctx, f.c.Node(nodeID), fmt.Sprintf(
-				`sudo iptables -A INPUT -p tcp -s %s --dport 26257 -j DROP`, peerIP))
-			f.c.Run(ctx, f.c.Node(nodeID), fmt.Sprintf(
-				`sudo iptables -A OUTPUT -p tcp -d %s --sport 26257 -j DROP`, peerIP))
-			// Outbound TCP connections, both sent and received packets.
-			f.c.Run(ctx, f.c.Node(nodeID), fmt.Sprintf(
-				`sudo iptables -A OUTPUT -p tcp -d %s --dport 26257 -j DROP`, peerIP))
-			f.c.Run(ctx, f.c.Node(nodeID), fmt.Sprintf(
-				`sudo iptables -A INPUT -p tcp -s %s --sport 26257 -j DROP`, peerIP))
-		} else if f.input {
-			f.c.Run(ctx, f.c.Node(nodeID), fmt.Sprintf(
-				`sudo iptables -A INPUT -p tcp -s %s --dport 26257 -j DROP`, peerIP))
-		} else if f.output {
-			f.c.Run(ctx, f.c.Node(nodeID), fmt.Sprintf(
-				`sudo iptables -A OUTPUT -p tcp -d %s --dport 26257 -j DROP`, peerIP))
+	// The test context may already have been cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), failureLogTimeout)
+	defer cancel()
+
+	lc.t.L().Printf("test failed, collecting logs around %d failure windows", len(windows))
+	const tsFormat = "060102 15:04:05" // cockroach log timestamps, in UTC
+	var script strings.Builder
+	script.WriteString(`rm -rf failure-logs && mkdir -p failure-logs`)
+	for _, w := range windows {
+		end := w.end
+		if end.IsZero() {
+			end = timeutil.Now()
 		}
+		from := w.start.Add(-failureLogMargin).UTC().Format(tsFormat)
+		to := end.Add(failureLogMargin).UTC().Format(tsFormat)
+		// Log entries can span multiple lines, so continuation lines without a
+		// timestamp inherit the previous line's inclusion.
+		fmt.Fprintf(&script, ` && { echo "=== cycle %d: n%d failed (%s) from %s to %s ==="; `+
+			`cat logs/cockroach.*.log 2>/dev/null | awk -v from=%q -v to=%q `+
+			`'/^[IWEF][0-9][0-9][0-9][0-9][0-9][0-9] / { ts = substr($1, 2) " " substr($2, 1, 8); `+
+			`keep = (ts >= from && ts <= to) } keep' | head -c %d; } > failure-logs/cycle-%02d-n%d.log`,
+			w.cycle+1, w.node, w.failureMode, w.start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339),
+			from, to, failureLogMaxBytes, w.cycle+1, w.node)
 	}
-}
 
-func (f *blackholeFailer) Recover(ctx context.Context, nodeID int) {
-	if f.c.IsLocal() {
-		f.t.Status("skipping blackhole recovery on local cluster")
-		return
+	for _, node := range lc.nodes {
+		if err := lc.c.RunE(ctx, lc.c.Node(node), script.String()); err != nil {
+			lc.t.L().Printf("failed to extract logs on n%d: %s", node, err)
+			continue
+		}
+		dest := filepath.Join(lc.t.ArtifactsDir(), "failure-logs", fmt.Sprintf("n%d", node))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			lc.t.L().Printf("failed to create log directory: %s", err)
+			return
+		}
+		if err := lc.c.Get(ctx, lc.t.L(), "failure-logs", dest, lc.c.Node(node)); err != nil {
+			lc.t.L().Printf("failed to fetch logs from n%d: %s", node, err)
+		}
 	}
-	f.c.Run(ctx, f.c.Node(nodeID), `sudo iptables -F`)
-}
-
-// crashFailer is a process crash where the TCP/IP stack remains responsive
-// and sends immediate RST packets to peers.
-type crashFailer struct {
-	t             test.Test
-	c             cluster.Cluster
-	m             cluster.Monitor
-	startOpts     option.StartOpts
-	startSettings install.ClusterSettings
 }
 
-func (f *crashFailer) Setup(_ context.Context)                    {}
-func (f *crashFailer) Ready(_ context.Context, m cluster.Monitor) { f.m = m }
-func (f *crashFailer) Cleanup(_ context.Context)                  {}
+const (
+	// recoveryTrackerInterval is the metrics sampling interval of the
+	// recoveryTracker.
+	recoveryTrackerInterval = 250 * time.Millisecond
+	// recoveryTrackerTimeout is the maximum time to track recovery for, after a
+	// failure.
+	recoveryTrackerTimeout = time.Minute
+)
 
-func (f *crashFailer) Fail(ctx context.Context, nodeID int) {
-	f.m.ExpectDeath()
-	f.c.Stop(ctx, f.t.L(), option.DefaultStopOpts(), f.c.Node(nodeID)) // uses SIGKILL
+// recoveryTracker decomposes the recovery following a node failure into the
+// failure detection latency, i.e. the time until other nodes consider the
+// failed node non-live, and the lease reacquisition latency, i.e. the time
+// from detection until the failed node's leases have been acquired by other
+// nodes. This helps tell whether failover is dominated by slow detection or
+// slow lease acquisition.
+//
+// Detection is determined by the liveness.livenodes metric on the gateway
+// dropping below its pre-failure value. Reacquisition is determined by the
+// leases.success metric on the surviving nodes increasing by the number of
+// leases held by the failed node, in addition to the background rate of lease
+// requests (e.g. expiration lease extensions) measured before the failure.
+// The metrics are sampled every recoveryTrackerInterval.
+type recoveryTracker struct {
+	t       test.Test
+	gateway int
+	nodes   []int
+	conns   map[int]*gosql.DB
+
+	// The following are set by prepare() and failed().
+	node        int
+	prepareTime time.Time
+	prepareReqs float64
+	failTime    time.Time
+	failReqs    float64
+	failLeases  float64
+	liveNodes   float64
+	cancel      context.CancelFunc
+	doneC       chan struct{}
+
+	// detection and reacquisition are the times since the failure when
+	// detection and reacquisition were observed, or 0 if not observed. They are
+	// written by the sampling goroutine, and can be read once doneC is closed.
+	detection     time.Duration
+	reacquisition time.Duration
 }
 
-func (f *crashFailer) Recover(ctx context.Context, nodeID int) {
-	f.c.Start(ctx, f.t.L(), f.startOpts, f.startSettings, f.c.Node(nodeID))
+// newRecoveryTracker creates a new recoveryTracker, which uses the given
+// gateway to detect liveness changes and tracks leases on the given nodes.
+func newRecoveryTracker(
+	ctx context.Context, t test.Test, c cluster.Cluster, gateway int, nodes []int,
+) *recoveryTracker {
+	rt := &recoveryTracker{t: t, gateway: gateway, nodes: nodes, conns: map[int]*gosql.DB{}}
+	for _, node := range append([]int{gateway}, nodes...) {
+		if _, ok := rt.conns[node]; !ok {
+			rt.conns[node] = c.Conn(ctx, t.L(), node)
+		}
+	}
+	return rt
 }
 
-// diskStallFailer stalls the disk indefinitely. This should cause the node to
-// eventually self-terminate, but we'd want leases to move off before then.
-type diskStallFailer struct {
-	t             test.Test
-	c             cluster.Cluster
-	m             cluster.Monitor
-	startOpts     option.StartOpts
-	startSettings install.ClusterSettings
-	staller       diskStaller
+// close closes the tracker's connections.
+func (rt *recoveryTracker) close() {
+	for _, conn := range rt.conns {
+		_ = conn.Close()
+	}
 }
 
-func (f *diskStallFailer) Setup(ctx context.Context) {
-	f.staller.Setup(ctx)
+// metric returns the value of the given metric on the given node.
+func (rt *recoveryTracker) metric(ctx context.Context, node int, name string) (float64, error) {
+	var value float64
+	err := rt.conns[node].QueryRowContext(ctx,
+		`SELECT value FROM crdb_internal.node_metrics WHERE name = $1`, name).Scan(&value)
+	return value, err
 }
 
-func (f *diskStallFailer) Ready(_ context.Context, m cluster.Monitor) {
-	f.m = m
+// survivorLeaseRequests returns the sum of the leases.success metric across
+// the tracked nodes, except the failed node.
+func (rt *recoveryTracker) survivorLeaseRequests(ctx context.Context) (float64, error) {
+	var sum float64
+	for _, node := range rt.nodes {
+		if node == rt.node {
+			continue
+		}
+		value, err := rt.metric(ctx, node, "leases.success")
+		if err != nil {
+			return 0, err
+		}
+		sum += value
+	}
+	return sum, nil
 }
 
-func (f *diskStallFailer) Cleanup(ctx context.Context) {
-	f.staller.Unstall(ctx, f.c.All())
-	// We have to stop the cluster before cleaning up the staller.
-	f.m.ExpectDeaths(int32(f.c.Spec().NodeCount))
-	f.c.Stop(ctx, f.t.L(), option.DefaultStopOpts(), f.c.All())
-	f.staller.Cleanup(ctx)
+// prepare records the baseline rate of lease requests ahead of failing the
+// given node. It should be called some time before failed(), to measure the
+// background rate over a reasonable interval.
+func (rt *recoveryTracker) prepare(ctx context.Context, node int) {
+	var err error
+	rt.node = node
+	rt.prepareTime = timeutil.Now()
+	rt.prepareReqs, err = rt.survivorLeaseRequests(ctx)
+	require.NoError(rt.t, err)
 }
 
-func (f *diskStallFailer) Fail(ctx context.Context, nodeID int) {
-	// Pebble's disk stall detector should crash the node.
-	f.m.ExpectDeath()
-	f.staller.Stall(ctx, f.c.Node(nodeID))
+// failed records the state immediately before the node is failed, and starts
+// sampling metrics in the background until result() is called.
+func (rt *recoveryTracker) failed(ctx context.Context) {
+	var err error
+	rt.failLeases, err = rt.metric(ctx, rt.node, "replicas.leaseholders")
+	require.NoError(rt.t, err)
+	rt.liveNodes, err = rt.metric(ctx, rt.gateway, "liveness.livenodes")
+	require.NoError(rt.t, err)
+	rt.failReqs, err = rt.survivorLeaseRequests(ctx)
+	require.NoError(rt.t, err)
+	rt.failTime = timeutil.Now()
+
+	rt.detection, rt.reacquisition = 0, 0
+	rt.doneC = make(chan struct{})
+	ctx, rt.cancel = context.WithTimeout(ctx, recoveryTrackerTimeout)
+	go func() {
+		defer close(rt.doneC)
+		rt.run(ctx)
+	}()
 }
 
-func (f *diskStallFailer) Recover(ctx context.Context, nodeID int) {
-	f.staller.Unstall(ctx, f.c.Node(nodeID))
-	// Pebble's disk stall detector should have terminated the node, but in case
-	// it didn't, we explicitly stop it first.
-	f.c.Stop(ctx, f.t.L(), option.DefaultStopOpts(), f.c.Node(nodeID))
-	f.c.Start(ctx, f.t.L(), f.startOpts, f.startSettings, f.c.Node(nodeID))
+func (rt *recoveryTracker) run(ctx context.Context) {
+	var baseRate float64 // lease requests per second
+	if elapsed := rt.failTime.Sub(rt.prepareTime).Seconds(); elapsed > 0 {
+		baseRate = (rt.failReqs - rt.prepareReqs) / elapsed
+	}
+
+	ticker := time.NewTicker(recoveryTrackerInterval)
+	defer ticker.Stop()
+
+	for rt.detection == 0 || rt.reacquisition == 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		now := timeutil.Now()
+		elapsed := now.Sub(rt.failTime)
+
+		if rt.detection == 0 {
+			liveNodes, err := rt.metric(ctx, rt.gateway, "liveness.livenodes")
+			if err != nil {
+				rt.t.L().Printf("failed to sample liveness: %s", err)
+				continue
+			}
+			if liveNodes < rt.liveNodes {
+				rt.detection = elapsed
+			}
+		}
+
+		if rt.reacquisition == 0 {
+			reqs, err := rt.survivorLeaseRequests(ctx)
+			if err != nil {
+				rt.t.L().Printf("failed to sample lease requests: %s", err)
+				continue
+			}
+			if reqs-rt.failReqs-baseRate*elapsed.Seconds() >= rt.failLeases {
+				rt.reacquisition = elapsed
+			}
+		}
+	}
 }
 
-// pauseFailer pauses the process, but keeps the OS (and thus network
-// connections) alive.
-type pauseFailer struct {
-	t test.Test
-	c cluster.Cluster
+// stop stops sampling metrics, and returns a report of the detection and
+// reacquisition latencies.
+func (rt *recoveryTracker) stop() string {
+	rt.cancel()
+	<-rt.doneC
+
+	format := func(d time.Duration) string {
+		if d == 0 {
+			return "n/a"
+		}
+		return d.Round(time.Millisecond).String()
+	}
+	// The reacquisition latency is relative to detection. Leases may be
+	// reacquired before liveness changes, e.g. with expiration-based leases.
+	var reacquisition time.Duration
+	if rt.detection > 0 && rt.reacquisition > 0 {
+		reacquisition = rt.reacquisition - rt.detection
+		if reacquisition <= 0 {
+			reacquisition = time.Nanosecond
+		}
+	}
+	return fmt.Sprintf("n%d: detection=%s reacquisition=%s total=%s (leases=%.0f)",
+		rt.node, format(rt.detection), format(reacquisition), format(rt.reacquisition),
+		rt.failLeases)
 }
 
-func (f *pauseFailer) Setup(ctx context.Context)   {}
-func (f *pauseFailer) Cleanup(ctx context.Context) {}
+// consistencyCheckerKeys is the number of keys written by the consistency
+// checker. Each key is placed in a separate range.
+const consistencyCheckerKeys = 100
 
-func (f *pauseFailer) Ready(ctx context.Context, m cluster.Monitor) {
-	// The process pause can trip the disk stall detector, so we disable it.
-	conn := f.c.Conn(ctx, f.t.L(), 1)
-	_, err := conn.ExecContext(ctx, `SET CLUSTER SETTING storage.max_sync_duration.fatal.enabled = false`)
-	require.NoError(f.t, err)
+// createConsistencyCheckerTable creates the kv database, constrained to the
+// given nodes, and the kv.consistency table used by consistencyChecker, with
+// each key in a separate range.
+func createConsistencyCheckerTable(t test.Test, ctx context.Context, conn *gosql.DB, nodes []int) {
+	_, err := conn.ExecContext(ctx, `CREATE DATABASE kv`)
+	require.NoError(t, err)
+	configureZone(t, ctx, conn, `DATABASE kv`, zoneConfig{replicas: 3, onlyNodes: nodes})
+	_, err = conn.ExecContext(ctx, `CREATE TABLE kv.consistency (k INT PRIMARY KEY, v INT NOT NULL)`)
+	require.NoError(t, err)
+	_, err = conn.ExecContext(ctx, `INSERT INTO kv.consistency `+
+		`SELECT i, 0 FROM generate_series(0, $1) AS g(i)`, consistencyCheckerKeys-1)
+	require.NoError(t, err)
+	_, err = conn.ExecContext(ctx, `ALTER TABLE kv.consistency `+
+		`SPLIT AT SELECT i FROM generate_series(1, $1) AS g(i)`, consistencyCheckerKeys-1)
+	require.NoError(t, err)
 }
 
-func (f *pauseFailer) Fail(ctx context.Context, nodeID int) {
-	f.c.Signal(ctx, f.t.L(), 19, f.c.Node(nodeID)) // SIGSTOP
+// consistencyViolation describes a stale read observed by consistencyChecker.
+type consistencyViolation struct {
+	key       int
+	expected  int64  // the minimum value the read must observe
+	read      int64  // the value that was actually read
+	writeTS   string // the MVCC timestamp of the expected value's write
+	readTS    string // the MVCC timestamp of the value that was read
+	monotonic bool   // if true, expected came from a prior read rather than a write
 }
 
-func (f *pauseFailer) Recover(ctx context.Context, nodeID int) {
-	f.c.Signal(ctx, f.t.L(), 18, f.c.Node(nodeID)) // SIGCONT
+func (v consistencyViolation) String() string {
+	kind := "read-your-writes"
+	if v.monotonic {
+		kind = "monotonic read"
+	}
+	return fmt.Sprintf("%s violation on key %d: read %d at %s, expected >= %d written at %s",
+		kind, v.key, v.read, v.readTS, v.expected, v.writeTS)
 }
 
-// waitForUpreplication waits for upreplication of ranges that satisfy the
-// given predicate (using SHOW RANGES).
+// consistencyChecker runs a client workload against kv.consistency which
+// writes monotonically increasing values to each key and reads them back via a
+// different gateway, recording any read that doesn't observe the last
+// acknowledged write or the last observed read.
 //
-// TODO(erikgrinaker): move this into WaitForReplication() when it can use SHOW
-// RANGES, i.e. when it's no longer needed in mixed-version tests with older
-// versions that don't have SHOW RANGES.
-func waitForUpreplication(
-	t test.Test, ctx context.Context, conn *gosql.DB, predicate string, replicationFactor int,
-) {
-	var count int
-	where := fmt.Sprintf("WHERE array_length(replicas, 1) < %d", replicationFactor)
-	if predicate != "" {
-		where += fmt.Sprintf(" AND (%s)", predicate)
+// Writes that fail are ambiguous, and may or may not have been applied, so
+// they don't update the expected value. Since values are monotonically
+// increasing, a read observing an ambiguous write is still valid.
+type consistencyChecker struct {
+	t     test.Test
+	conns []*gosql.DB
+
+	writes atomic.Int64
+	reads  atomic.Int64
+
+	mu struct {
+		syncutil.Mutex
+		violations []consistencyViolation
 	}
-	for {
-		require.NoError(t, conn.QueryRowContext(ctx,
-			`SELECT count(distinct range_id) FROM [SHOW CLUSTER RANGES WITH TABLES, DETAILS] `+where).
-			Scan(&count))
-		if count == 0 {
-			break
-		}
-		t.Status(fmt.Sprintf("waiting for %d ranges to upreplicate (%s)", count, predicate))
-		time.Sleep(time.Second)
+}
+
+func newConsistencyChecker(t test.Test, c cluster.Cluster, gateways []int) *consistencyChecker {
+	cc := &consistencyChecker{t: t}
+	for _, node := range gateways {
+		cc.conns = append(cc.conns, c.Conn(context.Background(), t.L(), node))
 	}
+	return cc
 }
 
-// relocateRanges relocates all ranges matching the given predicate from a set
-// of nodes to a different set of nodes. Moves are attempted sequentially from
-// each source onto each target, and errors are retried indefinitely.
-func relocateRanges(
-	t test.Test, ctx context.Context, conn *gosql.DB, predicate string, from, to []int,
-) {
-	require.NotEmpty(t, predicate)
-	var count int
-	for _, source := range from {
-		where := fmt.Sprintf("(%s) AND %d = ANY(replicas)", predicate, source)
-		for {
-			require.NoError(t, conn.QueryRowContext(ctx,
-				`SELECT count(distinct range_id) FROM [SHOW CLUSTER RANGES WITH TABLES] WHERE `+where).
-				Scan(&count))
-			if count == 0 {
-				break
+func (cc *consistencyChecker) close() {
+	for _, conn := range cc.conns {
+		_ = conn.Close()
+	}
+}
+
+// run runs the checker until the context is cancelled, with one worker per
+// gateway. Each worker owns a disjoint set of keys.
+func (cc *consistencyChecker) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := range cc.conns {
+		i := i // pin loop variable
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cc.runWorker(ctx, i)
+		}()
+	}
+	wg.Wait()
+}
+
+func (cc *consistencyChecker) runWorker(ctx context.Context, worker int) {
+	type keyState struct {
+		next     int64  // the next value to write
+		acked    int64  // the last acknowledged write
+		ackedTS  string // the timestamp of the last acknowledged write
+		lastRead int64  // the last value read
+		readTS   string // the timestamp of the last value read
+	}
+	writeConn := cc.conns[worker]
+	readConn := cc.conns[(worker+1)%len(cc.conns)]
+
+	keys := map[int]*keyState{}
+	for k := worker; k < consistencyCheckerKeys; k += len(cc.conns) {
+		keys[k] = &keyState{next: 1}
+	}
+
+	for ctx.Err() == nil {
+		for k, s := range keys {
+			if ctx.Err() != nil {
+				return
 			}
-			t.Status(fmt.Sprintf("moving %d ranges off of n%d (%s)", count, source, predicate))
-			for _, target := range to {
-				_, err := conn.ExecContext(ctx, `ALTER RANGE RELOCATE FROM $1::int TO $2::int FOR `+
-					`SELECT DISTINCT range_id FROM [SHOW CLUSTER RANGES WITH TABLES] WHERE `+where,
-					source, target)
-				if err != nil {
-					t.Status(fmt.Sprintf("failed to move ranges: %s", err))
-				}
+			opCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			var writeTS string
+			err := writeConn.QueryRowContext(opCtx,
+				`UPDATE kv.consistency SET v = $2 WHERE k = $1 RETURNING cluster_logical_timestamp()`,
+				k, s.next).Scan(&writeTS)
+			cc.writes.Add(1)
+			if err == nil {
+				s.acked, s.ackedTS = s.next, writeTS
+			}
+			s.next++
+
+			var v int64
+			var readTS string
+			err = readConn.QueryRowContext(opCtx,
+				`SELECT v, crdb_internal_mvcc_timestamp FROM kv.consistency WHERE k = $1`,
+				k).Scan(&v, &readTS)
+			cancel()
+			if err != nil {
+				continue
+			}
+			cc.reads.Add(1)
+			if v < s.acked {
+				cc.recordViolation(consistencyViolation{
+					key: k, expected: s.acked, read: v, writeTS: s.ackedTS, readTS: readTS,
+				})
+			} else if v < s.lastRead {
+				cc.recordViolation(consistencyViolation{
+					key: k, expected: s.lastRead, read: v, writeTS: s.readTS, readTS: readTS,
+					monotonic: true,
+				})
+			}
+			if v > s.lastRead {
+				s.lastRead, s.readTS = v, readTS
+			}
+			// An ambiguous write may have been applied, in which case the next
+			// write must still use a larger value.
+			if v >= s.next {
+				s.next = v + 1
 			}
-			time.Sleep(time.Second)
 		}
 	}
 }
 
-// relocateLeases relocates all leases matching the given predicate to the
-// given node. Errors and failures are retried indefinitely.
-func relocateLeases(t test.Test, ctx context.Context, conn *gosql.DB, predicate string, to int) {
-	require.NotEmpty(t, predicate)
-	var count int
-	where := fmt.Sprintf("%s AND lease_holder != %d", predicate, to)
-	for {
-		require.NoError(t, conn.QueryRowContext(ctx,
-			`SELECT count(distinct range_id) FROM [SHOW CLUSTER RANGES WITH TABLES, DETAILS] WHERE `+
-				where).
-			Scan(&count))
-		if count == 0 {
-			break
-		}
-		t.Status(fmt.Sprintf("moving %d leases to n%d (%s)", count, to, predicate))
-		_, err := conn.ExecContext(ctx, `ALTER RANGE RELOCATE LEASE TO $1::int FOR `+
-			`SELECT DISTINCT range_id FROM [SHOW CLUSTER RANGES WITH TABLES, DETAILS] WHERE `+where, to)
-		if err != nil {
-			t.Status(fmt.Sprintf("failed to move leases: %s", err))
-		}
-		time.Sleep(time.Second)
-	}
+func (cc *consistencyChecker) recordViolation(v consistencyViolation) {
+	cc.t.L().Printf("%s", v)
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.mu.violations = append(cc.mu.violations, v)
+}
+
+// violations returns all consistency violations observed so far.
+func (cc *consistencyChecker) violations() []consistencyViolation {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return append([]consistencyViolation(nil), cc.mu.violations...)
 }
 
 type zoneConfig struct {
 	replicas  int
 	onlyNodes []int
 	leaseNode int
+	// leasePreferences are ordered lease preferences, each a comma-separated
+	// list of constraints, e.g. "+region=us-east1". Can't be combined with
+	// leaseNode.
+	leasePreferences []string
 }
 
 // configureZone sets the zone config for the given target.
@@ -1407,10 +9527,18 @@ func configureZone(
 		}
 	}
 
+	require.False(t, cfg.leaseNode > 0 && len(cfg.leasePreferences) > 0,
+		"can't combine leaseNode and leasePreferences")
 	var leaseString string
 	if cfg.leaseNode > 0 {
 		leaseString += fmt.Sprintf("[+node%d]", cfg.leaseNode)
 	}
+	for i, preference := range cfg.leasePreferences {
+		if i > 0 {
+			leaseString += ","
+		}
+		leaseString += fmt.Sprintf("[%s]", preference)
+	}
 
 	query := fmt.Sprintf(
 		`ALTER %s CONFIGURE ZONE USING num_replicas = %d, constraints = '[%s]', lease_preferences = '[%s]'`,
@@ -1433,6 +9561,471 @@ func configureAllZones(t test.Test, ctx context.Context, conn *gosql.DB, cfg zon
 	require.NoError(t, rows.Err())
 }
 
+// failoverClusterSpec describes the cluster set up by setupFailoverCluster.
+type failoverClusterSpec struct {
+	// nodes are the CockroachDB nodes to start. If empty, the nodes are assumed
+	// to already be running, e.g. when reusing a pre-existing cluster.
+	nodes option.NodeListOption
+	// localities are the node localities, if any (see startWithLocalities).
+	localities []string
+	// gateway is the node to connect to. Defaults to n1.
+	gateway int
+	// manualSplits disables load-based splitting, for tests that control the
+	// ranges manually.
+	manualSplits bool
+	// systemNodes, if given, constrains all existing zones to these nodes, and
+	// waits for upreplication.
+	systemNodes []int
+	// replicas is the replication factor of the system nodes' zones. Defaults
+	// to 3.
+	replicas int
+}
+
+// setupFailoverCluster starts the cluster and configures it for a failover
+// test: it enables or disables expiration-based leases, and optionally
+// disables load-based splitting and places all ranges on the system nodes.
+//
+// It returns a connection to the gateway, which the caller must close, along
+// with a snapshot of the zone configs taken before any were changed. The
+// snapshot is passed to finishFailoverPlacement, which logs the changes made by
+// the test.
+func setupFailoverCluster(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	opts option.StartOpts,
+	settings install.ClusterSettings,
+	expLeases bool,
+	spec failoverClusterSpec,
+) (*gosql.DB, map[string]string) {
+	if len(spec.nodes) > 0 {
+		c.Put(ctx, t.Cockroach(), "./cockroach")
+		if spec.localities != nil {
+			startWithLocalities(ctx, t, c, opts, settings, spec.nodes, spec.localities)
+		} else {
+			c.Start(ctx, t.L(), opts, settings, spec.nodes)
+		}
+	}
+
+	gateway := 1
+	if spec.gateway > 0 {
+		gateway = spec.gateway
+	}
+	conn := c.Conn(ctx, t.L(), gateway)
+
+	t.Status("configuring cluster")
+	if spec.manualSplits {
+		_, err := conn.ExecContext(ctx, `SET CLUSTER SETTING kv.range_split.by_load_enabled = 'false'`)
+		require.NoError(t, err)
+	}
+	_, err := conn.ExecContext(ctx, `SET CLUSTER SETTING kv.expiration_leases_only.enabled = $1`,
+		expLeases)
+	require.NoError(t, err)
+	require.NoError(t, waitForSettingPropagation(ctx, t, c,
+		"kv.expiration_leases_only.enabled", strconv.FormatBool(expLeases), settingPropagationTimeout))
+
+	zoneConfigs, err := snapshotZoneConfigs(ctx, conn)
+	require.NoError(t, err)
+
+	if len(spec.systemNodes) > 0 {
+		replicas := 3
+		if spec.replicas > 0 {
+			replicas = spec.replicas
+		}
+		configureAllZones(t, ctx, conn, zoneConfig{replicas: replicas, onlyNodes: spec.systemNodes})
+		require.NoError(t, WaitForReplication(ctx, t, conn, replicas))
+		requireFullyReplicated(ctx, t, conn)
+	}
+
+	return conn, zoneConfigs
+}
+
+// finishFailoverPlacement is called once the test has placed its ranges and
+// leases. It logs the zone config changes since the given snapshot (see
+// setupFailoverCluster), waits for any ongoing rebalancing to settle, and
+// writes the test manifest.
+func finishFailoverPlacement(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	conn *gosql.DB,
+	zoneConfigs map[string]string,
+	manifest failoverManifest,
+) {
+	logZoneConfigDiff(ctx, t, conn, zoneConfigs)
+	require.NoError(t, waitForReplicateQueueIdle(ctx, t, conn, replicateQueueIdleTimeout))
+	writeFailoverManifest(ctx, t, c, conn, manifest)
+}
+
+// failoverWorkload is a workload started by startFailoverWorkload.
+type failoverWorkload struct {
+	// name is the workload name, e.g. kv, used to identify its connections.
+	name string
+	// node is the node to run the workload on.
+	node int
+	// gateways are the nodes the workload connects to.
+	gateways []int
+	// cmds are the workload commands, excluding the connection URLs. They run
+	// concurrently.
+	cmds []string
+}
+
+// startFailoverWorkload starts the workload under a new monitor of the given
+// nodes, and returns the monitor. It waits for the workload connections to
+// spread across the gateways, and for the workload to warm up, such that the
+// first failure is comparable to later ones.
+//
+// The returned function stops the workload early, e.g. once the failures are
+// done, without failing the test. Otherwise, the workload runs to completion.
+func startFailoverWorkload(
+	ctx context.Context,
+	t test.Test,
+	c cluster.Cluster,
+	conn *gosql.DB,
+	nodes option.NodeListOption,
+	w failoverWorkload,
+) (cluster.Monitor, func()) {
+	t.Status("running workload")
+	m := c.NewMonitor(ctx, nodes)
+	stopCtx, stop := context.WithCancel(ctx)
+	pgURLs := fmt.Sprintf("{pgurl%s}", c.Nodes(w.gateways...))
+	for _, cmd := range w.cmds {
+		cmd := cmd // pin loop variable
+		m.Go(func(ctx context.Context) error {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go func() {
+				select {
+				case <-stopCtx.Done():
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			err := c.RunE(ctx, c.Node(w.node), cmd+" "+pgURLs)
+			if stopCtx.Err() != nil {
+				return nil // stopped
+			}
+			return err
+		})
+	}
+
+	assertWorkloadGateways(ctx, t, conn, w.name, w.gateways)
+	require.NoError(t, waitForWorkloadSteadyState(ctx, t, c, w.gateways, workloadSteadyStateTimeout))
+	return m, stop
+}
+
+// snapshotZoneConfigs returns the raw SQL of all zone configs, keyed by
+// target.
+func snapshotZoneConfigs(ctx context.Context, conn *gosql.DB) (map[string]string, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT target, raw_config_sql FROM [SHOW ALL ZONE CONFIGURATIONS]`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	configs := map[string]string{}
+	for rows.Next() {
+		var target, config string
+		if err := rows.Scan(&target, &config); err != nil {
+			return nil, err
+		}
+		configs[target] = config
+	}
+	return configs, rows.Err()
+}
+
+// diffZoneConfigs returns a human-readable description of the zone config
+// changes between two snapshots taken by snapshotZoneConfigs, ordered by
+// target. Unchanged targets are omitted.
+func diffZoneConfigs(before, after map[string]string) string {
+	targets := map[string]bool{}
+	for target := range before {
+		targets[target] = true
+	}
+	for target := range after {
+		targets[target] = true
+	}
+	sorted := make([]string, 0, len(targets))
+	for target := range targets {
+		sorted = append(sorted, target)
+	}
+	sort.Strings(sorted)
+
+	var b strings.Builder
+	for _, target := range sorted {
+		prev, hadPrev := before[target]
+		cur, hasCur := after[target]
+		switch {
+		case !hadPrev:
+			fmt.Fprintf(&b, "added %s:\n+ %s\n", target, cur)
+		case !hasCur:
+			fmt.Fprintf(&b, "removed %s:\n- %s\n", target, prev)
+		case prev != cur:
+			fmt.Fprintf(&b, "changed %s:\n- %s\n+ %s\n", target, prev, cur)
+		}
+	}
+	if b.Len() == 0 {
+		return "no zone config changes"
+	}
+	return b.String()
+}
+
+// logZoneConfigDiff logs the zone config changes made since the given
+// snapshot. This makes zone config drift, e.g. due to bugs in configureZone or
+// the allocator overriding constraints, visible in the test output.
+func logZoneConfigDiff(ctx context.Context, t test.Test, conn *gosql.DB, before map[string]string) {
+	after, err := snapshotZoneConfigs(ctx, conn)
+	require.NoError(t, err)
+	t.Status(fmt.Sprintf("zone config changes:\n%s", diffZoneConfigs(before, after)))
+}
+
+// failoverCycleArtifactsDir returns the artifacts directory for the given
+// failure cycle, i.e. cycle-<n> under the test's artifacts directory. The
+// directory is created if it doesn't already exist.
+func failoverCycleArtifactsDir(t test.Test, cycle int) string {
+	dir := filepath.Join(t.ArtifactsDir(), fmt.Sprintf("cycle-%d", cycle))
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	return dir
+}
+
+// captureCycleArtifacts writes per-cycle captures to the given cycle
+// directory: the zone config changes since the given snapshot (zone-diff.txt),
+// and the per-store range and lease counts (stores.txt).
+func captureCycleArtifacts(
+	ctx context.Context, t test.Test, conn *gosql.DB, dir string, zoneConfigs map[string]string,
+) {
+	after, err := snapshotZoneConfigs(ctx, conn)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "zone-diff.txt"),
+		[]byte(diffZoneConfigs(zoneConfigs, after)+"\n"), 0644))
+
+	rows, err := conn.QueryContext(ctx, `SELECT node_id, store_id, range_count, lease_count `+
+		`FROM crdb_internal.kv_store_status ORDER BY node_id, store_id`)
+	require.NoError(t, err)
+	defer rows.Close()
+	var b strings.Builder
+	b.WriteString("node_id,store_id,range_count,lease_count\n")
+	for rows.Next() {
+		var nodeID, storeID, rangeCount, leaseCount int
+		require.NoError(t, rows.Scan(&nodeID, &storeID, &rangeCount, &leaseCount))
+		fmt.Fprintf(&b, "%d,%d,%d,%d\n", nodeID, storeID, rangeCount, leaseCount)
+	}
+	require.NoError(t, rows.Err())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stores.txt"), []byte(b.String()), 0644))
+}
+
+// nodePlacement is the number of replicas and leases on a node.
+type nodePlacement struct {
+	Node     int `json:"node"`
+	Replicas int `json:"replicas"`
+	Leases   int `json:"leases"`
+}
+
+// rangeDistribution returns the per-node replica and leaseholder counts (from
+// SHOW CLUSTER RANGES), ordered by node ID. Nodes without replicas are included
+// with zero counts.
+func rangeDistribution(
+	ctx context.Context, t test.Test, c cluster.Cluster, conn *gosql.DB,
+) []nodePlacement {
+	const query = `
+WITH ranges AS (SELECT range_id, replicas, lease_holder FROM [SHOW CLUSTER RANGES WITH DETAILS]),
+replicas AS (
+	SELECT s.node_id, count(*) AS count
+	FROM ranges CROSS JOIN unnest(ranges.replicas) AS r(store_id)
+	JOIN crdb_internal.kv_store_status AS s ON s.store_id = r.store_id
+	GROUP BY s.node_id
+),
+leases AS (SELECT lease_holder AS node_id, count(*) AS count FROM ranges GROUP BY lease_holder)
+SELECT node_id, COALESCE(replicas.count, 0), COALESCE(leases.count, 0)
+FROM replicas FULL OUTER JOIN leases USING (node_id)`
+
+	nodes := map[int]nodePlacement{}
+	for _, node := range c.All() {
+		nodes[node] = nodePlacement{Node: node}
+	}
+	rows, err := conn.QueryContext(ctx, query)
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var p nodePlacement
+		require.NoError(t, rows.Scan(&p.Node, &p.Replicas, &p.Leases))
+		nodes[p.Node] = p
+	}
+	require.NoError(t, rows.Err())
+
+	placement := make([]nodePlacement, 0, len(nodes))
+	for _, p := range nodes {
+		placement = append(placement, p)
+	}
+	sort.Slice(placement, func(i, j int) bool {
+		return placement[i].Node < placement[j].Node
+	})
+	return placement
+}
+
+// recordRangeDistribution writes the per-node replica and leaseholder counts
+// (see rangeDistribution) to range-distribution-<label>.txt in the artifacts
+// directory, to compare the cluster's range distribution at different points
+// of the test, e.g. "pre-workload" and "post-test".
+func recordRangeDistribution(
+	ctx context.Context, t test.Test, c cluster.Cluster, conn *gosql.DB, label string,
+) {
+	var b strings.Builder
+	b.WriteString("node_id,replicas,leases\n")
+	for _, p := range rangeDistribution(ctx, t, c, conn) {
+		fmt.Fprintf(&b, "%d,%d,%d\n", p.Node, p.Replicas, p.Leases)
+	}
+	t.L().Printf("range distribution (%s):\n%s", label, b.String())
+	require.NoError(t, os.WriteFile(
+		filepath.Join(t.ArtifactsDir(), fmt.Sprintf("range-distribution-%s.txt", label)),
+		[]byte(b.String()), 0644))
+}
+
+// failoverManifest is the effective configuration of a failover test run. It is
+// written to manifest.json in the artifacts directory by writeFailoverManifest,
+// such that each roachperf data point is self-describing and regressions can
+// be correlated with configuration changes.
+type failoverManifest struct {
+	Test         string `json:"test"`
+	BuildVersion string `json:"build_version"`
+	// Revision is the git SHA of the cockroach binary.
+	Revision string `json:"revision"`
+	// FailureMode is the failure mode, if the test is parameterized by it.
+	FailureMode      failureMode `json:"failure_mode,omitempty"`
+	ExpirationLeases bool        `json:"expiration_leases"`
+	// Cycles is the number of failure cycles, if any.
+	Cycles int `json:"cycles,omitempty"`
+	// Workload is the workload command, excluding the connection URLs.
+	Workload string `json:"workload,omitempty"`
+	// Config is the failoverConfig, if the test takes one.
+	Config *failoverConfigManifest `json:"config,omitempty"`
+	// Scenario is the failoverScenario, for scenario tests.
+	Scenario *failoverScenario `json:"scenario,omitempty"`
+	// Localities are the node localities, if the test sets them.
+	Localities []string `json:"localities,omitempty"`
+	// Env contains the environment variable overrides in effect.
+	Env map[string]string `json:"env,omitempty"`
+	// Placement is the replica and lease placement at the start of the run.
+	Placement []nodePlacement `json:"placement"`
+}
+
+// failoverConfigManifest is a failoverConfig in a failoverManifest. Durations
+// are encoded as strings, e.g. "1m30s", and omitted if unset.
+type failoverConfigManifest struct {
+	FullReplicationTimeout string `json:"full_replication_timeout,omitempty"`
+	Settle                 string `json:"settle,omitempty"`
+	RawErrorsDuration      string `json:"raw_errors_duration,omitempty"`
+	// The Raft configuration fields are the effective values, including
+	// defaults.
+	RaftTickInterval           string  `json:"raft_tick_interval"`
+	RaftElectionTimeoutTicks   int     `json:"raft_election_timeout_ticks"`
+	RaftHeartbeatIntervalTicks int     `json:"raft_heartbeat_interval_ticks"`
+	RangeLeaseDuration         string  `json:"range_lease_duration"`
+	TxnSize                    int     `json:"txn_size,omitempty"`
+	Planned                    bool    `json:"planned,omitempty"`
+	Replicas                   int     `json:"replicas"`
+	SplitReadWrite             bool    `json:"split_read_write,omitempty"`
+	FixedFailureOffset         bool    `json:"fixed_failure_offset,omitempty"`
+	FailureOffset              string  `json:"failure_offset,omitempty"`
+	SpanPercent                int     `json:"span_percent,omitempty"`
+	MinOpFraction              float64 `json:"min_op_fraction,omitempty"`
+	LatencySLOP99              string  `json:"latency_slo_p99,omitempty"`
+	LatencySLOPMax             string  `json:"latency_slo_pmax,omitempty"`
+	LeaseTrigger               bool    `json:"lease_trigger,omitempty"`
+	AdmissionMetrics           bool    `json:"admission_metrics,omitempty"`
+	Secure                     bool    `json:"secure,omitempty"`
+	GoroutineDumps             bool    `json:"goroutine_dumps,omitempty"`
+}
+
+// manifest returns the configuration for the test's failoverManifest.
+func (cfg failoverConfig) manifest() *failoverConfigManifest {
+	duration := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return d.String()
+	}
+	raftCfg := cfg.raftConfig()
+	return &failoverConfigManifest{
+		FullReplicationTimeout:     duration(cfg.fullReplicationTimeout),
+		Settle:                     duration(cfg.settle),
+		RawErrorsDuration:          duration(cfg.rawErrorsDuration),
+		RaftTickInterval:           raftCfg.RaftTickInterval.String(),
+		RaftElectionTimeoutTicks:   raftCfg.RaftElectionTimeoutTicks,
+		RaftHeartbeatIntervalTicks: raftCfg.RaftHeartbeatIntervalTicks,
+		RangeLeaseDuration:         raftCfg.RangeLeaseDuration.String(),
+		TxnSize:                    cfg.txnSize,
+		Planned:                    cfg.planned,
+		Replicas:                   cfg.replicationFactor(),
+		SplitReadWrite:             cfg.splitReadWrite,
+		FixedFailureOffset:         cfg.fixedFailureOffset,
+		FailureOffset:              duration(cfg.failureOffset),
+		SpanPercent:                cfg.spanPercent,
+		MinOpFraction:              cfg.minOpFraction,
+		LatencySLOP99:              duration(cfg.latencySLO.p99),
+		LatencySLOPMax:             duration(cfg.latencySLO.pMax),
+		LeaseTrigger:               cfg.leaseTrigger,
+		AdmissionMetrics:           cfg.admissionMetrics,
+		Secure:                     cfg.secure,
+		GoroutineDumps:             cfg.goroutineDumps,
+	}
+}
+
+// writeFailoverManifest fills in the build, environment, and placement details
+// of the given manifest, and writes it to manifest.json in the artifacts
+// directory. It should be called once the initial placement has converged,
+// before starting the workload.
+func writeFailoverManifest(
+	ctx context.Context, t test.Test, c cluster.Cluster, conn *gosql.DB, manifest failoverManifest,
+) {
+	manifest.Test = t.Name()
+	manifest.BuildVersion = t.BuildVersion().String()
+	details, err := c.RunWithDetailsSingleNode(ctx, t.L(), c.Node(1),
+		`./cockroach version | sed -n 's/^Build Commit ID: *//p'`)
+	require.NoError(t, err)
+	manifest.Revision = strings.TrimSpace(details.Stdout)
+	for _, env := range []string{envFailoverReuseCluster, envFailoverFailureOffset} {
+		if value := os.Getenv(env); value != "" {
+			if manifest.Env == nil {
+				manifest.Env = map[string]string{}
+			}
+			manifest.Env[env] = value
+		}
+	}
+	manifest.Placement = rangeDistribution(ctx, t, c, conn)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	require.NoError(t, err)
+	t.L().Printf("test manifest:\n%s", data)
+	require.NoError(t, os.WriteFile(filepath.Join(t.ArtifactsDir(), "manifest.json"), data, 0644))
+}
+
+// rangeCountDriftTolerance is the maximum fractional change in the total range
+// count over a failover test, see assertRangeCountStable. The tests disable
+// load-based splitting, so the range count should only change marginally, e.g.
+// due to size-based splits or merges of system ranges.
+const rangeCountDriftTolerance = 0.05
+
+// recordRangeCount returns the total number of ranges in the cluster.
+func recordRangeCount(ctx context.Context, t test.Test, conn *gosql.DB) int {
+	var count int
+	require.NoError(t, conn.QueryRowContext(ctx,
+		`SELECT count(*) FROM [SHOW CLUSTER RANGES]`).Scan(&count))
+	t.L().Printf("range count: %d", count)
+	return count
+}
+
+// assertRangeCountStable fails the test if the total range count changed by
+// more than the given fraction between before and after, as recorded by
+// recordRangeCount. This guards against failovers interacting with the split
+// and merge queues in ways that reshape the keyspace mid-measurement.
+func assertRangeCountStable(t test.Test, before, after int, tolerance float64) {
+	delta := after - before
+	if math.Abs(float64(delta)) > tolerance*float64(before) {
+		t.Fatalf("range count changed by %+d (from %d to %d), more than %.0f%%",
+			delta, before, after, tolerance*100)
+	}
+}
+
 // nodeMetric fetches the given metric value from the given node.
 func nodeMetric(
 	ctx context.Context, t test.Test, c cluster.Cluster, node int, metric string,
@@ -0,0 +1,172 @@
+// Copyright 2023 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestBlackholeFailerRules tests that the blackholeFailer only places rules in
+// its own chains, never directly in INPUT or OUTPUT.
+func TestBlackholeFailerRules(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		failer        blackholeFailer
+		peerIP        string
+		expectIn      int
+		expectOut     int
+		expectContain string
+	}{
+		{"full", blackholeFailer{input: true, output: true}, "", 2, 2, "--dport 26257"},
+		{"recv", blackholeFailer{input: true}, "", 1, 0, "--dport 26257"},
+		{"send", blackholeFailer{output: true}, "", 0, 1, "--dport 26257"},
+		{"partial", blackholeFailer{input: true, output: true}, "10.0.0.2", 2, 2, "10.0.0.2"},
+		{"ports", blackholeFailer{input: true, output: true, ports: []int{26300}}, "", 2, 2, "26300"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rules := tc.failer.blackholeRules(tc.peerIP)
+			var in, out int
+			for _, rule := range rules {
+				chain, _, _ := strings.Cut(rule, " ")
+				switch chain {
+				case blackholeInputChain:
+					in++
+				case blackholeOutputChain:
+					out++
+				default:
+					t.Fatalf("rule not in blackhole chain: %s", rule)
+				}
+				require.Contains(t, rule, tc.expectContain)
+			}
+			require.Equal(t, tc.expectIn, in)
+			require.Equal(t, tc.expectOut, out)
+		})
+	}
+}
+
+// TestBlackholeFailerProtocols tests that the blackholeFailer drops packets of
+// the requested protocols, and only TCP by default.
+func TestBlackholeFailerProtocols(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		failer blackholeFailer
+		expect map[string]int // protocol -> number of rules
+	}{
+		{"default", blackholeFailer{input: true, output: true}, map[string]int{"tcp": 4}},
+		{"udp", blackholeFailer{input: true, output: true, protocols: []string{"tcp", "udp"}},
+			map[string]int{"tcp": 4, "udp": 4}},
+		{"icmp", blackholeFailer{input: true, output: true, protocols: []string{"tcp", "icmp"}},
+			map[string]int{"tcp": 4, "icmp": 2}},
+		{"icmp-recv", blackholeFailer{input: true, protocols: []string{"icmp"}},
+			map[string]int{"icmp": 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			counts := map[string]int{}
+			for _, rule := range tc.failer.blackholeRules("" /* peerIP */) {
+				fields := strings.Fields(rule)
+				for i := 0; i < len(fields)-1; i++ {
+					if fields[i] == "-p" {
+						counts[fields[i+1]]++
+					}
+				}
+				if strings.Contains(rule, "-p icmp") {
+					require.NotContains(t, rule, "port")
+				}
+			}
+			require.Equal(t, tc.expect, counts)
+		})
+	}
+}
+
+// TestFailoverScenario tests that the sample non-system-crash scenario has the
+// same topology, schedule and workload as the hand-written
+// runFailoverNonSystem with the default failoverConfig.
+func TestFailoverScenario(t *testing.T) {
+	scenarios, err := loadFailoverScenarios()
+	require.NoError(t, err)
+	var scenario failoverScenario
+	for _, s := range scenarios {
+		if s.Name == "non-system-crash" {
+			scenario = s
+		}
+	}
+	require.Equal(t, "non-system-crash", scenario.Name)
+
+	// This mirrors the layout in runFailoverNonSystem.
+	cfg := failoverConfig{}
+	replicas := cfg.replicationFactor()
+	systemNodes := failoverNodeRange(1, replicas)
+	kvNodes := failoverNodeRange(replicas+1, replicas)
+	workloadNode := 2*replicas + 1
+	const cycles = 9
+
+	require.Equal(t, workloadNode, scenario.Nodes)
+	require.Equal(t, failureModeCrash, scenario.FailureMode)
+	require.Equal(t, failoverNodeRange(1, 2*replicas), scenario.cockroachNodes())
+
+	system, workload := scenario.zoneConfigs()
+	require.Equal(t, zoneConfig{replicas: replicas, onlyNodes: systemNodes}, system)
+	require.Equal(t, zoneConfig{replicas: replicas, onlyNodes: kvNodes}, workload)
+
+	require.Equal(t, failoverSchedule{
+		nodes:    roundRobinFailures(kvNodes, cycles),
+		interval: time.Minute,
+	}, scenario.schedule())
+	require.Equal(t, []int{4, 5, 6, 4, 5, 6, 4, 5, 6}, scenario.schedule().nodes)
+
+	require.Equal(t, systemNodes, scenario.Workload.Gateways)
+	require.Equal(t, fmt.Sprintf(`./cockroach workload run kv --read-percent %d --duration %s `+
+		`--concurrency 256 --max-rate 2048 --timeout 1m --tolerate-errors --histograms=stats.json`,
+		cfg.readPercent(50), 20*time.Minute), scenario.workloadCmd("stats.json"))
+}
+
+// TestParseFailoverScenarioErrors tests that invalid failover scenarios are
+// rejected.
+func TestParseFailoverScenarioErrors(t *testing.T) {
+	const valid = `{
+		"name": "test",
+		"nodes": 4,
+		"failure_mode": "crash",
+		"system_zone": {"replicas": 1, "only_nodes": [1]},
+		"workload_zone": {"replicas": 1, "only_nodes": [2]},
+		"workload": {"node": 4, "gateways": [1], "concurrency": 1, "max_rate": 1, "duration": "1m"},
+		"schedule": {"nodes": [2, 3], "cycles": 1, "interval": "1m"}
+	}`
+	_, err := parseFailoverScenario([]byte(valid))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		old, new  string
+		expectErr string
+	}{
+		{`"name": "test"`, `"name": ""`, "missing name"},
+		{`"name": "test"`, `"name": "test", "foo": 1`, `unknown field "foo"`},
+		{`"crash"`, `"meteor"`, `unknown failure mode "meteor"`},
+		{`"node": 4`, `"node": 5`, "workload node n5 not in cluster"},
+		{`"only_nodes": [2]`, `"only_nodes": [2, 4]`, "workload_zone: n4 is not a CockroachDB node"},
+		{`"only_nodes": [2]}`, `"only_nodes": [2], "lease_node": 3}`, "lease node n3 not in only_nodes"},
+		{`"replicas": 1, "only_nodes": [1]`, `"replicas": 0, "only_nodes": [1]`, "need at least 1 replica"},
+		{`"nodes": [2, 3]`, `"nodes": [1, 2]`, "schedule: n1 is a workload gateway"},
+		{`"interval": "1m"`, `"interval": "soon"`, "invalid duration"},
+	} {
+		t.Run(tc.expectErr, func(t *testing.T) {
+			require.Equal(t, 1, strings.Count(valid, tc.old))
+			_, err := parseFailoverScenario([]byte(strings.Replace(valid, tc.old, tc.new, 1)))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expectErr)
+		})
+	}
+}
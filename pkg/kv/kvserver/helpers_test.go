@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"testing"
 	"time"
+	"unsafe"
 
 	circuit "github.com/cockroachdb/circuitbreaker"
 	"github.com/cockroachdb/cockroach/pkg/kv"
@@ -501,6 +502,88 @@ func (t *RaftTransport) GetCircuitBreaker(
 	return t.dialer.GetCircuitBreaker(nodeID, class)
 }
 
+// SetPreserveQueueOnError sets whether outgoing queues are preserved across
+// stream failures. It must be called before sending any messages.
+func (t *RaftTransport) SetPreserveQueueOnError(preserve bool) {
+	t.preserveQueueOnError = preserve
+}
+
+// SetSendDelay injects an artificial delay before each message batch is sent
+// to the given node, simulating a slow link. A zero delay removes it.
+func (t *RaftTransport) SetSendDelay(nodeID roachpb.NodeID, delay time.Duration) {
+	hooks := t.getTestingHooks()
+	if delay <= 0 {
+		hooks.sendDelays.Delete(int64(nodeID))
+		return
+	}
+	hooks.sendDelays.Store(int64(nodeID), unsafe.Pointer(&delay))
+}
+
+// SetTimeSource sets the time source used to time send delays and resolve
+// error cooldowns, see SetSendDelay and SetResolveErrorCooldown. It must be
+// called before sending any messages.
+func (t *RaftTransport) SetTimeSource(timeSource timeutil.TimeSource) {
+	t.timeSource = timeSource
+}
+
+// SetSendQueueBudget overrides kv.raft.transport.send_queue_budget for the
+// transport.
+func (t *RaftTransport) SetSendQueueBudget(ctx context.Context, budget int64) {
+	raftTransportSendQueueBudget.Override(ctx, &t.st.SV, budget)
+}
+
+// QueuedBytes returns the total byte size of the messages buffered across all
+// outgoing queues, as accounted for kv.raft.transport.send_queue_budget.
+func (t *RaftTransport) QueuedBytes() int64 {
+	return t.queuedBytes.Load()
+}
+
+// SetDropInboundNodeIDs overrides kv.raft.transport.testing.drop_inbound_node_ids
+// for the transport.
+func (t *RaftTransport) SetDropInboundNodeIDs(ctx context.Context, nodeIDs string) {
+	raftTransportDropInboundNodeIDs.Override(ctx, &t.st.SV, nodeIDs)
+}
+
+// SetHandlerGracePeriod sets the duration after the transport's creation
+// during which incoming messages wait for the recipient store's handler to be
+// registered. It defaults to zero, i.e. no wait.
+func (t *RaftTransport) SetHandlerGracePeriod(gracePeriod time.Duration) {
+	t.handlerGracePeriod = gracePeriod
+}
+
+// SetIdleTimeout sets the duration after which an outgoing queue with no
+// queued messages shuts down. It must be called before sending any messages.
+func (t *RaftTransport) SetIdleTimeout(idleTimeout time.Duration) {
+	t.idleTimeout = idleTimeout
+}
+
+// SetTraceOrigin sets whether outgoing messages are stamped with their origin.
+// It must not be called concurrently with SendAsync.
+func (t *RaftTransport) SetTraceOrigin(traceOrigin bool) {
+	t.traceOrigin = traceOrigin
+}
+
+// SetResolveErrorCooldown sets the duration after a node's address fails to
+// resolve during which messages to it are dropped. It must be called before
+// sending any messages.
+func (t *RaftTransport) SetResolveErrorCooldown(cooldown time.Duration) {
+	t.resolveErrorCooldown = cooldown
+}
+
+// HasResolveError returns true if a failure to resolve the given node's address
+// is recorded, whether or not its cooldown has elapsed.
+func (t *RaftTransport) HasResolveError(nodeID roachpb.NodeID) bool {
+	_, ok := t.resolveErrors.Load(int64(nodeID))
+	return ok
+}
+
+// HasQueue returns true if an outgoing queue exists for the given node ID and
+// connection class.
+func (t *RaftTransport) HasQueue(nodeID roachpb.NodeID, class rpc.ConnectionClass) bool {
+	_, ok := t.queues[class].Load(int64(nodeID))
+	return ok
+}
+
 func WriteRandomDataToRange(
 	t testing.TB, store *Store, rangeID roachpb.RangeID, keyPrefix roachpb.Key,
 ) (splitKey []byte) {
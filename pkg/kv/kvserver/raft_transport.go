@@ -12,8 +12,13 @@ package kvserver
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -27,7 +32,10 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/cockroach/pkg/util/envutil"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
@@ -57,6 +65,30 @@ const (
 	raftIdleTimeout = time.Minute
 )
 
+// raftHandlerGracePeriod is the duration after the transport is created during
+// which incoming messages for stores without a registered handler wait for the
+// handler to be registered, instead of being rejected. During node startup,
+// messages may arrive before the recipient stores have registered their
+// handlers, and rejecting them causes needless churn. The wait blocks the
+// incoming stream, including messages for other stores, so it is disabled by
+// default.
+var raftHandlerGracePeriod = envutil.EnvOrDefaultDuration(
+	"COCKROACH_RAFT_HANDLER_GRACE_PERIOD", 0)
+
+// raftResolveErrorCooldown is the duration after a node's address fails to
+// resolve (e.g. because it's not yet in gossip) during which messages to the
+// node are dropped immediately, instead of creating a new queue and resolving
+// the address again. It should be below the Raft election timeout, such that
+// a newly started node hears from the leader before campaigning.
+var raftResolveErrorCooldown = envutil.EnvOrDefaultDuration(
+	"COCKROACH_RAFT_RESOLVE_ERROR_COOLDOWN", time.Second)
+
+// raftTraceOrigin, if set, stamps each outgoing Raft message with its origin
+// (see kvserverpb.RaftMessageOrigin), which is logged by the recipient. This
+// allows tracing a message's journey across nodes, but adds overhead to every
+// message, so it is only intended for debugging.
+var raftTraceOrigin = envutil.EnvOrDefaultBool("COCKROACH_RAFT_TRANSPORT_TRACE_ORIGIN", false)
+
 // targetRaftOutgoingBatchSize wraps "kv.raft.command.target_batch_size".
 var targetRaftOutgoingBatchSize = settings.RegisterByteSizeSetting(
 	settings.SystemOnly,
@@ -66,6 +98,100 @@ var targetRaftOutgoingBatchSize = settings.RegisterByteSizeSetting(
 	settings.PositiveInt,
 )
 
+// raftTransportSendQueueBudget wraps "kv.raft.transport.send_queue_budget".
+var raftTransportSendQueueBudget = settings.RegisterByteSizeSetting(
+	settings.SystemOnly,
+	"kv.raft.transport.send_queue_budget",
+	"maximum total byte size of outgoing Raft messages buffered across all send queues, "+
+		"beyond which new messages are dropped; 0 disables the limit",
+	0,
+	settings.NonNegativeInt,
+)
+
+// raftTransportSnapshotTimeout wraps "kv.raft.transport.snapshot_timeout".
+var raftTransportSnapshotTimeout = settings.RegisterDurationSetting(
+	settings.SystemOnly,
+	"kv.raft.transport.snapshot_timeout",
+	"maximum duration that a single send or receive on an outgoing snapshot stream may "+
+		"block once the recipient has accepted the snapshot, e.g. because the recipient hung "+
+		"mid-snapshot, after which the snapshot is abandoned; it does not bound the recipient's "+
+		"application of the snapshot; 0 disables the timeout",
+	0,
+	settings.NonNegativeDuration,
+)
+
+// raftTransportDropInboundNodeIDs wraps
+// "kv.raft.transport.testing.drop_inbound_node_ids".
+var raftTransportDropInboundNodeIDs = settings.RegisterValidatedStringSetting(
+	settings.SystemOnly,
+	"kv.raft.transport.testing.drop_inbound_node_ids",
+	"comma-separated IDs of nodes which silently drop all incoming Raft messages and reject "+
+		"incoming Raft snapshots, "+
+		"simulating a Raft-only network partition while SQL and liveness continue; "+
+		"for testing only",
+	"",
+	func(_ *settings.Values, s string) error {
+		_, err := parseNodeIDSet(s)
+		return err
+	},
+)
+
+// parseNodeIDSet parses a comma-separated list of node IDs into a set.
+func parseNodeIDSet(s string) (map[roachpb.NodeID]struct{}, error) {
+	set := map[roachpb.NodeID]struct{}{}
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 32)
+		if err != nil || id <= 0 {
+			return nil, errors.Newf("invalid node ID %q", field)
+		}
+		set[roachpb.NodeID(id)] = struct{}{}
+	}
+	return set, nil
+}
+
+// RaftQueueCloseReason is the reason an outgoing RaftTransport queue was
+// closed, see RaftTransport.OnQueueClose.
+type RaftQueueCloseReason int
+
+const (
+	// RaftQueueIdle indicates that no message was queued within the idle
+	// timeout.
+	RaftQueueIdle RaftQueueCloseReason = iota
+	// RaftQueueError indicates that the node couldn't be connected to, or that
+	// the stream failed.
+	RaftQueueError
+	// RaftQueueStopped indicates that the queue was explicitly stopped (e.g. by
+	// StopStore or ResetConnection), or that the transport is shutting down.
+	RaftQueueStopped
+)
+
+// String implements fmt.Stringer.
+func (r RaftQueueCloseReason) String() string {
+	switch r {
+	case RaftQueueIdle:
+		return "idle"
+	case RaftQueueError:
+		return "error"
+	case RaftQueueStopped:
+		return "stopped"
+	default:
+		return fmt.Sprintf("RaftQueueCloseReason(%d)", int(r))
+	}
+}
+
+// SafeValue implements redact.SafeValue.
+func (RaftQueueCloseReason) SafeValue() {}
+
+// RaftDeliveryConfirmation confirms the delivery of an incoming message to a
+// store's handler, see RaftTransport.DeliveryConfirmations.
+type RaftDeliveryConfirmation struct {
+	To   roachpb.ReplicaDescriptor
+	Type raftpb.MessageType
+}
+
 // RaftMessageResponseStream is the subset of the
 // MultiRaft_RaftMessageServer interface that is needed for sending responses.
 type RaftMessageResponseStream interface {
@@ -149,9 +275,121 @@ type RaftTransport struct {
 	stopper *stop.Stopper
 	metrics *RaftTransportMetrics
 
-	queues   [rpc.NumConnectionClasses]syncutil.IntMap // map[roachpb.NodeID]*raftSendQueue
-	dialer   *nodedialer.Dialer
-	handlers syncutil.IntMap // map[roachpb.StoreID]*RaftMessageHandler
+	queues [rpc.NumConnectionClasses]syncutil.IntMap // map[roachpb.NodeID]*raftSendQueue
+	dialer *nodedialer.Dialer
+
+	// handlers contains the registered handlers of the node's stores. It is
+	// replaced copy-on-write under handlersMu, such that a set of handlers
+	// registered via ListenAll, or unregistered via StopAll, becomes visible
+	// to incoming messages atomically.
+	handlersMu syncutil.Mutex
+	handlers   atomic.Pointer[map[roachpb.StoreID]RaftMessageHandler]
+	// handlersChangedC is closed and replaced under handlersMu whenever the
+	// registered handlers change, see waitForHandler.
+	handlersChangedC chan struct{}
+
+	// OnQueueOpen and OnQueueClose, if set, are called when an outgoing queue
+	// to a node is created and destroyed respectively, e.g. to track the number
+	// of active streams. The replica descriptor is the recipient of the message
+	// which created the queue, although the queue carries messages to all of the
+	// node's stores. Each OnQueueOpen call is followed by exactly one
+	// OnQueueClose call for the same queue. The callbacks must be set before
+	// the transport sends any messages, and must not block.
+	OnQueueOpen  func(roachpb.ReplicaDescriptor, rpc.ConnectionClass)
+	OnQueueClose func(roachpb.ReplicaDescriptor, rpc.ConnectionClass, RaftQueueCloseReason)
+
+	// DeliveryConfirmations, if set, receives a confirmation each time an
+	// incoming message has been successfully handled by the recipient store's
+	// handler, such that tests can wait for delivery rather than sleeping.
+	// Messages that are dropped or rejected by the handler are not confirmed.
+	// The send blocks the incoming stream until the confirmation is received,
+	// so the channel must be drained. It must be set before the transport
+	// receives any messages. Only used in tests.
+	DeliveryConfirmations chan<- RaftDeliveryConfirmation
+
+	// idleTimeout is the duration after which a queue with no queued messages
+	// shuts down. See raftIdleTimeout.
+	idleTimeout time.Duration
+
+	// traceOrigin, if set, stamps outgoing messages with their origin. See
+	// raftTraceOrigin.
+	traceOrigin bool
+
+	// preserveQueueOnError, if set, keeps a queue and its buffered messages
+	// when its stream fails, and reconnects to the node instead of deleting the
+	// queue. Messages that are enqueued during a brief disconnect are then
+	// delivered after the reconnect, rather than being lost. The queue is
+	// deleted if the node can't be reconnected to within raftIdleTimeout. This
+	// is intended for tests that are sensitive to message loss.
+	preserveQueueOnError bool
+
+	// startTime is the time when the transport was created, and
+	// handlerGracePeriod is the duration after startTime during which incoming
+	// messages wait for a handler to be registered for the recipient store. See
+	// raftHandlerGracePeriod.
+	startTime          time.Time
+	handlerGracePeriod time.Duration
+
+	// resolveErrors contains the time of the last failure to resolve each
+	// node's address (map[roachpb.NodeID]*time.Time). Messages to the node are
+	// dropped until resolveErrorCooldown has elapsed since then, after which
+	// the entry is pruned, see recordResolveError. See raftResolveErrorCooldown.
+	resolveErrors        syncutil.IntMap
+	resolveErrorCooldown time.Duration
+
+	// streamCounts contains the number of outgoing streams that have been
+	// established to each node (map[roachpb.NodeID]*atomic.Int64).
+	streamCounts syncutil.IntMap
+
+	// rcvdCounts contains the number of incoming messages received for each
+	// store (map[roachpb.StoreID]*atomic.Int64).
+	rcvdCounts syncutil.IntMap
+
+	// queuedBytes is the approximate total byte size of the messages buffered
+	// across all outgoing queues. It is used to enforce
+	// raftTransportSendQueueBudget, see addQueueBytes.
+	queuedBytes atomic.Int64
+
+	// testingHooks contains test-only hooks applied to outgoing message
+	// batches, or nil if none have been installed, such that they cost a
+	// single atomic load per batch in production. See getTestingHooks.
+	testingHooks atomic.Pointer[raftTransportTestingHooks]
+
+	// timeSource is used to time send delays and resolve error cooldowns, and
+	// can be replaced by tests.
+	timeSource timeutil.TimeSource
+
+	// dropInbound contains the nodes whose incoming Raft messages are dropped,
+	// as configured by raftTransportDropInboundNodeIDs.
+	dropInbound atomic.Pointer[map[roachpb.NodeID]struct{}]
+}
+
+// raftTransportTestingHooks contains test-only hooks applied to outgoing
+// message batches. They are installed lazily by the testing methods which need
+// them, see RaftTransport.getTestingHooks.
+type raftTransportTestingHooks struct {
+	// sendDelays contains artificial delays to inject before sending each
+	// message batch to a node (map[roachpb.NodeID]*time.Duration), simulating
+	// a slow link. See SetSendDelay.
+	sendDelays syncutil.IntMap
+
+	// pausedSends contains the nodes that sends are paused to
+	// (map[roachpb.NodeID]*raftSendPause). See PauseSends.
+	pausedSends syncutil.IntMap
+}
+
+// getSendDelay returns the artificial send delay for the given node, if any.
+func (h *raftTransportTestingHooks) getSendDelay(nodeID roachpb.NodeID) time.Duration {
+	if value, ok := h.sendDelays.Load(int64(nodeID)); ok {
+		return *(*time.Duration)(value)
+	}
+	return 0
+}
+
+// raftSendPause pauses sends to a node until it is resumed.
+type raftSendPause struct {
+	resumeC    chan struct{}
+	resumeOnce sync.Once
 }
 
 // raftSendQueue is a queue of outgoing RaftMessageRequest messages.
@@ -160,6 +398,51 @@ type raftSendQueue struct {
 	// The number of bytes in flight. Must be updated *atomically* on sending and
 	// receiving from the reqs channel.
 	bytes atomic.Int64
+	// stores contains the IDs of the stores that messages have been sent to via
+	// this queue (map[roachpb.StoreID]struct{}). Used by StopStore.
+	stores syncutil.IntMap
+	// stopC is closed to shut down the queue, see stop().
+	stopC    chan struct{}
+	stopOnce sync.Once
+	// deleted is set once the queue has been removed from the transport's
+	// queues, after which nothing but SendAsync drains it.
+	deleted atomic.Bool
+}
+
+// newRaftSendQueue creates a new raftSendQueue.
+func newRaftSendQueue() *raftSendQueue {
+	return &raftSendQueue{
+		reqs:  make(chan *kvserverpb.RaftMessageRequest, raftSendBufferSize),
+		stopC: make(chan struct{}),
+	}
+}
+
+// stop shuts down the queue's processQueue goroutine, if any. Remaining
+// messages in the queue are dropped.
+func (q *raftSendQueue) stop() {
+	q.stopOnce.Do(func() { close(q.stopC) })
+}
+
+// addStore records that a message was sent to the given store via the queue.
+func (q *raftSendQueue) addStore(storeID roachpb.StoreID) {
+	if _, ok := q.stores.Load(int64(storeID)); !ok {
+		q.stores.Store(int64(storeID), unsafe.Pointer(&struct{}{}))
+	}
+}
+
+// onlyStore returns true if messages have only been sent to the given store
+// via the queue.
+func (q *raftSendQueue) onlyStore(storeID roachpb.StoreID) bool {
+	var found, other bool
+	q.stores.Range(func(k int64, _ unsafe.Pointer) bool {
+		if k != int64(storeID) {
+			other = true
+			return false
+		}
+		found = true
+		return true
+	})
+	return found && !other
 }
 
 // NewDummyRaftTransport returns a dummy raft transport for use in tests which
@@ -187,8 +470,26 @@ func NewRaftTransport(
 		tracer:         tracer,
 		stopper:        stopper,
 		dialer:         dialer,
+
+		handlersChangedC:     make(chan struct{}),
+		idleTimeout:          raftIdleTimeout,
+		traceOrigin:          raftTraceOrigin,
+		startTime:            timeutil.Now(),
+		handlerGracePeriod:   raftHandlerGracePeriod,
+		resolveErrorCooldown: raftResolveErrorCooldown,
+		timeSource:           timeutil.DefaultTimeSource{},
 	}
 	t.initMetrics()
+	updateDropInbound := func(ctx context.Context) {
+		set, err := parseNodeIDSet(raftTransportDropInboundNodeIDs.Get(&st.SV))
+		if err != nil {
+			log.Warningf(ctx, "ignoring invalid %s: %v", raftTransportDropInboundNodeIDs.Key(), err)
+			return
+		}
+		t.dropInbound.Store(&set)
+	}
+	raftTransportDropInboundNodeIDs.SetOnChange(&st.SV, updateDropInbound)
+	updateDropInbound(context.Background())
 	if grpcServer != nil {
 		RegisterMultiRaftServer(grpcServer, t)
 	}
@@ -225,24 +526,131 @@ func (t *RaftTransport) queueByteSize() int64 {
 }
 
 func (t *RaftTransport) getHandler(storeID roachpb.StoreID) (RaftMessageHandler, bool) {
-	if value, ok := t.handlers.Load(int64(storeID)); ok {
-		return *(*RaftMessageHandler)(value), true
+	handlers := t.handlers.Load()
+	if handlers == nil {
+		return nil, false
+	}
+	handler, ok := (*handlers)[storeID]
+	return handler, ok
+}
+
+// updateHandlers replaces the registered handlers with a copy modified by fn.
+func (t *RaftTransport) updateHandlers(fn func(map[roachpb.StoreID]RaftMessageHandler)) {
+	t.handlersMu.Lock()
+	defer t.handlersMu.Unlock()
+	handlers := map[roachpb.StoreID]RaftMessageHandler{}
+	if old := t.handlers.Load(); old != nil {
+		for storeID, handler := range *old {
+			handlers[storeID] = handler
+		}
+	}
+	fn(handlers)
+	t.handlers.Store(&handlers)
+	close(t.handlersChangedC)
+	t.handlersChangedC = make(chan struct{})
+}
+
+// handlersChanged returns a channel which is closed when the registered
+// handlers next change.
+func (t *RaftTransport) handlersChanged() <-chan struct{} {
+	t.handlersMu.Lock()
+	defer t.handlersMu.Unlock()
+	return t.handlersChangedC
+}
+
+// waitForHandler waits for a handler to be registered for the given store, as
+// long as the transport is within its startup grace period (see
+// raftHandlerGracePeriod). It returns false if no handler was registered
+// before the grace period ended.
+func (t *RaftTransport) waitForHandler(
+	ctx context.Context, storeID roachpb.StoreID,
+) (RaftMessageHandler, bool) {
+	deadline := t.startTime.Add(t.handlerGracePeriod)
+	var timer timeutil.Timer
+	defer timer.Stop()
+	for {
+		// Fetch the notification channel before checking for the handler, so a
+		// concurrent registration can't be missed.
+		changedC := t.handlersChanged()
+		if handler, ok := t.getHandler(storeID); ok {
+			return handler, true
+		}
+		remaining := deadline.Sub(timeutil.Now())
+		if remaining <= 0 {
+			return nil, false
+		}
+		timer.Reset(remaining)
+		select {
+		case <-changedC:
+		case <-timer.C:
+			timer.Read = true
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		case <-t.stopper.ShouldQuiesce():
+			return nil, false
+		}
 	}
-	return nil, false
 }
 
 // handleRaftRequest proxies a request to the listening server interface.
 func (t *RaftTransport) handleRaftRequest(
 	ctx context.Context, req *kvserverpb.RaftMessageRequest, respStream RaftMessageResponseStream,
 ) *kvpb.Error {
+	if t.droppingInbound(req.ToReplica.NodeID) {
+		// Raft tolerates message loss, so the message is dropped silently, as
+		// if it had been lost by the network.
+		return nil
+	}
 	handler, ok := t.getHandler(req.ToReplica.StoreID)
+	if !ok && t.handlerGracePeriod > 0 {
+		handler, ok = t.waitForHandler(ctx, req.ToReplica.StoreID)
+	}
 	if !ok {
 		log.Warningf(ctx, "unable to accept Raft message from %+v: no handler registered for %+v",
 			req.FromReplica, req.ToReplica)
 		return kvpb.NewError(kvpb.NewStoreNotFoundError(req.ToReplica.StoreID))
 	}
+	if origin := req.Origin; origin != nil {
+		log.Infof(ctx, "received r%d %s message from n%d, sent %s ago",
+			req.RangeID, req.Message.Type, origin.NodeID,
+			timeutil.Since(timeutil.Unix(0, origin.SentAtNanos)))
+	}
+
+	if pErr := handler.HandleRaftRequest(ctx, req, respStream); pErr != nil {
+		return pErr
+	}
+	t.confirmDelivery(ctx, req)
+	return nil
+}
 
-	return handler.HandleRaftRequest(ctx, req, respStream)
+// confirmDelivery sends a confirmation for a delivered message to
+// DeliveryConfirmations, if set. It blocks until the confirmation is received,
+// the context is canceled, or the stopper quiesces.
+func (t *RaftTransport) confirmDelivery(
+	ctx context.Context, req *kvserverpb.RaftMessageRequest,
+) {
+	if t.DeliveryConfirmations == nil {
+		return
+	}
+	select {
+	case t.DeliveryConfirmations <- RaftDeliveryConfirmation{
+		To: req.ToReplica, Type: req.Message.Type,
+	}:
+	case <-ctx.Done():
+	case <-t.stopper.ShouldQuiesce():
+	}
+}
+
+// droppingInbound returns whether incoming Raft messages and snapshots to the
+// given node are dropped, see raftTransportDropInboundNodeIDs.
+func (t *RaftTransport) droppingInbound(nodeID roachpb.NodeID) bool {
+	set := t.dropInbound.Load()
+	if set == nil {
+		return false
+	}
+	_, ok := (*set)[nodeID]
+	return ok
 }
 
 // newRaftMessageResponse constructs a RaftMessageResponse from the
@@ -289,7 +697,10 @@ func (t *RaftTransport) RaftMessageBatch(stream MultiRaft_RaftMessageBatchServer
 					for i := range batch.Requests {
 						req := &batch.Requests[i]
 						t.metrics.MessagesRcvd.Inc(1)
+						recordMessageType(&t.metrics.MessagesRcvdByType, req.Message.Type)
+						t.recordMessageReceived(req.ToReplica.StoreID)
 						if pErr := t.handleRaftRequest(ctx, req, stream); pErr != nil {
+							t.metrics.HandlerErrors.Inc(1)
 							if err := stream.Send(newRaftMessageResponse(req, pErr)); err != nil {
 								return err
 							}
@@ -373,6 +784,11 @@ func (t *RaftTransport) RaftSnapshot(stream MultiRaft_RaftSnapshotServer) error
 		return stream.Send(snapRespErr(err))
 	}
 	rmr := req.Header.RaftMessageRequest
+	if t.droppingInbound(rmr.ToReplica.NodeID) {
+		// Reject the snapshot as if the network had failed, such that a lagging
+		// replica can't catch up via snapshots either.
+		return errors.Newf("n%d is dropping incoming Raft traffic", rmr.ToReplica.NodeID)
+	}
 	handler, ok := t.getHandler(rmr.ToReplica.StoreID)
 	if !ok {
 		log.Warningf(ctx, "unable to accept Raft message from %+v: no handler registered for %+v",
@@ -384,12 +800,105 @@ func (t *RaftTransport) RaftSnapshot(stream MultiRaft_RaftSnapshotServer) error
 
 // Listen registers a raftMessageHandler to receive proxied messages.
 func (t *RaftTransport) Listen(storeID roachpb.StoreID, handler RaftMessageHandler) {
-	t.handlers.Store(int64(storeID), unsafe.Pointer(&handler))
+	t.ListenAll(map[roachpb.StoreID]RaftMessageHandler{storeID: handler})
+}
+
+// ListenAll registers raftMessageHandlers for several stores at once. Incoming
+// messages either see all of the handlers or none of them, so there is no
+// window during which only some of the stores are registered.
+func (t *RaftTransport) ListenAll(handlers map[roachpb.StoreID]RaftMessageHandler) {
+	t.updateHandlers(func(m map[roachpb.StoreID]RaftMessageHandler) {
+		for storeID, handler := range handlers {
+			m[storeID] = handler
+		}
+	})
 }
 
 // Stop unregisters a raftMessageHandler.
 func (t *RaftTransport) Stop(storeID roachpb.StoreID) {
-	t.handlers.Delete(int64(storeID))
+	t.StopAll([]roachpb.StoreID{storeID})
+}
+
+// StopAll unregisters the raftMessageHandlers of several stores at once, see
+// ListenAll.
+func (t *RaftTransport) StopAll(storeIDs []roachpb.StoreID) {
+	t.updateHandlers(func(m map[roachpb.StoreID]RaftMessageHandler) {
+		for _, storeID := range storeIDs {
+			delete(m, storeID)
+		}
+	})
+}
+
+// Stores returns the IDs of the stores with a registered raftMessageHandler,
+// in ascending order.
+func (t *RaftTransport) Stores() []roachpb.StoreID {
+	var storeIDs []roachpb.StoreID
+	if handlers := t.handlers.Load(); handlers != nil {
+		for storeID := range *handlers {
+			storeIDs = append(storeIDs, storeID)
+		}
+	}
+	sort.Slice(storeIDs, func(i, j int) bool { return storeIDs[i] < storeIDs[j] })
+	return storeIDs
+}
+
+// StopStore unregisters a raftMessageHandler like Stop, and additionally shuts
+// down outgoing queues that have only been used to send messages to the given
+// store, dropping any remaining messages. This is used when removing a store,
+// to terminate queues to it immediately instead of waiting for them to idle
+// out.
+func (t *RaftTransport) StopStore(storeID roachpb.StoreID) {
+	t.Stop(storeID)
+	t.visitQueues(func(q *raftSendQueue) {
+		if q.onlyStore(storeID) {
+			q.stop()
+		}
+	})
+}
+
+// ResetConnection tears down the outgoing streams to the given node, across
+// all connection classes, dropping any queued messages as if the connection
+// had been reset. A new stream is established on the next send to the node.
+// This is intended for testing reconnection behavior.
+func (t *RaftTransport) ResetConnection(nodeID roachpb.NodeID) {
+	for class := range t.queues {
+		if value, ok := t.queues[class].Load(int64(nodeID)); ok {
+			(*raftSendQueue)(value).stop()
+		}
+	}
+}
+
+// PauseSends pauses sends to the given node, across all connection classes,
+// until ResumeSends is called. Outgoing messages are buffered in the queue
+// while paused, and are subject to the usual limits, but the stream is kept
+// open. This is intended for testing tolerance of transient send stalls.
+func (t *RaftTransport) PauseSends(nodeID roachpb.NodeID) {
+	t.getTestingHooks().pausedSends.LoadOrStore(int64(nodeID), unsafe.Pointer(&raftSendPause{
+		resumeC: make(chan struct{}),
+	}))
+}
+
+// ResumeSends resumes sends to the given node, paused by PauseSends. Buffered
+// messages are then sent in order. It is a noop if sends aren't paused.
+func (t *RaftTransport) ResumeSends(nodeID roachpb.NodeID) {
+	hooks := t.testingHooks.Load()
+	if hooks == nil {
+		return
+	}
+	if value, ok := hooks.pausedSends.Load(int64(nodeID)); ok {
+		hooks.pausedSends.Delete(int64(nodeID))
+		p := (*raftSendPause)(value)
+		p.resumeOnce.Do(func() { close(p.resumeC) })
+	}
+}
+
+// sendsPaused returns a channel which is closed when sends to the given node
+// are resumed, or nil if sends to the node aren't paused.
+func (h *raftTransportTestingHooks) sendsPaused(nodeID roachpb.NodeID) <-chan struct{} {
+	if value, ok := h.pausedSends.Load(int64(nodeID)); ok {
+		return (*raftSendPause)(value).resumeC
+	}
+	return nil
 }
 
 // processQueue opens a Raft client stream and sends messages from the
@@ -398,7 +907,7 @@ func (t *RaftTransport) Stop(storeID roachpb.StoreID) {
 // lost and a new instance of processQueue will be started by the next message
 // to be sent.
 func (t *RaftTransport) processQueue(
-	q *raftSendQueue, stream MultiRaft_RaftMessageBatchClient,
+	q *raftSendQueue, toNodeID roachpb.NodeID, stream MultiRaft_RaftMessageBatchClient,
 ) error {
 	errCh := make(chan error, 1)
 
@@ -429,14 +938,43 @@ func (t *RaftTransport) processQueue(
 		return err
 	}
 
+	// awaitResume blocks while sends to the node are paused, see PauseSends. It
+	// returns false if the queue should shut down instead, along with the
+	// stream error if any.
+	awaitResume := func(hooks *raftTransportTestingHooks) (bool, error) {
+		for {
+			resumeC := hooks.sendsPaused(toNodeID)
+			if resumeC == nil {
+				return true, nil
+			}
+			select {
+			case <-resumeC:
+			case <-t.stopper.ShouldQuiesce():
+				return false, nil
+			case <-q.stopC:
+				return false, nil
+			case err := <-errCh:
+				return false, err
+			}
+		}
+	}
+
 	var raftIdleTimer timeutil.Timer
 	defer raftIdleTimer.Stop()
 	batch := &kvserverpb.RaftMessageRequestBatch{}
 	for {
-		raftIdleTimer.Reset(raftIdleTimeout)
+		// Leave messages in the queue while paused, and don't idle out.
+		if hooks := t.testingHooks.Load(); hooks != nil {
+			if ok, err := awaitResume(hooks); !ok {
+				return err
+			}
+		}
+		raftIdleTimer.Reset(t.idleTimeout)
 		select {
 		case <-t.stopper.ShouldQuiesce():
 			return nil
+		case <-q.stopC:
+			return nil
 		case <-raftIdleTimer.C:
 			raftIdleTimer.Read = true
 			return nil
@@ -444,7 +982,7 @@ func (t *RaftTransport) processQueue(
 			return err
 		case req := <-q.reqs:
 			size := int64(req.Size())
-			q.bytes.Add(-size)
+			t.addQueueBytes(q, -size)
 			budget := targetRaftOutgoingBatchSize.Get(&t.st.SV) - size
 			batch.Requests = append(batch.Requests, *req)
 			releaseRaftMessageRequest(req)
@@ -453,7 +991,7 @@ func (t *RaftTransport) processQueue(
 				select {
 				case req = <-q.reqs:
 					size := int64(req.Size())
-					q.bytes.Add(-size)
+					t.addQueueBytes(q, -size)
 					budget -= size
 					batch.Requests = append(batch.Requests, *req)
 					releaseRaftMessageRequest(req)
@@ -462,11 +1000,32 @@ func (t *RaftTransport) processQueue(
 				}
 			}
 
+			if hooks := t.testingHooks.Load(); hooks != nil {
+				if delay := hooks.getSendDelay(toNodeID); delay > 0 {
+					timer := t.timeSource.NewTimer()
+					timer.Reset(delay)
+					select {
+					case <-timer.Ch():
+						timer.MarkRead()
+					case <-t.stopper.ShouldQuiesce():
+						timer.Stop()
+						return nil
+					}
+				}
+				// Sends may have been paused while we were waiting for messages.
+				if ok, err := awaitResume(hooks); !ok {
+					return err
+				}
+			}
+
 			err := stream.Send(batch)
 			if err != nil {
 				return err
 			}
 			t.metrics.MessagesSent.Inc(int64(len(batch.Requests)))
+			for i := range batch.Requests {
+				recordMessageType(&t.metrics.MessagesSentByType, batch.Requests[i].Message.Type)
+			}
 
 			// Reuse the Requests slice, but zero out the contents to avoid delaying
 			// GC of memory referenced from within.
@@ -478,6 +1037,121 @@ func (t *RaftTransport) processQueue(
 	}
 }
 
+// recordStreamEstablished records that an outgoing stream was established to
+// the given node.
+func (t *RaftTransport) recordStreamEstablished(nodeID roachpb.NodeID) {
+	t.metrics.StreamsEstablished.Inc(1)
+	value, ok := t.streamCounts.Load(int64(nodeID))
+	if !ok {
+		value, _ = t.streamCounts.LoadOrStore(int64(nodeID), unsafe.Pointer(new(atomic.Int64)))
+	}
+	(*atomic.Int64)(value).Add(1)
+}
+
+// StreamsEstablished returns the number of outgoing streams that have been
+// established to the given node, across all connection classes. A high number
+// relative to the transport's lifetime indicates a flapping link, since streams
+// are only re-established after a failure or idle timeout.
+func (t *RaftTransport) StreamsEstablished(nodeID roachpb.NodeID) int64 {
+	if value, ok := t.streamCounts.Load(int64(nodeID)); ok {
+		return (*atomic.Int64)(value).Load()
+	}
+	return 0
+}
+
+// recordMessageReceived records that an incoming message was received for the
+// given store.
+func (t *RaftTransport) recordMessageReceived(storeID roachpb.StoreID) {
+	value, ok := t.rcvdCounts.Load(int64(storeID))
+	if !ok {
+		value, _ = t.rcvdCounts.LoadOrStore(int64(storeID), unsafe.Pointer(new(atomic.Int64)))
+	}
+	(*atomic.Int64)(value).Add(1)
+}
+
+// MessagesReceived returns the number of incoming messages that have been
+// received for the given store, including messages that the store's handler
+// rejected or that had no handler.
+func (t *RaftTransport) MessagesReceived(storeID roachpb.StoreID) int64 {
+	if value, ok := t.rcvdCounts.Load(int64(storeID)); ok {
+		return (*atomic.Int64)(value).Load()
+	}
+	return 0
+}
+
+// resolveErrorCoolingDown returns true if the given node's address recently
+// failed to resolve, and messages to it should be dropped.
+func (t *RaftTransport) resolveErrorCoolingDown(nodeID roachpb.NodeID) bool {
+	value, ok := t.resolveErrors.Load(int64(nodeID))
+	if !ok {
+		return false
+	}
+	if t.timeSource.Since(*(*time.Time)(value)) < t.resolveErrorCooldown {
+		return true
+	}
+	t.resolveErrors.Delete(int64(nodeID))
+	return false
+}
+
+// recordResolveError records that the given node's address failed to resolve,
+// starting its cooldown. It also prunes the entries of other nodes whose
+// cooldown has elapsed, such that nodes which are never sent to again, e.g.
+// because they were decommissioned, don't leave entries behind. Resolve errors
+// are rare, and rate limited by the cooldown, so this is cheap.
+func (t *RaftTransport) recordResolveError(nodeID roachpb.NodeID) {
+	now := t.timeSource.Now()
+	t.resolveErrors.Range(func(k int64, v unsafe.Pointer) bool {
+		if now.Sub(*(*time.Time)(v)) >= t.resolveErrorCooldown {
+			t.resolveErrors.Delete(k)
+		}
+		return true
+	})
+	t.resolveErrors.Store(int64(nodeID), unsafe.Pointer(&now))
+}
+
+// getTestingHooks returns the transport's testing hooks, installing them if
+// they haven't been already.
+func (t *RaftTransport) getTestingHooks() *raftTransportTestingHooks {
+	if hooks := t.testingHooks.Load(); hooks != nil {
+		return hooks
+	}
+	t.testingHooks.CompareAndSwap(nil, &raftTransportTestingHooks{})
+	return t.testingHooks.Load()
+}
+
+// addQueueBytes adjusts the byte size of the messages buffered in the given
+// queue, and across all queues.
+func (t *RaftTransport) addQueueBytes(q *raftSendQueue, delta int64) {
+	q.bytes.Add(delta)
+	t.queuedBytes.Add(delta)
+}
+
+// drainQueue drops the messages remaining in a queue which has been deleted,
+// releasing their bytes. There's nobody who can safely close the channel, so
+// senders which raced with the deletion may still add messages to it
+// afterwards, and must drain it again.
+func (t *RaftTransport) drainQueue(q *raftSendQueue) {
+	for {
+		select {
+		case req := <-q.reqs:
+			t.addQueueBytes(q, -int64(req.Size()))
+			t.metrics.MessagesDropped.Inc(1)
+			releaseRaftMessageRequest(req)
+		default:
+			return
+		}
+	}
+}
+
+// deleteQueue removes the given queue from the transport's queues, and marks it
+// deleted, see raftSendQueue.deleted.
+func (t *RaftTransport) deleteQueue(
+	q *raftSendQueue, nodeID roachpb.NodeID, class rpc.ConnectionClass,
+) {
+	t.queues[class].Delete(int64(nodeID))
+	q.deleted.Store(true)
+}
+
 // getQueue returns the queue for the specified node ID and a boolean
 // indicating whether the queue already exists (true) or was created (false).
 func (t *RaftTransport) getQueue(
@@ -486,8 +1160,8 @@ func (t *RaftTransport) getQueue(
 	queuesMap := &t.queues[class]
 	value, ok := queuesMap.Load(int64(nodeID))
 	if !ok {
-		q := raftSendQueue{reqs: make(chan *kvserverpb.RaftMessageRequest, raftSendBufferSize)}
-		value, ok = queuesMap.LoadOrStore(int64(nodeID), unsafe.Pointer(&q))
+		q := newRaftSendQueue()
+		value, ok = queuesMap.LoadOrStore(int64(nodeID), unsafe.Pointer(q))
 	}
 	return (*raftSendQueue)(value), ok
 }
@@ -518,6 +1192,35 @@ func (t *RaftTransport) SendAsync(
 	}
 
 	if !t.dialer.GetCircuitBreaker(toNodeID, class).Ready() {
+		t.metrics.MessagesUnreachable.Inc(1)
+		return false
+	}
+	if t.resolveErrorCoolingDown(toNodeID) {
+		t.metrics.MessagesUnreachable.Inc(1)
+		return false
+	}
+
+	if t.traceOrigin {
+		req.Origin = &kvserverpb.RaftMessageOrigin{
+			NodeID:      req.FromReplica.NodeID,
+			SentAtNanos: timeutil.Now().UnixNano(),
+		}
+	}
+
+	// Note: computing the size of the request *before* sending it to the queue,
+	// because the receiver takes ownership of, and can modify it.
+	size := int64(req.Size())
+
+	// Refuse the message if the transport is already buffering more than its
+	// budget across all queues, to avoid running out of memory when many peers
+	// are slow to receive.
+	if budget := raftTransportSendQueueBudget.Get(&t.st.SV); budget > 0 &&
+		t.queuedBytes.Load()+size > budget {
+		t.metrics.MessagesOverBudget.Inc(1)
+		if logRaftSendQueueFullEvery.ShouldLog() {
+			log.Warningf(t.AnnotateCtx(context.Background()),
+				"raft send queues exceed budget of %s", humanizeutil.IBytes(budget))
+		}
 		return false
 	}
 
@@ -525,19 +1228,27 @@ func (t *RaftTransport) SendAsync(
 	if !existingQueue {
 		// Note that startProcessNewQueue is in charge of deleting the queue.
 		ctx := t.AnnotateCtx(context.Background())
-		if !t.startProcessNewQueue(ctx, toNodeID, class) {
+		if !t.startProcessNewQueue(ctx, req.ToReplica, class) {
 			return false
 		}
 	}
 
-	// Note: computing the size of the request *before* sending it to the queue,
-	// because the receiver takes ownership of, and can modify it.
-	size := int64(req.Size())
+	q.addStore(req.ToReplica.StoreID)
+
+	// Reserve the message's bytes before enqueueing it, since processQueue may
+	// release them as soon as it's enqueued.
+	t.addQueueBytes(q, size)
 	select {
 	case q.reqs <- req:
-		q.bytes.Add(size)
+		// The queue may have been shut down and deleted concurrently, and its
+		// cleanup may have drained it before the message landed, in which case
+		// nobody else will release its bytes. Drain the dead queue ourselves.
+		if q.deleted.Load() {
+			t.drainQueue(q)
+		}
 		return true
 	default:
+		t.addQueueBytes(q, -size)
 		if logRaftSendQueueFullEvery.ShouldLog() {
 			log.Warningf(t.AnnotateCtx(context.Background()), "raft send queue to n%d is full", toNodeID)
 		}
@@ -554,70 +1265,141 @@ func (t *RaftTransport) SendAsync(
 // different class than that of user data ranges.
 //
 // Returns whether the worker was started (the queue is deleted either way).
+// The OnQueueOpen callback is invoked before starting the worker, and
+// OnQueueClose after deleting the queue.
 func (t *RaftTransport) startProcessNewQueue(
-	ctx context.Context, toNodeID roachpb.NodeID, class rpc.ConnectionClass,
+	ctx context.Context, toReplica roachpb.ReplicaDescriptor, class rpc.ConnectionClass,
 ) (started bool) {
-	cleanup := func(q *raftSendQueue) {
-		// Account for the remainder of `ch` which was never sent.
-		// NB: we deleted the queue above, so within a short amount
-		// of time nobody should be writing into the channel any
-		// more. We might miss a message or two here, but that's
-		// OK (there's nobody who can safely close the channel the
-		// way the code is written).
-		for {
-			select {
-			case req := <-q.reqs:
-				q.bytes.Add(-int64(req.Size()))
-				t.metrics.MessagesDropped.Inc(1)
-				releaseRaftMessageRequest(req)
-			default:
-				return
-			}
-		}
-	}
+	toNodeID := toReplica.NodeID
 	worker := func(ctx context.Context) {
 		q, existingQueue := t.getQueue(toNodeID, class)
 		if !existingQueue {
 			log.Fatalf(ctx, "queue for n%d does not exist", toNodeID)
 		}
-		defer cleanup(q)
-		defer t.queues[class].Delete(int64(toNodeID))
-		// NB: we dial without a breaker here because the caller has already
-		// checked the breaker. Checking it again can cause livelock, see:
-		// https://github.com/cockroachdb/cockroach/issues/68419
-		conn, err := t.dialer.DialNoBreaker(ctx, toNodeID, class)
-		if err != nil {
-			// DialNode already logs sufficiently, so just return.
-			return
-		}
-
-		client := NewMultiRaftClient(conn)
-		batchCtx, cancel := context.WithCancel(ctx)
-		defer cancel()
+		var err error
+		defer func() {
+			t.onQueueClose(toReplica, class, t.queueCloseReason(q, err))
+		}()
+		// Account for the remainder of `ch` which was never sent. Messages which
+		// land in the channel after this are drained by SendAsync.
+		defer t.drainQueue(q)
+		defer t.deleteQueue(q, toNodeID, class)
 
-		stream, err := client.RaftMessageBatch(batchCtx) // closed via cancellation
-		if err != nil {
-			log.Warningf(ctx, "creating batch client for node %d failed: %+v", toNodeID, err)
+		_, err = t.connectAndProcessQueue(ctx, q, toNodeID, class)
+		if err == nil || !t.preserveQueueOnError {
 			return
 		}
 
-		if err := t.processQueue(q, stream); err != nil {
-			log.Warningf(ctx, "while processing outgoing Raft queue to node %d: %s:", toNodeID, err)
+		// Keep the queue and reconnect, until the node has been unreachable for
+		// the idle timeout.
+		deadline := timeutil.Now().Add(t.idleTimeout)
+		for r := retry.StartWithCtx(ctx, retry.Options{
+			InitialBackoff: 10 * time.Millisecond,
+			MaxBackoff:     time.Second,
+			Multiplier:     2,
+			Closer:         t.stopper.ShouldQuiesce(),
+		}); r.Next(); {
+			if timeutil.Now().After(deadline) {
+				log.Warningf(ctx, "unable to reconnect to node %d within %s, dropping queue",
+					toNodeID, t.idleTimeout)
+				return
+			}
+			var connected bool
+			connected, err = t.connectAndProcessQueue(ctx, q, toNodeID, class)
+			if err == nil {
+				return
+			}
+			if connected {
+				deadline = timeutil.Now().Add(t.idleTimeout)
+				r.Reset()
+			}
 		}
 	}
+	if t.OnQueueOpen != nil {
+		t.OnQueueOpen(toReplica, class)
+	}
 	err := t.stopper.RunAsyncTask(ctx, "storage.RaftTransport: sending/receiving messages",
 		func(ctx context.Context) {
 			pprof.Do(ctx, pprof.Labels("remote_node_id", toNodeID.String()), worker)
 		})
 	if err != nil {
-		t.queues[class].Delete(int64(toNodeID))
+		if value, ok := t.queues[class].Load(int64(toNodeID)); ok {
+			t.deleteQueue((*raftSendQueue)(value), toNodeID, class)
+		}
+		t.onQueueClose(toReplica, class, RaftQueueStopped)
 		return false
 	}
 	return true
 }
 
-// SendSnapshot streams the given outgoing snapshot. The caller is responsible
-// for closing the OutgoingSnapshot.
+// onQueueClose invokes the OnQueueClose callback, if set.
+func (t *RaftTransport) onQueueClose(
+	toReplica roachpb.ReplicaDescriptor, class rpc.ConnectionClass, reason RaftQueueCloseReason,
+) {
+	if t.OnQueueClose != nil {
+		t.OnQueueClose(toReplica, class, reason)
+	}
+}
+
+// queueCloseReason returns the reason the given queue's worker exited, given
+// the error it exited with, if any. Stream errors caused by the queue being
+// stopped or the transport shutting down are reported as RaftQueueStopped.
+func (t *RaftTransport) queueCloseReason(q *raftSendQueue, err error) RaftQueueCloseReason {
+	select {
+	case <-q.stopC:
+		return RaftQueueStopped
+	case <-t.stopper.ShouldQuiesce():
+		return RaftQueueStopped
+	default:
+	}
+	if err != nil {
+		return RaftQueueError
+	}
+	return RaftQueueIdle
+}
+
+// connectAndProcessQueue connects to the given node and processes the queue
+// until the stream fails or idles out. It returns whether a stream was
+// established, and an error if the node couldn't be connected to or the
+// stream failed (but not if it idled out or the stopper quiesced).
+func (t *RaftTransport) connectAndProcessQueue(
+	ctx context.Context, q *raftSendQueue, toNodeID roachpb.NodeID, class rpc.ConnectionClass,
+) (connected bool, _ error) {
+	// NB: we dial without a breaker here because the caller has already
+	// checked the breaker. Checking it again can cause livelock, see:
+	// https://github.com/cockroachdb/cockroach/issues/68419
+	conn, err := t.dialer.DialNoBreaker(ctx, toNodeID, class)
+	if err != nil {
+		if nodedialer.IsResolveError(err) {
+			t.recordResolveError(toNodeID)
+		}
+		// DialNode already logs sufficiently, so just return.
+		return false, err
+	}
+	t.resolveErrors.Delete(int64(toNodeID))
+
+	client := NewMultiRaftClient(conn)
+	batchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := client.RaftMessageBatch(batchCtx) // closed via cancellation
+	if err != nil {
+		log.Warningf(ctx, "creating batch client for node %d failed: %+v", toNodeID, err)
+		return false, err
+	}
+	t.recordStreamEstablished(toNodeID)
+
+	if err := t.processQueue(q, toNodeID, stream); err != nil {
+		log.Warningf(ctx, "while processing outgoing Raft queue to node %d: %s:", toNodeID, err)
+		return true, err
+	}
+	return true, nil
+}
+
+// SendSnapshot streams the given outgoing snapshot, and returns once the
+// recipient has applied it or the snapshot failed. If onProgress is non-nil, it
+// is called with the total number of bytes sent so far as each batch is sent.
+// The caller is responsible for closing the OutgoingSnapshot.
 func (t *RaftTransport) SendSnapshot(
 	ctx context.Context,
 	storePool *storepool.StorePool,
@@ -626,6 +1408,7 @@ func (t *RaftTransport) SendSnapshot(
 	newWriteBatch func() storage.WriteBatch,
 	sent func(),
 	recordBytesSent snapshotRecordMetrics,
+	onProgress func(bytesSent int64),
 ) error {
 	nodeID := header.RaftMessageRequest.ToReplica.NodeID
 
@@ -633,6 +1416,10 @@ func (t *RaftTransport) SendSnapshot(
 	if err != nil {
 		return err
 	}
+	// The stream is torn down by canceling its context if it times out, see
+	// snapshotTimeoutStream.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	client := NewMultiRaftClient(conn)
 	stream, err := client.RaftSnapshot(ctx)
 	if err != nil {
@@ -644,7 +1431,139 @@ func (t *RaftTransport) SendSnapshot(
 			log.Warningf(ctx, "failed to close snapshot stream: %+v", err)
 		}
 	}()
-	return sendSnapshot(ctx, t.st, t.tracer, stream, storePool, header, snap, newWriteBatch, sent, recordBytesSent)
+	timeoutStream := &snapshotTimeoutStream{
+		outgoingSnapshotStream: stream,
+		timeSource:             timeutil.DefaultTimeSource{},
+		timeout:                raftTransportSnapshotTimeout.Get(&t.st.SV),
+		cancel:                 cancel,
+	}
+	if err := timeoutStream.start(ctx, t.stopper); err != nil {
+		return err
+	}
+	return sendSnapshot(ctx, t.st, t.tracer, timeoutStream, storePool, header, snap, newWriteBatch,
+		sent, withSnapshotProgress(recordBytesSent, onProgress))
+}
+
+// snapshotTimeoutStream wraps an outgoing snapshot stream, and cancels it if a
+// send or receive blocks for longer than the timeout, such that a recipient
+// which hangs mid-snapshot can't stall the sender indefinitely. The snapshot
+// then fails, which is reported to Raft by the caller. Until the recipient has
+// responded to the snapshot header, the snapshot may legitimately be queued by
+// the recipient, so the timeout only applies after the first response. Once
+// the final request has been sent, the recipient ingests and applies the
+// snapshot, which may legitimately take long under ingestion backpressure, so
+// the timeout doesn't apply to the wait for the final response either; that
+// wait is only bounded by the caller's overall snapshot timeout.
+//
+// The timeout is enforced by a single watchdog task per stream, see start,
+// which each send or receive arms and disarms.
+type snapshotTimeoutStream struct {
+	outgoingSnapshotStream
+	timeSource timeutil.TimeSource
+	timeout    time.Duration
+	cancel     context.CancelFunc
+	accepted   bool
+	finalSent  bool
+	timedOut   atomic.Bool
+	// armC arms (true) or disarms (false) the watchdog's timer, which
+	// acknowledges on ackC once it has done so. doneC is closed when the
+	// watchdog exits. All are nil if the watchdog isn't running.
+	armC  chan bool
+	ackC  chan struct{}
+	doneC chan struct{}
+}
+
+// start runs the stream's watchdog as an async task, until the given context
+// is canceled, the stopper quiesces, or the timeout fires. It is a noop if the
+// timeout is disabled.
+func (s *snapshotTimeoutStream) start(ctx context.Context, stopper *stop.Stopper) error {
+	if s.timeout == 0 {
+		return nil
+	}
+	s.armC, s.ackC, s.doneC = make(chan bool), make(chan struct{}), make(chan struct{})
+	return stopper.RunAsyncTask(ctx, "storage.RaftTransport: snapshot timeout",
+		func(ctx context.Context) {
+			defer close(s.doneC)
+			var timer timeutil.TimerI
+			defer func() {
+				if timer != nil {
+					timer.Stop()
+				}
+			}()
+			for {
+				var timerC <-chan time.Time
+				if timer != nil {
+					timerC = timer.Ch()
+				}
+				select {
+				case armed := <-s.armC:
+					if timer != nil {
+						timer.Stop()
+						timer = nil
+					}
+					if armed {
+						timer = s.timeSource.NewTimer()
+						timer.Reset(s.timeout)
+					}
+					s.ackC <- struct{}{}
+				case <-timerC:
+					timer.MarkRead()
+					s.timedOut.Store(true)
+					s.cancel()
+					return
+				case <-ctx.Done():
+					return
+				case <-stopper.ShouldQuiesce():
+					return
+				}
+			}
+		})
+}
+
+func (s *snapshotTimeoutStream) Send(req *kvserverpb.SnapshotRequest) error {
+	stop := s.watch()
+	err := s.outgoingSnapshotStream.Send(req)
+	stop()
+	if req.Final {
+		s.finalSent = true
+	}
+	return s.wrapErr(err)
+}
+
+func (s *snapshotTimeoutStream) Recv() (*kvserverpb.SnapshotResponse, error) {
+	stop := s.watch()
+	resp, err := s.outgoingSnapshotStream.Recv()
+	stop()
+	s.accepted = true
+	return resp, s.wrapErr(err)
+}
+
+// watch arms the timeout for a stream operation, and returns a function which
+// disarms it.
+func (s *snapshotTimeoutStream) watch() func() {
+	if !s.accepted || s.finalSent || s.armC == nil {
+		return func() {}
+	}
+	s.arm(true)
+	return func() { s.arm(false) }
+}
+
+// arm arms or disarms the watchdog's timer, and returns once the watchdog has
+// done so or has exited.
+func (s *snapshotTimeoutStream) arm(armed bool) {
+	select {
+	case s.armC <- armed:
+		<-s.ackC
+	case <-s.doneC:
+	}
+}
+
+// wrapErr annotates errors caused by the timeout.
+func (s *snapshotTimeoutStream) wrapErr(err error) error {
+	if err != nil && s.timedOut.Load() {
+		return errors.Wrapf(err, "snapshot stream made no progress for %s", s.timeout)
+	}
+	return err
 }
 
 // DelegateSnapshot sends a DelegateSnapshotRequest to a remote store
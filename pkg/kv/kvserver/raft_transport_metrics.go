@@ -10,19 +10,58 @@
 
 package kvserver
 
-import "github.com/cockroachdb/cockroach/pkg/util/metric"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"go.etcd.io/raft/v3/raftpb"
+)
+
+// raftTransportMessageTypes are the Raft message types that are broken down in
+// the per-type transport metrics. Other message types are local to a store,
+// and are never sent over the transport.
+var raftTransportMessageTypes = []raftpb.MessageType{
+	raftpb.MsgProp,
+	raftpb.MsgApp,
+	raftpb.MsgAppResp,
+	raftpb.MsgVote,
+	raftpb.MsgVoteResp,
+	raftpb.MsgPreVote,
+	raftpb.MsgPreVoteResp,
+	raftpb.MsgSnap,
+	raftpb.MsgHeartbeat,
+	raftpb.MsgHeartbeatResp,
+	raftpb.MsgTransferLeader,
+	raftpb.MsgTimeoutNow,
+}
 
 // RaftTransportMetrics is the set of metrics for a given RaftTransport.
 type RaftTransportMetrics struct {
 	SendQueueSize  *metric.Gauge
 	SendQueueBytes *metric.Gauge
 
-	MessagesDropped *metric.Counter
-	MessagesSent    *metric.Counter
-	MessagesRcvd    *metric.Counter
+	MessagesDropped     *metric.Counter
+	MessagesOverBudget  *metric.Counter
+	MessagesUnreachable *metric.Counter
+	MessagesSent        *metric.Counter
+	MessagesRcvd        *metric.Counter
+	HandlerErrors       *metric.Counter
+
+	// Per-type breakdowns of MessagesSent and MessagesRcvd, indexed by message
+	// type. Entries for types not in raftTransportMessageTypes are nil. Like
+	// StoreMetrics.RaftRcvdMessages, these are arrays rather than maps such
+	// that recording a message on the hot path is a plain index. The init check
+	// in raft.go fails if raftpb gains a type beyond maxRaftMsgType, so a new
+	// type can't silently outgrow the arrays, and recordMessageType ignores
+	// out-of-range types regardless.
+	MessagesSentByType [maxRaftMsgType + 1]*metric.Counter
+	MessagesRcvdByType [maxRaftMsgType + 1]*metric.Counter
 
 	ReverseSent *metric.Counter
 	ReverseRcvd *metric.Counter
+
+	StreamsEstablished *metric.Counter
 }
 
 func (t *RaftTransport) initMetrics() {
@@ -58,6 +97,29 @@ have a fuller picture.`,
 			Unit:        metric.Unit_COUNT,
 		}),
 
+		MessagesOverBudget: metric.NewCounter(metric.Metadata{
+			Name: "raft.transport.sends-over-budget",
+			Help: `Number of Raft message sends refused because the send queues exceeded their budget.
+
+The total byte size of messages buffered across all send queues is bounded by
+kv.raft.transport.send_queue_budget. These sends are also counted in
+sends-dropped.`,
+			Measurement: "Messages",
+			Unit:        metric.Unit_COUNT,
+		}),
+
+		MessagesUnreachable: metric.NewCounter(metric.Metadata{
+			Name: "raft.transport.sends-unreachable",
+			Help: `Number of Raft message sends refused because the recipient node was unreachable.
+
+A node is considered unreachable while its circuit breaker is tripped, or for a
+cooldown period after its address failed to resolve. A steadily increasing count
+indicates that a peer has been marked down for a prolonged period. These sends
+are also counted in sends-dropped.`,
+			Measurement: "Messages",
+			Unit:        metric.Unit_COUNT,
+		}),
+
 		MessagesSent: metric.NewCounter(metric.Metadata{
 			Name:        "raft.transport.sent",
 			Help:        "Number of Raft messages sent by the Raft Transport",
@@ -72,6 +134,17 @@ have a fuller picture.`,
 			Unit:        metric.Unit_COUNT,
 		}),
 
+		HandlerErrors: metric.NewCounter(metric.Metadata{
+			Name: "raft.transport.handler-errors",
+			Help: `Number of incoming Raft messages rejected by the Raft Transport.
+
+Messages are rejected when the recipient store has no registered handler, or
+when the handler returns an error. The error is sent back to the sender in the
+reverse direction of the stream, see reverse-sent.`,
+			Measurement: "Messages",
+			Unit:        metric.Unit_COUNT,
+		}),
+
 		ReverseSent: metric.NewCounter(metric.Metadata{
 			Name: "raft.transport.reverse-sent",
 			Help: `Messages sent in the reverse direction of a stream.
@@ -91,5 +164,44 @@ responses to Raft messages. Responses are received over another stream.`,
 			Measurement: "Messages",
 			Unit:        metric.Unit_COUNT,
 		}),
+
+		StreamsEstablished: metric.NewCounter(metric.Metadata{
+			Name: "raft.transport.streams-established",
+			Help: `Number of outgoing Raft streams established by the Raft Transport.
+
+Streams are established on demand, and re-established after a stream failure or
+an idle timeout. A high rate of stream establishment could indicate an unstable
+connection to at least one peer.`,
+			Measurement: "Streams",
+			Unit:        metric.Unit_COUNT,
+		}),
+	}
+
+	for _, typ := range raftTransportMessageTypes {
+		// MsgApp is exported as raft.transport.sent.app, etc.
+		name := strings.ToLower(strings.TrimPrefix(typ.String(), "Msg"))
+		t.metrics.MessagesSentByType[typ] = metric.NewCounter(metric.Metadata{
+			Name:        "raft.transport.sent." + name,
+			Help:        fmt.Sprintf("Number of %s messages sent by the Raft Transport", typ),
+			Measurement: "Messages",
+			Unit:        metric.Unit_COUNT,
+		})
+		t.metrics.MessagesRcvdByType[typ] = metric.NewCounter(metric.Metadata{
+			Name:        "raft.transport.rcvd." + name,
+			Help:        fmt.Sprintf("Number of %s messages received by the Raft Transport", typ),
+			Measurement: "Messages",
+			Unit:        metric.Unit_COUNT,
+		})
+	}
+}
+
+// recordMessageType increments the per-type counter for the given message
+// type, if it is tracked. Types which aren't tracked, or which are out of range,
+// e.g. a corrupt message from a peer, are ignored.
+func recordMessageType(
+	counters *[maxRaftMsgType + 1]*metric.Counter, typ raftpb.MessageType,
+) {
+	if typ >= 0 && typ <= maxRaftMsgType && counters[typ] != nil {
+		counters[typ].Inc(1)
 	}
 }
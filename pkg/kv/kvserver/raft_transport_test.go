@@ -11,10 +11,14 @@
 package kvserver_test
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"math/rand"
 	"net"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -27,6 +31,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/rpc"
 	"github.com/cockroachdb/cockroach/pkg/rpc/nodedialer"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/storage"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/util"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
@@ -624,6 +629,1401 @@ func TestReopenConnection(t *testing.T) {
 	})
 }
 
+// TestRaftTransportPreserveQueueOnError verifies that with
+// preserveQueueOnError, a message enqueued while the connection to a node is
+// down is delivered once the node comes back up.
+func TestRaftTransportPreserveQueueOnError(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	// Use a special stopper for the initial server so that we can fully stop it
+	// (releasing its bound network address) and then restart it.
+	serverStopper := stop.NewStopper()
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	serverTransport, serverAddr :=
+		rttc.AddNodeWithoutGossip(serverReplica.NodeID, util.TestAddr, serverStopper)
+	rttc.GossipNode(serverReplica.NodeID, serverAddr)
+	serverChannel := rttc.ListenStore(serverReplica.NodeID, serverReplica.StoreID)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+	clientTransport.SetPreserveQueueOnError(true)
+
+	// Establish the connection.
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}))
+	select {
+	case req := <-serverChannel.ch:
+		require.EqualValues(t, 1, req.Message.Commit)
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for message")
+	}
+
+	// Take down the server, wait for the client to notice, and enqueue a
+	// message while it's down. The circuit breaker may trip while the client
+	// attempts to reconnect, so reset it if needed.
+	serverTransport.Stop(serverReplica.StoreID)
+	serverStopper.Stop(context.Background())
+	testutils.SucceedsSoon(t, func() error {
+		if rttc.nodeRPCContext.ConnHealth(
+			serverAddr.String(), serverReplica.NodeID, rpc.DefaultClass) == nil {
+			return errors.New("connection still healthy")
+		}
+		return nil
+	})
+	testutils.SucceedsSoon(t, func() error {
+		if !rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 2}) {
+			clientTransport.GetCircuitBreaker(serverReplica.NodeID, rpc.DefaultClass).Reset()
+			return errors.New("unable to enqueue message")
+		}
+		return nil
+	})
+
+	// Bring the server back up at the same address. The preserved message
+	// should be delivered without any further sends.
+	rttc.AddNodeWithoutGossip(serverReplica.NodeID, serverAddr, rttc.stopper)
+	serverChannel = rttc.ListenStore(serverReplica.NodeID, serverReplica.StoreID)
+	select {
+	case req := <-serverChannel.ch:
+		require.EqualValues(t, 2, req.Message.Commit)
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for preserved message")
+	}
+}
+
+// TestRaftTransportStopStore tests that StopStore shuts down outgoing queues
+// that are only used to send messages to the stopped store.
+func TestRaftTransportStopStore(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	rttc.AddNode(serverReplica.NodeID)
+	serverChannel := rttc.ListenStore(serverReplica.NodeID, serverReplica.StoreID)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}))
+	select {
+	case req := <-serverChannel.ch:
+		require.EqualValues(t, 1, req.Message.Commit)
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for message")
+	}
+	require.True(t, clientTransport.HasQueue(serverReplica.NodeID, rpc.DefaultClass))
+
+	// Stopping an unrelated store should not affect the queue.
+	clientTransport.StopStore(clientReplica.StoreID)
+	require.True(t, clientTransport.HasQueue(serverReplica.NodeID, rpc.DefaultClass))
+
+	// Stopping the server's store should shut down the queue.
+	clientTransport.StopStore(serverReplica.StoreID)
+	testutils.SucceedsSoon(t, func() error {
+		if clientTransport.HasQueue(serverReplica.NodeID, rpc.DefaultClass) {
+			return errors.New("queue still exists")
+		}
+		return nil
+	})
+}
+
+// TestRaftTransportSendDelay tests that an artificial send delay for a node
+// delays messages to it, but not to other nodes.
+func TestRaftTransportSendDelay(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	const delay = 500 * time.Millisecond
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 123))
+	clientTransport.SetTimeSource(clock)
+
+	delayedReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	rttc.AddNode(delayedReplica.NodeID)
+	delayedChannel := rttc.ListenStore(delayedReplica.NodeID, delayedReplica.StoreID)
+
+	normalReplica := roachpb.ReplicaDescriptor{
+		NodeID:    3,
+		StoreID:   3,
+		ReplicaID: 3,
+	}
+	rttc.AddNode(normalReplica.NodeID)
+	normalChannel := rttc.ListenStore(normalReplica.NodeID, normalReplica.StoreID)
+
+	clientTransport.SetSendDelay(delayedReplica.NodeID, delay)
+
+	require.True(t, rttc.Send(clientReplica, delayedReplica, 1, raftpb.Message{Commit: 1}))
+	require.True(t, rttc.Send(clientReplica, normalReplica, 1, raftpb.Message{Commit: 1}))
+
+	// The message to the normal node is delivered without advancing the clock.
+	select {
+	case <-normalChannel.ch:
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for message")
+	}
+
+	// The message to the delayed node waits for the delay to elapse.
+	testutils.SucceedsSoon(t, func() error {
+		if len(clock.Timers()) == 0 {
+			return errors.New("send not delayed yet")
+		}
+		return nil
+	})
+	select {
+	case req := <-delayedChannel.ch:
+		t.Fatalf("delayed message arrived early: %+v", req)
+	default:
+	}
+	clock.Advance(delay)
+	select {
+	case <-delayedChannel.ch:
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+// TestRaftTransportHandlerGracePeriod tests that a message which arrives before
+// the recipient store has registered its handler is delivered once the handler
+// is registered, if within the transport's startup grace period.
+func TestRaftTransportHandlerGracePeriod(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	serverTransport := rttc.AddNode(serverReplica.NodeID)
+	serverTransport.SetHandlerGracePeriod(time.Hour)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+
+	// Send a message before the server store has registered its handler, and
+	// wait for it to arrive at the server.
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}))
+	testutils.SucceedsSoon(t, func() error {
+		if clientTransport.Metrics().MessagesSent.Count() == 0 {
+			return errors.New("message not sent yet")
+		}
+		return nil
+	})
+
+	// Register the handler. The message should be delivered to it.
+	serverChannel := rttc.ListenStore(serverReplica.NodeID, serverReplica.StoreID)
+	select {
+	case req := <-serverChannel.ch:
+		require.EqualValues(t, 1, req.Message.Commit)
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+// TestRaftTransportStreamsEstablished tests that the number of streams
+// established to a node is tracked when the stream fails repeatedly.
+func TestRaftTransportStreamsEstablished(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	// The server node has no handler for the recipient store, so it responds
+	// with a StoreNotFoundError. This causes the client to tear down the stream.
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	rttc.AddNode(serverReplica.NodeID)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+	rttc.ListenStore(clientReplica.NodeID, clientReplica.StoreID)
+
+	require.Zero(t, clientTransport.StreamsEstablished(serverReplica.NodeID))
+
+	const attempts = 3
+	for i := 1; i <= attempts; i++ {
+		require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}))
+		testutils.SucceedsSoon(t, func() error {
+			if clientTransport.HasQueue(serverReplica.NodeID, rpc.DefaultClass) {
+				return errors.New("stream still open")
+			}
+			return nil
+		})
+		require.EqualValues(t, i, clientTransport.StreamsEstablished(serverReplica.NodeID))
+	}
+	require.EqualValues(t, attempts, clientTransport.Metrics().StreamsEstablished.Count())
+}
+
+// TestRaftTransportResetConnection tests that ResetConnection tears down the
+// stream to a node, and that the next send establishes a new stream.
+func TestRaftTransportResetConnection(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	rttc.AddNode(serverReplica.NodeID)
+	serverChannel := rttc.ListenStore(serverReplica.NodeID, serverReplica.StoreID)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+
+	// Resetting a node without a stream is a noop.
+	clientTransport.ResetConnection(serverReplica.NodeID)
+	require.Zero(t, clientTransport.StreamsEstablished(serverReplica.NodeID))
+
+	const resets = 3
+	for i := 1; i <= resets+1; i++ {
+		require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: uint64(i)}))
+		select {
+		case req := <-serverChannel.ch:
+			require.EqualValues(t, i, req.Message.Commit)
+		case <-time.After(testutils.DefaultSucceedsSoonDuration):
+			t.Fatal("timed out waiting for message")
+		}
+		require.EqualValues(t, i, clientTransport.StreamsEstablished(serverReplica.NodeID))
+		if i > resets {
+			break
+		}
+
+		clientTransport.ResetConnection(serverReplica.NodeID)
+		testutils.SucceedsSoon(t, func() error {
+			if clientTransport.HasQueue(serverReplica.NodeID, rpc.DefaultClass) {
+				return errors.New("stream still open")
+			}
+			return nil
+		})
+	}
+	require.EqualValues(t, resets+1, clientTransport.Metrics().StreamsEstablished.Count())
+}
+
+// TestRaftTransportStores tests that Stores reflects the stores registered via
+// Listen and unregistered via Stop.
+func TestRaftTransportStores(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	transport := rttc.AddNode(1)
+	require.Empty(t, transport.Stores())
+
+	rttc.ListenStore(1, 3)
+	rttc.ListenStore(1, 1)
+	rttc.ListenStore(1, 2)
+	require.Equal(t, []roachpb.StoreID{1, 2, 3}, transport.Stores())
+
+	transport.Stop(2)
+	require.Equal(t, []roachpb.StoreID{1, 3}, transport.Stores())
+
+	transport.StopStore(1)
+	transport.Stop(3)
+	require.Empty(t, transport.Stores())
+}
+
+// TestRaftTransportListenAll tests that ListenAll and StopAll register and
+// unregister several stores atomically, and that messages are delivered to all
+// of the registered stores.
+func TestRaftTransportListenAll(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	transport := rttc.AddNode(1)
+	storeIDs := []roachpb.StoreID{1, 2, 3}
+	channels := map[roachpb.StoreID]channelServer{}
+	handlers := map[roachpb.StoreID]kvserver.RaftMessageHandler{}
+	for _, storeID := range storeIDs {
+		channels[storeID] = newChannelServer(100, 0)
+		handlers[storeID] = channels[storeID]
+	}
+
+	// Concurrently register and unregister the stores, and check that they're
+	// never seen partially registered.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			transport.ListenAll(handlers)
+			transport.StopAll(storeIDs)
+		}
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		stores := transport.Stores()
+		require.True(t, len(stores) == 0 || len(stores) == len(storeIDs),
+			"stores partially registered: %v", stores)
+	}
+	require.Empty(t, transport.Stores())
+
+	// Messages are delivered to all of the registered stores.
+	transport.ListenAll(handlers)
+	require.Equal(t, storeIDs, transport.Stores())
+	rttc.AddNode(2)
+	clientReplica := roachpb.ReplicaDescriptor{NodeID: 2, StoreID: 4, ReplicaID: 4}
+	for _, storeID := range storeIDs {
+		serverReplica := roachpb.ReplicaDescriptor{
+			NodeID:    1,
+			StoreID:   storeID,
+			ReplicaID: roachpb.ReplicaID(storeID),
+		}
+		require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}))
+		select {
+		case req := <-channels[storeID].ch:
+			require.Equal(t, storeID, req.ToReplica.StoreID)
+		case <-time.After(testutils.DefaultSucceedsSoonDuration):
+			t.Fatalf("timed out waiting for message to s%d", storeID)
+		}
+	}
+
+	transport.StopAll(storeIDs[:2])
+	require.Equal(t, storeIDs[2:], transport.Stores())
+}
+
+// TestRaftTransportHandlerErrors tests that the server side of the transport
+// tracks the messages received for each store and the messages rejected by the
+// store's handler.
+func TestRaftTransportHandlerErrors(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	serverTransport := rttc.AddNode(serverReplica.NodeID)
+	serverChannel := newChannelServer(10, 0 /* maxSleep */)
+	serverChannel.brokenRange = 13
+	serverTransport.Listen(serverReplica.StoreID, serverChannel)
+
+	// The client has no handler, so the error responses are dropped rather than
+	// tearing down the stream.
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	rttc.AddNode(clientReplica.NodeID)
+
+	require.Zero(t, serverTransport.MessagesReceived(serverReplica.StoreID))
+	require.Zero(t, serverTransport.Metrics().HandlerErrors.Count())
+
+	// Messages on a stream are handled in order, so once the message to range 1
+	// has been delivered the message to the broken range has been rejected.
+	require.True(t, rttc.Send(clientReplica, serverReplica, 13, raftpb.Message{Commit: 1}))
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 2}))
+	select {
+	case req := <-serverChannel.ch:
+		require.Equal(t, roachpb.RangeID(1), req.RangeID)
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for message")
+	}
+
+	require.EqualValues(t, 2, serverTransport.MessagesReceived(serverReplica.StoreID))
+	require.Zero(t, serverTransport.MessagesReceived(clientReplica.StoreID))
+	require.EqualValues(t, 1, serverTransport.Metrics().HandlerErrors.Count())
+}
+
+// TestRaftTransportMessageTypeMetrics tests that sent and received messages are
+// broken down by message type.
+func TestRaftTransportMessageTypeMetrics(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	serverTransport := rttc.AddNode(serverReplica.NodeID)
+	serverChannel := rttc.ListenStore(serverReplica.NodeID, serverReplica.StoreID)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+
+	// MsgHup is never sent over the transport, so it isn't broken down.
+	msgTypes := []raftpb.MessageType{
+		raftpb.MsgApp, raftpb.MsgApp, raftpb.MsgApp,
+		raftpb.MsgHeartbeat, raftpb.MsgHeartbeat,
+		raftpb.MsgVote,
+		raftpb.MsgHup,
+	}
+	expect := map[raftpb.MessageType]int64{}
+	for i, typ := range msgTypes {
+		require.True(t, rttc.Send(clientReplica, serverReplica, 1,
+			raftpb.Message{Type: typ, Commit: uint64(i)}))
+		if typ != raftpb.MsgHup {
+			expect[typ]++
+		}
+	}
+	for range msgTypes {
+		select {
+		case <-serverChannel.ch:
+		case <-time.After(testutils.DefaultSucceedsSoonDuration):
+			t.Fatal("timed out waiting for message")
+		}
+	}
+
+	// The sender increments its metrics after sending the batch, which may race
+	// with the receipt above.
+	testutils.SucceedsSoon(t, func() error {
+		if n := clientTransport.Metrics().MessagesSent.Count(); n != int64(len(msgTypes)) {
+			return errors.Errorf("sent %d messages, expected %d", n, len(msgTypes))
+		}
+		return nil
+	})
+	require.EqualValues(t, len(msgTypes), serverTransport.Metrics().MessagesRcvd.Count())
+	for _, typ := range []raftpb.MessageType{
+		raftpb.MsgApp, raftpb.MsgHeartbeat, raftpb.MsgVote, raftpb.MsgSnap,
+	} {
+		require.Equal(t, expect[typ],
+			clientTransport.Metrics().MessagesSentByType[typ].Count(), "sent %s", typ)
+		require.Equal(t, expect[typ],
+			serverTransport.Metrics().MessagesRcvdByType[typ].Count(), "rcvd %s", typ)
+	}
+	require.Nil(t, clientTransport.Metrics().MessagesSentByType[raftpb.MsgHup])
+}
+
+// TestRaftTransportSendQueueBudget tests that sends are refused once the
+// messages buffered across all queues exceed the transport's budget, and are
+// accepted again once the budget allows it.
+func TestRaftTransportSendQueueBudget(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	ctx := context.Background()
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	const budget = 64 << 10
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+	clientTransport.SetSendQueueBudget(ctx, budget)
+
+	// Saturate the queues to several nodes, by delaying sends to them past the
+	// end of the test.
+	var servers []roachpb.ReplicaDescriptor
+	for id := 2; id <= 4; id++ {
+		server := roachpb.ReplicaDescriptor{
+			NodeID:    roachpb.NodeID(id),
+			StoreID:   roachpb.StoreID(id),
+			ReplicaID: roachpb.ReplicaID(id),
+		}
+		rttc.AddNode(server.NodeID)
+		rttc.ListenStore(server.NodeID, server.StoreID)
+		clientTransport.SetSendDelay(server.NodeID, time.Hour)
+		servers = append(servers, server)
+	}
+
+	// Sends are refused once about budget bytes are buffered, well before the
+	// individual queues are full.
+	const maxSends = 1000
+	data := make([]byte, 1<<10)
+	var sent int
+	for ; sent < maxSends; sent++ {
+		msg := raftpb.Message{
+			Type:    raftpb.MsgApp,
+			Index:   uint64(sent),
+			Entries: []raftpb.Entry{{Index: uint64(sent), Data: data}},
+		}
+		if !rttc.Send(clientReplica, servers[sent%len(servers)], 1, msg) {
+			break
+		}
+	}
+	require.Less(t, sent, maxSends)
+	require.Greater(t, sent, len(servers))
+	require.EqualValues(t, 1, clientTransport.Metrics().MessagesOverBudget.Count())
+	require.LessOrEqual(t, clientTransport.Metrics().SendQueueBytes.Value(), int64(budget))
+
+	// The budget applies across all queues, so sends to a node that isn't
+	// saturated are refused as well.
+	normalReplica := roachpb.ReplicaDescriptor{
+		NodeID:    5,
+		StoreID:   5,
+		ReplicaID: 5,
+	}
+	rttc.AddNode(normalReplica.NodeID)
+	normalChannel := rttc.ListenStore(normalReplica.NodeID, normalReplica.StoreID)
+	require.False(t, rttc.Send(clientReplica, normalReplica, 1, raftpb.Message{Commit: 1}))
+	require.EqualValues(t, 2, clientTransport.Metrics().MessagesOverBudget.Count())
+
+	// Once the budget allows it, sends are accepted again.
+	clientTransport.SetSendQueueBudget(ctx, 0)
+	require.True(t, rttc.Send(clientReplica, normalReplica, 1, raftpb.Message{Commit: 2}))
+	select {
+	case req := <-normalChannel.ch:
+		require.EqualValues(t, 2, req.Message.Commit)
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+// TestRaftTransportSendQueueBudgetTeardown tests that the bytes of messages
+// which are enqueued concurrently with their queue being torn down are
+// released, such that the transport's buffered bytes return to zero once all
+// messages have been sent or dropped.
+func TestRaftTransportSendQueueBudgetTeardown(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	ctx := context.Background()
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	rttc.AddNode(serverReplica.NodeID)
+	serverChannel := rttc.ListenStore(serverReplica.NodeID, serverReplica.StoreID)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+	clientTransport.SetSendQueueBudget(ctx, 1<<30)
+
+	// Consume the delivered messages, so the server doesn't block.
+	doneC := make(chan struct{})
+	defer close(doneC)
+	go func() {
+		for {
+			select {
+			case <-serverChannel.ch:
+			case <-doneC:
+				return
+			}
+		}
+	}()
+
+	// Send messages from several goroutines while repeatedly tearing down the
+	// queue to the server.
+	const senders = 4
+	const sends = 500
+	var wg sync.WaitGroup
+	stopResetsC, resetsStoppedC := make(chan struct{}), make(chan struct{})
+	wg.Add(senders)
+	for i := 0; i < senders; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < sends; j++ {
+				rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: uint64(j)})
+			}
+		}()
+	}
+	go func() {
+		defer close(resetsStoppedC)
+		for {
+			select {
+			case <-stopResetsC:
+				return
+			default:
+				clientTransport.ResetConnection(serverReplica.NodeID)
+			}
+		}
+	}()
+	wg.Wait()
+	close(stopResetsC)
+	<-resetsStoppedC
+
+	// All messages are eventually sent or dropped, and their bytes released.
+	testutils.SucceedsSoon(t, func() error {
+		if b := clientTransport.QueuedBytes(); b != 0 {
+			return errors.Errorf("%d bytes still queued", b)
+		}
+		return nil
+	})
+}
+
+// TestRaftTransportQueueCallbacks tests that the OnQueueOpen and OnQueueClose
+// callbacks are invoked when a queue is created by a send and destroyed when it
+// later idles out.
+func TestRaftTransportQueueCallbacks(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	rttc.AddNode(serverReplica.NodeID)
+	serverChannel := rttc.ListenStore(serverReplica.NodeID, serverReplica.StoreID)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+	clientTransport.SetIdleTimeout(100 * time.Millisecond)
+
+	type queueEvent struct {
+		open   bool
+		to     roachpb.ReplicaDescriptor
+		class  rpc.ConnectionClass
+		reason kvserver.RaftQueueCloseReason
+	}
+	events := make(chan queueEvent, 10)
+	clientTransport.OnQueueOpen = func(to roachpb.ReplicaDescriptor, class rpc.ConnectionClass) {
+		events <- queueEvent{open: true, to: to, class: class}
+	}
+	clientTransport.OnQueueClose = func(
+		to roachpb.ReplicaDescriptor, class rpc.ConnectionClass, reason kvserver.RaftQueueCloseReason,
+	) {
+		events <- queueEvent{to: to, class: class, reason: reason}
+	}
+	nextEvent := func() queueEvent {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(testutils.DefaultSucceedsSoonDuration):
+			t.Fatal("timed out waiting for queue event")
+		}
+		return queueEvent{}
+	}
+
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}))
+	require.Equal(t, queueEvent{open: true, to: serverReplica, class: rpc.DefaultClass}, nextEvent())
+	select {
+	case req := <-serverChannel.ch:
+		require.EqualValues(t, 1, req.Message.Commit)
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for message")
+	}
+
+	// The queue is closed once it idles out, and no longer exists once the
+	// callback is invoked.
+	require.Equal(t, queueEvent{
+		to: serverReplica, class: rpc.DefaultClass, reason: kvserver.RaftQueueIdle,
+	}, nextEvent())
+	require.False(t, clientTransport.HasQueue(serverReplica.NodeID, rpc.DefaultClass))
+
+	// A subsequent send opens a new queue.
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 2}))
+	require.Equal(t, queueEvent{open: true, to: serverReplica, class: rpc.DefaultClass}, nextEvent())
+	require.Equal(t, kvserver.RaftQueueIdle, nextEvent().reason)
+}
+
+// TestRaftTransportDeliveryConfirmations tests that DeliveryConfirmations
+// receives a confirmation once a message has been handled by the recipient
+// store, but not for messages rejected by the handler.
+func TestRaftTransportDeliveryConfirmations(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	serverTransport := rttc.AddNode(serverReplica.NodeID)
+	confirmations := make(chan kvserver.RaftDeliveryConfirmation, 10)
+	serverTransport.DeliveryConfirmations = confirmations
+	serverChannel := newChannelServer(10, 10*time.Millisecond)
+	serverChannel.brokenRange = 13
+	serverTransport.Listen(serverReplica.StoreID, serverChannel)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	rttc.AddNode(clientReplica.NodeID)
+
+	nextConfirmation := func() kvserver.RaftDeliveryConfirmation {
+		select {
+		case c := <-confirmations:
+			return c
+		case <-time.After(testutils.DefaultSucceedsSoonDuration):
+			t.Fatal("timed out waiting for delivery confirmation")
+		}
+		return kvserver.RaftDeliveryConfirmation{}
+	}
+
+	// Once the message is confirmed, it has been handled, so there's no need to
+	// wait for it.
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1,
+		raftpb.Message{Type: raftpb.MsgApp, Commit: 1}))
+	require.Equal(t, kvserver.RaftDeliveryConfirmation{
+		To: serverReplica, Type: raftpb.MsgApp,
+	}, nextConfirmation())
+	require.Len(t, serverChannel.ch, 1)
+	require.EqualValues(t, 1, (<-serverChannel.ch).Message.Commit)
+
+	// Messages on a stream are handled in order, so the rejected message to the
+	// broken range is never confirmed.
+	require.True(t, rttc.Send(clientReplica, serverReplica, 13,
+		raftpb.Message{Type: raftpb.MsgHeartbeat, Commit: 2}))
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1,
+		raftpb.Message{Type: raftpb.MsgAppResp, Commit: 3}))
+	require.Equal(t, kvserver.RaftDeliveryConfirmation{
+		To: serverReplica, Type: raftpb.MsgAppResp,
+	}, nextConfirmation())
+	require.Len(t, serverChannel.ch, 1)
+	require.EqualValues(t, 3, (<-serverChannel.ch).Message.Commit)
+	require.Empty(t, confirmations)
+}
+
+// TestRaftTransportPauseSends tests that messages are buffered while sends to a
+// node are paused, subject to the queue limit, and are delivered in order once
+// resumed, without re-establishing the stream.
+func TestRaftTransportPauseSends(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	serverTransport := rttc.AddNode(serverReplica.NodeID)
+	// Don't sleep while handling messages, since we flush a full queue.
+	serverChannel := newChannelServer(100, 0 /* maxSleep */)
+	serverTransport.Listen(serverReplica.StoreID, serverChannel)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+
+	// Establish the stream.
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}))
+	select {
+	case req := <-serverChannel.ch:
+		require.EqualValues(t, 1, req.Message.Commit)
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for message")
+	}
+	require.EqualValues(t, 1, clientTransport.StreamsEstablished(serverReplica.NodeID))
+	testutils.SucceedsSoon(t, func() error {
+		if n := clientTransport.Metrics().MessagesSent.Count(); n != 1 {
+			return errors.Errorf("%d messages sent", n)
+		}
+		return nil
+	})
+
+	// Pause sends, and fill up the queue. Sends are refused once it's full.
+	clientTransport.PauseSends(serverReplica.NodeID)
+	const maxSends = 100000
+	commit := uint64(1)
+	for ; commit < maxSends; commit++ {
+		if !rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: commit + 1}) {
+			break
+		}
+	}
+	require.Less(t, commit, uint64(maxSends), "queue never filled up")
+	require.EqualValues(t, 1, clientTransport.Metrics().MessagesDropped.Count())
+
+	// Nothing is sent while paused. The queue filling up shows that the
+	// buffered messages weren't sent.
+	require.EqualValues(t, 1, clientTransport.Metrics().MessagesSent.Count())
+	require.EqualValues(t, 1, serverTransport.MessagesReceived(serverReplica.StoreID))
+	require.Empty(t, serverChannel.ch)
+
+	// Once resumed, all buffered messages are delivered in order, over the
+	// existing stream.
+	clientTransport.ResumeSends(serverReplica.NodeID)
+	for expect := uint64(2); expect <= commit; expect++ {
+		select {
+		case req := <-serverChannel.ch:
+			require.Equal(t, expect, req.Message.Commit)
+		case <-time.After(testutils.DefaultSucceedsSoonDuration):
+			t.Fatalf("timed out waiting for message %d", expect)
+		}
+	}
+	require.EqualValues(t, 1, clientTransport.StreamsEstablished(serverReplica.NodeID))
+
+	// Resuming again is a noop.
+	clientTransport.ResumeSends(serverReplica.NodeID)
+}
+
+// TestRaftTransportTraceOrigin tests that outgoing messages are stamped with
+// their origin when enabled, and that it round-trips to the recipient's
+// handler.
+func TestRaftTransportTraceOrigin(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	rttc.AddNode(serverReplica.NodeID)
+	serverChannel := rttc.ListenStore(serverReplica.NodeID, serverReplica.StoreID)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+
+	recv := func() *kvserverpb.RaftMessageRequest {
+		select {
+		case req := <-serverChannel.ch:
+			return req
+		case <-time.After(testutils.DefaultSucceedsSoonDuration):
+			t.Fatal("timed out waiting for message")
+		}
+		return nil
+	}
+
+	// Messages aren't stamped by default.
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}))
+	require.Nil(t, recv().Origin)
+
+	clientTransport.SetTraceOrigin(true)
+	before := timeutil.Now()
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 2}))
+	req := recv()
+	require.EqualValues(t, 2, req.Message.Commit)
+	require.NotNil(t, req.Origin)
+	require.Equal(t, clientReplica.NodeID, req.Origin.NodeID)
+	require.GreaterOrEqual(t, req.Origin.SentAtNanos, before.UnixNano())
+	require.LessOrEqual(t, req.Origin.SentAtNanos, timeutil.Now().UnixNano())
+}
+
+// TestRaftTransportDropInbound tests that a node listed in
+// kv.raft.transport.testing.drop_inbound_node_ids drops incoming Raft messages
+// and rejects incoming snapshots, and delivers messages again once removed.
+func TestRaftTransportDropInbound(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	ctx := context.Background()
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	serverTransport := rttc.AddNode(serverReplica.NodeID)
+	serverChannel := rttc.ListenStore(serverReplica.NodeID, serverReplica.StoreID)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	rttc.AddNode(clientReplica.NodeID)
+
+	// Messages to other nodes are unaffected.
+	serverTransport.SetDropInboundNodeIDs(ctx, "3, 4")
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}))
+	select {
+	case req := <-serverChannel.ch:
+		require.EqualValues(t, 1, req.Message.Commit)
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for message")
+	}
+
+	// Messages to the server are dropped. The send itself succeeds, since the
+	// messages are dropped by the recipient. Wait for the server to receive
+	// them.
+	serverTransport.SetDropInboundNodeIDs(ctx, "2")
+	rcvd := serverTransport.MessagesReceived(serverReplica.StoreID)
+	const dropped = 10
+	for i := 0; i < dropped; i++ {
+		require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 2}))
+	}
+	testutils.SucceedsSoon(t, func() error {
+		if n := serverTransport.MessagesReceived(serverReplica.StoreID) - rcvd; n < dropped {
+			return errors.Errorf("received %d/%d messages", n, dropped)
+		}
+		return nil
+	})
+
+	// Snapshots to the server are rejected.
+	dialer := nodedialer.New(rttc.nodeRPCContext, gossip.AddressResolver(rttc.gossip))
+	conn, err := dialer.Dial(ctx, serverReplica.NodeID, rpc.DefaultClass)
+	require.NoError(t, err)
+	snapCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := kvserver.NewMultiRaftClient(conn).RaftSnapshot(snapCtx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&kvserverpb.SnapshotRequest{
+		Header: &kvserverpb.SnapshotRequest_Header{
+			RaftMessageRequest: kvserverpb.RaftMessageRequest{
+				FromReplica: clientReplica,
+				ToReplica:   serverReplica,
+			},
+		},
+	}))
+	_, err = stream.Recv()
+	require.ErrorContains(t, err, "n2 is dropping incoming Raft traffic")
+
+	// Once removed, messages are delivered again. The stream delivers messages
+	// in order, so the dropped messages would have been delivered first.
+	serverTransport.SetDropInboundNodeIDs(ctx, "")
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 3}))
+	select {
+	case req := <-serverChannel.ch:
+		require.EqualValues(t, 3, req.Message.Commit)
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+// TestRaftTransportResolveErrorCooldown tests that sending to a node whose
+// address can't be resolved doesn't repeatedly re-resolve it, and that messages
+// are delivered once the node becomes resolvable.
+func TestRaftTransportResolveErrorCooldown(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	// The server isn't gossiped, so its address can't be resolved.
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	_, serverAddr := rttc.AddNodeWithoutGossip(serverReplica.NodeID, util.TestAddr, rttc.stopper)
+	serverChannel := rttc.ListenStore(serverReplica.NodeID, serverReplica.StoreID)
+
+	// The client counts the attempts to resolve the server's address.
+	var resolves atomic.Int64
+	resolver := func(nodeID roachpb.NodeID) (net.Addr, error) {
+		if nodeID == serverReplica.NodeID {
+			resolves.Add(1)
+		}
+		return gossip.AddressResolver(rttc.gossip)(nodeID)
+	}
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	ambient := log.MakeTestingAmbientCtxWithNewTracer()
+	clientTransport := kvserver.NewRaftTransport(
+		ambient,
+		cluster.MakeTestingClusterSettings(),
+		ambient.Tracer,
+		nodedialer.New(rttc.nodeRPCContext, resolver),
+		nil, /* grpcServer */
+		rttc.stopper,
+	)
+	const cooldown = 5 * time.Second
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 123))
+	clientTransport.SetTimeSource(clock)
+	clientTransport.SetResolveErrorCooldown(cooldown)
+	rttc.transports[clientReplica.NodeID] = clientTransport
+	breaker := clientTransport.GetCircuitBreaker(serverReplica.NodeID, rpc.DefaultClass)
+
+	// The first message attempts to resolve the address, and fails. Wait for
+	// the queue to shut down.
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}))
+	testutils.SucceedsSoon(t, func() error {
+		if clientTransport.HasQueue(serverReplica.NodeID, rpc.DefaultClass) {
+			return errors.New("queue still exists")
+		}
+		return nil
+	})
+	require.EqualValues(t, 1, resolves.Load())
+
+	// The rest are dropped during the cooldown, without resolving the address
+	// again, even once the circuit breaker lets them through.
+	breaker.Reset()
+	for i := 0; i < 10; i++ {
+		require.False(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}))
+	}
+	require.EqualValues(t, 1, resolves.Load())
+
+	// Once the server is gossiped and the cooldown has elapsed, messages are
+	// delivered.
+	rttc.GossipNode(serverReplica.NodeID, serverAddr)
+	clock.Advance(cooldown)
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 2}))
+	select {
+	case req := <-serverChannel.ch:
+		require.EqualValues(t, 2, req.Message.Commit)
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for message")
+	}
+	require.EqualValues(t, 2, resolves.Load())
+}
+
+// TestRaftTransportResolveErrorPruning tests that recorded failures to resolve a
+// node's address are pruned once their cooldown has elapsed, even if the node
+// is never sent to again.
+func TestRaftTransportResolveErrorPruning(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+	const cooldown = 5 * time.Second
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 123))
+	clientTransport.SetTimeSource(clock)
+	clientTransport.SetResolveErrorCooldown(cooldown)
+
+	// sendUnresolvable sends a message to the given node, which isn't gossiped,
+	// and waits for its queue to shut down after failing to resolve it.
+	sendUnresolvable := func(nodeID roachpb.NodeID) {
+		to := roachpb.ReplicaDescriptor{
+			NodeID:    nodeID,
+			StoreID:   roachpb.StoreID(nodeID),
+			ReplicaID: roachpb.ReplicaID(nodeID),
+		}
+		require.True(t, rttc.Send(clientReplica, to, 1, raftpb.Message{Commit: 1}))
+		testutils.SucceedsSoon(t, func() error {
+			if clientTransport.HasQueue(nodeID, rpc.DefaultClass) {
+				return errors.New("queue still exists")
+			}
+			return nil
+		})
+		require.True(t, clientTransport.HasResolveError(nodeID))
+	}
+
+	// n2 fails to resolve, e.g. because it has been decommissioned, and is never
+	// sent to again. Once its cooldown has elapsed, the next resolve error
+	// prunes its entry.
+	sendUnresolvable(2)
+	clock.Advance(cooldown)
+	sendUnresolvable(3)
+	require.False(t, clientTransport.HasResolveError(2))
+}
+
+// TestRaftTransportUnreachableMetric tests that sends refused because the
+// recipient node is unreachable are counted, and that sends are no longer
+// refused once the node becomes reachable.
+func TestRaftTransportUnreachableMetric(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	// The server isn't gossiped, so its address can't be resolved.
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	_, serverAddr := rttc.AddNodeWithoutGossip(serverReplica.NodeID, util.TestAddr, rttc.stopper)
+	serverChannel := rttc.ListenStore(serverReplica.NodeID, serverReplica.StoreID)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+	const cooldown = 5 * time.Second
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 123))
+	clientTransport.SetTimeSource(clock)
+	clientTransport.SetResolveErrorCooldown(cooldown)
+	unreachable := clientTransport.Metrics().MessagesUnreachable
+
+	// The first message dials the server, which fails to resolve its address
+	// and marks it unreachable. Wait for sends to be refused.
+	testutils.SucceedsSoon(t, func() error {
+		if rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}) {
+			return errors.New("message not refused")
+		}
+		return nil
+	})
+	refused := unreachable.Count()
+	require.NotZero(t, refused)
+
+	// Every further send is refused and counted, since the cooldown hasn't
+	// elapsed yet.
+	const sends = 10
+	for i := 0; i < sends; i++ {
+		require.False(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}))
+	}
+	require.EqualValues(t, refused+sends, unreachable.Count())
+
+	// Once the server is gossiped and the cooldown has elapsed, messages are
+	// delivered.
+	rttc.GossipNode(serverReplica.NodeID, serverAddr)
+	clock.Advance(cooldown)
+	clientTransport.GetCircuitBreaker(serverReplica.NodeID, rpc.DefaultClass).Reset()
+	require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 2}))
+	select {
+	case req := <-serverChannel.ch:
+		require.EqualValues(t, 2, req.Message.Commit)
+	case <-time.After(testutils.DefaultSucceedsSoonDuration):
+		t.Fatal("timed out waiting for message")
+	}
+
+	// Sends to the recovered server are no longer refused.
+	refused = unreachable.Count()
+	for i := 0; i < sends; i++ {
+		require.True(t, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 3}))
+	}
+	require.Equal(t, refused, unreachable.Count())
+}
+
+// BenchmarkRaftTransport measures the throughput and end-to-end latency of
+// Raft messages sent via RaftTransport between two nodes, for varying message
+// sizes and numbers of messages in flight (i.e. queued or not yet received).
+func BenchmarkRaftTransport(b *testing.B) {
+	defer leaktest.AfterTest(b)()
+	defer log.Scope(b).Close(b)
+
+	for _, msgSize := range []int{64, 1 << 10, 16 << 10} {
+		for _, inflight := range []int{1, 100, 1000} {
+			b.Run(fmt.Sprintf("msgSize=%d/inflight=%d", msgSize, inflight), func(b *testing.B) {
+				benchmarkRaftTransport(b, msgSize, inflight)
+			})
+		}
+	}
+}
+
+func benchmarkRaftTransport(b *testing.B, msgSize, inflight int) {
+	rttc := newRaftTransportTestContext(b)
+	defer rttc.Stop()
+
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	rttc.AddNode(serverReplica.NodeID)
+	serverChannel := newChannelServer(inflight, 0 /* maxSleep */)
+	rttc.transports[serverReplica.NodeID].Listen(serverReplica.StoreID, serverChannel)
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	rttc.AddNode(clientReplica.NodeID)
+
+	// Establish the connection before starting the benchmark.
+	require.True(b, rttc.Send(clientReplica, serverReplica, 1, raftpb.Message{Commit: 1}))
+	<-serverChannel.ch
+
+	data := make([]byte, msgSize)
+	sendTimes := make([]time.Time, b.N)
+	var latency time.Duration
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := timeutil.Now()
+	for sent, received := 0, 0; received < b.N; received++ {
+		for ; sent < b.N && sent-received < inflight; sent++ {
+			sendTimes[sent] = timeutil.Now()
+			msg := raftpb.Message{
+				Type:    raftpb.MsgApp,
+				Index:   uint64(sent),
+				Entries: []raftpb.Entry{{Index: uint64(sent), Data: data}},
+			}
+			if !rttc.Send(clientReplica, serverReplica, 1, msg) {
+				b.Fatal("message dropped")
+			}
+		}
+		req := <-serverChannel.ch
+		latency += timeutil.Since(sendTimes[req.Message.Index])
+	}
+	elapsed := timeutil.Since(start)
+	b.StopTimer()
+
+	b.ReportMetric(float64(b.N)/elapsed.Seconds(), "msgs/s")
+	b.ReportMetric(float64(latency.Nanoseconds())/float64(b.N), "latency-ns/msg")
+}
+
+// snapshotServer is a channelServer which accepts and applies all incoming
+// snapshots, recording the size of each KV batch received.
+type snapshotServer struct {
+	channelServer
+	mu struct {
+		sync.Mutex
+		batches []int
+	}
+}
+
+func (s *snapshotServer) HandleSnapshot(
+	_ context.Context,
+	_ *kvserverpb.SnapshotRequest_Header,
+	stream kvserver.SnapshotResponseStream,
+) error {
+	if err := stream.Send(&kvserverpb.SnapshotResponse{
+		Status: kvserverpb.SnapshotResponse_ACCEPTED,
+	}); err != nil {
+		return err
+	}
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if req.KVBatch != nil {
+			s.mu.Lock()
+			s.mu.batches = append(s.mu.batches, len(req.KVBatch))
+			s.mu.Unlock()
+		}
+		if req.Final {
+			return stream.Send(&kvserverpb.SnapshotResponse{
+				Status: kvserverpb.SnapshotResponse_APPLIED,
+			})
+		}
+	}
+}
+
+// TestRaftTransportSendSnapshotProgress tests that RaftTransport.SendSnapshot
+// calls its onProgress callback with the cumulative bytes sent as each batch
+// of the snapshot is sent to the recipient.
+func TestRaftTransportSendSnapshotProgress(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	ctx := context.Background()
+	rttc := newRaftTransportTestContext(t)
+	defer rttc.Stop()
+
+	clientReplica := roachpb.ReplicaDescriptor{
+		NodeID:    1,
+		StoreID:   1,
+		ReplicaID: 1,
+	}
+	serverReplica := roachpb.ReplicaDescriptor{
+		NodeID:    2,
+		StoreID:   2,
+		ReplicaID: 2,
+	}
+	clientTransport := rttc.AddNode(clientReplica.NodeID)
+	serverTransport := rttc.AddNode(serverReplica.NodeID)
+	server := &snapshotServer{channelServer: newChannelServer(10, 0 /* maxSleep */)}
+	serverTransport.Listen(serverReplica.StoreID, server)
+
+	// Write enough data for the snapshot to be sent in several batches of the
+	// default batch size (256 KB).
+	e := storage.NewDefaultInMemForTesting()
+	defer e.Close()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, e.PutUnversioned(
+			roachpb.Key(fmt.Sprintf("k%03d", i)), bytes.Repeat([]byte{'v'}, 10<<10)))
+	}
+	header := kvserverpb.SnapshotRequest_Header{
+		State: kvserverpb.ReplicaState{
+			Desc: &roachpb.RangeDescriptor{
+				RangeID: 1, StartKey: roachpb.RKey("a"), EndKey: roachpb.RKey("z"),
+			},
+		},
+		RaftMessageRequest: kvserverpb.RaftMessageRequest{
+			RangeID:     1,
+			FromReplica: clientReplica,
+			ToReplica:   serverReplica,
+		},
+	}
+	snap := &kvserver.OutgoingSnapshot{EngineSnap: e.NewSnapshot(), State: header.State}
+	defer snap.EngineSnap.Close()
+
+	var recorded int64
+	var progress []int64
+	require.NoError(t, clientTransport.SendSnapshot(
+		ctx, nil /* storePool */, header, snap, e.NewWriteBatch, func() {},
+		func(inc int64) { recorded += inc },
+		func(bytesSent int64) { progress = append(progress, bytesSent) },
+	))
+
+	// The callback is called once per batch received by the recipient, with
+	// the cumulative size of the batches sent so far.
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	require.Greater(t, len(server.mu.batches), 1)
+	require.Len(t, progress, len(server.mu.batches))
+	var bytesSent int64
+	for i, size := range server.mu.batches {
+		bytesSent += int64(size)
+		require.Equal(t, bytesSent, progress[i])
+	}
+	require.Equal(t, recorded, bytesSent)
+}
+
 // This test ensures that blocking by a node dialer attempting to dial a
 // remote node does not block calls to SendAsync.
 func TestSendFailureToConnectDoesNotHangRaft(t *testing.T) {
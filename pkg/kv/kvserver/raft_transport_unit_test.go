@@ -12,6 +12,7 @@ package kvserver
 
 import (
 	"context"
+	"math"
 	"math/rand"
 	"net"
 	"sync"
@@ -19,6 +20,7 @@ import (
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvserverpb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/rpc"
 	"github.com/cockroachdb/cockroach/pkg/rpc/nodedialer"
@@ -26,12 +28,14 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
 	"github.com/cockroachdb/cockroach/pkg/util/netutil"
 	"github.com/cockroachdb/cockroach/pkg/util/stop"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
+	"go.etcd.io/raft/v3/raftpb"
 )
 
 func TestRaftTransportStartNewQueue(t *testing.T) {
@@ -108,7 +112,182 @@ func TestRaftTransportStartNewQueue(t *testing.T) {
 		ln = nil
 		wg.Done()
 	}()
-	tp.startProcessNewQueue(ctxBoom, 1, rpc.SystemClass)
+	tp.startProcessNewQueue(ctxBoom, roachpb.ReplicaDescriptor{NodeID: 1}, rpc.SystemClass)
 
 	wg.Wait()
 }
+
+// blockingSnapshotStream is an outgoing snapshot stream which accepts the
+// snapshot header, and then blocks each KV batch send and each subsequent
+// receive until it is released or the stream's context is canceled.
+type blockingSnapshotStream struct {
+	ctx      context.Context
+	accepted bool
+	// blockedC receives a value when an operation blocks, and releaseC
+	// releases it.
+	blockedC chan struct{}
+	releaseC chan struct{}
+}
+
+func (s *blockingSnapshotStream) block() error {
+	s.blockedC <- struct{}{}
+	select {
+	case <-s.releaseC:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *blockingSnapshotStream) Send(req *kvserverpb.SnapshotRequest) error {
+	if req.KVBatch == nil {
+		return s.ctx.Err()
+	}
+	return s.block()
+}
+
+func (s *blockingSnapshotStream) Recv() (*kvserverpb.SnapshotResponse, error) {
+	if !s.accepted {
+		s.accepted = true
+		return &kvserverpb.SnapshotResponse{Status: kvserverpb.SnapshotResponse_ACCEPTED}, nil
+	}
+	if err := s.block(); err != nil {
+		return nil, err
+	}
+	return &kvserverpb.SnapshotResponse{Status: kvserverpb.SnapshotResponse_APPLIED}, nil
+}
+
+// newTimeoutSnapshotStream returns a snapshotTimeoutStream wrapping a
+// blockingSnapshotStream with a manual clock, which has accepted the snapshot.
+// The returned function cancels the stream and stops its watchdog.
+func newTimeoutSnapshotStream(
+	t *testing.T, timeout time.Duration,
+) (*snapshotTimeoutStream, *blockingSnapshotStream, *timeutil.ManualTime, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopper := stop.NewStopper()
+	inner := &blockingSnapshotStream{
+		ctx:      ctx,
+		blockedC: make(chan struct{}),
+		releaseC: make(chan struct{}),
+	}
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 123))
+	stream := &snapshotTimeoutStream{
+		outgoingSnapshotStream: inner,
+		timeSource:             clock,
+		timeout:                timeout,
+		cancel:                 cancel,
+	}
+	require.NoError(t, stream.start(ctx, stopper))
+	require.NoError(t, stream.Send(&kvserverpb.SnapshotRequest{Header: &kvserverpb.SnapshotRequest_Header{}}))
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, kvserverpb.SnapshotResponse_ACCEPTED, resp.Status)
+	return stream, inner, clock, func() {
+		cancel()
+		stopper.Stop(context.Background())
+	}
+}
+
+// TestSnapshotTimeoutStream tests that an outgoing snapshot stream is canceled
+// with an error when the recipient hangs after accepting the snapshot.
+func TestSnapshotTimeoutStream(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	stream, inner, clock, cancel := newTimeoutSnapshotStream(t, time.Minute)
+	defer cancel()
+
+	// A batch which is sent within the timeout succeeds.
+	errC := make(chan error, 1)
+	go func() { errC <- stream.Send(&kvserverpb.SnapshotRequest{KVBatch: []byte("a")}) }()
+	<-inner.blockedC
+	clock.Advance(30 * time.Second)
+	inner.releaseC <- struct{}{}
+	require.NoError(t, <-errC)
+
+	// The recipient hangs on the next batch, so the timeout fires and cancels
+	// the stream.
+	go func() { errC <- stream.Send(&kvserverpb.SnapshotRequest{KVBatch: []byte("b")}) }()
+	<-inner.blockedC
+	clock.Advance(time.Minute)
+	err := <-errC
+	require.Error(t, err)
+	require.True(t, errors.Is(err, context.Canceled), "%+v", err)
+	require.Contains(t, err.Error(), "snapshot stream made no progress for 1m0s")
+
+	// The stream stays canceled.
+	require.Error(t, stream.Send(&kvserverpb.SnapshotRequest{Final: true}))
+}
+
+// TestSnapshotTimeoutStreamSlowApply tests that the timeout of an outgoing
+// snapshot stream doesn't apply while the recipient applies the snapshot,
+// which may legitimately take longer than the timeout.
+func TestSnapshotTimeoutStreamSlowApply(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	stream, inner, clock, cancel := newTimeoutSnapshotStream(t, time.Minute)
+	defer cancel()
+
+	errC := make(chan error, 1)
+	go func() { errC <- stream.Send(&kvserverpb.SnapshotRequest{KVBatch: []byte("a")}) }()
+	<-inner.blockedC
+	inner.releaseC <- struct{}{}
+	require.NoError(t, <-errC)
+	require.NoError(t, stream.Send(&kvserverpb.SnapshotRequest{Final: true}))
+
+	// The recipient takes much longer than the timeout to apply the snapshot,
+	// but the stream isn't canceled.
+	respC := make(chan *kvserverpb.SnapshotResponse, 1)
+	go func() {
+		resp, err := stream.Recv()
+		errC <- err
+		respC <- resp
+	}()
+	<-inner.blockedC
+	require.Empty(t, clock.Timers())
+	clock.Advance(time.Hour)
+	inner.releaseC <- struct{}{}
+	require.NoError(t, <-errC)
+	require.Equal(t, kvserverpb.SnapshotResponse_APPLIED, (<-respC).Status)
+}
+
+// TestSnapshotTimeoutStreamDisabled tests that an outgoing snapshot stream
+// without a timeout doesn't run a watchdog, and is never canceled.
+func TestSnapshotTimeoutStreamDisabled(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	stream, inner, clock, cancel := newTimeoutSnapshotStream(t, 0)
+	defer cancel()
+
+	errC := make(chan error, 1)
+	go func() { errC <- stream.Send(&kvserverpb.SnapshotRequest{KVBatch: []byte("a")}) }()
+	<-inner.blockedC
+	require.Empty(t, clock.Timers())
+	clock.Advance(time.Hour)
+	inner.releaseC <- struct{}{}
+	require.NoError(t, <-errC)
+}
+
+// TestRecordMessageType tests that recordMessageType only counts tracked
+// message types, and ignores untracked and out-of-range ones.
+func TestRecordMessageType(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	var counters [maxRaftMsgType + 1]*metric.Counter
+	counters[raftpb.MsgApp] = metric.NewCounter(metric.Metadata{Name: "app"})
+
+	recordMessageType(&counters, raftpb.MsgApp)
+	recordMessageType(&counters, raftpb.MsgHup)
+	recordMessageType(&counters, maxRaftMsgType+1)
+	recordMessageType(&counters, raftpb.MessageType(math.MaxInt32))
+	recordMessageType(&counters, raftpb.MessageType(-1))
+	require.EqualValues(t, 1, counters[raftpb.MsgApp].Count())
+	for typ, c := range counters {
+		if raftpb.MessageType(typ) != raftpb.MsgApp {
+			require.Nil(t, c)
+		}
+	}
+}
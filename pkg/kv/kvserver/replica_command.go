@@ -40,6 +40,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
 	"github.com/cockroachdb/cockroach/pkg/util/envutil"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
 	"github.com/cockroachdb/cockroach/pkg/util/retry"
@@ -3150,6 +3151,9 @@ func (r *Replica) followerSendSnapshot(
 				newBatchFn,
 				sent,
 				recordBytesSent,
+				func(bytesSent int64) {
+					log.VEventf(ctx, 3, "sent %s of snapshot", humanizeutil.IBytes(bytesSent))
+				},
 			)
 		},
 	)
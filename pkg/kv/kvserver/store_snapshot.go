@@ -104,6 +104,24 @@ type outgoingSnapshotStream interface {
 // function specifies which metrics are incremented.
 type snapshotRecordMetrics func(inc int64)
 
+// withSnapshotProgress returns a snapshotRecordMetrics which records the sent
+// bytes via record, if non-nil, and then calls onProgress, if non-nil, with the
+// total number of bytes sent so far. It is called as each batch is sent.
+func withSnapshotProgress(
+	record snapshotRecordMetrics, onProgress func(bytesSent int64),
+) snapshotRecordMetrics {
+	var bytesSent int64
+	return func(inc int64) {
+		if record != nil {
+			record(inc)
+		}
+		bytesSent += inc
+		if onProgress != nil {
+			onProgress(bytesSent)
+		}
+	}
+}
+
 // snapshotStrategy is an approach to sending and receiving Range snapshots.
 // Each implementation corresponds to a SnapshotRequest_Strategy, and it is
 // expected that the implementation that matches the Strategy specified in the
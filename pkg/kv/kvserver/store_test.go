@@ -3130,6 +3130,81 @@ func TestSendSnapshotThrottling(t *testing.T) {
 	}
 }
 
+// chunkedSnapshotStream is a fake outgoing snapshot stream which accepts the
+// snapshot, counts the KV batches sent, and then fails the final response with
+// finalErr.
+type chunkedSnapshotStream struct {
+	recvs    int
+	batches  int
+	finalErr error
+}
+
+func (c *chunkedSnapshotStream) Recv() (*kvserverpb.SnapshotResponse, error) {
+	c.recvs++
+	if c.recvs == 1 {
+		return &kvserverpb.SnapshotResponse{Status: kvserverpb.SnapshotResponse_ACCEPTED}, nil
+	}
+	return nil, c.finalErr
+}
+
+func (c *chunkedSnapshotStream) Send(req *kvserverpb.SnapshotRequest) error {
+	if req.KVBatch != nil {
+		c.batches++
+	}
+	return nil
+}
+
+// TestSendSnapshotProgress tests that the progress callback installed by
+// withSnapshotProgress is called with the cumulative bytes sent as each batch of
+// a snapshot is sent, and that the final error is returned to the sender.
+func TestSendSnapshotProgress(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+	e := storage.NewDefaultInMemForTesting()
+	defer e.Close()
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	snapshotSenderBatchSize.Override(ctx, &st.SV, 4<<10)
+	tr := tracing.NewTracer()
+
+	// Write enough data for the snapshot to be sent in several batches.
+	for i := 0; i < 100; i++ {
+		require.NoError(t, e.PutUnversioned(
+			roachpb.Key(fmt.Sprintf("k%03d", i)), bytes.Repeat([]byte{'v'}, 1<<10)))
+	}
+	header := kvserverpb.SnapshotRequest_Header{
+		State: kvserverpb.ReplicaState{
+			Desc: &roachpb.RangeDescriptor{
+				RangeID: 1, StartKey: roachpb.RKey("a"), EndKey: roachpb.RKey("z"),
+			},
+		},
+	}
+	snap := &OutgoingSnapshot{EngineSnap: e.NewSnapshot(), State: header.State}
+	defer snap.EngineSnap.Close()
+
+	var recorded int64
+	var progress []int64
+	finalErr := errors.New("boom")
+	stream := &chunkedSnapshotStream{finalErr: finalErr}
+	err := sendSnapshot(
+		ctx, st, tr, stream, &fakeStorePool{}, header, snap, e.NewWriteBatch, func() {},
+		withSnapshotProgress(
+			func(inc int64) { recorded += inc },
+			func(bytesSent int64) { progress = append(progress, bytesSent) },
+		),
+	)
+	require.True(t, errors.Is(err, finalErr), "expected %v, got %v", finalErr, err)
+	require.Contains(t, err.Error(), "remote failed to apply snapshot")
+
+	require.Greater(t, stream.batches, 1)
+	require.Len(t, progress, stream.batches)
+	for i := 1; i < len(progress); i++ {
+		require.Greater(t, progress[i], progress[i-1])
+	}
+	require.Equal(t, recorded, progress[len(progress)-1])
+}
+
 // TestSendSnapshotConcurrency tests the sending of concurrent snapshots and
 // verifies they are only sent "2 at a time". This is not intended to test the
 // prioritization of the snapshots as that is covered by the multi-queue
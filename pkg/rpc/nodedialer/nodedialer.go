@@ -96,6 +96,16 @@ func (n *Dialer) Stopper() *stop.Stopper {
 // Silence lint warning because this method is only used in race builds.
 var _ = (*Dialer).Stopper
 
+// errResolve is marked on errors returned when a node's address could not be
+// resolved.
+var errResolve = errors.New("failed to resolve node address")
+
+// IsResolveError returns true if the error was returned because the node's
+// address could not be resolved, e.g. because it's not yet in gossip.
+func IsResolveError(err error) bool {
+	return errors.Is(err, errResolve)
+}
+
 // Dial returns a grpc connection to the given node. It logs whenever the
 // node first becomes unreachable or reachable.
 func (n *Dialer) Dial(
@@ -111,7 +121,7 @@ func (n *Dialer) Dial(
 	breaker := n.getBreaker(nodeID, class)
 	addr, err := n.resolver(nodeID)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to resolve n%d", nodeID)
+		err = errors.Mark(errors.Wrapf(err, "failed to resolve n%d", nodeID), errResolve)
 		breaker.Fail(err)
 		return nil, err
 	}
@@ -130,6 +140,7 @@ func (n *Dialer) DialNoBreaker(
 	}
 	addr, err := n.resolver(nodeID)
 	if err != nil {
+		err = errors.Mark(err, errResolve)
 		if ctx.Err() == nil {
 			n.getBreaker(nodeID, class).Fail(err)
 		}
@@ -0,0 +1,476 @@
+// Copyright 2023 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package upgrades
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/clusterversion"
+	"github.com/cockroachdb/cockroach/pkg/config/zonepb"
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/catid"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/upgrade"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/errors"
+)
+
+// preconditionChecks are the checks run by preconditionBeforeStartingAnUpgrade.
+// Each check inspects some part of the cluster's state which, if corrupt or
+// stale, could cause the upgrade to fail part-way through.
+var preconditionChecks = []struct {
+	name string
+	fn   upgrade.PreconditionFunc
+}{
+	{name: "zone config localities", fn: checkZoneConfigLocalities},
+	{name: "namespace collisions", fn: checkNamespaceCollisions},
+	{name: "descriptors", fn: checkDescriptors},
+}
+
+// descriptorPreconditionChecks are the checks, run as part of the
+// preconditionChecks, which only inspect the descriptors in the catalog. They
+// can also be run against a descriptor snapshot using
+// RunDescriptorPreconditions.
+var descriptorPreconditionChecks = []struct {
+	name string
+	fn   func(descs []*descpb.Descriptor) (violations []string)
+}{
+	{name: "descriptor name collisions", fn: descriptorNameCollisions},
+	{name: "orphaned mutations", fn: orphanedMutations},
+	{name: "dangling type references", fn: danglingTypeReferences},
+	{name: "orphaned sequence owners", fn: orphanedSequenceOwners},
+}
+
+// RunDescriptorPreconditions runs the descriptor-oriented upgrade preconditions
+// against the given descriptors, for example ones loaded from a descriptor
+// dump, and returns the violations found, each prefixed with the name of the
+// check which found it.
+func RunDescriptorPreconditions(descs []*descpb.Descriptor) (violations []string) {
+	for _, check := range descriptorPreconditionChecks {
+		for _, v := range check.fn(descs) {
+			violations = append(violations, check.name+": "+v)
+		}
+	}
+	return violations
+}
+
+// preconditionBeforeStartingAnUpgrade runs all of the preconditionChecks and
+// returns the error of the first one that fails.
+func preconditionBeforeStartingAnUpgrade(
+	ctx context.Context, cv clusterversion.ClusterVersion, deps upgrade.TenantDeps,
+) error {
+	for _, check := range preconditionChecks {
+		if err := check.fn(ctx, cv, deps); err != nil {
+			return errors.Wrapf(err, "checking %s", check.name)
+		}
+	}
+	return nil
+}
+
+// violationsError returns nil if there are no violations, and otherwise an
+// error listing them after msg, with the given hint.
+func violationsError(violations []string, msg string, hint string) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	return errors.WithHint(
+		pgerror.Newf(pgcode.ObjectNotInPrerequisiteState,
+			"%s: %s", msg, strings.Join(violations, ", ")),
+		hint,
+	)
+}
+
+// checkZoneConfigLocalities verifies that every required constraint and lease
+// preference in the cluster's zone configs is satisfiable by at least one of
+// the existing stores. Such references become stale when the nodes they
+// referred to are decommissioned or restarted with different localities or
+// attributes, as they are only validated when the zone config is set.
+//
+// Like the validation performed when setting a zone config, prohibited
+// constraints are not checked, as it is reasonable to prohibit localities
+// which don't exist (yet).
+func checkZoneConfigLocalities(
+	ctx context.Context, _ clusterversion.ClusterVersion, deps upgrade.TenantDeps,
+) error {
+	// Only the system tenant has access to the node and store descriptors.
+	if !deps.Codec.ForSystemTenant() {
+		return nil
+	}
+	stores, err := collectStoreDescriptors(ctx, deps)
+	if err != nil {
+		return err
+	}
+	rows, err := deps.InternalExecutor.QueryBufferedEx(
+		ctx, "check-zone-config-localities", nil, /* txn */
+		sessiondata.NodeUserSessionDataOverride,
+		`SELECT COALESCE(target, 'zone ' || zone_id::STRING), raw_config_protobuf FROM crdb_internal.zones`,
+	)
+	if err != nil {
+		return err
+	}
+	var violations []string
+	for _, row := range rows {
+		target := string(tree.MustBeDString(row[0]))
+		var zone zonepb.ZoneConfig
+		if err := protoutil.Unmarshal([]byte(tree.MustBeDBytes(row[1])), &zone); err != nil {
+			return errors.Wrapf(err, "decoding zone config for %s", target)
+		}
+		for _, c := range zoneConfigRequiredConstraints(&zone) {
+			if !constraintSatisfiable(stores, c) {
+				violations = append(violations, target+": "+c.String())
+			}
+		}
+	}
+	return violationsError(violations,
+		"zone configs reference localities or attributes which match no existing stores",
+		"Update or remove the listed constraints and lease preferences "+
+			"before upgrading the cluster.")
+}
+
+// collectStoreDescriptors returns a descriptor, populated with its attributes
+// and its node's attributes and locality, for every store in the cluster.
+func collectStoreDescriptors(
+	ctx context.Context, deps upgrade.TenantDeps,
+) ([]roachpb.StoreDescriptor, error) {
+	rows, err := deps.InternalExecutor.QueryBufferedEx(
+		ctx, "collect-store-localities", nil, /* txn */
+		sessiondata.NodeUserSessionDataOverride, `
+SELECT n.locality, n.attrs::STRING, s.attrs::STRING
+  FROM crdb_internal.kv_store_status AS s
+  JOIN crdb_internal.kv_node_status AS n USING (node_id)`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	stores := make([]roachpb.StoreDescriptor, 0, len(rows))
+	for _, row := range rows {
+		var store roachpb.StoreDescriptor
+		if locality := string(tree.MustBeDString(row[0])); locality != "" {
+			if err := store.Node.Locality.Set(locality); err != nil {
+				return nil, errors.Wrapf(err, "parsing locality %q", locality)
+			}
+		}
+		for i, attrs := range []*roachpb.Attributes{&store.Node.Attrs, &store.Attrs} {
+			if err := json.Unmarshal([]byte(tree.MustBeDString(row[i+1])), &attrs.Attrs); err != nil {
+				return nil, errors.Wrap(err, "parsing attributes")
+			}
+		}
+		stores = append(stores, store)
+	}
+	return stores, nil
+}
+
+// zoneConfigRequiredConstraints returns the unique required constraints of the
+// zone's replica constraints, voter constraints and lease preferences.
+func zoneConfigRequiredConstraints(zone *zonepb.ZoneConfig) []zonepb.Constraint {
+	var constraints []zonepb.Constraint
+	add := func(cs []zonepb.Constraint) {
+		for _, c := range cs {
+			if c.Type != zonepb.Constraint_REQUIRED {
+				continue
+			}
+			var seen bool
+			for _, existing := range constraints {
+				if existing == c {
+					seen = true
+					break
+				}
+			}
+			if !seen {
+				constraints = append(constraints, c)
+			}
+		}
+	}
+	for _, conj := range zone.Constraints {
+		add(conj.Constraints)
+	}
+	for _, conj := range zone.VoterConstraints {
+		add(conj.Constraints)
+	}
+	for _, pref := range zone.LeasePreferences {
+		add(pref.Constraints)
+	}
+	return constraints
+}
+
+// constraintSatisfiable returns whether any of the stores satisfies c.
+func constraintSatisfiable(stores []roachpb.StoreDescriptor, c zonepb.Constraint) bool {
+	for _, store := range stores {
+		if zonepb.StoreSatisfiesConstraint(store, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNamespaceCollisions verifies that no two system.namespace entries
+// reference the same descriptor. This would make name resolution ambiguous for
+// upgrades which look up or rewrite descriptors. Collisions between the names
+// of the descriptors themselves are detected by checkDescriptors.
+func checkNamespaceCollisions(
+	ctx context.Context, _ clusterversion.ClusterVersion, deps upgrade.TenantDeps,
+) error {
+	violations, err := collectNamespaceIDCollisions(ctx, deps)
+	if err != nil {
+		return err
+	}
+	return violationsError(violations,
+		"catalog contains colliding namespace entries",
+		"Remove the spurious namespace entries, for example using "+
+			"crdb_internal.unsafe_delete_namespace_entry, before upgrading the cluster.")
+}
+
+// checkDescriptors runs the descriptorPreconditionChecks against all of the
+// descriptors in the catalog.
+func checkDescriptors(
+	ctx context.Context, _ clusterversion.ClusterVersion, deps upgrade.TenantDeps,
+) error {
+	descs, err := collectDescriptors(ctx, deps)
+	if err != nil {
+		return err
+	}
+	return violationsError(RunDescriptorPreconditions(descs),
+		"catalog contains invalid descriptors",
+		"Repair or remove the listed descriptors before upgrading the cluster.")
+}
+
+// collectNamespaceIDCollisions returns a violation for every pair of
+// system.namespace entries referencing the same descriptor ID. The pseudo
+// public schema ID is excluded, as it is legitimately shared.
+func collectNamespaceIDCollisions(
+	ctx context.Context, deps upgrade.TenantDeps,
+) (violations []string, retErr error) {
+	it, err := deps.InternalExecutor.QueryIteratorEx(
+		ctx, "collect-namespace-id-collisions", nil, /* txn */
+		sessiondata.NodeUserSessionDataOverride, `
+SELECT a.id,
+       a."parentID", a."parentSchemaID", a.name,
+       b."parentID", b."parentSchemaID", b.name
+  FROM system.namespace AS a
+  JOIN system.namespace AS b
+    ON a.id = b.id
+   AND (a."parentID", a."parentSchemaID", a.name) < (b."parentID", b."parentSchemaID", b.name)
+ WHERE a.id != $1
+ ORDER BY a.id`, keys.PublicSchemaID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { retErr = errors.CombineErrors(retErr, it.Close()) }()
+	var ok bool
+	for ok, err = it.Next(ctx); ok; ok, err = it.Next(ctx) {
+		row := it.Cur()
+		violations = append(violations, fmt.Sprintf(
+			"namespace entries (%d, %d, %q) and (%d, %d, %q) both reference descriptor %d",
+			tree.MustBeDInt(row[1]), tree.MustBeDInt(row[2]), tree.MustBeDString(row[3]),
+			tree.MustBeDInt(row[4]), tree.MustBeDInt(row[5]), tree.MustBeDString(row[6]),
+			tree.MustBeDInt(row[0])))
+	}
+	return violations, err
+}
+
+// collectDescriptors returns all of the descriptors in system.descriptor.
+func collectDescriptors(
+	ctx context.Context, deps upgrade.TenantDeps,
+) (descs []*descpb.Descriptor, retErr error) {
+	it, err := deps.InternalExecutor.QueryIteratorEx(
+		ctx, "collect-descriptors", nil, /* txn */
+		sessiondata.NodeUserSessionDataOverride,
+		`SELECT id, descriptor FROM system.descriptor ORDER BY id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { retErr = errors.CombineErrors(retErr, it.Close()) }()
+	var ok bool
+	for ok, err = it.Next(ctx); ok; ok, err = it.Next(ctx) {
+		row := it.Cur()
+		var desc descpb.Descriptor
+		if err := protoutil.Unmarshal([]byte(tree.MustBeDBytes(row[1])), &desc); err != nil {
+			return nil, errors.Wrapf(err, "decoding descriptor %d", tree.MustBeDInt(row[0]))
+		}
+		descs = append(descs, &desc)
+	}
+	return descs, err
+}
+
+// descriptorNameCollisions returns a violation for every pair of live
+// descriptors which share the same name under the same parent database and
+// schema. Functions are excluded as they may be overloaded, and dropped
+// descriptors are excluded as they no longer have namespace entries.
+func descriptorNameCollisions(descs []*descpb.Descriptor) (violations []string) {
+	type nameKey struct {
+		parentID, parentSchemaID descpb.ID
+		name                     string
+	}
+	seen := make(map[nameKey]descpb.ID)
+	for _, desc := range descs {
+		var key nameKey
+		var id descpb.ID
+		var state descpb.DescriptorState
+		table, database, typ, schema, _ := descpb.GetDescriptors(desc)
+		switch {
+		case table != nil:
+			id, state = table.ID, table.State
+			key = nameKey{table.ParentID, table.GetUnexposedParentSchemaID(), table.Name}
+			// Tables created before the field was added belong to the public
+			// physical schema.
+			if key.parentSchemaID == descpb.InvalidID {
+				key.parentSchemaID = keys.PublicSchemaID
+			}
+		case database != nil:
+			id, state = database.ID, database.State
+			key = nameKey{name: database.Name}
+		case typ != nil:
+			id, state = typ.ID, typ.State
+			key = nameKey{typ.ParentID, typ.ParentSchemaID, typ.Name}
+		case schema != nil:
+			id, state = schema.ID, schema.State
+			key = nameKey{parentID: schema.ParentID, name: schema.Name}
+		default:
+			continue
+		}
+		if state == descpb.DescriptorState_DROP {
+			continue
+		}
+		if other, ok := seen[key]; ok {
+			violations = append(violations, fmt.Sprintf(
+				"descriptors %d and %d are both named %q under parent (%d, %d)",
+				other, id, key.name, key.parentID, key.parentSchemaID))
+			continue
+		}
+		seen[key] = id
+	}
+	return violations
+}
+
+// orphanedMutations returns a violation for every mutation of a live table
+// which was enqueued by the legacy schema changer, but has no schema change job
+// to complete it. Such mutations are left behind by an improperly aborted
+// schema change, and can never be completed or rolled back. Tables undergoing
+// a declarative schema change are skipped, as their mutations are tracked by
+// the declarative schema changer's state instead.
+func orphanedMutations(descs []*descpb.Descriptor) (violations []string) {
+	for _, desc := range descs {
+		table := desc.GetTable()
+		if table == nil || table.State == descpb.DescriptorState_DROP ||
+			table.DeclarativeSchemaChangerState != nil {
+			continue
+		}
+		jobs := make(map[descpb.MutationID]bool, len(table.MutationJobs))
+		for _, mj := range table.MutationJobs {
+			jobs[mj.MutationID] = true
+		}
+		reported := make(map[descpb.MutationID]bool)
+		for _, m := range table.Mutations {
+			if jobs[m.MutationID] || reported[m.MutationID] {
+				continue
+			}
+			reported[m.MutationID] = true
+			violations = append(violations, fmt.Sprintf(
+				"table %s (%d) has mutation %d without a schema change job",
+				table.Name, table.ID, m.MutationID))
+		}
+	}
+	return violations
+}
+
+// danglingTypeReferences returns a violation for every column of a live table
+// whose type is a user-defined type without a live type descriptor. Such
+// references are left behind when a type is dropped improperly, and break
+// upgrades which resolve the table's column types.
+func danglingTypeReferences(descs []*descpb.Descriptor) (violations []string) {
+	liveTypes := make(map[descpb.ID]bool)
+	for _, desc := range descs {
+		if typ := desc.GetType(); typ != nil && typ.State != descpb.DescriptorState_DROP {
+			liveTypes[typ.ID] = true
+		}
+	}
+	for _, desc := range descs {
+		table := desc.GetTable()
+		if table == nil || table.State == descpb.DescriptorState_DROP {
+			continue
+		}
+		columns := append([]descpb.ColumnDescriptor(nil), table.Columns...)
+		for _, m := range table.Mutations {
+			if col := m.GetColumn(); col != nil {
+				columns = append(columns, *col)
+			}
+		}
+		for _, col := range columns {
+			if col.Type == nil || !col.Type.UserDefined() {
+				continue
+			}
+			if typeID := catid.UserDefinedOIDToID(col.Type.Oid()); !liveTypes[typeID] {
+				violations = append(violations, fmt.Sprintf(
+					"column %s.%s (table %d) references missing type %d",
+					table.Name, col.Name, table.ID, typeID))
+			}
+		}
+	}
+	return violations
+}
+
+// orphanedSequenceOwners returns a violation for every live sequence which is
+// owned by a table or column that doesn't exist. Such ownership references are
+// left behind when the owning table or column is dropped improperly, and break
+// upgrades which resolve the sequence's owner.
+func orphanedSequenceOwners(descs []*descpb.Descriptor) (violations []string) {
+	liveTables := make(map[descpb.ID]*descpb.TableDescriptor)
+	for _, desc := range descs {
+		if table := desc.GetTable(); table != nil && table.State != descpb.DescriptorState_DROP {
+			liveTables[table.ID] = table
+		}
+	}
+	hasColumn := func(table *descpb.TableDescriptor, id descpb.ColumnID) bool {
+		for i := range table.Columns {
+			if table.Columns[i].ID == id {
+				return true
+			}
+		}
+		for _, m := range table.Mutations {
+			if col := m.GetColumn(); col != nil && col.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	for _, desc := range descs {
+		seq := desc.GetTable()
+		if seq == nil || seq.State == descpb.DescriptorState_DROP || !seq.IsSequence() {
+			continue
+		}
+		if !seq.SequenceOpts.HasOwner() {
+			continue
+		}
+		owner := seq.SequenceOpts.SequenceOwner
+		table, ok := liveTables[owner.OwnerTableID]
+		switch {
+		case !ok:
+			violations = append(violations, fmt.Sprintf(
+				"sequence %s (%d) is owned by missing table %d",
+				seq.Name, seq.ID, owner.OwnerTableID))
+		case !hasColumn(table, owner.OwnerColumnID):
+			violations = append(violations, fmt.Sprintf(
+				"sequence %s (%d) is owned by missing column %d of table %s (%d)",
+				seq.Name, seq.ID, owner.OwnerColumnID, table.Name, table.ID))
+		}
+	}
+	return violations
+}
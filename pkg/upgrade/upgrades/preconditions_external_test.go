@@ -0,0 +1,353 @@
+// Copyright 2023 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package upgrades_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/clusterversion"
+	"github.com/cockroachdb/cockroach/pkg/config/zonepb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/server"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/catid"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/testutils/serverutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/upgrade/upgrades"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/stretchr/testify/require"
+)
+
+// startPreconditionTestServer starts a server at the minimum supported version
+// which can be upgraded to V23_2Start, which runs the upgrade preconditions.
+func startPreconditionTestServer(
+	t *testing.T, args base.TestServerArgs,
+) (serverutils.TestServerInterface, *sqlutils.SQLRunner) {
+	var (
+		v0 = clusterversion.TestingBinaryMinSupportedVersion
+		v1 = clusterversion.ByKey(clusterversion.V23_2Start)
+	)
+	ctx := context.Background()
+	settings := cluster.MakeTestingClusterSettingsWithVersions(v1, v0, false /* initializeVersion */)
+	require.NoError(t, clusterversion.Initialize(ctx, v0, &settings.SV))
+	args.Settings = settings
+	args.Knobs.Server = &server.TestingKnobs{
+		DisableAutomaticVersionUpgrade: make(chan struct{}),
+		BinaryVersionOverride:          v0,
+	}
+	s, sqlDB, _ := serverutils.StartServer(t, args)
+	return s, sqlutils.MakeSQLRunner(sqlDB)
+}
+
+func TestPreconditionZoneConfigLocalities(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	s, tdb := startPreconditionTestServer(t, base.TestServerArgs{
+		Locality: roachpb.Locality{Tiers: []roachpb.Tier{{Key: "region", Value: "us-east1"}}},
+	})
+	defer s.Stopper().Stop(ctx)
+
+	tdb.Exec(t, "CREATE DATABASE db")
+	tdb.Exec(t, "ALTER DATABASE db CONFIGURE ZONE USING constraints = '[+region=us-east1]'")
+
+	// Setting a zone config validates its constraints against the current
+	// nodes, so write the stale constraint directly, as if the node with the
+	// referenced locality had since been removed.
+	var dbID int
+	tdb.QueryRow(t,
+		`SELECT zone_id FROM crdb_internal.zones WHERE target = 'DATABASE db'`,
+	).Scan(&dbID)
+	var zone zonepb.ZoneConfig
+	decodeProto(t, tdb, &zone,
+		`SELECT raw_config_protobuf FROM crdb_internal.zones WHERE zone_id = $1`, dbID)
+	zone.Constraints = []zonepb.ConstraintsConjunction{{
+		Constraints: []zonepb.Constraint{{
+			Type: zonepb.Constraint_REQUIRED, Key: "region", Value: "us-west1",
+		}},
+	}}
+	raw, err := protoutil.Marshal(&zone)
+	require.NoError(t, err)
+	tdb.Exec(t, `UPSERT INTO system.zones (id, config) VALUES ($1, $2)`, dbID, raw)
+
+	tdb.ExpectErr(t,
+		`verifying precondition for version .*: checking zone config localities: `+
+			`zone configs reference localities or attributes which match no existing stores: `+
+			`DATABASE db: \+region=us-west1`,
+		"SET CLUSTER SETTING version = crdb_internal.node_executable_version()")
+
+	tdb.Exec(t, "ALTER DATABASE db CONFIGURE ZONE USING constraints = '[+region=us-east1]'")
+	tdb.Exec(t, "SET CLUSTER SETTING version = crdb_internal.node_executable_version()")
+}
+
+// decodeProto decodes the protobuf-encoded value returned by the given
+// single-row, single-column query into msg.
+func decodeProto(
+	t *testing.T, tdb *sqlutils.SQLRunner, msg protoutil.Message, query string, args ...interface{},
+) {
+	var raw []byte
+	tdb.QueryRow(t, query, args...).Scan(&raw)
+	require.NoError(t, protoutil.Unmarshal(raw, msg))
+}
+
+// injectNamespaceEntry upserts a system.namespace entry mapping the given name
+// to id, bypassing all validation, as may happen in a corrupt catalog.
+func injectNamespaceEntry(
+	t *testing.T, tdb *sqlutils.SQLRunner, parentID, parentSchemaID int, name string, id int,
+) {
+	tdb.Exec(t, `SELECT crdb_internal.unsafe_upsert_namespace_entry($1, $2, $3, $4, true /* force */)`,
+		parentID, parentSchemaID, name, id)
+}
+
+func TestPreconditionNamespaceCollisions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	s, tdb := startPreconditionTestServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(ctx)
+
+	tdb.Exec(t, "CREATE DATABASE db")
+	tdb.Exec(t, "CREATE TABLE db.foo (i INT PRIMARY KEY)")
+	var parentID, parentSchemaID, fooID int
+	tdb.QueryRow(t,
+		`SELECT "parentID", "parentSchemaID", id FROM system.namespace WHERE name = 'foo'`,
+	).Scan(&parentID, &parentSchemaID, &fooID)
+
+	// Add a second name for db.foo.
+	injectNamespaceEntry(t, tdb, parentID, parentSchemaID, "bar", fooID)
+	tdb.ExpectErr(t,
+		`verifying precondition for version .*: checking namespace collisions: `+
+			`catalog contains colliding namespace entries: `+
+			fmt.Sprintf(`namespace entries \(%[1]d, %[2]d, "bar"\) and \(%[1]d, %[2]d, "foo"\) `+
+				`both reference descriptor %[3]d`, parentID, parentSchemaID, fooID),
+		"SET CLUSTER SETTING version = crdb_internal.node_executable_version()")
+
+	tdb.Exec(t, `SELECT crdb_internal.unsafe_delete_namespace_entry($1, $2, 'bar', $3, true /* force */)`,
+		parentID, parentSchemaID, fooID)
+	tdb.Exec(t, "SET CLUSTER SETTING version = crdb_internal.node_executable_version()")
+}
+
+// upsertDescriptor writes the given descriptor, bypassing all validation, as
+// may happen in a corrupt catalog.
+func upsertDescriptor(t *testing.T, tdb *sqlutils.SQLRunner, id int, desc *descpb.Descriptor) {
+	raw, err := protoutil.Marshal(desc)
+	require.NoError(t, err)
+	tdb.Exec(t, `SELECT crdb_internal.unsafe_upsert_descriptor($1, $2, true /* force */)`, id, raw)
+}
+
+func TestPreconditionDanglingTypeReferences(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	s, tdb := startPreconditionTestServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(ctx)
+
+	tdb.Exec(t, "CREATE DATABASE db")
+	tdb.Exec(t, "CREATE TABLE db.t (k INT PRIMARY KEY, c INT)")
+	var tableID int
+	tdb.QueryRow(t, `SELECT 'db.t'::REGCLASS::INT`).Scan(&tableID)
+
+	// setColumnType rewrites the type of column c of db.t.
+	setColumnType := func(typ *types.T) {
+		var desc descpb.Descriptor
+		decodeProto(t, tdb, &desc, `SELECT descriptor FROM system.descriptor WHERE id = $1`, tableID)
+		table := desc.GetTable()
+		require.Equal(t, "c", table.Columns[1].Name)
+		table.Columns[1].Type = typ
+		upsertDescriptor(t, tdb, tableID, &desc)
+	}
+
+	// Point the column at an enum type which doesn't exist, as if it had been
+	// dropped improperly.
+	const missingTypeID = 1000
+	setColumnType(types.MakeEnum(catid.TypeIDToOID(missingTypeID), catid.TypeIDToOID(missingTypeID+1)))
+	tdb.ExpectErr(t,
+		`verifying precondition for version .*: checking descriptors: `+
+			`catalog contains invalid descriptors: dangling type references: `+
+			fmt.Sprintf(`column t.c \(table %d\) references missing type %d`, tableID, missingTypeID),
+		"SET CLUSTER SETTING version = crdb_internal.node_executable_version()")
+
+	setColumnType(types.Int)
+	tdb.Exec(t, "SET CLUSTER SETTING version = crdb_internal.node_executable_version()")
+}
+
+func TestPreconditionOrphanedSequenceOwners(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	s, tdb := startPreconditionTestServer(t, base.TestServerArgs{})
+	defer s.Stopper().Stop(ctx)
+
+	tdb.Exec(t, "CREATE DATABASE db")
+	tdb.Exec(t, "CREATE TABLE db.t (k INT PRIMARY KEY, c INT)")
+	tdb.Exec(t, "CREATE SEQUENCE db.s OWNED BY db.t.c")
+	var tableID, seqID int
+	tdb.QueryRow(t, `SELECT 'db.t'::REGCLASS::INT, 'db.s'::REGCLASS::INT`).Scan(&tableID, &seqID)
+
+	// setOwner rewrites the owner of sequence db.s.
+	setOwner := func(owner descpb.TableDescriptor_SequenceOpts_SequenceOwner) {
+		var desc descpb.Descriptor
+		decodeProto(t, tdb, &desc, `SELECT descriptor FROM system.descriptor WHERE id = $1`, seqID)
+		desc.GetTable().SequenceOpts.SequenceOwner = owner
+		upsertDescriptor(t, tdb, seqID, &desc)
+	}
+	const errPrefix = `verifying precondition for version .*: checking descriptors: ` +
+		`catalog contains invalid descriptors: orphaned sequence owners: `
+
+	// Point the owner at a column which doesn't exist, as if it had been
+	// dropped improperly.
+	const missingID = 1000
+	setOwner(descpb.TableDescriptor_SequenceOpts_SequenceOwner{
+		OwnerTableID: descpb.ID(tableID), OwnerColumnID: missingID,
+	})
+	tdb.ExpectErr(t, errPrefix+fmt.Sprintf(
+		`sequence s \(%d\) is owned by missing column %d of table t \(%d\)`, seqID, missingID, tableID),
+		"SET CLUSTER SETTING version = crdb_internal.node_executable_version()")
+
+	// Likewise for the owning table.
+	setOwner(descpb.TableDescriptor_SequenceOpts_SequenceOwner{
+		OwnerTableID: missingID, OwnerColumnID: 2,
+	})
+	tdb.ExpectErr(t, errPrefix+fmt.Sprintf(
+		`sequence s \(%d\) is owned by missing table %d`, seqID, missingID),
+		"SET CLUSTER SETTING version = crdb_internal.node_executable_version()")
+
+	setOwner(descpb.TableDescriptor_SequenceOpts_SequenceOwner{
+		OwnerTableID: descpb.ID(tableID), OwnerColumnID: 2,
+	})
+	tdb.Exec(t, "SET CLUSTER SETTING version = crdb_internal.node_executable_version()")
+}
+
+func TestRunDescriptorPreconditions(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// table returns a table descriptor in database db, with columns k (1) and
+	// c (2).
+	table := func(id descpb.ID, name string, state descpb.DescriptorState) *descpb.TableDescriptor {
+		return &descpb.TableDescriptor{
+			ID: id, Name: name, ParentID: 104, UnexposedParentSchemaID: 105, State: state,
+			Columns: []descpb.ColumnDescriptor{
+				{ID: 1, Name: "k", Type: types.Int},
+				{ID: 2, Name: "c", Type: types.Int},
+			},
+		}
+	}
+	wrap := func(tables ...*descpb.TableDescriptor) []*descpb.Descriptor {
+		descs := []*descpb.Descriptor{{Union: &descpb.Descriptor_Database{
+			Database: &descpb.DatabaseDescriptor{ID: 104, Name: "db"},
+		}}}
+		for _, table := range tables {
+			descs = append(descs, &descpb.Descriptor{Union: &descpb.Descriptor_Table{Table: table}})
+		}
+		return descs
+	}
+
+	t.Run("name collisions", func(t *testing.T) {
+		require.Empty(t, upgrades.RunDescriptorPreconditions(wrap(
+			table(106, "foo", descpb.DescriptorState_PUBLIC),
+			table(107, "bar", descpb.DescriptorState_PUBLIC),
+			table(108, "foo", descpb.DescriptorState_DROP),
+		)))
+		require.Equal(t, []string{
+			`descriptor name collisions: descriptors 106 and 109 are both named "foo" under parent (104, 105)`,
+		}, upgrades.RunDescriptorPreconditions(wrap(
+			table(106, "foo", descpb.DescriptorState_PUBLIC),
+			table(109, "foo", descpb.DescriptorState_OFFLINE),
+		)))
+	})
+
+	t.Run("orphaned mutations", func(t *testing.T) {
+		// addColumn adds a mutation which adds column d to the table.
+		addColumn := func(table *descpb.TableDescriptor, mutationID descpb.MutationID) {
+			table.Mutations = append(table.Mutations, descpb.DescriptorMutation{
+				Descriptor_: &descpb.DescriptorMutation_Column{Column: &descpb.ColumnDescriptor{
+					ID: 3, Name: "d", Type: types.Int, Nullable: true,
+				}},
+				State:      descpb.DescriptorMutation_DELETE_ONLY,
+				Direction:  descpb.DescriptorMutation_ADD,
+				MutationID: mutationID,
+			})
+		}
+
+		// A mutation with a schema change job is fine.
+		withJob := table(106, "t", descpb.DescriptorState_PUBLIC)
+		addColumn(withJob, 1)
+		withJob.MutationJobs = []descpb.TableDescriptor_MutationJob{{MutationID: 1, JobID: 1000}}
+		require.Empty(t, upgrades.RunDescriptorPreconditions(wrap(withJob)))
+
+		// A mutation without one, as left behind by an improperly aborted schema
+		// change, is a violation.
+		orphaned := table(107, "u", descpb.DescriptorState_PUBLIC)
+		addColumn(orphaned, 2)
+		require.Equal(t, []string{
+			`orphaned mutations: table u (107) has mutation 2 without a schema change job`,
+		}, upgrades.RunDescriptorPreconditions(wrap(withJob, orphaned)))
+
+		// Dropped tables are ignored.
+		orphaned.State = descpb.DescriptorState_DROP
+		require.Empty(t, upgrades.RunDescriptorPreconditions(wrap(withJob, orphaned)))
+	})
+
+	t.Run("dangling type references", func(t *testing.T) {
+		const typeID = 110
+		enum := types.MakeEnum(catid.TypeIDToOID(typeID), catid.TypeIDToOID(typeID+1))
+		typ := &descpb.Descriptor{Union: &descpb.Descriptor_Type{Type: &descpb.TypeDescriptor{
+			ID: typeID, Name: "e", ParentID: 104, ParentSchemaID: 105,
+		}}}
+		withEnum := table(106, "t", descpb.DescriptorState_PUBLIC)
+		withEnum.Columns[1].Type = enum
+
+		// A column referencing a live type is fine.
+		require.Empty(t, upgrades.RunDescriptorPreconditions(append(wrap(withEnum), typ)))
+
+		// A column referencing a type which doesn't exist, as if it had been
+		// dropped improperly, is a violation.
+		require.Equal(t, []string{
+			`dangling type references: column t.c (table 106) references missing type 110`,
+		}, upgrades.RunDescriptorPreconditions(wrap(withEnum)))
+	})
+
+	t.Run("orphaned sequence owners", func(t *testing.T) {
+		// sequence returns a sequence owned by the given table and column.
+		sequence := func(ownerTableID descpb.ID, ownerColumnID descpb.ColumnID) *descpb.TableDescriptor {
+			seq := table(107, "s", descpb.DescriptorState_PUBLIC)
+			seq.Columns = nil
+			seq.SequenceOpts = &descpb.TableDescriptor_SequenceOpts{
+				Increment: 1,
+				SequenceOwner: descpb.TableDescriptor_SequenceOpts_SequenceOwner{
+					OwnerTableID: ownerTableID, OwnerColumnID: ownerColumnID,
+				},
+			}
+			return seq
+		}
+		owner := table(106, "t", descpb.DescriptorState_PUBLIC)
+
+		require.Empty(t, upgrades.RunDescriptorPreconditions(wrap(owner, sequence(106, 2))))
+		require.Equal(t, []string{
+			`orphaned sequence owners: sequence s (107) is owned by missing column 3 of table t (106)`,
+		}, upgrades.RunDescriptorPreconditions(wrap(owner, sequence(106, 3))))
+		require.Equal(t, []string{
+			`orphaned sequence owners: sequence s (107) is owned by missing table 108`,
+		}, upgrades.RunDescriptorPreconditions(wrap(owner, sequence(108, 2))))
+	})
+}
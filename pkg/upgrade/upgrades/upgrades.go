@@ -310,6 +310,12 @@ var upgrades = []upgradebase.Upgrade{
 		createActivityUpdateJobMigration,
 		"create statement_activity and transaction_activity job",
 	),
+	upgrade.NewTenantUpgrade(
+		"preconditions before starting an upgrade",
+		toCV(clusterversion.V23_2Start),
+		preconditionBeforeStartingAnUpgrade,
+		NoTenantUpgradeFunc,
+	),
 	upgrade.NewTenantUpgrade(
 		"enable partially visible indexes",
 		toCV(clusterversion.V23_2_PartiallyVisibleIndexes),